@@ -7,13 +7,18 @@ require (
 	github.com/expr-lang/expr v1.15.8
 	github.com/finishy1995/codegenerator v0.0.0-20221211063759-605448222ea4
 	github.com/go-redis/redis/v8 v8.11.5
+	github.com/golang/snappy v0.0.1
 	github.com/guregu/dynamo v1.19.0
+	github.com/klauspost/compress v1.13.6
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/panjf2000/ants/v2 v2.4.6
 	github.com/panjf2000/gnet v1.4.6
 	github.com/stretchr/testify v1.8.4
 	github.com/valyala/bytebufferpool v1.0.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	go.mongodb.org/mongo-driver v1.13.1
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
+	google.golang.org/protobuf v1.28.1
 )
 
 require (
@@ -21,11 +26,10 @@ require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/golang/snappy v0.0.1 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/montanaflynn/stats v0.0.0-20171201202039-1bf9dbcd8cbe // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
@@ -33,7 +37,6 @@ require (
 	go.uber.org/atomic v1.8.0 // indirect
 	go.uber.org/multierr v1.7.0 // indirect
 	go.uber.org/zap v1.17.0 // indirect
-	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4 // indirect
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"errors"
+	"github.com/finishy1995/go-library/network/core"
 	"github.com/stretchr/testify/require"
 	"net"
 	"testing"
@@ -15,6 +16,7 @@ func (conn *mockConn) Run()                 {}
 func (conn *mockConn) Close()               {}
 func (conn *mockConn) LocalAddr() net.Addr  { return nil }
 func (conn *mockConn) RemoteAddr() net.Addr { return nil }
+func (conn *mockConn) ID() core.ID          { return 0 }
 func (conn *mockConn) Write(b []byte) (n int, err error) {
 	if conn.handle != nil {
 		return conn.handle(b)
@@ -0,0 +1,247 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// CompressAlgorithm 压缩算法，同时作为每一帧开头的标志字节
+type CompressAlgorithm byte
+
+const (
+	// CompressNone 不压缩
+	CompressNone CompressAlgorithm = 0
+	// CompressSnappy snappy 压缩，速度快
+	CompressSnappy CompressAlgorithm = 1
+	// CompressZstd zstd 压缩，压缩率高
+	CompressZstd CompressAlgorithm = 2
+	// CompressFlate flate 压缩，只依赖标准库
+	CompressFlate CompressAlgorithm = 3
+
+	// DefaultCompressThreshold 默认压缩阈值，小于这个长度的数据不压缩
+	DefaultCompressThreshold = 256
+	// DefaultCompressNegotiateWait 服务端握手默认等待客户端协商请求的时间，超时视为等待服务端先发送数据的旧客户端
+	DefaultCompressNegotiateWait = time.Millisecond * 50
+)
+
+// CompressOptions 压缩选项
+type CompressOptions struct {
+	// Algorithms 支持的压缩算法，按照优先级排列，握手时选择双方都支持的第一个算法
+	Algorithms []CompressAlgorithm
+	// Threshold 压缩阈值，小于这个长度的数据不压缩
+	Threshold int
+	// MaxDecompressedLength 解压后最大长度，超过时返回 ErrFrameTooLarge
+	MaxDecompressedLength int
+	// NegotiateWait 服务端握手等待客户端协商请求的时间，超时视为不主动发送数据的旧客户端；
+	//	为 0 时不兼容这种旧客户端，客户端需要协商或者先发送数据，否则握手超时
+	NegotiateWait time.Duration
+}
+
+// CompressOption 压缩选项闭包
+type CompressOption func(*CompressOptions)
+
+// WithCompressAlgorithms 设置支持的压缩算法，按照优先级排列
+func WithCompressAlgorithms(algorithms ...CompressAlgorithm) CompressOption {
+	return func(options *CompressOptions) {
+		options.Algorithms = algorithms
+	}
+}
+
+// WithCompressThreshold 设置压缩阈值
+func WithCompressThreshold(threshold int) CompressOption {
+	return func(options *CompressOptions) {
+		options.Threshold = threshold
+	}
+}
+
+// WithMaxDecompressedLength 设置解压后最大长度
+func WithMaxDecompressedLength(length int) CompressOption {
+	return func(options *CompressOptions) {
+		options.MaxDecompressedLength = length
+	}
+}
+
+// WithCompressNegotiateWait 设置服务端握手等待客户端协商请求的时间，所有客户端都会协商或者先发送数据时可以设置为 0
+func WithCompressNegotiateWait(wait time.Duration) CompressOption {
+	return func(options *CompressOptions) {
+		options.NegotiateWait = wait
+	}
+}
+
+func newCompressOptions(opts []CompressOption) CompressOptions {
+	options := CompressOptions{
+		Algorithms:            []CompressAlgorithm{CompressSnappy, CompressZstd, CompressFlate},
+		Threshold:             DefaultCompressThreshold,
+		MaxDecompressedLength: DefaultMaxFrameLength,
+		NegotiateWait:         DefaultCompressNegotiateWait,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+// CompressCodec 压缩编解码，包装任意 Codec
+//
+//	每一帧开头为一个标志字节，表示负载使用的压缩算法，超过阈值且压缩后更短时才压缩；
+//	解码时按照标志字节解压，不要求对端使用相同的算法
+type CompressCodec struct {
+	codec     core.Codec
+	algorithm CompressAlgorithm
+	threshold int
+	maxLength int
+}
+
+// NewCompressCodec 创建压缩编解码，编码时使用 algorithm 压缩，codec 为 nil 时使用默认的 LengthFieldBasedFrameCodec
+func NewCompressCodec(codec core.Codec, algorithm CompressAlgorithm, opts ...CompressOption) (*CompressCodec, error) {
+	if algorithm > CompressFlate {
+		return nil, ErrUnsupportedCompress
+	}
+	if codec == nil {
+		codec = new(LengthFieldBasedFrameCodec)
+	}
+	options := newCompressOptions(opts)
+	return &CompressCodec{
+		codec:     codec,
+		algorithm: algorithm,
+		threshold: options.Threshold,
+		maxLength: options.MaxDecompressedLength,
+	}, nil
+}
+
+// Encode ...
+func (cc *CompressCodec) Encode(c core.CodecConn, buf []byte) ([]byte, error) {
+	if cc.algorithm != CompressNone && len(buf) >= cc.threshold {
+		out, err := compress(cc.algorithm, buf)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) < 1+len(buf) {
+			return cc.codec.Encode(c, out)
+		}
+	}
+	out := make([]byte, 1+len(buf))
+	out[0] = byte(CompressNone)
+	copy(out[1:], buf)
+	return cc.codec.Encode(c, out)
+}
+
+// Decode ...
+func (cc *CompressCodec) Decode(c core.CodecConn) ([]byte, error) {
+	buf, err := cc.codec.Decode(c)
+	if err != nil || buf == nil {
+		return buf, err
+	}
+	if len(buf) == 0 {
+		return nil, ErrUnsupportedCompress
+	}
+	return decompress(CompressAlgorithm(buf[0]), buf[1:], cc.maxLength)
+}
+
+// Closing 被包装的 Codec 需要在关闭前发送数据时透传
+func (cc *CompressCodec) Closing() []byte {
+	if closing, ok := cc.codec.(core.ClosingCodec); ok {
+		return closing.Closing()
+	}
+	return nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdDecoder *zstd.Decoder
+	// zstdEncoderPool 只使用 EncodeAll 的编码器不会启动协程，可以放入 sync.Pool，每次编码独占一个
+	zstdEncoderPool = sync.Pool{
+		New: func() interface{} {
+			e, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+			return e
+		},
+	}
+	flatePool = sync.Pool{
+		New: func() interface{} {
+			w, _ := flate.NewWriter(nil, flate.BestSpeed)
+			return w
+		},
+	}
+)
+
+// initZstd 解码器创建时会启动协程，不能放入 sync.Pool 后被回收，所有连接共用一个，
+// 内部保留 GOMAXPROCS 个块解码器，DecodeAll 可以并发执行
+func initZstd() {
+	zstdOnce.Do(func() {
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(runtime.GOMAXPROCS(0)), zstd.WithDecoderMaxMemory(DefaultMaxFrameLength))
+	})
+}
+
+// compress 压缩数据，返回的数据开头为标志字节
+func compress(algorithm CompressAlgorithm, buf []byte) ([]byte, error) {
+	header := []byte{byte(algorithm)}
+	switch algorithm {
+	case CompressSnappy:
+		out := make([]byte, 1+snappy.MaxEncodedLen(len(buf)))
+		out[0] = byte(algorithm)
+		return out[:1+len(snappy.Encode(out[1:], buf))], nil
+	case CompressZstd:
+		e := zstdEncoderPool.Get().(*zstd.Encoder)
+		defer zstdEncoderPool.Put(e)
+		return e.EncodeAll(buf, header), nil
+	case CompressFlate:
+		out := bytes.NewBuffer(header)
+		w := flatePool.Get().(*flate.Writer)
+		defer flatePool.Put(w)
+		w.Reset(out)
+		if _, err := w.Write(buf); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+	return nil, ErrUnsupportedCompress
+}
+
+// decompress 按照标志字节解压数据，解压后超过 maxLength 时返回 ErrFrameTooLarge
+func decompress(algorithm CompressAlgorithm, buf []byte, maxLength int) ([]byte, error) {
+	switch algorithm {
+	case CompressNone:
+		return buf, nil
+	case CompressSnappy:
+		n, err := snappy.DecodedLen(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxLength {
+			return nil, ErrFrameTooLarge
+		}
+		return snappy.Decode(make([]byte, n), buf)
+	case CompressZstd:
+		initZstd()
+		out, err := zstdDecoder.DecodeAll(buf, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > maxLength {
+			return nil, ErrFrameTooLarge
+		}
+		return out, nil
+	case CompressFlate:
+		r := flate.NewReader(bytes.NewReader(buf))
+		defer r.Close()
+		out, err := io.ReadAll(io.LimitReader(r, int64(maxLength)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > maxLength {
+			return nil, ErrFrameTooLarge
+		}
+		return out, nil
+	}
+	return nil, ErrUnsupportedCompress
+}
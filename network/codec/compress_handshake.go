@@ -0,0 +1,133 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/finishy1995/go-library/network/core"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	// compressMagic 压缩协商请求和回复的开头，作为 4 字节长度字段时远超最大帧长度，旧服务端会直接断开而不是等待
+	compressMagic = []byte{0xfe, 'C', 'M', 'P'}
+)
+
+// prefixConn 握手时多读取的数据，读取时先消费这些数据
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (conn *prefixConn) Read(b []byte) (int, error) {
+	if len(conn.prefix) > 0 {
+		n := copy(b, conn.prefix)
+		conn.prefix = conn.prefix[n:]
+		return n, nil
+	}
+	return conn.Conn.Read(b)
+}
+
+// NetConn 被包装的原始连接
+func (conn *prefixConn) NetConn() net.Conn {
+	return conn.Conn
+}
+
+// CompressServerHandshake 服务端压缩协商，协商成功后使用 CompressCodec 包装原来的 Codec
+//
+//	客户端在连接建立后立即发送协商请求，收到的数据和协商请求的开头不一致时视为旧客户端，立即返回且不启用压缩，读取的数据不会丢失；
+//	NegotiateWait（默认 DefaultCompressNegotiateWait）内没有收到完整开头的客户端同样视为旧客户端，兼容等待服务端先发送数据的旧客户端。
+//	只有旧客户端的数据恰好以协商请求的 4 字节开头时才会误判，默认的 4 字节长度字段 LengthFieldBasedFrameCodec 不会出现这种数据；
+//	2 字节长度字段、BuiltInCodec 或者文本协议的旧客户端不会被误判为协商，但是第一个字节为 0xfe 时需要等待后续字节才能区分。
+//	NegotiateWait 为 0 时要求所有客户端协商或者先发送数据，否则等待到握手超时后断开
+func CompressServerHandshake(opts ...CompressOption) core.Handshake {
+	options := newCompressOptions(opts)
+	return func(conn net.Conn, codec core.Codec) (net.Conn, core.Codec, error) {
+		wait := options.NegotiateWait
+		if wait <= 0 {
+			wait = core.DefaultHandshakeTimeout
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(wait))
+		head := make([]byte, len(compressMagic))
+		n := 0
+		for n < len(head) {
+			m, err := conn.Read(head[n:])
+			n += m
+			if n > 0 && !bytes.Equal(head[:n], compressMagic[:n]) {
+				break
+			}
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() && options.NegotiateWait > 0 {
+					break
+				}
+				return conn, nil, err
+			}
+		}
+		if n < len(head) || !bytes.Equal(head, compressMagic) {
+			// 旧客户端，已经读取的数据在之后读取时先返回
+			_ = conn.SetReadDeadline(time.Time{})
+			if n == 0 {
+				return conn, nil, nil
+			}
+			return &prefixConn{Conn: conn, prefix: head[:n]}, nil, nil
+		}
+
+		_ = conn.SetDeadline(time.Now().Add(core.DefaultHandshakeTimeout))
+		count := make([]byte, 1)
+		if _, err := io.ReadFull(conn, count); err != nil {
+			return conn, nil, err
+		}
+		offered := make([]byte, count[0])
+		if _, err := io.ReadFull(conn, offered); err != nil {
+			return conn, nil, err
+		}
+		algorithm := CompressNone
+		for _, a := range options.Algorithms {
+			if a != CompressNone && bytes.IndexByte(offered, byte(a)) >= 0 {
+				algorithm = a
+				break
+			}
+		}
+		if _, err := conn.Write(append(append([]byte{}, compressMagic...), byte(algorithm))); err != nil {
+			return conn, nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return conn, newNegotiatedCodec(codec, algorithm, opts), nil
+	}
+}
+
+// CompressClientHandshake 客户端压缩协商，服务端需要使用 CompressServerHandshake
+func CompressClientHandshake(opts ...CompressOption) core.Handshake {
+	options := newCompressOptions(opts)
+	return func(conn net.Conn, codec core.Codec) (net.Conn, core.Codec, error) {
+		_ = conn.SetDeadline(time.Now().Add(core.DefaultHandshakeTimeout))
+		req := append([]byte{}, compressMagic...)
+		req = append(req, byte(len(options.Algorithms)))
+		for _, a := range options.Algorithms {
+			req = append(req, byte(a))
+		}
+		if _, err := conn.Write(req); err != nil {
+			return conn, nil, err
+		}
+		resp := make([]byte, len(compressMagic)+1)
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return conn, nil, err
+		}
+		algorithm := CompressAlgorithm(resp[len(compressMagic)])
+		if !bytes.Equal(resp[:len(compressMagic)], compressMagic) ||
+			(algorithm != CompressNone && bytes.IndexByte(req[len(compressMagic)+1:], byte(algorithm)) < 0) {
+			return conn, nil, ErrCompressHandshake
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return conn, newNegotiatedCodec(codec, algorithm, opts), nil
+	}
+}
+
+// newNegotiatedCodec 协商结果为不压缩时继续使用原来的 Codec
+func newNegotiatedCodec(codec core.Codec, algorithm CompressAlgorithm, opts []CompressOption) core.Codec {
+	if algorithm == CompressNone {
+		return nil
+	}
+	cc, _ := NewCompressCodec(codec, algorithm, opts...)
+	return cc
+}
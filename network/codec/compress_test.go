@@ -0,0 +1,247 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newStatePacket 模拟状态同步包，重复度高，容易压缩
+func newStatePacket(size int) []byte {
+	entity := []byte(`{"id":1024,"x":12.5,"y":-3.25,"hp":100,"state":"idle"},`)
+	return bytes.Repeat(entity, size/len(entity)+1)[:size]
+}
+
+func TestCompressCodec(t *testing.T) {
+	r := require.New(t)
+	large := newStatePacket(4096)
+	small := []byte("hello")
+	random := make([]byte, 1024)
+	_, _ = rand.Read(random)
+
+	for _, algorithm := range []CompressAlgorithm{CompressSnappy, CompressZstd, CompressFlate} {
+		t.Logf("test algorithm: %d", algorithm)
+		cc, err := NewCompressCodec(nil, algorithm)
+		r.Nil(err)
+		conn := newMockConn()
+
+		// 超过阈值的数据被压缩
+		bb, err := cc.Encode(conn, large)
+		r.Nil(err)
+		r.Less(len(bb), len(large))
+		r.Equal(byte(algorithm), bb[4])
+		conn.MockGetNetworkMsg(bb)
+		bb, err = cc.Decode(conn)
+		r.Nil(err)
+		r.Equal(large, bb)
+
+		// 小于阈值或者压缩后更长的数据不压缩
+		for _, payload := range [][]byte{small, random} {
+			bb, err = cc.Encode(conn, payload)
+			r.Nil(err)
+			r.Equal(byte(CompressNone), bb[4])
+			conn.MockGetNetworkMsg(bb)
+			bb, err = cc.Decode(conn)
+			r.Nil(err)
+			r.Equal(payload, bb)
+		}
+
+		// 解码不要求对端使用相同的算法
+		other, err := NewCompressCodec(nil, algorithm%CompressFlate+1)
+		r.Nil(err)
+		bb, err = other.Encode(conn, large)
+		r.Nil(err)
+		conn.MockGetNetworkMsg(bb)
+		bb, err = cc.Decode(conn)
+		r.Nil(err)
+		r.Equal(large, bb)
+
+		// 解压后超过最大长度
+		limited, err := NewCompressCodec(nil, algorithm, WithMaxDecompressedLength(1024))
+		r.Nil(err)
+		bb, err = cc.Encode(conn, large)
+		r.Nil(err)
+		conn.MockGetNetworkMsg(bb)
+		_, err = limited.Decode(conn)
+		r.Equal(ErrFrameTooLarge, err)
+	}
+
+	_, err := NewCompressCodec(nil, CompressFlate+1)
+	r.Equal(ErrUnsupportedCompress, err)
+	cc, err := NewCompressCodec(nil, CompressSnappy)
+	r.Nil(err)
+	conn := newMockConn()
+	bb, err := new(LengthFieldBasedFrameCodec).Encode(conn, []byte{9, 1, 2})
+	r.Nil(err)
+	conn.MockGetNetworkMsg(bb)
+	_, err = cc.Decode(conn)
+	r.Equal(ErrUnsupportedCompress, err)
+}
+
+// 多个连接同时压缩和解压
+func TestCompressConcurrent(t *testing.T) {
+	r := require.New(t)
+	for _, algorithm := range []CompressAlgorithm{CompressSnappy, CompressZstd, CompressFlate} {
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(size int) {
+				defer wg.Done()
+				payload := newStatePacket(size)
+				for j := 0; j < 50; j++ {
+					out, err := compress(algorithm, payload)
+					if err == nil {
+						out, err = decompress(CompressAlgorithm(out[0]), out[1:], DefaultMaxFrameLength)
+					}
+					if err == nil && !bytes.Equal(payload, out) {
+						err = ErrUnsupportedCompress
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(1024 * (i + 1))
+		}
+		wg.Wait()
+		close(errs)
+		r.Nil(<-errs)
+	}
+}
+
+// runHandshake 在内存连接上执行服务端和客户端握手
+func runHandshake(server core.Handshake, client func(conn net.Conn) (net.Conn, core.Codec, error)) (net.Conn, core.Codec, error, core.Codec, error) {
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	var (
+		conn         net.Conn
+		serverCodec  core.Codec
+		serverErr    error
+		clientCodec  core.Codec
+		clientErr    error
+		defaultCodec = new(LengthFieldBasedFrameCodec)
+	)
+	go func() {
+		defer close(done)
+		conn, serverCodec, serverErr = server(serverConn, defaultCodec)
+	}()
+	_, clientCodec, clientErr = client(clientConn)
+	<-done
+	return conn, serverCodec, serverErr, clientCodec, clientErr
+}
+
+func TestCompressHandshake(t *testing.T) {
+	r := require.New(t)
+	server := CompressServerHandshake(WithCompressAlgorithms(CompressZstd, CompressSnappy))
+
+	// 选择服务端优先级最高的共同算法
+	_, serverCodec, serverErr, clientCodec, clientErr := runHandshake(server, func(conn net.Conn) (net.Conn, core.Codec, error) {
+		return CompressClientHandshake(WithCompressAlgorithms(CompressSnappy, CompressZstd))(conn, nil)
+	})
+	r.Nil(serverErr)
+	r.Nil(clientErr)
+	r.Equal(CompressZstd, serverCodec.(*CompressCodec).algorithm)
+	r.Equal(CompressZstd, clientCodec.(*CompressCodec).algorithm)
+
+	// 没有共同算法时不压缩
+	_, serverCodec, serverErr, clientCodec, clientErr = runHandshake(server, func(conn net.Conn) (net.Conn, core.Codec, error) {
+		return CompressClientHandshake(WithCompressAlgorithms(CompressFlate))(conn, nil)
+	})
+	r.Nil(serverErr)
+	r.Nil(clientErr)
+	r.Nil(serverCodec)
+	r.Nil(clientCodec)
+
+	// 旧客户端直接发送数据，按照第一个字节识别，不等待也不丢失数据
+	start := time.Now()
+	conn, serverCodec, serverErr, _, _ := runHandshake(CompressServerHandshake(), func(conn net.Conn) (net.Conn, core.Codec, error) {
+		go func() {
+			_, _ = conn.Write([]byte{0, 0, 0, 2, 'h', 'i'})
+		}()
+		return conn, nil, nil
+	})
+	r.Nil(serverErr)
+	r.Nil(serverCodec)
+	r.Less(time.Since(start), time.Millisecond*50)
+	b := make([]byte, 6)
+	n, err := io.ReadFull(conn, b)
+	r.Nil(err)
+	r.Equal([]byte{0, 0, 0, 2, 'h', 'i'}, b[:n])
+
+	// 旧客户端的数据以 0xfe 开头但不是协商请求，数据不会丢失
+	conn, serverCodec, serverErr, _, _ = runHandshake(server, func(conn net.Conn) (net.Conn, core.Codec, error) {
+		go func() {
+			_, _ = conn.Write([]byte{0xfe, 'C', 'X', 'Y'})
+		}()
+		return conn, nil, nil
+	})
+	r.Nil(serverErr)
+	r.Nil(serverCodec)
+	n, err = io.ReadFull(conn, b[:4])
+	r.Nil(err)
+	r.Equal([]byte{0xfe, 'C', 'X', 'Y'}, b[:n])
+
+	// 旧客户端不发送数据，默认等待 DefaultCompressNegotiateWait 后不压缩
+	start = time.Now()
+	_, serverCodec, serverErr, _, _ = runHandshake(server, func(conn net.Conn) (net.Conn, core.Codec, error) {
+		return conn, nil, nil
+	})
+	r.Nil(serverErr)
+	r.Nil(serverCodec)
+	r.GreaterOrEqual(time.Since(start), DefaultCompressNegotiateWait)
+	r.Less(time.Since(start), core.DefaultHandshakeTimeout)
+
+	// NegotiateWait 为 0 时要求客户端协商或者先发送数据
+	strict := CompressServerHandshake(WithCompressNegotiateWait(0))
+	_, serverCodec, serverErr, clientCodec, clientErr = runHandshake(strict, func(conn net.Conn) (net.Conn, core.Codec, error) {
+		return CompressClientHandshake()(conn, nil)
+	})
+	r.Nil(serverErr)
+	r.Nil(clientErr)
+	r.Equal(CompressSnappy, serverCodec.(*CompressCodec).algorithm)
+	r.Equal(CompressSnappy, clientCodec.(*CompressCodec).algorithm)
+}
+
+func benchmarkCodec(b *testing.B, cc core.Codec, size int) {
+	payload := newStatePacket(size)
+	conn := newMockConn()
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bb, err := cc.Encode(conn, payload)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conn.MockGetNetworkMsg(bb)
+		if _, err = cc.Decode(conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLengthFieldBasedFrameCodec(b *testing.B) {
+	benchmarkCodec(b, new(LengthFieldBasedFrameCodec), 16384)
+}
+
+func BenchmarkCompressCodecSnappy(b *testing.B) {
+	cc, _ := NewCompressCodec(nil, CompressSnappy)
+	benchmarkCodec(b, cc, 16384)
+}
+
+func BenchmarkCompressCodecZstd(b *testing.B) {
+	cc, _ := NewCompressCodec(nil, CompressZstd)
+	benchmarkCodec(b, cc, 16384)
+}
+
+func BenchmarkCompressCodecFlate(b *testing.B) {
+	cc, _ := NewCompressCodec(nil, CompressFlate)
+	benchmarkCodec(b, cc, 16384)
+}
@@ -0,0 +1,34 @@
+package codec
+
+import "errors"
+
+var (
+	// ErrNotProtoMessage 使用 protobuf 序列化的值没有实现 proto.Message
+	ErrNotProtoMessage = errors.New("value is not a proto.Message")
+	// ErrFrameTooLarge 帧长度超过最大限制
+	ErrFrameTooLarge = errors.New("frame too large")
+	// ErrInvalidLengthField 不合法的长度字段配置或长度字段值
+	ErrInvalidLengthField = errors.New("invalid length field")
+	// ErrUnsupportedCompress 不支持的压缩算法
+	ErrUnsupportedCompress = errors.New("unsupported compress algorithm")
+	// ErrCompressHandshake 压缩协商失败
+	ErrCompressHandshake = errors.New("compress handshake failed")
+	// ErrSecureHandshake 加密握手失败
+	ErrSecureHandshake = errors.New("secure handshake failed")
+	// ErrUnsupportedCipher 没有双方都支持的加密算法
+	ErrUnsupportedCipher = errors.New("unsupported cipher suite")
+	// ErrReplay 收到的序列号不是期望的下一个，可能是重放或者丢失
+	ErrReplay = errors.New("secure frame replayed")
+	// ErrDecrypt 解密失败
+	ErrDecrypt = errors.New("secure frame decrypt failed")
+	// ErrSessionHandshake 会话握手失败
+	ErrSessionHandshake = errors.New("session handshake failed")
+	// ErrSessionReplaced 会话已经被新的连接恢复，旧连接不能继续使用
+	ErrSessionReplaced = errors.New("session replaced by new connection")
+	// ErrSessionSequence 收到的序列号不连续，中间的帧丢失
+	ErrSessionSequence = errors.New("session frame out of sequence")
+	// ErrSessionLost 对端需要的帧已经被丢弃，会话无法恢复
+	ErrSessionLost = errors.New("session lost")
+	// ErrSessionLimit 会话数量达到上限，不能创建新会话
+	ErrSessionLimit = errors.New("too many sessions")
+)
@@ -1,69 +1,203 @@
 package codec
 
 import (
-	"github.com/finishy1995/go-library/network/core"
 	"encoding/binary"
+	"github.com/finishy1995/go-library/network/core"
+	"math"
+)
+
+const (
+	// DefaultMaxFrameLength 默认帧最大长度（包括头部）
+	DefaultMaxFrameLength = 1 << 24
+	// StripHeader 用于 InitialBytesToStrip，去掉长度字段及之前的全部头部，长度字段为 varint 时使用
+	StripHeader = -1
 )
 
+// LengthFieldConfig 基于长度字段的帧格式配置
+//
+//	帧格式为 [头部 LengthFieldOffset 字节][长度字段][数据]，长度字段的值加上 LengthAdjustment 为数据长度
+type LengthFieldConfig struct {
+	// ByteOrder 长度字段字节序，nil 为大端序
+	ByteOrder binary.ByteOrder
+	// LengthFieldOffset 长度字段之前的头部长度
+	LengthFieldOffset int
+	// LengthFieldLength 长度字段字节数，支持 1/2/4/8，Varint 为 true 时忽略
+	LengthFieldLength int
+	// Varint 长度字段使用无符号 varint 编码
+	Varint bool
+	// LengthAdjustment 长度字段的值加上这个值为数据长度，例如长度字段的值包含长度字段本身时为 -LengthFieldLength
+	LengthAdjustment int
+	// InitialBytesToStrip 解码后去掉帧开头的字节数，StripHeader 为去掉长度字段及之前的全部头部
+	InitialBytesToStrip int
+	// MaxFrameLength 帧最大长度（包括头部），超过时返回 ErrFrameTooLarge，0 为 DefaultMaxFrameLength，小于 0 为不限制
+	MaxFrameLength int
+}
+
+var (
+	// DefaultLengthFieldConfig 默认帧格式，4 字节大端序长度字段，解码后只保留数据
+	DefaultLengthFieldConfig = LengthFieldConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   4,
+		InitialBytesToStrip: 4,
+	}
+)
+
+// LengthFieldBasedFrameCodec 基于长度字段的帧编解码，零值使用 DefaultLengthFieldConfig
+//
+//	编码时在数据的 LengthFieldOffset 字节之后插入长度字段，解码时按照长度字段切分出完整的帧
 type LengthFieldBasedFrameCodec struct {
+	config *LengthFieldConfig
 }
 
-type innerBuffer []byte
+// NewLengthFieldBasedFrameCodec 使用给定帧格式创建编解码
+func NewLengthFieldBasedFrameCodec(config LengthFieldConfig) (*LengthFieldBasedFrameCodec, error) {
+	if config.ByteOrder == nil {
+		config.ByteOrder = binary.BigEndian
+	}
+	if !config.Varint {
+		switch config.LengthFieldLength {
+		case 1, 2, 4, 8:
+		default:
+			return nil, ErrInvalidLengthField
+		}
+	}
+	if config.LengthFieldOffset < 0 || config.InitialBytesToStrip < StripHeader {
+		return nil, ErrInvalidLengthField
+	}
+	return &LengthFieldBasedFrameCodec{config: &config}, nil
+}
 
-func (in *innerBuffer) readN(n int) (buf []byte, err error) {
-	if n == 0 {
-		return nil, nil
+func (cc *LengthFieldBasedFrameCodec) getConfig() *LengthFieldConfig {
+	if cc.config == nil {
+		return &DefaultLengthFieldConfig
 	}
+	return cc.config
+}
 
-	if n < 0 {
-		return nil, core.ErrTooLessLength
-	} else if n > len(*in) {
-		return nil, core.ErrTooMoreLength
+// maxFrameLength 帧最大长度，不限制时返回 math.MaxInt
+func (config *LengthFieldConfig) maxFrameLength() int {
+	if config.MaxFrameLength == 0 {
+		return DefaultMaxFrameLength
 	}
-	buf = (*in)[:n]
-	*in = (*in)[n:]
-	return
+	if config.MaxFrameLength < 0 {
+		return math.MaxInt
+	}
+	return config.MaxFrameLength
 }
 
 // Encode ...
 func (cc *LengthFieldBasedFrameCodec) Encode(_ core.CodecConn, buf []byte) (out []byte, err error) {
-	length := len(buf)
-	if length < 0 {
+	config := cc.getConfig()
+	length := len(buf) - config.LengthFieldOffset - config.LengthAdjustment
+	if len(buf) < config.LengthFieldOffset || length < 0 {
 		return nil, core.ErrTooLessLength
 	}
 
-	out = make([]byte, 4)
-	binary.BigEndian.PutUint32(out, uint32(length))
-
-	out = append(out, buf...)
+	field, err := config.putLength(uint64(length))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf)+len(field) > config.maxFrameLength() {
+		return nil, ErrFrameTooLarge
+	}
+	out = make([]byte, 0, len(buf)+len(field))
+	out = append(out, buf[:config.LengthFieldOffset]...)
+	out = append(out, field...)
+	out = append(out, buf[config.LengthFieldOffset:]...)
 	return
 }
 
 // Decode ...
 func (cc *LengthFieldBasedFrameCodec) Decode(c core.CodecConn) ([]byte, error) {
-	var (
-		in          innerBuffer
-		err         error
-		frameLength uint64
-	)
-	in = c.Read()
-
-	lenBuf, err := in.readN(4)
-	if err != nil {
+	config := cc.getConfig()
+	in := c.Read()
+	if len(in) < config.LengthFieldOffset {
 		return nil, core.ErrPacketSplit
-	} else {
-		frameLength = uint64(binary.BigEndian.Uint32(lenBuf))
 	}
-
-	// real message length
-	msgLength := int(frameLength)
-	msg, err := in.readN(msgLength)
+	length, fieldLength, err := config.readLength(in[config.LengthFieldOffset:])
 	if err != nil {
+		return nil, err
+	}
+
+	// 在计算帧长度前检查，避免溢出
+	headerLength := config.LengthFieldOffset + fieldLength
+	maxFrameLength := config.maxFrameLength()
+	if length > uint64(maxFrameLength) {
+		return nil, ErrFrameTooLarge
+	}
+	frameLength := headerLength + int(length) + config.LengthAdjustment
+	if frameLength < headerLength {
+		return nil, ErrInvalidLengthField
+	}
+	if frameLength > maxFrameLength {
+		return nil, ErrFrameTooLarge
+	}
+	if len(in) < frameLength {
 		return nil, core.ErrPacketSplit
 	}
 
-	fullMessage := make([]byte, msgLength)
-	copy(fullMessage, msg)
-	c.ShiftN(msgLength + 4)
+	strip := config.InitialBytesToStrip
+	if strip == StripHeader {
+		strip = headerLength
+	}
+	if strip > frameLength {
+		return nil, ErrInvalidLengthField
+	}
+	fullMessage := make([]byte, frameLength-strip)
+	copy(fullMessage, in[strip:frameLength])
+	c.ShiftN(frameLength)
 	return fullMessage, nil
 }
+
+// putLength 编码长度字段
+func (config *LengthFieldConfig) putLength(length uint64) ([]byte, error) {
+	if config.Varint {
+		field := make([]byte, binary.MaxVarintLen64)
+		return field[:binary.PutUvarint(field, length)], nil
+	}
+	if config.LengthFieldLength < 8 && length >= 1<<(8*config.LengthFieldLength) {
+		return nil, core.ErrTooMoreLength
+	}
+	field := make([]byte, config.LengthFieldLength)
+	switch config.LengthFieldLength {
+	case 1:
+		field[0] = byte(length)
+	case 2:
+		config.ByteOrder.PutUint16(field, uint16(length))
+	case 4:
+		config.ByteOrder.PutUint32(field, uint32(length))
+	case 8:
+		config.ByteOrder.PutUint64(field, length)
+	default:
+		return nil, ErrInvalidLengthField
+	}
+	return field, nil
+}
+
+// readLength 解析长度字段，返回长度字段的值和长度字段字节数，数据不够时返回 core.ErrPacketSplit
+func (config *LengthFieldConfig) readLength(in []byte) (uint64, int, error) {
+	if config.Varint {
+		length, n := binary.Uvarint(in)
+		if n == 0 {
+			return 0, 0, core.ErrPacketSplit
+		}
+		if n < 0 {
+			return 0, 0, ErrInvalidLengthField
+		}
+		return length, n, nil
+	}
+	if len(in) < config.LengthFieldLength {
+		return 0, 0, core.ErrPacketSplit
+	}
+	switch config.LengthFieldLength {
+	case 1:
+		return uint64(in[0]), 1, nil
+	case 2:
+		return uint64(config.ByteOrder.Uint16(in)), 2, nil
+	case 4:
+		return uint64(config.ByteOrder.Uint32(in)), 4, nil
+	case 8:
+		return config.ByteOrder.Uint64(in), 8, nil
+	}
+	return 0, 0, ErrInvalidLengthField
+}
@@ -1,6 +1,7 @@
 package codec
 
 import (
+	"encoding/binary"
 	"github.com/finishy1995/go-library/buffer/bytebuffer"
 	"github.com/finishy1995/go-library/network/core"
 	"github.com/stretchr/testify/require"
@@ -64,3 +65,88 @@ func TestLengthFieldBasedFrameCodec(t *testing.T) {
 	r.Nil(err)
 	r.Equal(test5, bb)
 }
+
+func TestLengthFieldBasedFrameCodecConfig(t *testing.T) {
+	r := require.New(t)
+	payload := []byte("hello world")
+	tests := []struct {
+		name   string
+		config LengthFieldConfig
+		header []byte
+	}{
+		{"1 byte", LengthFieldConfig{LengthFieldLength: 1, InitialBytesToStrip: 1}, []byte{11}},
+		{"2 bytes little endian", LengthFieldConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 2, InitialBytesToStrip: 2}, []byte{11, 0}},
+		{"8 bytes", LengthFieldConfig{LengthFieldLength: 8, InitialBytesToStrip: 8}, []byte{0, 0, 0, 0, 0, 0, 0, 11}},
+		{"length includes field", LengthFieldConfig{LengthFieldLength: 2, LengthAdjustment: -2, InitialBytesToStrip: 2}, []byte{0, 13}},
+		{"varint", LengthFieldConfig{Varint: true, InitialBytesToStrip: StripHeader}, []byte{11}},
+	}
+	for _, test := range tests {
+		t.Logf("test config: %s", test.name)
+		cc, err := NewLengthFieldBasedFrameCodec(test.config)
+		r.Nil(err)
+		conn := newMockConn()
+		bb, err := cc.Encode(conn, payload)
+		r.Nil(err)
+		r.Equal(append(append([]byte{}, test.header...), payload...), bb)
+
+		// 拆包
+		conn.MockGetNetworkMsg(bb[:len(test.header)])
+		_, err = cc.Decode(conn)
+		r.Equal(core.ErrPacketSplit, err)
+		conn.MockGetNetworkMsg(bb[len(test.header):])
+		bb, err = cc.Decode(conn)
+		r.Nil(err)
+		r.Equal(payload, bb)
+		r.Zero(conn.BufferLength())
+	}
+}
+
+func TestLengthFieldBasedFrameCodecOffset(t *testing.T) {
+	r := require.New(t)
+	// 2 字节消息类型 + 2 字节长度字段 + 数据，解码后保留消息类型和长度字段
+	cc, err := NewLengthFieldBasedFrameCodec(LengthFieldConfig{LengthFieldOffset: 2, LengthFieldLength: 2})
+	r.Nil(err)
+	conn := newMockConn()
+	bb, err := cc.Encode(conn, []byte{0xab, 0xcd, 'h', 'i'})
+	r.Nil(err)
+	r.Equal([]byte{0xab, 0xcd, 0, 2, 'h', 'i'}, bb)
+	conn.MockGetNetworkMsg(bb)
+	bb, err = cc.Decode(conn)
+	r.Nil(err)
+	r.Equal([]byte{0xab, 0xcd, 0, 2, 'h', 'i'}, bb)
+
+	_, err = cc.Encode(conn, []byte{0xab})
+	r.Equal(core.ErrTooLessLength, err)
+}
+
+func TestLengthFieldBasedFrameCodecMaxFrameLength(t *testing.T) {
+	r := require.New(t)
+	cc, err := NewLengthFieldBasedFrameCodec(LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: 2, MaxFrameLength: 10})
+	r.Nil(err)
+	conn := newMockConn()
+	_, err = cc.Encode(conn, []byte("hello world"))
+	r.Equal(ErrFrameTooLarge, err)
+	_, err = cc.Encode(conn, make([]byte, 1<<16))
+	r.Equal(core.ErrTooMoreLength, err)
+
+	// 只收到长度字段就拒绝，不需要等待整个帧
+	conn.MockGetNetworkMsg([]byte{0xff, 0xff})
+	_, err = cc.Decode(conn)
+	r.Equal(ErrFrameTooLarge, err)
+
+	// 默认最大长度
+	conn = newMockConn()
+	conn.MockGetNetworkMsg([]byte{0xff, 0xff, 0xff, 0xff})
+	_, err = new(LengthFieldBasedFrameCodec).Decode(conn)
+	r.Equal(ErrFrameTooLarge, err)
+
+	// 不合法的配置
+	_, err = NewLengthFieldBasedFrameCodec(LengthFieldConfig{LengthFieldLength: 3})
+	r.Equal(ErrInvalidLengthField, err)
+	cc, err = NewLengthFieldBasedFrameCodec(LengthFieldConfig{LengthFieldLength: 1, LengthAdjustment: -2})
+	r.Nil(err)
+	conn.ResetBuffer()
+	conn.MockGetNetworkMsg([]byte{1, 0})
+	_, err = cc.Decode(conn)
+	r.Equal(ErrInvalidLengthField, err)
+}
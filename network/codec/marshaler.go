@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"encoding/json"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Marshaler 消息序列化，用于把结构体转换为网络消息的负载
+type Marshaler interface {
+	// Marshal 序列化
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal 反序列化，v 必须为指针
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONMarshaler JSON 序列化
+type JSONMarshaler struct {
+}
+
+// Marshal ...
+func (m *JSONMarshaler) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal ...
+func (m *JSONMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtobufMarshaler protobuf 序列化，值必须实现 proto.Message
+type ProtobufMarshaler struct {
+}
+
+// Marshal ...
+func (m *ProtobufMarshaler) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(msg)
+}
+
+// Unmarshal ...
+func (m *ProtobufMarshaler) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// MsgpackMarshaler msgpack 序列化
+type MsgpackMarshaler struct {
+}
+
+// Marshal ...
+func (m *MsgpackMarshaler) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal ...
+func (m *MsgpackMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
@@ -0,0 +1,51 @@
+package codec
+
+import (
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"testing"
+)
+
+func TestJSONMarshaler(t *testing.T) {
+	r := require.New(t)
+	type message struct {
+		ID   int
+		Name string
+	}
+	m := new(JSONMarshaler)
+	b, err := m.Marshal(&message{ID: 1, Name: "a"})
+	r.Nil(err)
+	out := new(message)
+	r.Nil(m.Unmarshal(b, out))
+	r.Equal(&message{ID: 1, Name: "a"}, out)
+	r.NotNil(m.Unmarshal([]byte("{"), out))
+}
+
+func TestProtobufMarshaler(t *testing.T) {
+	r := require.New(t)
+	m := new(ProtobufMarshaler)
+	b, err := m.Marshal(wrapperspb.String("hello"))
+	r.Nil(err)
+	out := new(wrapperspb.StringValue)
+	r.Nil(m.Unmarshal(b, out))
+	r.Equal("hello", out.GetValue())
+
+	_, err = m.Marshal(struct{}{})
+	r.Equal(ErrNotProtoMessage, err)
+	r.Equal(ErrNotProtoMessage, m.Unmarshal(b, new(string)))
+}
+
+func TestMsgpackMarshaler(t *testing.T) {
+	r := require.New(t)
+	type message struct {
+		ID   int
+		Name string
+	}
+	m := new(MsgpackMarshaler)
+	b, err := m.Marshal(&message{ID: 1, Name: "a"})
+	r.Nil(err)
+	out := new(message)
+	r.Nil(m.Unmarshal(b, out))
+	r.Equal(&message{ID: 1, Name: "a"}, out)
+	r.NotNil(m.Unmarshal([]byte{0xc1}, out))
+}
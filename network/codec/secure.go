@@ -0,0 +1,185 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/finishy1995/go-library/network/core"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"sync"
+)
+
+// CipherSuite 对称加密算法
+type CipherSuite byte
+
+const (
+	// CipherAESGCM AES-256-GCM，有硬件加速时优先使用
+	CipherAESGCM CipherSuite = 1
+	// CipherChaCha20Poly1305 ChaCha20-Poly1305，没有 AES 硬件加速的设备上更快
+	CipherChaCha20Poly1305 CipherSuite = 2
+
+	// secureSeqSize 每一帧开头的序列号长度
+	secureSeqSize = 8
+	// secureKeySize 每个方向的密钥长度
+	secureKeySize = 32
+)
+
+// SecureOptions 加密选项
+type SecureOptions struct {
+	// Ciphers 支持的加密算法，按照优先级排列，握手时选择双方都支持的第一个算法
+	Ciphers []CipherSuite
+	// PreSharedKey 预共享密钥，不为空时参与密钥派生，双方不一致时无法通信，可以防止中间人攻击
+	PreSharedKey []byte
+}
+
+// SecureOption 加密选项闭包
+type SecureOption func(*SecureOptions)
+
+// WithCiphers 设置支持的加密算法，按照优先级排列
+func WithCiphers(ciphers ...CipherSuite) SecureOption {
+	return func(options *SecureOptions) {
+		options.Ciphers = ciphers
+	}
+}
+
+// WithPreSharedKey 设置预共享密钥
+func WithPreSharedKey(key []byte) SecureOption {
+	return func(options *SecureOptions) {
+		options.PreSharedKey = key
+	}
+}
+
+func newSecureOptions(opts []SecureOption) SecureOptions {
+	options := SecureOptions{
+		Ciphers: []CipherSuite{CipherAESGCM, CipherChaCha20Poly1305},
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+// SecureCodec 加密编解码，包装任意 Codec，由 SecureServerHandshake/SecureClientHandshake 在握手后创建
+//
+//	每一帧开头为 8 字节大端序的序列号，后面为密文，nonce 由序列号生成；
+//	每个方向使用独立的密钥和序列号，收到的序列号不是期望的下一个时返回 ErrReplay
+type SecureCodec struct {
+	codec core.Codec
+	suite CipherSuite
+
+	sendMutex sync.Mutex
+	sendAEAD  cipher.AEAD
+	sendSeq   uint64
+
+	recvAEAD cipher.AEAD
+	recvSeq  uint64
+}
+
+// newSecureCodec 创建加密编解码，sendKey/recvKey 为两个方向的密钥
+func newSecureCodec(codec core.Codec, suite CipherSuite, sendKey []byte, recvKey []byte) (*SecureCodec, error) {
+	if codec == nil {
+		codec = new(LengthFieldBasedFrameCodec)
+	}
+	sendAEAD, err := newAEAD(suite, sendKey)
+	if err != nil {
+		return nil, err
+	}
+	recvAEAD, err := newAEAD(suite, recvKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SecureCodec{
+		codec:    codec,
+		suite:    suite,
+		sendAEAD: sendAEAD,
+		recvAEAD: recvAEAD,
+	}, nil
+}
+
+// Cipher 握手选择的加密算法
+func (cc *SecureCodec) Cipher() CipherSuite {
+	return cc.suite
+}
+
+// Encode ...
+func (cc *SecureCodec) Encode(c core.CodecConn, buf []byte) ([]byte, error) {
+	cc.sendMutex.Lock()
+	seq := cc.sendSeq
+	cc.sendSeq++
+	out := make([]byte, secureSeqSize, secureSeqSize+len(buf)+cc.sendAEAD.Overhead())
+	binary.BigEndian.PutUint64(out, seq)
+	out = cc.sendAEAD.Seal(out, secureNonce(cc.sendAEAD, seq), buf, out[:secureSeqSize])
+	cc.sendMutex.Unlock()
+	return cc.codec.Encode(c, out)
+}
+
+// Decode 只在连接的读协程中调用
+func (cc *SecureCodec) Decode(c core.CodecConn) ([]byte, error) {
+	buf, err := cc.codec.Decode(c)
+	if err != nil || buf == nil {
+		return buf, err
+	}
+	if len(buf) < secureSeqSize {
+		return nil, ErrDecrypt
+	}
+	seq := binary.BigEndian.Uint64(buf)
+	if seq != cc.recvSeq {
+		return nil, ErrReplay
+	}
+	out, err := cc.recvAEAD.Open(nil, secureNonce(cc.recvAEAD, seq), buf[secureSeqSize:], buf[:secureSeqSize])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	cc.recvSeq++
+	if out == nil {
+		out = []byte{}
+	}
+	return out, nil
+}
+
+// Closing 被包装的 Codec 需要在关闭前发送数据时透传
+func (cc *SecureCodec) Closing() []byte {
+	if closing, ok := cc.codec.(core.ClosingCodec); ok {
+		return closing.Closing()
+	}
+	return nil
+}
+
+// secureNonce 序列号放在 nonce 末尾，其余字节为 0
+func secureNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-secureSeqSize:], seq)
+	return nonce
+}
+
+func newAEAD(suite CipherSuite, key []byte) (cipher.AEAD, error) {
+	switch suite {
+	case CipherAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	}
+	return nil, ErrUnsupportedCipher
+}
+
+// deriveKeys 使用 HKDF-SHA256 从共享密钥派生两个方向的密钥
+func deriveKeys(shared []byte, clientPublic []byte, serverPublic []byte, psk []byte) (clientKey []byte, serverKey []byte, err error) {
+	salt := make([]byte, 0, len(clientPublic)+len(serverPublic)+len(psk))
+	salt = append(salt, clientPublic...)
+	salt = append(salt, serverPublic...)
+	salt = append(salt, psk...)
+	clientKey = make([]byte, secureKeySize)
+	serverKey = make([]byte, secureKeySize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("go-library client key")), clientKey); err != nil {
+		return
+	}
+	_, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("go-library server key")), serverKey)
+	return
+}
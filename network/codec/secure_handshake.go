@@ -0,0 +1,135 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/finishy1995/go-library/network/core"
+	"golang.org/x/crypto/curve25519"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	// secureMagic 加密握手请求和回复的开头
+	secureMagic = []byte{0xfe, 'S', 'E', 'C'}
+)
+
+// SecureServerHandshake 服务端加密握手，使用 X25519 交换密钥，握手成功后使用 SecureCodec 包装原来的 Codec
+//
+//	不支持 TLS 的客户端使用；没有 PreSharedKey 时握手本身没有身份认证。和压缩一起使用时，加密握手需要在压缩握手之前
+func SecureServerHandshake(opts ...SecureOption) core.Handshake {
+	options := newSecureOptions(opts)
+	return func(conn net.Conn, codec core.Codec) (net.Conn, core.Codec, error) {
+		_ = conn.SetDeadline(time.Now().Add(core.DefaultHandshakeTimeout))
+		head := make([]byte, len(secureMagic)+1)
+		if _, err := io.ReadFull(conn, head); err != nil {
+			return conn, nil, err
+		}
+		if !bytes.Equal(head[:len(secureMagic)], secureMagic) {
+			return conn, nil, ErrSecureHandshake
+		}
+		offered := make([]byte, head[len(secureMagic)])
+		if _, err := io.ReadFull(conn, offered); err != nil {
+			return conn, nil, err
+		}
+		clientPublic := make([]byte, curve25519.PointSize)
+		if _, err := io.ReadFull(conn, clientPublic); err != nil {
+			return conn, nil, err
+		}
+
+		var suite CipherSuite
+		for _, c := range options.Ciphers {
+			if bytes.IndexByte(offered, byte(c)) >= 0 {
+				suite = c
+				break
+			}
+		}
+		if suite == 0 {
+			_, _ = conn.Write(append(append([]byte{}, secureMagic...), 0))
+			return conn, nil, ErrUnsupportedCipher
+		}
+		private, public, err := newKeyPair()
+		if err != nil {
+			return conn, nil, err
+		}
+		shared, err := curve25519.X25519(private, clientPublic)
+		if err != nil {
+			return conn, nil, err
+		}
+		clientKey, serverKey, err := deriveKeys(shared, clientPublic, public, options.PreSharedKey)
+		if err != nil {
+			return conn, nil, err
+		}
+		resp := append(append([]byte{}, secureMagic...), byte(suite))
+		if _, err = conn.Write(append(resp, public...)); err != nil {
+			return conn, nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		cc, err := newSecureCodec(codec, suite, serverKey, clientKey)
+		if err != nil {
+			return conn, nil, err
+		}
+		return conn, cc, nil
+	}
+}
+
+// SecureClientHandshake 客户端加密握手，服务端需要使用 SecureServerHandshake
+func SecureClientHandshake(opts ...SecureOption) core.Handshake {
+	options := newSecureOptions(opts)
+	return func(conn net.Conn, codec core.Codec) (net.Conn, core.Codec, error) {
+		private, public, err := newKeyPair()
+		if err != nil {
+			return conn, nil, err
+		}
+		_ = conn.SetDeadline(time.Now().Add(core.DefaultHandshakeTimeout))
+		req := append([]byte{}, secureMagic...)
+		req = append(req, byte(len(options.Ciphers)))
+		for _, c := range options.Ciphers {
+			req = append(req, byte(c))
+		}
+		if _, err = conn.Write(append(req, public...)); err != nil {
+			return conn, nil, err
+		}
+
+		head := make([]byte, len(secureMagic)+1)
+		if _, err = io.ReadFull(conn, head); err != nil {
+			return conn, nil, err
+		}
+		suite := CipherSuite(head[len(secureMagic)])
+		if !bytes.Equal(head[:len(secureMagic)], secureMagic) {
+			return conn, nil, ErrSecureHandshake
+		}
+		if suite == 0 || bytes.IndexByte(req[len(secureMagic)+1:], byte(suite)) < 0 {
+			return conn, nil, ErrUnsupportedCipher
+		}
+		serverPublic := make([]byte, curve25519.PointSize)
+		if _, err = io.ReadFull(conn, serverPublic); err != nil {
+			return conn, nil, err
+		}
+		shared, err := curve25519.X25519(private, serverPublic)
+		if err != nil {
+			return conn, nil, err
+		}
+		clientKey, serverKey, err := deriveKeys(shared, public, serverPublic, options.PreSharedKey)
+		if err != nil {
+			return conn, nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		cc, err := newSecureCodec(codec, suite, clientKey, serverKey)
+		if err != nil {
+			return conn, nil, err
+		}
+		return conn, cc, nil
+	}
+}
+
+// newKeyPair 生成 X25519 密钥对
+func newKeyPair() (private []byte, public []byte, err error) {
+	private = make([]byte, curve25519.ScalarSize)
+	if _, err = rand.Read(private); err != nil {
+		return
+	}
+	public, err = curve25519.X25519(private, curve25519.Basepoint)
+	return
+}
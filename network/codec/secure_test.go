@@ -0,0 +1,111 @@
+package codec
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+// secureHandshake 在内存连接上完成加密握手
+func secureHandshake(r *require.Assertions, server []SecureOption, client []SecureOption) (core.Codec, core.Codec) {
+	_, serverCodec, serverErr, clientCodec, clientErr := runHandshake(SecureServerHandshake(server...), func(conn net.Conn) (net.Conn, core.Codec, error) {
+		return SecureClientHandshake(client...)(conn, nil)
+	})
+	r.Nil(serverErr)
+	r.Nil(clientErr)
+	return serverCodec, clientCodec
+}
+
+func TestSecureCodec(t *testing.T) {
+	r := require.New(t)
+	for _, suite := range []CipherSuite{CipherAESGCM, CipherChaCha20Poly1305} {
+		t.Logf("test cipher: %d", suite)
+		serverCodec, clientCodec := secureHandshake(r, nil, []SecureOption{WithCiphers(suite)})
+		r.Equal(suite, serverCodec.(*SecureCodec).Cipher())
+		r.Equal(suite, clientCodec.(*SecureCodec).Cipher())
+		conn := newMockConn()
+
+		// 两个方向都可以通信，密文不包含明文
+		for _, pair := range [][2]core.Codec{{clientCodec, serverCodec}, {serverCodec, clientCodec}} {
+			for _, msg := range []string{"hello", "", "world"} {
+				bb, err := pair[0].Encode(conn, []byte(msg))
+				r.Nil(err)
+				if msg != "" {
+					r.NotContains(string(bb), msg)
+				}
+				conn.MockGetNetworkMsg(bb)
+				bb, err = pair[1].Decode(conn)
+				r.Nil(err)
+				r.Equal([]byte(msg), bb)
+			}
+		}
+
+		// 重放的帧被拒绝
+		bb, err := clientCodec.Encode(conn, []byte("pay"))
+		r.Nil(err)
+		conn.MockGetNetworkMsg(bb)
+		_, err = serverCodec.Decode(conn)
+		r.Nil(err)
+		conn.MockGetNetworkMsg(bb)
+		_, err = serverCodec.Decode(conn)
+		r.Equal(ErrReplay, err)
+
+		// 篡改的帧无法解密
+		conn.ResetBuffer()
+		bb, err = clientCodec.Encode(conn, []byte("pay"))
+		r.Nil(err)
+		bb[len(bb)-1] ^= 1
+		conn.MockGetNetworkMsg(bb)
+		_, err = serverCodec.Decode(conn)
+		r.Equal(ErrDecrypt, err)
+	}
+}
+
+func TestSecureHandshakeFailed(t *testing.T) {
+	r := require.New(t)
+	// 没有共同的加密算法
+	_, _, serverErr, _, clientErr := runHandshake(SecureServerHandshake(WithCiphers(CipherAESGCM)), func(conn net.Conn) (net.Conn, core.Codec, error) {
+		return SecureClientHandshake(WithCiphers(CipherChaCha20Poly1305))(conn, nil)
+	})
+	r.Equal(ErrUnsupportedCipher, serverErr)
+	r.Equal(ErrUnsupportedCipher, clientErr)
+
+	// 不支持加密的客户端
+	_, _, serverErr, _, _ = runHandshake(SecureServerHandshake(), func(conn net.Conn) (net.Conn, core.Codec, error) {
+		go func() {
+			_, _ = conn.Write([]byte{0, 0, 0, 2, 'h', 'i'})
+		}()
+		return conn, nil, nil
+	})
+	r.Equal(ErrSecureHandshake, serverErr)
+
+	// 预共享密钥不一致时无法解密
+	serverCodec, clientCodec := secureHandshake(r, []SecureOption{WithPreSharedKey([]byte("a"))}, []SecureOption{WithPreSharedKey([]byte("b"))})
+	conn := newMockConn()
+	bb, err := clientCodec.Encode(conn, []byte("hello"))
+	r.Nil(err)
+	conn.MockGetNetworkMsg(bb)
+	_, err = serverCodec.Decode(conn)
+	r.Equal(ErrDecrypt, err)
+}
+
+func BenchmarkSecureCodec(b *testing.B) {
+	r := require.New(b)
+	serverCodec, clientCodec := secureHandshake(r, nil, nil)
+	payload := newStatePacket(16384)
+	conn := newMockConn()
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bb, err := clientCodec.Encode(conn, payload)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conn.MockGetNetworkMsg(bb)
+		if _, err = serverCodec.Decode(conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
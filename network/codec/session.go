@@ -0,0 +1,319 @@
+package codec
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/finishy1995/go-library/network/core"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionTTL 默认会话保留时间，从最后一次收发消息开始计算
+	DefaultSessionTTL = time.Minute * 2
+	// DefaultSessionMaxUnacked 默认每个方向最多保留的未确认消息数量
+	DefaultSessionMaxUnacked = 1024
+	// DefaultSessionMaxUnackedBytes 默认每个方向最多保留的未确认消息字节数
+	DefaultSessionMaxUnackedBytes = 1 << 20
+	// DefaultSessionMaxSessions 默认服务端最多保存的会话数量
+	DefaultSessionMaxSessions = 10000
+
+	// sessionTokenSize 会话令牌长度
+	sessionTokenSize = 16
+	// sessionHeaderSize 每一帧开头的序列号和确认号长度
+	sessionHeaderSize = 16
+)
+
+// SessionOptions 可恢复会话选项
+type SessionOptions struct {
+	// TTL 会话保留时间，超过这个时间没有收发消息的会话无法恢复
+	TTL time.Duration
+	// MaxUnacked 最多保留的未确认消息数量，超过时丢弃最早的消息，被丢弃的消息对端没有收到时会话无法恢复
+	MaxUnacked int
+	// MaxUnackedBytes 最多保留的未确认消息字节数，超过时同样丢弃最早的消息
+	MaxUnackedBytes int
+	// MaxSessions 服务端最多保存的会话数量，达到上限时新会话的握手返回 ErrSessionLimit，已有会话仍然可以恢复
+	MaxSessions int
+}
+
+// SessionOption 可恢复会话选项闭包
+type SessionOption func(*SessionOptions)
+
+// WithSessionTTL 设置会话保留时间
+func WithSessionTTL(ttl time.Duration) SessionOption {
+	return func(options *SessionOptions) {
+		options.TTL = ttl
+	}
+}
+
+// WithSessionMaxUnacked 设置最多保留的未确认消息数量
+func WithSessionMaxUnacked(n int) SessionOption {
+	return func(options *SessionOptions) {
+		options.MaxUnacked = n
+	}
+}
+
+// WithSessionMaxUnackedBytes 设置最多保留的未确认消息字节数
+func WithSessionMaxUnackedBytes(n int) SessionOption {
+	return func(options *SessionOptions) {
+		options.MaxUnackedBytes = n
+	}
+}
+
+// WithSessionMaxSessions 设置服务端最多保存的会话数量
+func WithSessionMaxSessions(n int) SessionOption {
+	return func(options *SessionOptions) {
+		options.MaxSessions = n
+	}
+}
+
+func newSessionOptions(opts []SessionOption) SessionOptions {
+	options := SessionOptions{
+		TTL:             DefaultSessionTTL,
+		MaxUnacked:      DefaultSessionMaxUnacked,
+		MaxUnackedBytes: DefaultSessionMaxUnackedBytes,
+		MaxSessions:     DefaultSessionMaxSessions,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+// session 可恢复会话的状态，跨越多个连接，服务端保存在 SessionStore 中，客户端保存在握手流程中
+type session struct {
+	mutex sync.Mutex
+	token []byte
+	// generation 每次连接使用会话时加一，旧连接的 SessionCodec 发现不一致时返回 ErrSessionReplaced
+	generation uint64
+	// sendSeq 最后发送的序列号，recvSeq 最后收到的序列号
+	sendSeq uint64
+	recvSeq uint64
+	// unacked 对端还没有确认的帧，序列号连续递增，unackedBytes 为这些帧的总长度
+	unacked         [][]byte
+	unackedBytes    int
+	maxUnacked      int
+	maxUnackedBytes int
+	activeTime      time.Time
+}
+
+func newSession(token []byte, options SessionOptions) *session {
+	return &session{
+		token:           token,
+		maxUnacked:      options.MaxUnacked,
+		maxUnackedBytes: options.MaxUnackedBytes,
+		activeTime:      time.Now(),
+	}
+}
+
+// newSessionToken 生成随机的会话令牌
+func newSessionToken() ([]byte, error) {
+	token := make([]byte, sessionTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ack 释放对端已经收到的帧
+func (s *session) ack(seq uint64) {
+	i := 0
+	for i < len(s.unacked) && binary.BigEndian.Uint64(s.unacked[i]) <= seq {
+		s.unackedBytes -= len(s.unacked[i])
+		i++
+	}
+	s.unacked = s.unacked[i:]
+}
+
+// push 保留发送的帧，超过数量或者字节数上限时丢弃最早的帧
+func (s *session) push(frame []byte) {
+	s.unacked = append(s.unacked, frame)
+	s.unackedBytes += len(frame)
+	i := 0
+	for i < len(s.unacked) && (len(s.unacked)-i > s.maxUnacked || (s.maxUnackedBytes > 0 && s.unackedBytes > s.maxUnackedBytes)) {
+		s.unackedBytes -= len(s.unacked[i])
+		i++
+	}
+	s.unacked = s.unacked[i:]
+}
+
+// resumable 对端最后收到 peerRecv 时，是否还保留着之后的全部帧
+func (s *session) resumable(peerRecv uint64) bool {
+	if peerRecv > s.sendSeq {
+		return false
+	}
+	if len(s.unacked) == 0 {
+		return peerRecv == s.sendSeq
+	}
+	return binary.BigEndian.Uint64(s.unacked[0]) <= peerRecv+1
+}
+
+// attach 新连接开始使用会话，恢复时返回需要补发的帧
+func (s *session) attach(peerRecv uint64, resumed bool) (generation uint64, pending [][]byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.generation++
+	s.activeTime = time.Now()
+	if resumed {
+		s.ack(peerRecv)
+		pending = append(pending, s.unacked...)
+	}
+	return s.generation, pending
+}
+
+// SessionStore 服务端保存的可恢复会话，同一个 SessionStore 可以被多个服务器共用
+type SessionStore struct {
+	mutex    sync.Mutex
+	options  SessionOptions
+	sessions map[string]*session
+	// 上一次清理过期会话的时间，清理间隔为 TTL 的四分之一
+	lastSweep time.Time
+}
+
+// NewSessionStore 创建会话存储，配合 SessionServerHandshake 使用
+func NewSessionStore(opts ...SessionOption) *SessionStore {
+	return &SessionStore{
+		options:   newSessionOptions(opts),
+		sessions:  make(map[string]*session),
+		lastSweep: time.Now(),
+	}
+}
+
+// Len 保存的会话数量，包括已经过期但还没有清理的会话
+func (store *SessionStore) Len() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return len(store.sessions)
+}
+
+// sweep 清理过期的会话，距离上一次清理不到 TTL 的四分之一时跳过，调用时需要持有锁
+func (store *SessionStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < store.options.TTL/4 {
+		return
+	}
+	store.lastSweep = now
+	for key, s := range store.sessions {
+		if s.expired(now, store.options.TTL) {
+			delete(store.sessions, key)
+		}
+	}
+}
+
+// expired 会话是否已经过期
+func (s *session) expired(now time.Time, ttl time.Duration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return now.Sub(s.activeTime) > ttl
+}
+
+// resume 按照令牌恢复会话，令牌不存在、会话过期或者无法补发时创建新会话
+//
+//	过期的会话按照间隔批量清理，会话数量达到上限时返回 ErrSessionLimit
+func (store *SessionStore) resume(token []byte, peerRecv uint64) (*session, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now()
+	store.sweep(now)
+
+	if s, ok := store.sessions[string(token)]; ok {
+		if !s.expired(now, store.options.TTL) {
+			s.mutex.Lock()
+			resumable := s.resumable(peerRecv)
+			s.mutex.Unlock()
+			if resumable {
+				return s, true, nil
+			}
+		}
+		delete(store.sessions, string(token))
+	}
+	if store.options.MaxSessions > 0 && len(store.sessions) >= store.options.MaxSessions {
+		return nil, false, ErrSessionLimit
+	}
+	token, err := newSessionToken()
+	if err != nil {
+		return nil, false, err
+	}
+	s := newSession(token, store.options)
+	store.sessions[string(token)] = s
+	return s, false, nil
+}
+
+// SessionCodec 可恢复会话编解码，包装任意 Codec，由 SessionServerHandshake/SessionClientHandshake 在握手后创建
+//
+//	每一帧开头为 8 字节大端序的序列号和 8 字节的确认号（最后收到的序列号），发送的帧保留到对端确认，
+//	重连恢复会话时补发对端没有收到的帧，重复收到的帧被忽略。确认号随发送的帧送达，建议开启心跳
+type SessionCodec struct {
+	codec      core.Codec
+	session    *session
+	generation uint64
+	resumed    bool
+}
+
+// Token 会话令牌
+func (cc *SessionCodec) Token() []byte {
+	return cc.session.token
+}
+
+// Resumed 这个连接是否恢复了之前的会话
+func (cc *SessionCodec) Resumed() bool {
+	return cc.resumed
+}
+
+// Encode ...
+func (cc *SessionCodec) Encode(c core.CodecConn, buf []byte) ([]byte, error) {
+	s := cc.session
+	s.mutex.Lock()
+	if cc.generation != s.generation {
+		s.mutex.Unlock()
+		return nil, ErrSessionReplaced
+	}
+	s.sendSeq++
+	frame := make([]byte, sessionHeaderSize+len(buf))
+	binary.BigEndian.PutUint64(frame, s.sendSeq)
+	binary.BigEndian.PutUint64(frame[8:], s.recvSeq)
+	copy(frame[sessionHeaderSize:], buf)
+	s.push(frame)
+	s.activeTime = time.Now()
+	s.mutex.Unlock()
+	return cc.codec.Encode(c, frame)
+}
+
+// Decode 只在连接的读协程中调用，重复收到的帧返回 nil
+func (cc *SessionCodec) Decode(c core.CodecConn) ([]byte, error) {
+	buf, err := cc.codec.Decode(c)
+	if err != nil || buf == nil {
+		return buf, err
+	}
+	if len(buf) < sessionHeaderSize {
+		return nil, ErrSessionSequence
+	}
+	seq := binary.BigEndian.Uint64(buf)
+	s := cc.session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if cc.generation != s.generation {
+		return nil, ErrSessionReplaced
+	}
+	s.ack(binary.BigEndian.Uint64(buf[8:]))
+	s.activeTime = time.Now()
+	if seq <= s.recvSeq {
+		return nil, nil
+	}
+	if seq != s.recvSeq+1 {
+		return nil, ErrSessionSequence
+	}
+	s.recvSeq = seq
+	out := buf[sessionHeaderSize:]
+	if len(out) == 0 {
+		out = []byte{}
+	}
+	return out, nil
+}
+
+// Closing 被包装的 Codec 需要在关闭前发送数据时透传
+func (cc *SessionCodec) Closing() []byte {
+	if closing, ok := cc.codec.(core.ClosingCodec); ok {
+		return closing.Closing()
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/finishy1995/go-library/network/core"
+	"net"
+	"time"
+)
+
+var (
+	// sessionMagic 会话握手请求和回复的开头
+	sessionMagic = []byte{0xfe, 'S', 'E', 'S'}
+)
+
+// SessionServerHandshake 服务端可恢复会话握手，握手成功后使用 SessionCodec 包装原来的 Codec
+//
+//	客户端带着之前的令牌和最后收到的序列号重连时恢复会话，先补发客户端没有收到的帧；新旧连接同时存在时旧连接失效。
+//	握手请求和回复使用被包装的 Codec 编码，和加密、压缩一起使用时，会话握手需要在它们之后，令牌和补发的帧都会经过加密；
+//	没有加密（SecureServerHandshake 或者 TLS）时令牌明文传输，可以被截获后冒用会话
+func SessionServerHandshake(store *SessionStore) core.Handshake {
+	return func(conn net.Conn, codec core.Codec) (net.Conn, core.Codec, error) {
+		if codec == nil {
+			codec = new(LengthFieldBasedFrameCodec)
+		}
+		_ = conn.SetDeadline(time.Now().Add(core.DefaultHandshakeTimeout))
+		hc := newHandshakeConn(conn)
+		req, err := hc.readFrame(codec)
+		if err != nil {
+			return conn, nil, err
+		}
+		if len(req) != len(sessionMagic)+sessionTokenSize+8 || !bytes.Equal(req[:len(sessionMagic)], sessionMagic) {
+			return conn, nil, ErrSessionHandshake
+		}
+		token := req[len(sessionMagic) : len(sessionMagic)+sessionTokenSize]
+		peerRecv := binary.BigEndian.Uint64(req[len(sessionMagic)+sessionTokenSize:])
+
+		s, resumed, err := store.resume(token, peerRecv)
+		if err != nil {
+			return conn, nil, err
+		}
+		generation, pending := s.attach(peerRecv, resumed)
+		if err = writeSessionResponse(hc, codec, s, resumed, pending); err != nil {
+			return conn, nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return hc.netConn(), &SessionCodec{codec: codec, session: s, generation: generation, resumed: resumed}, nil
+	}
+}
+
+// SessionClientHandshake 客户端可恢复会话握手，服务端需要使用 SessionServerHandshake
+//
+//	返回的握手流程保存会话状态，同一个客户端重连时复用，不能被多个客户端共用
+func SessionClientHandshake(opts ...SessionOption) core.Handshake {
+	options := newSessionOptions(opts)
+	var s *session
+	return func(conn net.Conn, codec core.Codec) (net.Conn, core.Codec, error) {
+		if codec == nil {
+			codec = new(LengthFieldBasedFrameCodec)
+		}
+		_ = conn.SetDeadline(time.Now().Add(core.DefaultHandshakeTimeout))
+		req := make([]byte, len(sessionMagic)+sessionTokenSize+8)
+		copy(req, sessionMagic)
+		if s != nil {
+			s.mutex.Lock()
+			copy(req[len(sessionMagic):], s.token)
+			binary.BigEndian.PutUint64(req[len(sessionMagic)+sessionTokenSize:], s.recvSeq)
+			s.mutex.Unlock()
+		}
+		hc := newHandshakeConn(conn)
+		if err := writeSessionFrames(hc, codec, [][]byte{req}); err != nil {
+			return conn, nil, err
+		}
+		resp, err := hc.readFrame(codec)
+		if err != nil {
+			return conn, nil, err
+		}
+		if len(resp) != len(sessionMagic)+1+sessionTokenSize+8 || !bytes.Equal(resp[:len(sessionMagic)], sessionMagic) {
+			return conn, nil, ErrSessionHandshake
+		}
+		resumed := resp[len(sessionMagic)] == 1
+		token := resp[len(sessionMagic)+1 : len(sessionMagic)+1+sessionTokenSize]
+		peerRecv := binary.BigEndian.Uint64(resp[len(sessionMagic)+1+sessionTokenSize:])
+
+		if !resumed || s == nil || !bytes.Equal(token, s.token) {
+			s = newSession(token, options)
+			resumed = false
+		} else {
+			s.mutex.Lock()
+			resumable := s.resumable(peerRecv)
+			s.mutex.Unlock()
+			if !resumable {
+				// 服务端需要的帧已经被丢弃，下次重连创建新会话
+				s = nil
+				return conn, nil, ErrSessionLost
+			}
+		}
+		generation, pending := s.attach(peerRecv, resumed)
+		if err = writeSessionFrames(hc, codec, pending); err != nil {
+			return conn, nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return hc.netConn(), &SessionCodec{codec: codec, session: s, generation: generation, resumed: resumed}, nil
+	}
+}
+
+// writeSessionResponse 发送握手回复和需要补发的帧
+func writeSessionResponse(hc *handshakeConn, codec core.Codec, s *session, resumed bool, pending [][]byte) error {
+	resp := make([]byte, len(sessionMagic)+1+sessionTokenSize+8)
+	copy(resp, sessionMagic)
+	if resumed {
+		resp[len(sessionMagic)] = 1
+	}
+	s.mutex.Lock()
+	copy(resp[len(sessionMagic)+1:], s.token)
+	binary.BigEndian.PutUint64(resp[len(sessionMagic)+1+sessionTokenSize:], s.recvSeq)
+	s.mutex.Unlock()
+	return writeSessionFrames(hc, codec, append([][]byte{resp}, pending...))
+}
+
+// writeSessionFrames 使用被包装的 Codec 编码并发送，握手请求和回复同样经过加密，令牌不会明文传输
+func writeSessionFrames(hc *handshakeConn, codec core.Codec, frames [][]byte) error {
+	for _, frame := range frames {
+		out, err := codec.Encode(hc, frame)
+		if err != nil {
+			return err
+		}
+		if _, err = hc.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handshakeConn 握手时使用 Codec 读取帧的连接，这时连接还没有交给 Agent
+type handshakeConn struct {
+	ConnHelper
+	conn net.Conn
+}
+
+func newHandshakeConn(conn net.Conn) *handshakeConn {
+	hc := &handshakeConn{conn: conn}
+	hc.InitBuffer()
+	return hc
+}
+
+func (hc *handshakeConn) Run()                 {}
+func (hc *handshakeConn) Close()               {}
+func (hc *handshakeConn) ID() core.ID          { return 0 }
+func (hc *handshakeConn) LocalAddr() net.Addr  { return hc.conn.LocalAddr() }
+func (hc *handshakeConn) RemoteAddr() net.Addr { return hc.conn.RemoteAddr() }
+
+func (hc *handshakeConn) Write(b []byte) (int, error) {
+	return hc.conn.Write(b)
+}
+
+// readFrame 读取并解码一帧
+func (hc *handshakeConn) readFrame(codec core.Codec) ([]byte, error) {
+	b := make([]byte, 1024)
+	for {
+		if hc.BufferLength() > 0 {
+			out, err := codec.Decode(hc)
+			if err != core.ErrPacketSplit {
+				if err == nil && out == nil {
+					err = ErrSessionHandshake
+				}
+				return out, err
+			}
+		}
+		n, err := hc.conn.Read(b)
+		if n > 0 {
+			hc.PushPacket(b[:n])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// netConn 握手后交给 Agent 的连接，多读取的数据（对端紧接着补发的帧）在之后读取时先返回
+func (hc *handshakeConn) netConn() net.Conn {
+	if hc.BufferLength() == 0 {
+		return hc.conn
+	}
+	prefix := append([]byte{}, hc.ConnHelper.Read()...)
+	hc.ResetBuffer()
+	return &prefixConn{Conn: hc.conn, prefix: prefix}
+}
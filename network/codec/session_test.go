@@ -0,0 +1,266 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair 建立一对本地 TCP 连接，握手时补发的帧需要内核缓冲，不能使用 net.Pipe
+func tcpPair(r *require.Assertions) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	r.Nil(err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	r.Nil(err)
+	server := <-accepted
+	r.NotNil(server)
+	return server, client
+}
+
+// sessionHandshake 在本地 TCP 连接上完成会话握手
+func sessionHandshake(r *require.Assertions, store *SessionStore, client core.Handshake) (net.Conn, *SessionCodec, net.Conn, *SessionCodec) {
+	serverConn, clientConn := tcpPair(r)
+	done := make(chan struct{})
+	var (
+		serverCodec core.Codec
+		serverErr   error
+	)
+	go func() {
+		defer close(done)
+		serverConn, serverCodec, serverErr = SessionServerHandshake(store)(serverConn, nil)
+	}()
+	clientConn, clientCodec, clientErr := client(clientConn, nil)
+	<-done
+	r.Nil(serverErr)
+	r.Nil(clientErr)
+	return serverConn, serverCodec.(*SessionCodec), clientConn, clientCodec.(*SessionCodec)
+}
+
+// recordConn 记录写入的原始数据
+type recordConn struct {
+	net.Conn
+	written []byte
+}
+
+func (conn *recordConn) Write(b []byte) (int, error) {
+	conn.written = append(conn.written, b...)
+	return conn.Conn.Write(b)
+}
+
+// writeMessages 编码并发送消息
+func writeMessages(r *require.Assertions, conn net.Conn, cc core.Codec, msgs ...string) {
+	for _, msg := range msgs {
+		bb, err := cc.Encode(nil, []byte(msg))
+		r.Nil(err)
+		_, err = conn.Write(bb)
+		r.Nil(err)
+	}
+}
+
+// readMessages 读取并解码 n 条消息
+func readMessages(r *require.Assertions, conn net.Conn, cc core.Codec, n int) []string {
+	mc := newMockConn()
+	b := make([]byte, 1024)
+	msgs := make([]string, 0, n)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(msgs) < n {
+		k, err := conn.Read(b)
+		r.Nil(err)
+		mc.MockGetNetworkMsg(b[:k])
+		for {
+			out, err := cc.Decode(mc)
+			if err == core.ErrPacketSplit {
+				break
+			}
+			r.Nil(err)
+			if out != nil {
+				msgs = append(msgs, string(out))
+			}
+		}
+	}
+	return msgs
+}
+
+func TestSessionResume(t *testing.T) {
+	r := require.New(t)
+	store := NewSessionStore()
+	client := SessionClientHandshake()
+
+	serverConn, serverCodec, clientConn, clientCodec := sessionHandshake(r, store, client)
+	r.False(serverCodec.Resumed())
+	r.False(clientCodec.Resumed())
+	r.Equal(serverCodec.Token(), clientCodec.Token())
+	writeMessages(r, serverConn, serverCodec, "a", "b")
+	r.Equal([]string{"a", "b"}, readMessages(r, clientConn, clientCodec, 2))
+	// 客户端的消息带着确认号，服务端释放已经确认的帧
+	writeMessages(r, clientConn, clientCodec, "x")
+	r.Equal([]string{"x"}, readMessages(r, serverConn, serverCodec, 1))
+	r.Len(serverCodec.session.unacked, 0)
+
+	// 连接断开前发送的消息没有送达
+	_, err := serverCodec.Encode(nil, []byte("c"))
+	r.Nil(err)
+	_, err = serverCodec.Encode(nil, []byte("d"))
+	r.Nil(err)
+	_ = serverConn.Close()
+	_ = clientConn.Close()
+
+	// 重连后恢复会话并补发
+	newServerConn, newServerCodec, newClientConn, newClientCodec := sessionHandshake(r, store, client)
+	defer newServerConn.Close()
+	defer newClientConn.Close()
+	r.True(newServerCodec.Resumed())
+	r.True(newClientCodec.Resumed())
+	r.Equal(clientCodec.Token(), newClientCodec.Token())
+	r.Equal([]string{"c", "d"}, readMessages(r, newClientConn, newClientCodec, 2))
+	writeMessages(r, newClientConn, newClientCodec, "y")
+	r.Equal([]string{"y"}, readMessages(r, newServerConn, newServerCodec, 1))
+
+	// 旧连接不能继续使用
+	_, err = serverCodec.Encode(nil, []byte("e"))
+	r.Equal(ErrSessionReplaced, err)
+
+	// 重复的帧被忽略，不连续的帧返回错误
+	mc := newMockConn()
+	bb, err := newServerCodec.Encode(mc, []byte("e"))
+	r.Nil(err)
+	mc.MockGetNetworkMsg(bb)
+	mc.MockGetNetworkMsg(bb)
+	out, err := newClientCodec.Decode(mc)
+	r.Nil(err)
+	r.Equal([]byte("e"), out)
+	out, err = newClientCodec.Decode(mc)
+	r.Nil(err)
+	r.Nil(out)
+	_, err = newServerCodec.Encode(mc, []byte("f"))
+	r.Nil(err)
+	bb, err = newServerCodec.Encode(mc, []byte("g"))
+	r.Nil(err)
+	mc.MockGetNetworkMsg(bb)
+	_, err = newClientCodec.Decode(mc)
+	r.Equal(ErrSessionSequence, err)
+
+	// 新客户端创建新会话
+	otherServerConn, otherServerCodec, otherClientConn, _ := sessionHandshake(r, store, SessionClientHandshake())
+	defer otherServerConn.Close()
+	defer otherClientConn.Close()
+	r.False(otherServerCodec.Resumed())
+	r.NotEqual(clientCodec.Token(), otherServerCodec.Token())
+	r.Equal(2, store.Len())
+}
+
+func TestSessionLost(t *testing.T) {
+	r := require.New(t)
+	store := NewSessionStore(WithSessionMaxUnacked(1))
+	client := SessionClientHandshake()
+
+	serverConn, serverCodec, clientConn, clientCodec := sessionHandshake(r, store, client)
+	for _, msg := range []string{"a", "b", "c"} {
+		_, err := serverCodec.Encode(nil, []byte(msg))
+		r.Nil(err)
+	}
+	_ = serverConn.Close()
+	_ = clientConn.Close()
+
+	// 未确认的帧超过上限被丢弃，无法恢复时创建新会话
+	serverConn, serverCodec, clientConn, newClientCodec := sessionHandshake(r, store, client)
+	defer serverConn.Close()
+	defer clientConn.Close()
+	r.False(serverCodec.Resumed())
+	r.False(newClientCodec.Resumed())
+	r.NotEqual(clientCodec.Token(), newClientCodec.Token())
+
+	// 不支持会话的客户端
+	_, _, serverErr, _, _ := runHandshake(SessionServerHandshake(store), func(conn net.Conn) (net.Conn, core.Codec, error) {
+		go func() {
+			_, _ = conn.Write(make([]byte, len(sessionMagic)+sessionTokenSize+8))
+		}()
+		return conn, nil, nil
+	})
+	r.Equal(ErrSessionHandshake, serverErr)
+}
+
+// 加密握手之后的会话握手，令牌不会明文传输
+func TestSessionSecure(t *testing.T) {
+	r := require.New(t)
+	store := NewSessionStore()
+	serverConn, clientConn := tcpPair(r)
+	defer serverConn.Close()
+	defer clientConn.Close()
+	record := &recordConn{Conn: clientConn}
+	done := make(chan struct{})
+	var (
+		serverCodec core.Codec
+		serverErr   error
+	)
+	go func() {
+		defer close(done)
+		_, serverCodec, serverErr = SecureServerHandshake()(serverConn, nil)
+		if serverErr == nil {
+			serverConn, serverCodec, serverErr = SessionServerHandshake(store)(serverConn, serverCodec)
+		}
+	}()
+	conn, clientCodec, clientErr := SecureClientHandshake()(record, nil)
+	r.Nil(clientErr)
+	conn, clientCodec, clientErr = SessionClientHandshake()(conn, clientCodec)
+	<-done
+	r.Nil(serverErr)
+	r.Nil(clientErr)
+	token := clientCodec.(*SessionCodec).Token()
+	r.Equal(token, serverCodec.(*SessionCodec).Token())
+	r.False(bytes.Contains(record.written, token))
+	r.False(bytes.Contains(record.written, sessionMagic))
+
+	writeMessages(r, serverConn, serverCodec, "a")
+	r.Equal([]string{"a"}, readMessages(r, conn, clientCodec, 1))
+}
+
+func TestSessionStoreLimit(t *testing.T) {
+	r := require.New(t)
+	store := NewSessionStore(WithSessionMaxSessions(2), WithSessionTTL(time.Millisecond*40))
+	first, _, err := store.resume(nil, 0)
+	r.Nil(err)
+	_, _, err = store.resume(nil, 0)
+	r.Nil(err)
+	_, _, err = store.resume(nil, 0)
+	r.Equal(ErrSessionLimit, err)
+	// 达到上限时已有的会话仍然可以恢复
+	s, resumed, err := store.resume(first.token, 0)
+	r.Nil(err)
+	r.True(resumed)
+	r.Equal(first, s)
+
+	// 过期的会话按照间隔清理后可以创建新会话
+	time.Sleep(time.Millisecond * 50)
+	_, resumed, err = store.resume(first.token, 0)
+	r.Nil(err)
+	r.False(resumed)
+	r.Equal(1, store.Len())
+}
+
+func TestSessionUnackedBytes(t *testing.T) {
+	r := require.New(t)
+	s := newSession(nil, newSessionOptions([]SessionOption{WithSessionMaxUnackedBytes(100)}))
+	cc := &SessionCodec{codec: new(LengthFieldBasedFrameCodec), session: s}
+	for i := 0; i < 10; i++ {
+		_, err := cc.Encode(nil, make([]byte, 24))
+		r.Nil(err)
+	}
+	// 每帧 40 字节，只保留最后两帧
+	r.Len(s.unacked, 2)
+	r.Equal(80, s.unackedBytes)
+	s.ack(9)
+	r.Len(s.unacked, 1)
+	r.Equal(40, s.unackedBytes)
+	r.False(s.resumable(5))
+	r.True(s.resumable(9))
+}
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"bytes"
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/codec"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// frameAgent 连接后发送一条消息，记录收到的消息和断开次数
+type frameAgent struct {
+	msg      []byte
+	messages int32
+	closes   int32
+}
+
+func (a *frameAgent) OnConnect(conn core.Conn) {
+	_, _ = conn.Write(a.msg)
+}
+
+func (a *frameAgent) OnMessage(_ []byte, _ core.Conn) {
+	atomic.AddInt32(&a.messages, 1)
+}
+
+func (a *frameAgent) OnClose(_ core.Conn) {
+	atomic.AddInt32(&a.closes, 1)
+}
+
+// 测试 tcpnet 和 tcpgnet 使用相同的分帧配置，超过最大长度的帧被拒绝
+func TestNetworkFrameCodec(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	config := codec.LengthFieldConfig{Varint: true, InitialBytesToStrip: codec.StripHeader, MaxFrameLength: 64}
+	serverCodec, err := codec.NewLengthFieldBasedFrameCodec(config)
+	r.Nil(err)
+	config.MaxFrameLength = -1
+	clientCodec, err := codec.NewLengthFieldBasedFrameCodec(config)
+	r.Nil(err)
+
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test network type: %d", typ)
+		_, err = Listen(typ, "127.0.0.1:"+TestPort1, agent.GetEchoAgent, core.WithCodec(serverCodec))
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+
+		small := &frameAgent{msg: []byte("hello")}
+		large := &frameAgent{msg: make([]byte, 100)}
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return small }, core.WithClientCodec(clientCodec))
+		r.Nil(err)
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return large }, core.WithClientCodec(clientCodec))
+		r.Nil(err)
+
+		time.Sleep(WaitMsgSendTime)
+		r.Equal(int32(1), atomic.LoadInt32(&small.messages))
+		r.Zero(atomic.LoadInt32(&small.closes))
+		r.Zero(atomic.LoadInt32(&large.messages))
+		r.Equal(int32(1), atomic.LoadInt32(&large.closes))
+		destroyAfterTest()
+	}
+}
+
+// 测试压缩协商，不支持压缩的旧客户端仍然可以正常通信
+func TestNetworkCompress(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	_, err := Listen(TcpNet, "127.0.0.1:"+TestPort1, agent.GetEchoAgent,
+		core.WithServerHandshake(codec.CompressServerHandshake()))
+	r.Nil(err)
+	time.Sleep(ListenAllowWaitTime)
+
+	// tcpgnet 客户端使用相同的握手流程，可以连接 tcpnet 服务端
+	msg := bytes.Repeat([]byte("state sync packet "), 100)
+	newClient := &frameAgent{msg: msg}
+	oldClient := &frameAgent{msg: msg}
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return newClient },
+			core.WithClientHandshake(codec.CompressClientHandshake()))
+		r.Nil(err)
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return oldClient })
+		r.Nil(err)
+	}
+
+	time.Sleep(WaitMsgSendTime)
+	r.Equal(int32(2), atomic.LoadInt32(&newClient.messages))
+	r.Equal(int32(2), atomic.LoadInt32(&oldClient.messages))
+	r.Zero(atomic.LoadInt32(&newClient.closes))
+	r.Zero(atomic.LoadInt32(&oldClient.closes))
+}
+
+// 测试加密握手和压缩一起使用，不支持加密的客户端被断开
+func TestNetworkSecure(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	_, err := Listen(TcpNet, "127.0.0.1:"+TestPort1, agent.GetEchoAgent,
+		core.WithServerHandshake(codec.SecureServerHandshake()),
+		core.WithServerHandshake(codec.CompressServerHandshake()))
+	r.Nil(err)
+	time.Sleep(ListenAllowWaitTime)
+
+	msg := bytes.Repeat([]byte("state sync packet "), 100)
+	secureClient := &frameAgent{msg: msg}
+	plainClient := &frameAgent{msg: msg}
+	for _, suite := range []codec.CipherSuite{codec.CipherAESGCM, codec.CipherChaCha20Poly1305} {
+		_, err = Connect(TcpNet, "127.0.0.1:"+TestPort1, func() core.Agent { return secureClient },
+			core.WithClientHandshake(codec.SecureClientHandshake(codec.WithCiphers(suite))),
+			core.WithClientHandshake(codec.CompressClientHandshake()))
+		r.Nil(err)
+	}
+	_, err = Connect(TcpNet, "127.0.0.1:"+TestPort1, func() core.Agent { return plainClient })
+	r.Nil(err)
+
+	time.Sleep(WaitMsgSendTime)
+	r.Equal(int32(2), atomic.LoadInt32(&secureClient.messages))
+	r.Zero(atomic.LoadInt32(&secureClient.closes))
+	r.Zero(atomic.LoadInt32(&plainClient.messages))
+	r.Equal(int32(1), atomic.LoadInt32(&plainClient.closes))
+}
@@ -12,3 +12,45 @@ type Agent interface {
 	// OnClose 连接关闭
 	OnClose(conn Conn)
 }
+
+// TimeoutAgent 需要区分超时断开和正常断开的 Agent
+type TimeoutAgent interface {
+	Agent
+	// OnTimeout 连接因为空闲超时（或者对端不可达）被断开，在 OnClose 之前调用
+	OnTimeout(conn Conn)
+}
+
+// NotifyTimeout 如果 Agent 实现了 TimeoutAgent，通知连接超时
+func NotifyTimeout(agent Agent, conn Conn) {
+	if ta, ok := agent.(TimeoutAgent); ok {
+		ta.OnTimeout(conn)
+	}
+}
+
+// KickAgent 需要区分被服务器踢下线和正常断开的 Agent
+type KickAgent interface {
+	Agent
+	// OnKick 连接被 ConnManager.Kick 断开，在 OnClose 之前调用
+	OnKick(conn Conn, reason string)
+}
+
+// NotifyKick 如果 Agent 实现了 KickAgent，通知连接被踢下线
+func NotifyKick(agent Agent, conn Conn, reason string) {
+	if ka, ok := agent.(KickAgent); ok {
+		ka.OnKick(conn, reason)
+	}
+}
+
+// ShutdownAgent 需要在服务器优雅关闭时收到通知的 Agent，例如通知客户端迁移到其他服务器
+type ShutdownAgent interface {
+	Agent
+	// OnShutdown 服务器开始优雅关闭，已经停止接受新连接，连接会在 Shutdown 的 ctx 结束时被强制关闭
+	OnShutdown(conn Conn)
+}
+
+// NotifyShutdown 如果 Agent 实现了 ShutdownAgent，通知服务器开始优雅关闭
+func NotifyShutdown(agent Agent, conn Conn) {
+	if sa, ok := agent.(ShutdownAgent); ok {
+		sa.OnShutdown(conn)
+	}
+}
@@ -0,0 +1,42 @@
+package core
+
+import "reflect"
+
+// FrameCache 广播时按照 Codec 缓存编码后的数据，使用同一个 Codec 的连接只编码一次
+//
+//	多个连接共用的 Codec 编码时不能依赖连接状态，不能并发调用
+type FrameCache struct {
+	msg    []byte
+	frames map[Codec][]byte
+}
+
+// NewFrameCache 创建广播消息 msg 的编码缓存
+func NewFrameCache(msg []byte) *FrameCache {
+	return &FrameCache{
+		msg:    msg,
+		frames: make(map[Codec][]byte),
+	}
+}
+
+// Encode 使用 cc 编码消息，cc 编码过时直接返回缓存的数据，返回的数据不能被修改
+func (fc *FrameCache) Encode(cc Codec, c CodecConn) ([]byte, error) {
+	// 不可比较的 Codec 不能作为 map 的键，每次都编码
+	if !reflect.TypeOf(cc).Comparable() {
+		return cc.Encode(c, fc.msg)
+	}
+	if out, ok := fc.frames[cc]; ok {
+		return out, nil
+	}
+	out, err := cc.Encode(c, fc.msg)
+	if err != nil {
+		return nil, err
+	}
+	fc.frames[cc] = out
+	return out, nil
+}
+
+// FrameWriter 可以直接发送 FrameCache 编码结果的连接，tcpnet 和 tcpgnet 的连接实现了这个接口
+type FrameWriter interface {
+	// WriteFrame 使用连接的 Codec 从 frames 获取编码结果并发送
+	WriteFrame(frames *FrameCache) error
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type countCodec struct {
+	encodes int
+}
+
+func (cc *countCodec) Encode(_ CodecConn, buf []byte) ([]byte, error) {
+	cc.encodes++
+	return append([]byte{byte(cc.encodes)}, buf...), nil
+}
+
+func (cc *countCodec) Decode(_ CodecConn) ([]byte, error) {
+	return nil, nil
+}
+
+func TestFrameCache(t *testing.T) {
+	r := require.New(t)
+	shared := new(countCodec)
+	other := new(countCodec)
+	fc := NewFrameCache([]byte("hello"))
+	for i := 0; i < 3; i++ {
+		out, err := fc.Encode(shared, nil)
+		r.Nil(err)
+		r.Equal([]byte("\x01hello"), out)
+	}
+	out, err := fc.Encode(other, nil)
+	r.Nil(err)
+	r.Equal([]byte("\x01hello"), out)
+	r.Equal(1, shared.encodes)
+	r.Equal(1, other.encodes)
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"crypto/tls"
 	"time"
 )
 
@@ -24,10 +25,26 @@ type ClientOption func(*ClientOptions)
 
 // ClientOptions 配置结构体
 type ClientOptions struct {
-	// 是否自动重连 默认为否
+	// 是否在连接断开后自动重连 默认为否，第一次连接失败时总是按照 ReconnectBackoff 重试
 	Reconnect bool
+	// 重连退避配置
+	ReconnectBackoff ReconnectOptions
+	// 连接状态变化回调
+	StateListener StateListener
 	// 特定客户端参数
 	Context map[string]interface{}
+	// 连接建立后依次执行的握手流程
+	Handshakes []Handshake
+	// TLS 配置，不为 nil 时使用 TLS 连接，在其他握手流程之前完成 TLS 握手
+	TLSConfig *tls.Config
+	// 心跳间隔，超过这个时间没有发送数据时发送心跳包，0 为不发送
+	HeartbeatInterval time.Duration
+	// 空闲超时，超过这个时间没有收到数据时断开连接，0 为不限制
+	IdleTimeout time.Duration
+	// Codec 消息编解码，nil 为默认的 4 字节长度字段分帧，需要和服务端一致
+	Codec Codec
+	// 异步写队列配置
+	WriteQueue WriteQueueOptions
 }
 
 // WithReconnect 重连配置
@@ -37,6 +54,30 @@ func WithReconnect(reconnect bool) ClientOption {
 	}
 }
 
+// WithReconnectBackoff 重连退避配置，jitter 为随机抖动比例
+func WithReconnectBackoff(initial time.Duration, max time.Duration, multiplier float64, jitter float64) ClientOption {
+	return func(o *ClientOptions) {
+		o.ReconnectBackoff.InitialDelay = initial
+		o.ReconnectBackoff.MaxDelay = max
+		o.ReconnectBackoff.Multiplier = multiplier
+		o.ReconnectBackoff.Jitter = jitter
+	}
+}
+
+// WithMaxReconnectAttempts 连续失败的最大次数，达到后通知 ClientGaveUp 并停止连接，0 为不限制
+func WithMaxReconnectAttempts(attempts int) ClientOption {
+	return func(o *ClientOptions) {
+		o.ReconnectBackoff.MaxAttempts = attempts
+	}
+}
+
+// WithClientStateListener 连接状态变化回调配置
+func WithClientStateListener(listener StateListener) ClientOption {
+	return func(o *ClientOptions) {
+		o.StateListener = listener
+	}
+}
+
 // WithClientContext 特定参数配置
 func WithClientContext(context map[string]interface{}) ClientOption {
 	return func(o *ClientOptions) {
@@ -44,10 +85,62 @@ func WithClientContext(context map[string]interface{}) ClientOption {
 	}
 }
 
+// WithClientHandshake 添加握手流程，多次调用时按调用顺序执行
+func WithClientHandshake(handshake Handshake) ClientOption {
+	return func(o *ClientOptions) {
+		o.Handshakes = append(o.Handshakes, handshake)
+	}
+}
+
+// WithClientTLSConfig TLS 配置，没有设置 ServerName 时使用连接地址中的主机名，双向认证时设置 Certificates 或 GetClientCertificate
+func WithClientTLSConfig(config *tls.Config) ClientOption {
+	return func(o *ClientOptions) {
+		o.TLSConfig = config
+	}
+}
+
+// WithClientHeartbeat 心跳间隔配置，对端会过滤心跳包，不会交给 Agent
+func WithClientHeartbeat(interval time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.HeartbeatInterval = interval
+	}
+}
+
+// WithClientIdleTimeout 空闲超时配置，超时断开时先调用 TimeoutAgent.OnTimeout 再调用 OnClose
+func WithClientIdleTimeout(timeout time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.IdleTimeout = timeout
+	}
+}
+
+// WithClientCodec 消息编解码配置，例如 codec.NewLengthFieldBasedFrameCodec 创建的分帧编解码
+func WithClientCodec(codec Codec) ClientOption {
+	return func(o *ClientOptions) {
+		o.Codec = codec
+	}
+}
+
+// WithClientWriteQueue 异步写队列配置，low 大于 high 时使用 high
+func WithClientWriteQueue(high int, low int, policy OverflowPolicy) ClientOption {
+	return func(o *ClientOptions) {
+		o.WriteQueue.HighWatermark = high
+		o.WriteQueue.LowWatermark = low
+		o.WriteQueue.Policy = policy
+	}
+}
+
+// WithClientWriteDeadline 写入超时配置，对端长时间不读取数据时断开连接
+func WithClientWriteDeadline(deadline time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.WriteQueue.WriteDeadline = deadline
+	}
+}
+
 var (
 	// DefaultClientOptions 默认 Client 选项
 	DefaultClientOptions = ClientOptions{
-		Reconnect: false,
-		Context:   nil,
+		Reconnect:        false,
+		ReconnectBackoff: DefaultReconnectOptions,
+		Context:          nil,
 	}
 )
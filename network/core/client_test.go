@@ -3,6 +3,7 @@ package core
 import (
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestWithClientOpt(t *testing.T) {
@@ -17,3 +18,41 @@ func TestWithClientOpt(t *testing.T) {
 	r.Equal(true, options.Reconnect)
 	r.Equal(context, options.Context)
 }
+
+func TestWithClientOptKeepalive(t *testing.T) {
+	options := DefaultClientOptions
+	WithClientHeartbeat(time.Second)(&options)
+	WithClientIdleTimeout(time.Second * 3)(&options)
+	r := require.New(t)
+
+	r.Equal(time.Second, options.HeartbeatInterval)
+	r.Equal(time.Second*3, options.IdleTimeout)
+}
+
+func TestReconnectOptionsDelay(t *testing.T) {
+	r := require.New(t)
+	options := ReconnectOptions{
+		InitialDelay: time.Millisecond * 100,
+		MaxDelay:     time.Second,
+		Multiplier:   2,
+	}
+	r.Equal(time.Millisecond*100, options.Delay(1))
+	r.Equal(time.Millisecond*200, options.Delay(2))
+	r.Equal(time.Millisecond*800, options.Delay(4))
+	r.Equal(time.Second, options.Delay(5))
+	r.Equal(time.Second, options.Delay(100))
+
+	// 抖动在 ±Jitter 比例范围内
+	options.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := options.Delay(2)
+		r.GreaterOrEqual(delay, time.Millisecond*100)
+		r.LessOrEqual(delay, time.Millisecond*300)
+	}
+
+	defaults := DefaultClientOptions
+	WithReconnectBackoff(time.Millisecond, time.Second, 3, 0)(&defaults)
+	WithMaxReconnectAttempts(5)(&defaults)
+	r.Equal(ReconnectOptions{InitialDelay: time.Millisecond, MaxDelay: time.Second, Multiplier: 3, MaxAttempts: 5}, defaults.ReconnectBackoff)
+	r.Equal(DefaultReconnectOptions, DefaultClientOptions.ReconnectBackoff)
+}
@@ -2,17 +2,58 @@ package core
 
 import (
 	"net"
+	"time"
 )
 
 const (
 	TCPMaxPackageSize       = 10240
 	TimeoutTime       int64 = 7000
 	HeartbeatTime     int64 = 2000
+
+	// DefaultHighWatermark 默认写队列高水位（字节）
+	DefaultHighWatermark = 1 << 20
+	// DefaultLowWatermark 默认写队列低水位（字节）
+	DefaultLowWatermark = 1 << 18
+)
+
+// OverflowPolicy 写队列超过高水位后的处理策略，队列降到低水位以下之前持续生效
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞写入，直到队列降到低水位以下或者连接关闭
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop 丢弃消息并返回 ErrWriteQueueFull
+	OverflowDrop
+	// OverflowDisconnect 断开连接并返回 ErrWriteQueueFull，适合不能丢消息的慢客户端
+	OverflowDisconnect
+)
+
+// WriteQueueOptions 异步写队列配置，消息编码后放入队列，由单独的协程合并写入
+type WriteQueueOptions struct {
+	// HighWatermark 高水位（字节），队列中的数据超过高水位后按照 Policy 处理新的消息，0 为不启用写队列
+	HighWatermark int
+	// LowWatermark 低水位（字节），超过高水位后降到低水位以下才恢复正常写入
+	LowWatermark int
+	// Policy 超过高水位后的处理策略
+	Policy OverflowPolicy
+	// WriteDeadline 单次写入 socket 的超时时间，超时后断开连接，0 为不限制，不启用写队列时同样生效
+	WriteDeadline time.Duration
+}
+
+var (
+	// DefaultWriteQueueOptions 没有配置写队列时调用 WriteAsync 使用的配置
+	DefaultWriteQueueOptions = WriteQueueOptions{
+		HighWatermark: DefaultHighWatermark,
+		LowWatermark:  DefaultLowWatermark,
+		Policy:        OverflowDisconnect,
+	}
 )
 
 // Conn 网络连接
 type Conn interface {
 	Object
+	// ID 连接唯一键，连接建立时生成
+	ID() ID
 	// Write 写入并发送数据
 	Write(b []byte) (n int, err error)
 	// LocalAddr 本地地址
@@ -40,3 +81,12 @@ type CodecConn interface {
 	// BufferLength 读取容器数据长度
 	BufferLength() (size int)
 }
+
+// AsyncConn 支持异步写入的连接，tcpnet 和 tcpgnet 的连接实现了这个接口
+type AsyncConn interface {
+	Conn
+	// WriteAsync 编码后放入写队列立即返回，没有配置写队列时使用 DefaultWriteQueueOptions 创建
+	WriteAsync(b []byte) error
+	// Flush 等待写队列中的数据全部写入 socket，连接关闭时返回错误
+	Flush() error
+}
@@ -17,4 +17,20 @@ var (
 	ErrTooMoreLength		= errors.New("buffer too more length")
 	// ErrPacketSplit 网络包传输不完全
 	ErrPacketSplit 			= errors.New("network packet split")
+	// ErrConnNotFound 连接不存在或者已经断开
+	ErrConnNotFound			= errors.New("connection not found")
+	// ErrConnClosed 连接已经关闭
+	ErrConnClosed			= errors.New("connection closed")
+	// ErrWriteQueueFull 写队列超过高水位
+	ErrWriteQueueFull		= errors.New("write queue full")
+	// ErrServerClosed 服务器已经关闭
+	ErrServerClosed			= errors.New("server closed")
+	// ErrConnRejected 连接被准入控制拒绝
+	ErrConnRejected			= errors.New("connection rejected")
+	// ErrInvalidCIDR 不合法的 IP 或者 CIDR
+	ErrInvalidCIDR			= errors.New("invalid ip or cidr")
+	// ErrProxyHeader 不合法的 PROXY 协议头部
+	ErrProxyHeader			= errors.New("invalid proxy protocol header")
+	// ErrProxyTrusted 启用 PROXY 协议时没有设置受信任的地址
+	ErrProxyTrusted			= errors.New("proxy protocol needs trusted addresses")
 )
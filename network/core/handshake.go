@@ -0,0 +1,41 @@
+package core
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// DefaultHandshakeTimeout 默认握手超时时间
+	DefaultHandshakeTimeout = time.Second * 5
+)
+
+// Handshake 连接建立后、交给 Agent 之前执行的握手流程
+//
+// 返回的 net.Conn 会替换原始连接（例如握手时多读取了数据，需要包装后交还），返回的 Codec 会替换这个连接使用的 Codec（例如 WebSocket）
+// 握手需要自行设置读写超时，返回 error 时连接会被直接关闭，不会通知 Agent
+type Handshake func(conn net.Conn, codec Codec) (net.Conn, Codec, error)
+
+// ClosingCodec 连接主动关闭前需要发送数据的 Codec，例如 WebSocket 的 close 帧
+type ClosingCodec interface {
+	Codec
+	// Closing 连接关闭前需要发送给对端的数据，返回 nil 代表不需要发送
+	Closing() []byte
+}
+
+// RunHandshakes 依次执行握手流程，返回最终使用的连接和 Codec，失败时返回的连接仍需要关闭
+func RunHandshakes(handshakes []Handshake, conn net.Conn, codec Codec) (net.Conn, Codec, error) {
+	for _, handshake := range handshakes {
+		newConn, newCodec, err := handshake(conn, codec)
+		if newConn != nil {
+			conn = newConn
+		}
+		if err != nil {
+			return conn, codec, err
+		}
+		if newCodec != nil {
+			codec = newCodec
+		}
+	}
+	return conn, codec, nil
+}
@@ -0,0 +1,388 @@
+package core
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RejectReason 连接被拒绝或者被限流断开的原因
+type RejectReason int
+
+const (
+	// RejectMaxConn 超过服务器最大连接数
+	RejectMaxConn RejectReason = iota
+	// RejectIPFilter IP 在黑名单中或者不在白名单中
+	RejectIPFilter
+	// RejectAcceptRate 超过接受新连接的速率
+	RejectAcceptRate
+	// RejectMaxConnPerIP 超过单个 IP 的最大连接数
+	RejectMaxConnPerIP
+	// RejectAdmission 准入回调返回错误
+	RejectAdmission
+	// RejectMessageRate 连接超过消息速率后被断开
+	RejectMessageRate
+	// RejectBandwidth 连接超过带宽限制后被断开，只有 tcpgnet 会因为带宽断开连接，tcpnet 会暂停读取
+	RejectBandwidth
+
+	rejectReasonCount
+)
+
+// String ...
+func (reason RejectReason) String() string {
+	switch reason {
+	case RejectMaxConn:
+		return "max_conn"
+	case RejectIPFilter:
+		return "ip_filter"
+	case RejectAcceptRate:
+		return "accept_rate"
+	case RejectMaxConnPerIP:
+		return "max_conn_per_ip"
+	case RejectAdmission:
+		return "admission"
+	case RejectMessageRate:
+		return "message_rate"
+	case RejectBandwidth:
+		return "bandwidth"
+	}
+	return "unknown"
+}
+
+// AdmissionFunc 准入回调，在创建 Agent 之前调用，返回错误时关闭连接
+type AdmissionFunc func(remote net.Addr) error
+
+// RejectListener 连接被拒绝时的回调，可以用于上报监控，不能阻塞
+type RejectListener func(remote net.Addr, reason RejectReason)
+
+// RejectStats 可以查询拒绝次数的服务器，tcpnet、tcpgnet 和 websocket 服务器实现了这个接口
+type RejectStats interface {
+	// Rejections 按照原因统计的拒绝次数
+	Rejections() map[RejectReason]uint64
+}
+
+// LimitOptions 连接准入和限流配置，数值为 0 时不限制
+type LimitOptions struct {
+	// MaxConnPerIP 单个 IP 的最大连接数
+	MaxConnPerIP int
+	// AcceptRate 每秒接受的新连接数，AcceptBurst 为允许的突发数量
+	AcceptRate  float64
+	AcceptBurst int
+	// MessageRate 每个连接每秒接收的消息数，MessageBurst 为允许的突发数量，超过时断开连接
+	MessageRate  float64
+	MessageBurst int
+	// Bandwidth 每个连接每秒接收的字节数，BandwidthBurst 为允许的突发字节数
+	Bandwidth      int
+	BandwidthBurst int
+	// Allow IP 白名单，支持 CIDR，不为空时只接受白名单中的 IP
+	Allow []string
+	// Deny IP 黑名单，支持 CIDR，优先于白名单
+	Deny []string
+	// Admission 准入回调
+	Admission AdmissionFunc
+	// OnReject 连接被拒绝时的回调
+	OnReject RejectListener
+}
+
+// TokenBucket 令牌桶，以固定速率补充令牌，最多保留 burst 个
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket 创建令牌桶，初始时令牌是满的，burst 小于 1 时使用 rate（至少为 1）
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	b := float64(burst)
+	if b < 1 {
+		b = rate
+		if b < 1 {
+			b = 1
+		}
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// refill 按照经过的时间补充令牌，调用前需要持有锁
+func (bucket *TokenBucket) refill(now time.Time) {
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+	bucket.last = now
+}
+
+// Allow 取出一个令牌
+func (bucket *TokenBucket) Allow() bool {
+	return bucket.AllowN(1)
+}
+
+// AllowN 令牌足够时取出 n 个令牌并返回 true，否则不取出
+func (bucket *TokenBucket) AllowN(n int) bool {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.refill(time.Now())
+	if bucket.tokens < float64(n) {
+		return false
+	}
+	bucket.tokens -= float64(n)
+	return true
+}
+
+// AllowOverdraft 没有透支时取出 n 个令牌并返回 true，n 可以超过 burst，取出后可以透支；已经透支时不取出并返回 false
+//
+//	用于不能拆分的数据块（例如一次读取的字节数），单次数据块超过 burst 时不会被误判，持续超过速率时仍然会被拒绝
+func (bucket *TokenBucket) AllowOverdraft(n int) bool {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.refill(time.Now())
+	if bucket.tokens < 0 {
+		return false
+	}
+	bucket.tokens -= float64(n)
+	return true
+}
+
+// Reserve 取出 n 个令牌，令牌可以透支，返回补足透支需要等待的时间
+func (bucket *TokenBucket) Reserve(n int) time.Duration {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.refill(time.Now())
+	bucket.tokens -= float64(n)
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// IPFilter IP 黑白名单
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewIPFilter 创建 IP 黑白名单，每一项可以是 IP 或者 CIDR
+func NewIPFilter(allow []string, deny []string) (*IPFilter, error) {
+	filter := new(IPFilter)
+	var err error
+	if filter.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if filter.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// parseCIDRs 解析 IP 或者 CIDR 列表，单个 IP 视为只包含自己的网段
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, ErrInvalidCIDR
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, ErrInvalidCIDR
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Allowed IP 是否允许连接，黑名单优先，白名单为空时允许所有不在黑名单中的 IP
+func (filter *IPFilter) Allowed(ip net.IP) bool {
+	for _, n := range filter.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(filter.allow) == 0 {
+		return true
+	}
+	for _, n := range filter.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Admission 服务器的连接准入控制，由服务器在接受连接时调用，所有方法可以并发调用
+type Admission struct {
+	options LimitOptions
+	filter  *IPFilter
+	accept  *TokenBucket
+
+	mutex sync.Mutex
+	perIP map[string]int
+
+	rejects [rejectReasonCount]uint64
+}
+
+// NewAdmission 创建连接准入控制，IP 黑白名单格式不正确时返回 ErrInvalidCIDR
+func NewAdmission(options LimitOptions) (*Admission, error) {
+	a := &Admission{
+		options: options,
+		perIP:   make(map[string]int),
+	}
+	if len(options.Allow) > 0 || len(options.Deny) > 0 {
+		filter, err := NewIPFilter(options.Allow, options.Deny)
+		if err != nil {
+			return nil, err
+		}
+		a.filter = filter
+	}
+	if options.AcceptRate > 0 {
+		a.accept = NewTokenBucket(options.AcceptRate, options.AcceptBurst)
+	}
+	return a, nil
+}
+
+// Accept 检查 IP 黑白名单、接受速率和单个 IP 的连接数，通过时占用一个 IP 连接数，连接关闭时需要调用 Release
+func (a *Admission) Accept(remote net.Addr) error {
+	host := addrHost(remote)
+	if a.filter != nil && !a.filter.Allowed(net.ParseIP(host)) {
+		a.Reject(remote, RejectIPFilter)
+		return ErrConnRejected
+	}
+	if a.accept != nil && !a.accept.Allow() {
+		a.Reject(remote, RejectAcceptRate)
+		return ErrConnRejected
+	}
+	a.mutex.Lock()
+	if a.options.MaxConnPerIP > 0 && a.perIP[host] >= a.options.MaxConnPerIP {
+		a.mutex.Unlock()
+		a.Reject(remote, RejectMaxConnPerIP)
+		return ErrConnRejected
+	}
+	a.perIP[host]++
+	a.mutex.Unlock()
+	return nil
+}
+
+// Admit 调用准入回调，返回回调的错误
+func (a *Admission) Admit(remote net.Addr) error {
+	if a.options.Admission == nil {
+		return nil
+	}
+	if err := a.options.Admission(remote); err != nil {
+		a.Reject(remote, RejectAdmission)
+		return err
+	}
+	return nil
+}
+
+// Release 释放 Accept 占用的 IP 连接数
+func (a *Admission) Release(remote net.Addr) {
+	host := addrHost(remote)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.perIP[host] <= 1 {
+		delete(a.perIP, host)
+	} else {
+		a.perIP[host]--
+	}
+}
+
+// Reject 记录一次拒绝并通知 OnReject
+func (a *Admission) Reject(remote net.Addr, reason RejectReason) {
+	atomic.AddUint64(&a.rejects[reason], 1)
+	if a.options.OnReject != nil {
+		a.options.OnReject(remote, reason)
+	}
+}
+
+// Rejections 按照原因统计的拒绝次数，只包含发生过的原因
+func (a *Admission) Rejections() map[RejectReason]uint64 {
+	rejections := make(map[RejectReason]uint64)
+	for reason := RejectReason(0); reason < rejectReasonCount; reason++ {
+		if n := atomic.LoadUint64(&a.rejects[reason]); n > 0 {
+			rejections[reason] = n
+		}
+	}
+	return rejections
+}
+
+// NewConnLimiter 创建单个连接的限流器，没有配置消息速率和带宽时返回 nil
+func (a *Admission) NewConnLimiter(remote net.Addr) *ConnLimiter {
+	if a.options.MessageRate <= 0 && a.options.Bandwidth <= 0 {
+		return nil
+	}
+	limiter := &ConnLimiter{admission: a, remote: remote}
+	if a.options.MessageRate > 0 {
+		limiter.message = NewTokenBucket(a.options.MessageRate, a.options.MessageBurst)
+	}
+	if a.options.Bandwidth > 0 {
+		limiter.bandwidth = NewTokenBucket(float64(a.options.Bandwidth), a.options.BandwidthBurst)
+	}
+	return limiter
+}
+
+// ConnLimiter 单个连接的消息速率和带宽限制，nil 时不限制
+type ConnLimiter struct {
+	admission *Admission
+	remote    net.Addr
+	message   *TokenBucket
+	bandwidth *TokenBucket
+}
+
+// AllowMessage 收到一条消息，超过消息速率时记录拒绝并返回 false，连接需要断开
+func (limiter *ConnLimiter) AllowMessage() bool {
+	if limiter == nil || limiter.message == nil || limiter.message.Allow() {
+		return true
+	}
+	limiter.admission.Reject(limiter.remote, RejectMessageRate)
+	return false
+}
+
+// ReserveBytes 收到 n 个字节，返回为了不超过带宽需要暂停读取的时间
+func (limiter *ConnLimiter) ReserveBytes(n int) time.Duration {
+	if limiter == nil || limiter.bandwidth == nil {
+		return 0
+	}
+	return limiter.bandwidth.Reserve(n)
+}
+
+// AllowBytes 收到 n 个字节，超过带宽时记录拒绝并返回 false，用于不能暂停读取的连接
+//
+//	单次读取可以超过 burst，超出的部分记为透支，透支没有补足前再次收到数据时才拒绝
+func (limiter *ConnLimiter) AllowBytes(n int) bool {
+	if limiter == nil || limiter.bandwidth == nil || limiter.bandwidth.AllowOverdraft(n) {
+		return true
+	}
+	limiter.admission.Reject(limiter.remote, RejectBandwidth)
+	return false
+}
+
+// addrHost 地址中的主机部分，用于按照 IP 统计
+func addrHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	r := require.New(t)
+	bucket := NewTokenBucket(100, 5)
+	for i := 0; i < 5; i++ {
+		r.True(bucket.Allow())
+	}
+	r.False(bucket.Allow())
+	time.Sleep(time.Millisecond * 25)
+	r.True(bucket.AllowN(2))
+	r.False(bucket.AllowN(5))
+
+	// 透支后返回需要等待的时间
+	bucket = NewTokenBucket(1000, 100)
+	r.Equal(time.Duration(0), bucket.Reserve(100))
+	wait := bucket.Reserve(100)
+	r.Greater(wait, time.Millisecond*90)
+	r.LessOrEqual(wait, time.Millisecond*100)
+
+	// 单次超过 burst 的数据块允许透支，透支补足前拒绝
+	bucket = NewTokenBucket(1000, 0)
+	r.True(bucket.AllowOverdraft(1500))
+	r.False(bucket.AllowOverdraft(1))
+	time.Sleep(time.Millisecond * 600)
+	r.True(bucket.AllowOverdraft(1))
+}
+
+func TestIPFilter(t *testing.T) {
+	r := require.New(t)
+	filter, err := NewIPFilter([]string{"10.0.0.0/8", "192.168.1.1"}, []string{"10.0.1.0/24", "::1"})
+	r.Nil(err)
+	r.True(filter.Allowed(net.ParseIP("10.1.2.3")))
+	r.True(filter.Allowed(net.ParseIP("192.168.1.1")))
+	r.False(filter.Allowed(net.ParseIP("192.168.1.2")))
+	r.False(filter.Allowed(net.ParseIP("10.0.1.5")))
+	r.False(filter.Allowed(net.ParseIP("::1")))
+
+	filter, err = NewIPFilter(nil, []string{"127.0.0.0/8"})
+	r.Nil(err)
+	r.False(filter.Allowed(net.ParseIP("127.0.0.1")))
+	r.True(filter.Allowed(net.ParseIP("8.8.8.8")))
+
+	_, err = NewIPFilter([]string{"10.0.0.0/33"}, nil)
+	r.Equal(ErrInvalidCIDR, err)
+	_, err = NewIPFilter(nil, []string{"localhost"})
+	r.Equal(ErrInvalidCIDR, err)
+}
+
+func TestAdmission(t *testing.T) {
+	r := require.New(t)
+	rejected := make([]RejectReason, 0)
+	errDenied := errors.New("denied")
+	admission, err := NewAdmission(LimitOptions{
+		MaxConnPerIP: 2,
+		Deny:         []string{"10.0.0.0/8"},
+		MessageRate:  10,
+		MessageBurst: 2,
+		Admission: func(remote net.Addr) error {
+			if remote.(*net.TCPAddr).Port == 1 {
+				return errDenied
+			}
+			return nil
+		},
+		OnReject: func(_ net.Addr, reason RejectReason) {
+			rejected = append(rejected, reason)
+		},
+	})
+	r.Nil(err)
+
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2}
+	r.Nil(admission.Accept(addr))
+	r.Nil(admission.Accept(addr))
+	r.Equal(ErrConnRejected, admission.Accept(addr))
+	admission.Release(addr)
+	r.Nil(admission.Accept(addr))
+	r.Equal(ErrConnRejected, admission.Accept(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2}))
+	r.Nil(admission.Admit(addr))
+	r.Equal(errDenied, admission.Admit(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1}))
+
+	// 超过消息速率
+	limiter := admission.NewConnLimiter(addr)
+	r.True(limiter.AllowMessage())
+	r.True(limiter.AllowMessage())
+	r.False(limiter.AllowMessage())
+	r.Equal(time.Duration(0), limiter.ReserveBytes(1<<20))
+	r.True(limiter.AllowBytes(1 << 20))
+	var none *ConnLimiter
+	r.True(none.AllowMessage())
+
+	r.Equal([]RejectReason{RejectMaxConnPerIP, RejectIPFilter, RejectAdmission, RejectMessageRate}, rejected)
+	r.Equal(map[RejectReason]uint64{
+		RejectMaxConnPerIP: 1,
+		RejectIPFilter:     1,
+		RejectAdmission:    1,
+		RejectMessageRate:  1,
+	}, admission.Rejections())
+
+	_, err = NewAdmission(LimitOptions{Allow: []string{"bad"}})
+	r.Equal(ErrInvalidCIDR, err)
+	admission, err = NewAdmission(LimitOptions{})
+	r.Nil(err)
+	r.Nil(admission.NewConnLimiter(addr))
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ProxyHeaderTimeout 等待 PROXY 协议头部的超时时间
+	ProxyHeaderTimeout = time.Second * 5
+
+	// v1 头部最大长度，包括结尾的 \r\n
+	proxyV1MaxLength = 107
+	// v1 头部最小长度，"PROXY UNKNOWN\r\n"
+	proxyV1MinLength = 15
+	// v2 固定头部长度，签名、版本和命令、地址族、长度
+	proxyV2HeaderLength = 16
+)
+
+// PROXY 协议 v2 的 TLV 类型
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a}
+)
+
+// ProxyTLV PROXY 协议 v2 的扩展字段
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader PROXY 协议头部，负载均衡在连接建立后发送，包含客户端的真实地址
+type ProxyHeader struct {
+	// Version 协议版本，1 或者 2
+	Version int
+	// Local 代理自己发起的连接（例如健康检查），没有客户端地址
+	Local bool
+	// Source 客户端地址，Local 或者地址族未知时为 nil
+	Source net.Addr
+	// Destination 客户端连接的代理地址，Local 或者地址族未知时为 nil
+	Destination net.Addr
+	// TLVs v2 的扩展字段
+	TLVs []ProxyTLV
+}
+
+// TLV 查找第一个指定类型的扩展字段
+func (header *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range header.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ProxyConn 支持 PROXY 协议的连接，tcpnet 和 tcpgnet 的连接实现了这个接口
+type ProxyConn interface {
+	Conn
+	// ProxyHeader PROXY 协议头部，没有启用 PROXY 协议或者连接来自不受信任的地址时返回 nil
+	ProxyHeader() *ProxyHeader
+}
+
+// ParseProxyHeader 解析 v1 或者 v2 的 PROXY 协议头部，返回头部和头部的长度，数据不完整时返回 ErrPacketSplit
+func ParseProxyHeader(b []byte) (*ProxyHeader, int, error) {
+	if len(b) == 0 {
+		return nil, 0, ErrPacketSplit
+	}
+	if b[0] == proxyV2Signature[0] {
+		return parseProxyV2(b)
+	}
+	return parseProxyV1(b)
+}
+
+// parseProxyV1 解析文本格式，例如 "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func parseProxyV1(b []byte) (*ProxyHeader, int, error) {
+	if !bytes.HasPrefix(b, proxyV1Prefix) && !bytes.HasPrefix(proxyV1Prefix, b) {
+		return nil, 0, ErrProxyHeader
+	}
+	end := bytes.IndexByte(b, '\n')
+	if end < 0 {
+		if len(b) >= proxyV1MaxLength {
+			return nil, 0, ErrProxyHeader
+		}
+		return nil, 0, ErrPacketSplit
+	}
+	if end+1 > proxyV1MaxLength || end < 1 || b[end-1] != '\r' {
+		return nil, 0, ErrProxyHeader
+	}
+	header := &ProxyHeader{Version: 1}
+	fields := strings.Split(string(b[len(proxyV1Prefix):end-1]), " ")
+	switch fields[0] {
+	case "UNKNOWN":
+		// 地址族未知时忽略之后的内容
+		return header, end + 1, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+	if len(fields) != 5 {
+		return nil, 0, ErrProxyHeader
+	}
+	source, err := parseProxyV1Addr(fields[0], fields[1], fields[3])
+	if err != nil {
+		return nil, 0, err
+	}
+	destination, err := parseProxyV1Addr(fields[0], fields[2], fields[4])
+	if err != nil {
+		return nil, 0, err
+	}
+	header.Source = source
+	header.Destination = destination
+	return header, end + 1, nil
+}
+
+func parseProxyV1Addr(protocol string, ip string, port string) (net.Addr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || (protocol == "TCP4") != (addr.To4() != nil && !strings.Contains(ip, ":")) {
+		return nil, ErrProxyHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrProxyHeader
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+// parseProxyV2 解析二进制格式
+func parseProxyV2(b []byte) (*ProxyHeader, int, error) {
+	n := len(proxyV2Signature)
+	if len(b) < n {
+		n = len(b)
+	}
+	if !bytes.Equal(b[:n], proxyV2Signature[:n]) {
+		return nil, 0, ErrProxyHeader
+	}
+	if len(b) < proxyV2HeaderLength {
+		return nil, 0, ErrPacketSplit
+	}
+	length := proxyV2HeaderLength + int(binary.BigEndian.Uint16(b[14:16]))
+	if len(b) < length {
+		return nil, 0, ErrPacketSplit
+	}
+	if b[12]>>4 != 2 {
+		return nil, 0, ErrProxyHeader
+	}
+	header := &ProxyHeader{Version: 2}
+	switch b[12] & 0x0f {
+	case 0x00:
+		header.Local = true
+	case 0x01:
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+
+	payload := b[proxyV2HeaderLength:length]
+	var size int
+	switch b[13] >> 4 {
+	case 0x0:
+	case 0x1:
+		size = 12
+		if len(payload) < size {
+			return nil, 0, ErrProxyHeader
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(append([]byte{}, payload[0:4]...)), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(append([]byte{}, payload[4:8]...)), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case 0x2:
+		size = 36
+		if len(payload) < size {
+			return nil, 0, ErrProxyHeader
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(append([]byte{}, payload[0:16]...)), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(append([]byte{}, payload[16:32]...)), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	case 0x3:
+		size = 216
+		if len(payload) < size {
+			return nil, 0, ErrProxyHeader
+		}
+		header.Source = &net.UnixAddr{Name: unixPath(payload[0:108]), Net: "unix"}
+		header.Destination = &net.UnixAddr{Name: unixPath(payload[108:216]), Net: "unix"}
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+	if header.Local {
+		// LOCAL 命令的地址没有意义，使用连接本身的地址
+		header.Source = nil
+		header.Destination = nil
+	}
+
+	for tlvs := payload[size:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, 0, ErrProxyHeader
+		}
+		l := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+l {
+			return nil, 0, ErrProxyHeader
+		}
+		header.TLVs = append(header.TLVs, ProxyTLV{Type: tlvs[0], Value: append([]byte{}, tlvs[3:3+l]...)})
+		tlvs = tlvs[3+l:]
+	}
+	return header, length, nil
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// ReadProxyHeader 从连接读取 PROXY 协议头部，不会读取头部之后的数据
+func ReadProxyHeader(conn net.Conn) (*ProxyHeader, error) {
+	buf := make([]byte, proxyV1MinLength, proxyV1MaxLength)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	for {
+		header, _, err := ParseProxyHeader(buf)
+		if err != ErrPacketSplit {
+			return header, err
+		}
+		// v1 逐个字节读取到换行，v2 按照头部中的长度读取
+		need := 1
+		if buf[0] == proxyV2Signature[0] {
+			if len(buf) < proxyV2HeaderLength {
+				need = proxyV2HeaderLength - len(buf)
+			} else {
+				need = proxyV2HeaderLength + int(binary.BigEndian.Uint16(buf[14:16])) - len(buf)
+			}
+		}
+		n := len(buf)
+		buf = append(buf, make([]byte, need)...)
+		if _, err = io.ReadFull(conn, buf[n:]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ProxyProtocol PROXY 协议配置，只解析来自受信任地址的连接，其他连接直接使用
+type ProxyProtocol struct {
+	trusted *IPFilter
+}
+
+// NewProxyProtocol 创建 PROXY 协议配置，trusted 为受信任的负载均衡地址（IP 或者 CIDR）
+//
+//	trusted 为空时返回 ErrProxyTrusted，否则任何客户端都可以发送头部伪造自己的地址，绕过单 IP 限制和黑名单
+func NewProxyProtocol(trusted []string) (*ProxyProtocol, error) {
+	if len(trusted) == 0 {
+		return nil, ErrProxyTrusted
+	}
+	filter, err := NewIPFilter(trusted, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyProtocol{trusted: filter}, nil
+}
+
+// Trusted 连接是否来自受信任的地址，受信任的连接必须发送 PROXY 协议头部
+func (proxy *ProxyProtocol) Trusted(remote net.Addr) bool {
+	return proxy.trusted.Allowed(net.ParseIP(addrHost(remote)))
+}
+
+// Accept 读取受信任连接的 PROXY 协议头部，返回使用真实地址的连接，不受信任的连接原样返回，header 为 nil
+func (proxy *ProxyProtocol) Accept(conn net.Conn) (net.Conn, *ProxyHeader, error) {
+	if !proxy.Trusted(conn.RemoteAddr()) {
+		return conn, nil, nil
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+	header, err := ReadProxyHeader(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return conn, nil, err
+	}
+	return NewProxyConn(conn, header), header, nil
+}
+
+// NewProxyConn 包装连接，RemoteAddr 和 LocalAddr 返回头部中的地址，头部没有地址时返回连接本身的地址
+func NewProxyConn(conn net.Conn, header *ProxyHeader) net.Conn {
+	return &proxyConn{Conn: conn, header: header}
+}
+
+type proxyConn struct {
+	net.Conn
+	header *ProxyHeader
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	if conn.header.Source != nil {
+		return conn.header.Source
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+func (conn *proxyConn) LocalAddr() net.Addr {
+	if conn.header.Destination != nil {
+		return conn.header.Destination
+	}
+	return conn.Conn.LocalAddr()
+}
+
+// NetConn 被包装的连接
+func (conn *proxyConn) NetConn() net.Conn {
+	return conn.Conn
+}
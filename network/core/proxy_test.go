@@ -0,0 +1,164 @@
+package core
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+// proxyV2 构造 v2 头部，payload 为地址和 TLV
+func proxyV2(cmd byte, fam byte, payload []byte) []byte {
+	b := append([]byte{}, proxyV2Signature...)
+	b = append(b, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:16], uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func TestParseProxyHeaderV1(t *testing.T) {
+	r := require.New(t)
+	b := []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nhello")
+	header, n, err := ParseProxyHeader(b)
+	r.Nil(err)
+	r.Equal(len(b)-5, n)
+	r.Equal(1, header.Version)
+	r.Equal("192.168.0.1:56324", header.Source.String())
+	r.Equal("10.0.0.1:443", header.Destination.String())
+
+	header, _, err = ParseProxyHeader([]byte("PROXY TCP6 2001:db8::1 ::1 1000 2000\r\n"))
+	r.Nil(err)
+	r.Equal("[2001:db8::1]:1000", header.Source.String())
+
+	header, n, err = ParseProxyHeader([]byte("PROXY UNKNOWN ffff::1 ::1 1 2\r\n"))
+	r.Nil(err)
+	r.Equal(31, n)
+	r.Nil(header.Source)
+
+	// 数据不完整
+	for _, s := range []string{"PRO", "PROXY TCP4 192.168.0.1", "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r"} {
+		_, _, err = ParseProxyHeader([]byte(s))
+		r.Equal(ErrPacketSplit, err, s)
+	}
+	for _, s := range []string{
+		"GET / HTTP/1.1\r\n",
+		"PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n",
+		"PROXY TCP4 ::1 10.0.0.1 56324 443\r\n",
+		"PROXY TCP4 192.168.0.1 10.0.0.1 65536 443\r\n",
+		"PROXY UDP4 192.168.0.1 10.0.0.1 56324 443\r\n",
+		"PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\n",
+	} {
+		_, _, err = ParseProxyHeader([]byte(s))
+		r.Equal(ErrProxyHeader, err, s)
+	}
+	_, _, err = ParseProxyHeader(append([]byte("PROXY TCP4 "), make([]byte, proxyV1MaxLength)...))
+	r.Equal(ErrProxyHeader, err)
+}
+
+func TestParseProxyHeaderV2(t *testing.T) {
+	r := require.New(t)
+	payload := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	payload = append(payload, ProxyTLVAuthority, 0, 11)
+	payload = append(payload, "example.com"...)
+	payload = append(payload, ProxyTLVNoop, 0, 0)
+	b := proxyV2(0x1, 0x11, payload)
+	header, n, err := ParseProxyHeader(append(b, "hello"...))
+	r.Nil(err)
+	r.Equal(len(b), n)
+	r.Equal(2, header.Version)
+	r.False(header.Local)
+	r.Equal("192.168.0.1:56324", header.Source.String())
+	r.Equal("10.0.0.1:443", header.Destination.String())
+	r.Len(header.TLVs, 2)
+	authority, ok := header.TLV(ProxyTLVAuthority)
+	r.True(ok)
+	r.Equal("example.com", string(authority))
+	_, ok = header.TLV(ProxyTLVUniqueID)
+	r.False(ok)
+
+	// 数据不完整
+	for i := 1; i < len(b); i++ {
+		_, _, err = ParseProxyHeader(b[:i])
+		r.Equal(ErrPacketSplit, err, i)
+	}
+
+	ipv6 := make([]byte, 36)
+	ipv6[15] = 1
+	copy(ipv6[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(ipv6[32:], 1000)
+	header, _, err = ParseProxyHeader(proxyV2(0x1, 0x21, ipv6))
+	r.Nil(err)
+	r.Equal("[::1]:1000", header.Source.String())
+	r.Equal("[2001:db8::2]:0", header.Destination.String())
+
+	header, _, err = ParseProxyHeader(proxyV2(0x0, 0x11, payload[:12]))
+	r.Nil(err)
+	r.True(header.Local)
+	r.Nil(header.Source)
+
+	for _, bad := range [][]byte{
+		proxyV2(0x2, 0x11, payload[:12]),
+		proxyV2(0x1, 0x11, payload[:8]),
+		proxyV2(0x1, 0x11, append(append([]byte{}, payload[:12]...), ProxyTLVNoop, 0, 5)),
+		{0x0d, 0x0a, 0x0d, 0x0a, 0x01},
+	} {
+		_, _, err = ParseProxyHeader(bad)
+		r.Equal(ErrProxyHeader, err)
+	}
+}
+
+func TestProxyProtocolAccept(t *testing.T) {
+	r := require.New(t)
+	proxy, err := NewProxyProtocol([]string{"10.0.0.0/8"})
+	r.Nil(err)
+	r.True(proxy.Trusted(&net.TCPAddr{IP: net.ParseIP("10.1.1.1")}))
+	r.False(proxy.Trusted(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}))
+	_, err = NewProxyProtocol([]string{"bad"})
+	r.Equal(ErrInvalidCIDR, err)
+
+	// 没有受信任的地址时任何客户端都可以伪造地址
+	_, err = NewProxyProtocol(nil)
+	r.Equal(ErrProxyTrusted, err)
+
+	// net.Pipe 的地址不是 IP，使用没有限制的 IPFilter 解析头部
+	proxy = &ProxyProtocol{trusted: new(IPFilter)}
+	server, client := net.Pipe()
+	go func() {
+		_, _ = client.Write([]byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"))
+		_, _ = client.Write([]byte("hello"))
+	}()
+	conn, header, err := proxy.Accept(server)
+	r.Nil(err)
+	r.Equal("192.168.0.1:56324", conn.RemoteAddr().String())
+	r.Equal("10.0.0.1:443", conn.LocalAddr().String())
+	r.Equal(header.Source, conn.RemoteAddr())
+	r.Equal(server, conn.(interface{ NetConn() net.Conn }).NetConn())
+	// 头部之后的数据没有被读取
+	buf := make([]byte, 5)
+	_, err = conn.Read(buf)
+	r.Nil(err)
+	r.Equal("hello", string(buf))
+	_ = client.Close()
+	_ = server.Close()
+
+	server, client = net.Pipe()
+	go func() {
+		_, _ = client.Write(proxyV2(0x1, 0x11, []byte{1, 2, 3, 4, 5, 6, 7, 8, 0, 1, 0, 2, ProxyTLVUniqueID, 0, 1, 9}))
+	}()
+	conn, header, err = proxy.Accept(server)
+	r.Nil(err)
+	r.Equal("1.2.3.4:1", conn.RemoteAddr().String())
+	id, ok := header.TLV(ProxyTLVUniqueID)
+	r.True(ok)
+	r.Equal([]byte{9}, id)
+	_ = client.Close()
+	_ = server.Close()
+
+	server, client = net.Pipe()
+	go func() {
+		_, _ = client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	}()
+	_, _, err = proxy.Accept(server)
+	r.Equal(ErrProxyHeader, err)
+	_ = client.Close()
+	_ = server.Close()
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ClientState 客户端连接状态
+type ClientState int
+
+const (
+	// ClientConnecting 开始一次连接尝试
+	ClientConnecting ClientState = iota
+	// ClientConnected 连接和握手都已完成
+	ClientConnected
+	// ClientDisconnected 已经建立的连接断开，开启重连时之后按照退避策略重连
+	ClientDisconnected
+	// ClientGaveUp 连续失败次数达到 MaxAttempts，客户端不再尝试连接
+	ClientGaveUp
+)
+
+// String ...
+func (state ClientState) String() string {
+	switch state {
+	case ClientConnecting:
+		return "connecting"
+	case ClientConnected:
+		return "connected"
+	case ClientDisconnected:
+		return "disconnected"
+	case ClientGaveUp:
+		return "gave up"
+	}
+	return "unknown"
+}
+
+// StateListener 客户端状态变化回调，err 为 ClientGaveUp 时最后一次连接失败的原因，其他状态为 nil
+//
+//	回调在客户端的连接协程中执行，不能阻塞
+type StateListener func(state ClientState, err error)
+
+// ReconnectOptions 重连退避配置
+//
+//	第 n 次重试前等待 min(InitialDelay * Multiplier^(n-1), MaxDelay)，再加上 ±Jitter 比例的随机抖动，避免大量客户端同时重连
+type ReconnectOptions struct {
+	// InitialDelay 第一次重试前的等待时间
+	InitialDelay time.Duration
+	// MaxDelay 最大等待时间
+	MaxDelay time.Duration
+	// Multiplier 每次重试等待时间的倍数，小于 1 时为 1
+	Multiplier float64
+	// Jitter 随机抖动比例，范围 [0, 1]
+	Jitter float64
+	// MaxAttempts 连续失败的最大次数，0 为不限制，连接成功后重新计数
+	MaxAttempts int
+}
+
+var (
+	// DefaultReconnectOptions 默认重连退避配置
+	DefaultReconnectOptions = ReconnectOptions{
+		InitialDelay: DefaultConnectWaitStart,
+		MaxDelay:     DefaultConnectMaxWait,
+		Multiplier:   DefaultConnectWaitMut,
+		Jitter:       0.2,
+	}
+)
+
+// Delay 第 attempt 次重试（从 1 开始）前的等待时间
+func (options ReconnectOptions) Delay(attempt int) time.Duration {
+	delay := float64(options.InitialDelay)
+	maxDelay := float64(options.MaxDelay)
+	if maxDelay <= 0 {
+		maxDelay = delay
+	}
+	for i := 1; i < attempt && delay < maxDelay && options.Multiplier > 1; i++ {
+		delay *= options.Multiplier
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if jitter := options.Jitter; jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay += delay * jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(delay)
+}
+
+// Reconnector 客户端连接循环的重连状态，记录连续失败次数、计算退避时间并通知状态变化，只在客户端的连接协程中使用
+type Reconnector struct {
+	options   ReconnectOptions
+	reconnect bool
+	listener  StateListener
+
+	started  bool
+	failures int
+	lastErr  error
+}
+
+// NewReconnector 使用客户端配置创建重连状态
+func NewReconnector(options ClientOptions) *Reconnector {
+	return &Reconnector{
+		options:   options.ReconnectBackoff,
+		reconnect: options.Reconnect,
+		listener:  options.StateListener,
+	}
+}
+
+// Next 等待下一次连接尝试，第一次连接不等待
+//
+//	收到 closeSig 时返回 false；连续失败次数达到 MaxAttempts 时通知 ClientGaveUp 并返回 false
+func (r *Reconnector) Next(closeSig <-chan bool) bool {
+	if r.options.MaxAttempts > 0 && r.failures >= r.options.MaxAttempts {
+		r.notify(ClientGaveUp, r.lastErr)
+		return false
+	}
+	if r.started {
+		select {
+		case <-closeSig:
+			return false
+		case <-time.After(r.options.Delay(r.failures + 1)):
+		}
+	} else {
+		select {
+		case <-closeSig:
+			return false
+		default:
+		}
+	}
+	r.started = true
+	r.notify(ClientConnecting, nil)
+	return true
+}
+
+// Failed 连接或者握手失败
+func (r *Reconnector) Failed(err error) {
+	r.failures++
+	r.lastErr = err
+}
+
+// Connected 连接成功，重新计算失败次数
+func (r *Reconnector) Connected() {
+	r.failures = 0
+	r.lastErr = nil
+	r.notify(ClientConnected, nil)
+}
+
+// Disconnected 已经建立的连接断开，返回是否需要重连
+func (r *Reconnector) Disconnected() bool {
+	r.notify(ClientDisconnected, nil)
+	return r.reconnect
+}
+
+func (r *Reconnector) notify(state ClientState, err error) {
+	if r.listener != nil {
+		r.listener(state, err)
+	}
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"context"
+	"crypto/tls"
 	"time"
 )
 
@@ -19,6 +21,29 @@ type Server interface {
 	GetConnNum() (num int)
 }
 
+// GracefulServer 支持优雅关闭的服务器，tcpnet、tcpgnet 和 websocket 服务器实现了这个接口
+type GracefulServer interface {
+	Server
+	// Shutdown 停止接受新连接并通知 ShutdownAgent，等待所有连接断开，ctx 结束时强制关闭剩余的连接并返回 ctx.Err()
+	//
+	//	ctx 已经结束时不通知 Agent，直接强制关闭；服务器已经关闭时返回 ErrServerClosed
+	Shutdown(ctx context.Context) error
+}
+
+// ConnManager 可以按照连接 ID 管理连接的服务器，tcpnet、tcpgnet 和 websocket 服务器实现了这个接口
+type ConnManager interface {
+	// GetConn 获取连接，不存在时返回 nil
+	GetConn(id ID) Conn
+	// Kick 断开连接，Agent 实现了 KickAgent 时先调用 OnKick，连接不存在时返回 ErrConnNotFound
+	Kick(id ID, reason string) error
+	// Range 遍历所有连接，f 返回 false 时停止遍历，f 中可以调用 Kick
+	Range(f func(conn Conn) bool)
+	// Broadcast 向 filter 返回 true 的连接发送消息，filter 为 nil 时发送给所有连接，返回发送成功的连接数
+	//
+	//	使用同一个 Codec 的连接只编码一次，握手后使用独立 Codec 的连接（例如加密）逐个编码
+	Broadcast(msg []byte, filter func(conn Conn) bool) (num int)
+}
+
 // ServerOption 服务器配置项
 type ServerOption func(*ServerOptions)
 
@@ -28,6 +53,24 @@ type ServerOptions struct {
 	MaxConnNum int
 	// 特定服务器参数
 	Context map[string]interface{}
+	// 连接建立后依次执行的握手流程
+	Handshakes []Handshake
+	// TLS 配置，不为 nil 时所有连接使用 TLS，在其他握手流程之前完成 TLS 握手
+	TLSConfig *tls.Config
+	// 心跳间隔，超过这个时间没有发送数据时发送心跳包，0 为不发送
+	HeartbeatInterval time.Duration
+	// 空闲超时，超过这个时间没有收到数据时断开连接，0 为不限制
+	IdleTimeout time.Duration
+	// Codec 消息编解码，nil 为默认的 4 字节长度字段分帧，tcpnet 和 tcpgnet 使用相同的编解码
+	Codec Codec
+	// 异步写队列配置
+	WriteQueue WriteQueueOptions
+	// 连接准入和限流配置
+	Limit LimitOptions
+	// 是否解析 PROXY 协议头部，启用后 RemoteAddr、IP 限制和日志使用头部中的客户端地址
+	ProxyProtocol bool
+	// 发送 PROXY 协议头部的受信任负载均衡地址，支持 CIDR，启用时不能为空
+	ProxyTrusted []string
 }
 
 // WithMaxConnNum 最大连接数配置
@@ -44,6 +87,126 @@ func WithServerContext(context map[string]interface{}) ServerOption {
 	}
 }
 
+// WithServerHandshake 添加握手流程，多次调用时按调用顺序执行
+func WithServerHandshake(handshake Handshake) ServerOption {
+	return func(o *ServerOptions) {
+		o.Handshakes = append(o.Handshakes, handshake)
+	}
+}
+
+// WithTLSConfig TLS 配置，需要双向认证时设置 ClientAuth 和 ClientCAs，证书热更新使用 CertReloader.GetCertificate
+func WithTLSConfig(config *tls.Config) ServerOption {
+	return func(o *ServerOptions) {
+		o.TLSConfig = config
+	}
+}
+
+// WithHeartbeat 心跳间隔配置，对端会过滤心跳包，不会交给 Agent
+func WithHeartbeat(interval time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.HeartbeatInterval = interval
+	}
+}
+
+// WithIdleTimeout 空闲超时配置，超时断开时先调用 TimeoutAgent.OnTimeout 再调用 OnClose
+func WithIdleTimeout(timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.IdleTimeout = timeout
+	}
+}
+
+// WithCodec 消息编解码配置，例如 codec.NewLengthFieldBasedFrameCodec 创建的分帧编解码
+func WithCodec(codec Codec) ServerOption {
+	return func(o *ServerOptions) {
+		o.Codec = codec
+	}
+}
+
+// WithWriteQueue 异步写队列配置，启用后 Write 和 Broadcast 不再等待 socket 写入完成，low 大于 high 时使用 high
+func WithWriteQueue(high int, low int, policy OverflowPolicy) ServerOption {
+	return func(o *ServerOptions) {
+		o.WriteQueue.HighWatermark = high
+		o.WriteQueue.LowWatermark = low
+		o.WriteQueue.Policy = policy
+	}
+}
+
+// WithWriteDeadline 写入超时配置，对端长时间不读取数据时断开连接
+func WithWriteDeadline(deadline time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.WriteQueue.WriteDeadline = deadline
+	}
+}
+
+// WithMaxConnPerIP 单个 IP 的最大连接数配置
+func WithMaxConnPerIP(n int) ServerOption {
+	return func(o *ServerOptions) {
+		o.Limit.MaxConnPerIP = n
+	}
+}
+
+// WithAcceptRate 每秒接受的新连接数配置，超过时直接关闭新连接
+func WithAcceptRate(rate float64, burst int) ServerOption {
+	return func(o *ServerOptions) {
+		o.Limit.AcceptRate = rate
+		o.Limit.AcceptBurst = burst
+	}
+}
+
+// WithMessageRate 每个连接每秒接收的消息数配置，超过时断开连接
+func WithMessageRate(rate float64, burst int) ServerOption {
+	return func(o *ServerOptions) {
+		o.Limit.MessageRate = rate
+		o.Limit.MessageBurst = burst
+	}
+}
+
+// WithBandwidthLimit 每个连接每秒接收的字节数配置，tcpnet 超过时暂停读取，tcpgnet 超过时断开连接
+func WithBandwidthLimit(bytesPerSecond int, burst int) ServerOption {
+	return func(o *ServerOptions) {
+		o.Limit.Bandwidth = bytesPerSecond
+		o.Limit.BandwidthBurst = burst
+	}
+}
+
+// WithIPAllowList IP 白名单配置，支持 CIDR，多次调用时合并
+func WithIPAllowList(cidrs ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.Limit.Allow = append(o.Limit.Allow, cidrs...)
+	}
+}
+
+// WithIPDenyList IP 黑名单配置，支持 CIDR，多次调用时合并
+func WithIPDenyList(cidrs ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.Limit.Deny = append(o.Limit.Deny, cidrs...)
+	}
+}
+
+// WithAdmission 准入回调配置，tcpgnet 在事件循环中调用，不能阻塞
+func WithAdmission(admission AdmissionFunc) ServerOption {
+	return func(o *ServerOptions) {
+		o.Limit.Admission = admission
+	}
+}
+
+// WithRejectListener 连接被拒绝时的回调配置
+func WithRejectListener(listener RejectListener) ServerOption {
+	return func(o *ServerOptions) {
+		o.Limit.OnReject = listener
+	}
+}
+
+// WithProxyProtocol 启用 PROXY 协议 v1 和 v2，trusted 为负载均衡的地址，支持 CIDR，不能为空，否则 Listen 返回 ErrProxyTrusted
+//
+//	来自受信任地址的连接必须先发送 PROXY 协议头部，否则断开连接，其他地址的连接不解析头部
+func WithProxyProtocol(trusted ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.ProxyProtocol = true
+		o.ProxyTrusted = append(o.ProxyTrusted, trusted...)
+	}
+}
+
 var (
 	// DefaultServerOptions 默认 Server 选项
 	DefaultServerOptions = ServerOptions{
@@ -3,6 +3,7 @@ package core
 import (
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestWithServerOpt(t *testing.T) {
@@ -17,3 +18,13 @@ func TestWithServerOpt(t *testing.T) {
 	r.Equal(10, options.MaxConnNum)
 	r.Equal(context, options.Context)
 }
+
+func TestWithServerOptKeepalive(t *testing.T) {
+	options := DefaultServerOptions
+	WithHeartbeat(time.Second)(&options)
+	WithIdleTimeout(time.Second * 3)(&options)
+	r := require.New(t)
+
+	r.Equal(time.Second, options.HeartbeatInterval)
+	r.Equal(time.Second*3, options.IdleTimeout)
+}
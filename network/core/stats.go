@@ -0,0 +1,130 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Traffic 流量统计，消息数包括心跳包
+type Traffic struct {
+	// BytesIn 收到的字节数
+	BytesIn uint64
+	// BytesOut 写入 socket 的字节数
+	BytesOut uint64
+	// MessagesIn 解码出的消息数
+	MessagesIn uint64
+	// MessagesOut 编码后发送或者放入写队列的消息数
+	MessagesOut uint64
+	// DecodeErrors 解码失败的次数，解码失败后连接会断开
+	DecodeErrors uint64
+}
+
+// ConnStats 单个连接的统计
+type ConnStats struct {
+	Traffic
+	// ID 连接唯一键
+	ID ID
+	// RemoteAddr 远程地址
+	RemoteAddr string
+	// WriteQueueBytes 写队列中等待写入的字节数，没有启用写队列时为 0
+	WriteQueueBytes int
+	// ConnectedAt 连接建立时间
+	ConnectedAt time.Time
+	// Duration 连接持续时间
+	Duration time.Duration
+}
+
+// ServerStats 服务器统计，流量统计包括已经断开的连接
+type ServerStats struct {
+	Traffic
+	// Address 监听地址
+	Address string
+	// Conns 当前连接数
+	Conns int
+	// Accepted 累计接受的连接数，不包括被拒绝的连接
+	Accepted uint64
+	// Rejections 按照原因统计的拒绝次数
+	Rejections map[RejectReason]uint64
+	// Connections 当前所有连接的统计
+	Connections []ConnStats
+}
+
+// StatsServer 可以查询统计的服务器，tcpnet、tcpgnet 和 websocket 服务器实现了这个接口
+type StatsServer interface {
+	Server
+	// Stats 服务器和所有连接的统计
+	Stats() ServerStats
+}
+
+// StatsConn 可以查询统计的连接，tcpnet 和 tcpgnet 的连接实现了这个接口
+type StatsConn interface {
+	Conn
+	// Stats 连接的统计
+	Stats() ConnStats
+}
+
+// Counter 流量计数器，所有方法可以并发调用，parent 不为 nil 时同时累加到 parent
+type Counter struct {
+	bytesIn      uint64
+	bytesOut     uint64
+	messagesIn   uint64
+	messagesOut  uint64
+	decodeErrors uint64
+	parent       *Counter
+}
+
+// Reset 清零并设置 parent，连接复用时调用，不能和其他方法并发调用
+func (counter *Counter) Reset(parent *Counter) {
+	atomic.StoreUint64(&counter.bytesIn, 0)
+	atomic.StoreUint64(&counter.bytesOut, 0)
+	atomic.StoreUint64(&counter.messagesIn, 0)
+	atomic.StoreUint64(&counter.messagesOut, 0)
+	atomic.StoreUint64(&counter.decodeErrors, 0)
+	counter.parent = parent
+}
+
+// AddIn 收到 n 个字节
+func (counter *Counter) AddIn(n int) {
+	for c := counter; c != nil; c = c.parent {
+		atomic.AddUint64(&c.bytesIn, uint64(n))
+	}
+}
+
+// AddOut 写入 n 个字节
+func (counter *Counter) AddOut(n int) {
+	for c := counter; c != nil; c = c.parent {
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+	}
+}
+
+// AddMessageIn 解码出一条消息
+func (counter *Counter) AddMessageIn() {
+	for c := counter; c != nil; c = c.parent {
+		atomic.AddUint64(&c.messagesIn, 1)
+	}
+}
+
+// AddMessageOut 发送一条消息
+func (counter *Counter) AddMessageOut() {
+	for c := counter; c != nil; c = c.parent {
+		atomic.AddUint64(&c.messagesOut, 1)
+	}
+}
+
+// AddDecodeError 解码失败一次
+func (counter *Counter) AddDecodeError() {
+	for c := counter; c != nil; c = c.parent {
+		atomic.AddUint64(&c.decodeErrors, 1)
+	}
+}
+
+// Load 当前的流量统计
+func (counter *Counter) Load() Traffic {
+	return Traffic{
+		BytesIn:      atomic.LoadUint64(&counter.bytesIn),
+		BytesOut:     atomic.LoadUint64(&counter.bytesOut),
+		MessagesIn:   atomic.LoadUint64(&counter.messagesIn),
+		MessagesOut:  atomic.LoadUint64(&counter.messagesOut),
+		DecodeErrors: atomic.LoadUint64(&counter.decodeErrors),
+	}
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	r := require.New(t)
+	parent := new(Counter)
+	counter := new(Counter)
+	counter.Reset(parent)
+	counter.AddIn(10)
+	counter.AddOut(20)
+	counter.AddMessageIn()
+	counter.AddMessageOut()
+	counter.AddMessageOut()
+	counter.AddDecodeError()
+	traffic := Traffic{BytesIn: 10, BytesOut: 20, MessagesIn: 1, MessagesOut: 2, DecodeErrors: 1}
+	r.Equal(traffic, counter.Load())
+	r.Equal(traffic, parent.Load())
+
+	// 复用时清零，parent 保留累计值
+	counter.Reset(parent)
+	counter.AddIn(5)
+	r.Equal(Traffic{BytesIn: 5}, counter.Load())
+	r.Equal(uint64(15), parent.Load().BytesIn)
+	counter.Reset(nil)
+	counter.AddIn(5)
+	r.Equal(uint64(15), parent.Load().BytesIn)
+}
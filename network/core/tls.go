@@ -0,0 +1,171 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/finishy1995/go-library/log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCertReloadInterval 默认检查证书文件变化的间隔
+	DefaultCertReloadInterval = time.Second * 10
+)
+
+// TLSConn 使用 TLS 的连接，可以获取 TLS 状态（例如双向认证时对端的证书）
+type TLSConn interface {
+	Conn
+	// ConnectionState TLS 连接状态，不是 TLS 连接时返回 nil
+	ConnectionState() *tls.ConnectionState
+}
+
+// PeerCertificate 对端证书（双向认证时服务端获取到的客户端证书），不是 TLS 连接或者对端没有提供证书时返回 nil
+func PeerCertificate(conn Conn) *x509.Certificate {
+	tc, ok := conn.(TLSConn)
+	if !ok {
+		return nil
+	}
+	state := tc.ConnectionState()
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// GetConnectionState 获取 net.Conn 的 TLS 状态，会依次解开实现了 NetConn() 的包装连接，不是 TLS 连接时返回 nil
+func GetConnectionState(conn net.Conn) *tls.ConnectionState {
+	for conn != nil {
+		if tc, ok := conn.(*tls.Conn); ok {
+			state := tc.ConnectionState()
+			return &state
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = wrapper.NetConn()
+	}
+	return nil
+}
+
+// TLSServerHandshake 服务端 TLS 握手，完成后 Agent 才能在 OnConnect 中获取对端证书
+func TLSServerHandshake(config *tls.Config) Handshake {
+	return func(conn net.Conn, _ Codec) (net.Conn, Codec, error) {
+		return tlsHandshake(tls.Server(conn, config))
+	}
+}
+
+// TLSClientHandshake 客户端 TLS 握手
+func TLSClientHandshake(config *tls.Config) Handshake {
+	return func(conn net.Conn, _ Codec) (net.Conn, Codec, error) {
+		return tlsHandshake(tls.Client(conn, config))
+	}
+}
+
+func tlsHandshake(conn *tls.Conn) (net.Conn, Codec, error) {
+	_ = conn.SetDeadline(time.Now().Add(DefaultHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	return conn, nil, conn.Handshake()
+}
+
+// CertReloader 证书热更新，证书文件变化后新的连接使用新证书，不需要重启服务器
+//
+//	使用 GetCertificate/GetClientCertificate 设置 tls.Config，可以手动调用 Reload，也可以使用 Run 定时检查文件变化
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+
+	closeSig  chan bool
+	closeOnce sync.Once
+}
+
+// NewCertReloader 创建证书热更新，会立即加载一次证书
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		closeSig: make(chan bool),
+	}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新加载证书，加载失败时继续使用原来的证书
+func (r *CertReloader) Reload() error {
+	modTime := r.lastModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mutex.Unlock()
+	return nil
+}
+
+// lastModTime 证书和私钥文件中最近的修改时间
+func (r *CertReloader) lastModTime() time.Time {
+	var t time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+	return t
+}
+
+// GetCertificate 用于 tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// GetClientCertificate 用于 tls.Config.GetClientCertificate
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// Run 定时检查证书文件，发生变化时重新加载，直到 Close
+func (r *CertReloader) Run() {
+	ticker := time.NewTicker(DefaultCertReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closeSig:
+			return
+		case <-ticker.C:
+		}
+		r.mutex.RLock()
+		changed := r.lastModTime().After(r.modTime)
+		r.mutex.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			log.Error("reload certificate %s failed, error: %s", r.certFile, err.Error())
+		} else {
+			log.Info("reload certificate %s success", r.certFile)
+		}
+	}
+}
+
+// Close 停止检查证书文件
+func (r *CertReloader) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closeSig)
+	})
+}
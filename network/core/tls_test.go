@@ -0,0 +1,149 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCert 生成自签名证书，同时作为 CA 使用
+func newTestCert(r *require.Assertions, name string) (tls.Certificate, *x509.CertPool, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.Nil(err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	r.Nil(err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	r.Nil(err)
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	r.Nil(err)
+	leaf, err := x509.ParseCertificate(der)
+	r.Nil(err)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return cert, pool, certPem, keyPem
+}
+
+type testTLSConn struct {
+	net.Conn
+}
+
+func (conn *testTLSConn) Run()   {}
+func (conn *testTLSConn) Close() {}
+func (conn *testTLSConn) ID() ID { return 0 }
+func (conn *testTLSConn) ConnectionState() *tls.ConnectionState {
+	return GetConnectionState(conn.Conn)
+}
+
+// wrappedConn 握手流程包装过的连接
+type wrappedConn struct {
+	net.Conn
+}
+
+func (conn *wrappedConn) NetConn() net.Conn {
+	return conn.Conn
+}
+
+func TestTLSHandshake(t *testing.T) {
+	r := require.New(t)
+	serverCert, serverPool, _, _ := newTestCert(r, "server")
+	clientCert, clientPool, _, _ := newTestCert(r, "client")
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	var (
+		serverTLS net.Conn
+		serverErr error
+		wg        sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serverTLS, _, serverErr = TLSServerHandshake(&tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientPool,
+		})(serverConn, nil)
+	}()
+	clientTLS, _, err := TLSClientHandshake(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      serverPool,
+		ServerName:   "server",
+	})(clientConn, nil)
+	wg.Wait()
+	r.Nil(err)
+	r.Nil(serverErr)
+
+	// 服务端获取客户端证书
+	cert := PeerCertificate(&testTLSConn{Conn: serverTLS})
+	r.NotNil(cert)
+	r.Equal("client", cert.Subject.CommonName)
+	r.Equal("server", PeerCertificate(&testTLSConn{Conn: clientTLS}).Subject.CommonName)
+	// 包装过的连接
+	r.Equal("client", PeerCertificate(&testTLSConn{Conn: &wrappedConn{Conn: serverTLS}}).Subject.CommonName)
+	r.Nil(GetConnectionState(struct{ net.Conn }{serverTLS}))
+	r.Nil(PeerCertificate(&testTLSConn{Conn: serverConn}))
+}
+
+func TestCertReloader(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	_, err := NewCertReloader(certFile, keyFile)
+	r.NotNil(err)
+
+	_, _, certPem, keyPem := newTestCert(r, "first")
+	r.Nil(os.WriteFile(certFile, certPem, 0600))
+	r.Nil(os.WriteFile(keyFile, keyPem, 0600))
+	reloader, err := NewCertReloader(certFile, keyFile)
+	r.Nil(err)
+	defer reloader.Close()
+	cert, err := reloader.GetCertificate(nil)
+	r.Nil(err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	r.Nil(err)
+	r.Equal("first", leaf.Subject.CommonName)
+
+	// 加载失败时继续使用原来的证书
+	r.Nil(os.WriteFile(keyFile, []byte("invalid"), 0600))
+	r.NotNil(reloader.Reload())
+	same, _ := reloader.GetClientCertificate(nil)
+	r.Equal(cert, same)
+
+	_, _, certPem, keyPem = newTestCert(r, "second")
+	r.Nil(os.WriteFile(certFile, certPem, 0600))
+	r.Nil(os.WriteFile(keyFile, keyPem, 0600))
+	r.Nil(reloader.Reload())
+	cert, _ = reloader.GetCertificate(nil)
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	r.Nil(err)
+	r.Equal("second", leaf.Subject.CommonName)
+}
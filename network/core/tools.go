@@ -63,8 +63,33 @@ func GenerateID() ID {
 	return id
 }
 
-// VerifyAddress 验证地址是否正确
+const (
+	// UnixNetwork Unix 域套接字，地址格式为 unix:路径，例如 unix:/tmp/server.sock
+	UnixNetwork = "unix"
+	// PipeNetwork 进程内管道，地址格式为 pipe:名称，例如 pipe:server
+	PipeNetwork = "pipe"
+)
+
+// SplitAddress 拆分地址中的网络类型，返回 UnixNetwork 和路径、PipeNetwork 和名称，ip:port 格式的地址 network 为空
+func SplitAddress(address string) (network string, addr string) {
+	for _, n := range []string{UnixNetwork, PipeNetwork} {
+		if strings.HasPrefix(address, n+":") {
+			return n, address[len(n)+1:]
+		}
+	}
+	return "", address
+}
+
+// VerifyAddress 验证地址是否正确，支持 ip:port、unix:路径 和 pipe:名称
 func VerifyAddress(address string) bool {
+	if network, addr := SplitAddress(address); network != "" {
+		return addr != ""
+	}
+	return VerifyIPAddress(address)
+}
+
+// VerifyIPAddress 验证 ip:port 格式的地址是否正确
+func VerifyIPAddress(address string) bool {
 	var pair []string
 
 	// 是否是 ipv6
@@ -24,6 +24,27 @@ func TestVerifyAddress(t *testing.T) {
 	r.True(VerifyAddress(":660"))
 	r.False(VerifyAddress(":100000"))
 	r.False(VerifyAddress(":1a1"))
+
+	// 检查 Unix 域套接字和进程内管道
+	r.True(VerifyAddress("unix:/tmp/server.sock"))
+	r.True(VerifyAddress("pipe:server"))
+	r.False(VerifyAddress("unix:"))
+	r.False(VerifyAddress("pipe:"))
+	r.False(VerifyIPAddress("pipe:server"))
+	r.True(VerifyIPAddress("127.0.0.1:50"))
+}
+
+func TestSplitAddress(t *testing.T) {
+	r := require.New(t)
+	network, addr := SplitAddress("unix:/tmp/server.sock")
+	r.Equal(UnixNetwork, network)
+	r.Equal("/tmp/server.sock", addr)
+	network, addr = SplitAddress("pipe:server")
+	r.Equal(PipeNetwork, network)
+	r.Equal("server", addr)
+	network, addr = SplitAddress("127.0.0.1:50")
+	r.Equal("", network)
+	r.Equal("127.0.0.1:50", addr)
 }
 
 func TestGenerateID(t *testing.T) {
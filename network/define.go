@@ -28,8 +28,17 @@ const (
 	// SeriesInterval 系列间隔
 	SeriesInterval NetType = 1000
 
-	TcpNet  = TcpSeries + 0
-	TcpGNet = TcpSeries + 1
+	TcpNet    = TcpSeries + 0
+	TcpGNet   = TcpSeries + 1
+	WebSocket = TcpSeries + 2
+	// UnixNet Unix 域套接字（流式），地址格式为 unix:路径
+	UnixNet = TcpSeries + 3
+	// PipeNet 进程内管道，地址格式为 pipe:名称，用于测试和同一个进程内的通信
+	PipeNet = TcpSeries + 4
+
+	UdpNet = UdpSeries + 0
+	// ReliableUdpNet 可靠有序的 UDP（类 KCP 的 ARQ）
+	ReliableUdpNet = UdpSeries + 1
 )
 
 // SupportClient 支持客户端
@@ -0,0 +1,30 @@
+package group
+
+import "github.com/finishy1995/go-library/network/core"
+
+// groupAgent 连接断开时自动退出所有分组，超时、踢下线和优雅关闭通知转发给被包装的 Agent
+type groupAgent struct {
+	core.Agent
+	manager *Manager
+}
+
+// OnClose 先交给被包装的 Agent 处理，此时还可以通过 Manager.Groups 获取连接所在的分组
+func (a *groupAgent) OnClose(conn core.Conn) {
+	a.Agent.OnClose(conn)
+	a.manager.LeaveAll(conn)
+}
+
+// OnTimeout ...
+func (a *groupAgent) OnTimeout(conn core.Conn) {
+	core.NotifyTimeout(a.Agent, conn)
+}
+
+// OnKick ...
+func (a *groupAgent) OnKick(conn core.Conn, reason string) {
+	core.NotifyKick(a.Agent, conn, reason)
+}
+
+// OnShutdown ...
+func (a *groupAgent) OnShutdown(conn core.Conn) {
+	core.NotifyShutdown(a.Agent, conn)
+}
@@ -0,0 +1,96 @@
+package group
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+)
+
+// Group 分组，例如游戏房间、公会频道，成员为 core.Conn，可以并发使用
+type Group struct {
+	name    string
+	manager *Manager
+	members map[core.ID]core.Conn
+}
+
+// Name 分组名称
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Join 加入分组，已经在分组中或者连接已经断开时不做任何事
+func (g *Group) Join(conn core.Conn) {
+	g.manager.mutex.Lock()
+	defer g.manager.mutex.Unlock()
+	g.manager.join(g, conn)
+}
+
+// Leave 离开分组
+func (g *Group) Leave(conn core.Conn) {
+	g.manager.mutex.Lock()
+	defer g.manager.mutex.Unlock()
+	g.manager.leave(g, conn.ID())
+}
+
+// Has 连接是否在分组中
+func (g *Group) Has(conn core.Conn) bool {
+	g.manager.mutex.RLock()
+	defer g.manager.mutex.RUnlock()
+	_, ok := g.members[conn.ID()]
+	return ok
+}
+
+// Len 成员数量
+func (g *Group) Len() int {
+	g.manager.mutex.RLock()
+	defer g.manager.mutex.RUnlock()
+	return len(g.members)
+}
+
+// Range 遍历成员，遍历的是调用时的快照，f 返回 false 时停止遍历
+func (g *Group) Range(f func(conn core.Conn) bool) {
+	for _, conn := range g.snapshot() {
+		if !f(conn) {
+			return
+		}
+	}
+}
+
+// Broadcast 向除了 except 之外的所有成员发送 b，返回发送成功的成员数
+//
+//	连接实现了 core.FrameWriter 时，使用同一个 Codec 的连接只编码一次
+func (g *Group) Broadcast(b []byte, except ...core.Conn) (num int) {
+	frames := core.NewFrameCache(b)
+	for _, conn := range g.snapshot() {
+		if excluded(conn, except) {
+			continue
+		}
+		var err error
+		if fw, ok := conn.(core.FrameWriter); ok {
+			err = fw.WriteFrame(frames)
+		} else {
+			_, err = conn.Write(b)
+		}
+		if err == nil {
+			num++
+		}
+	}
+	return
+}
+
+func (g *Group) snapshot() []core.Conn {
+	g.manager.mutex.RLock()
+	defer g.manager.mutex.RUnlock()
+	conns := make([]core.Conn, 0, len(g.members))
+	for _, conn := range g.members {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func excluded(conn core.Conn, except []core.Conn) bool {
+	for _, c := range except {
+		if c != nil && c.ID() == conn.ID() {
+			return true
+		}
+	}
+	return false
+}
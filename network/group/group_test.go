@@ -0,0 +1,178 @@
+package group
+
+import (
+	"github.com/finishy1995/go-library/network/codec"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type mockConn struct {
+	sync.Mutex
+	id      core.ID
+	written [][]byte
+}
+
+func newMockConn() *mockConn {
+	return &mockConn{id: core.GenerateID()}
+}
+
+func (conn *mockConn) Run()                 {}
+func (conn *mockConn) Close()               {}
+func (conn *mockConn) LocalAddr() net.Addr  { return nil }
+func (conn *mockConn) RemoteAddr() net.Addr { return nil }
+func (conn *mockConn) ID() core.ID          { return conn.id }
+func (conn *mockConn) Write(b []byte) (int, error) {
+	conn.Lock()
+	defer conn.Unlock()
+	conn.written = append(conn.written, b)
+	return len(b), nil
+}
+
+func (conn *mockConn) count() int {
+	conn.Lock()
+	defer conn.Unlock()
+	return len(conn.written)
+}
+
+// frameConn 使用共享 Codec 的连接，用于测试广播只编码一次
+type frameConn struct {
+	mockConn
+	codec core.Codec
+}
+
+func (conn *frameConn) WriteFrame(frames *core.FrameCache) error {
+	out, err := frames.Encode(conn.codec, nil)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(out)
+	return err
+}
+
+type countCodec struct {
+	codec.LengthFieldBasedFrameCodec
+	mutex   sync.Mutex
+	encodes int
+}
+
+func (cc *countCodec) Encode(c core.CodecConn, buf []byte) ([]byte, error) {
+	cc.mutex.Lock()
+	cc.encodes++
+	cc.mutex.Unlock()
+	return cc.LengthFieldBasedFrameCodec.Encode(c, buf)
+}
+
+type closeAgent struct {
+	closes    int
+	shutdowns int
+}
+
+func (a *closeAgent) OnConnect(_ core.Conn)           {}
+func (a *closeAgent) OnMessage(_ []byte, _ core.Conn) {}
+func (a *closeAgent) OnClose(_ core.Conn)             { a.closes++ }
+func (a *closeAgent) OnShutdown(_ core.Conn)          { a.shutdowns++ }
+
+func TestGroup(t *testing.T) {
+	r := require.New(t)
+	manager := NewManager()
+	a, b, c := newMockConn(), newMockConn(), newMockConn()
+	room := manager.Join("room", a)
+	room.Join(b)
+	room.Join(b)
+	manager.Join("guild", a)
+	r.Equal("room", room.Name())
+	r.Equal(2, room.Len())
+	r.True(room.Has(a))
+	r.False(room.Has(c))
+	r.Nil(manager.Get("lobby"))
+	r.Same(room, manager.Group("room"))
+
+	groups := manager.Groups(a)
+	sort.Strings(groups)
+	r.Equal([]string{"guild", "room"}, groups)
+
+	r.Equal(1, room.Broadcast([]byte("hello"), a))
+	r.Equal(2, room.Broadcast([]byte("hello")))
+	r.Equal(1, a.count())
+	r.Equal(2, b.count())
+	r.Zero(c.count())
+
+	room.Leave(b)
+	r.False(room.Has(b))
+	r.Empty(manager.Groups(b))
+	manager.Leave("guild", a)
+	r.Equal([]string{"room"}, manager.Groups(a))
+
+	manager.Remove("room")
+	r.Nil(manager.Get("room"))
+	r.Empty(manager.Groups(a))
+}
+
+// 测试 OnClose 后自动退出所有分组
+func TestGroupAgent(t *testing.T) {
+	r := require.New(t)
+	manager := NewManager()
+	inner := new(closeAgent)
+	agent := manager.NewAgent(func() core.Agent { return inner })()
+	conn := newMockConn()
+	manager.Join("room", conn)
+	manager.Join("guild", conn)
+
+	core.NotifyShutdown(agent, conn)
+	r.Equal(1, inner.shutdowns)
+	agent.OnClose(conn)
+	r.Equal(1, inner.closes)
+	r.Empty(manager.Groups(conn))
+	r.Zero(manager.Get("room").Len())
+	r.Zero(manager.Get("guild").Len())
+	// 断开后才执行的加入（例如处理协程）被忽略，广播不会再写入这个连接
+	manager.Join("room", conn)
+	manager.Get("guild").Join(conn)
+	r.Empty(manager.Groups(conn))
+	r.Zero(manager.Get("room").Broadcast([]byte("hello")))
+	r.Nil(manager.NewAgent(func() core.Agent { return nil })())
+}
+
+// 测试使用同一个 Codec 的连接只编码一次
+func TestGroupBroadcastFrame(t *testing.T) {
+	r := require.New(t)
+	manager := NewManager()
+	cc := new(countCodec)
+	room := manager.Group("room")
+	conns := make([]*frameConn, 10)
+	for i := range conns {
+		conns[i] = &frameConn{mockConn: mockConn{id: core.GenerateID()}, codec: cc}
+		room.Join(conns[i])
+	}
+	plain := newMockConn()
+	room.Join(plain)
+
+	r.Equal(11, room.Broadcast([]byte("hello")))
+	r.Equal(1, cc.encodes)
+	for _, conn := range conns {
+		r.Equal([][]byte{{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}}, conn.written)
+	}
+	r.Equal([][]byte{[]byte("hello")}, plain.written)
+}
+
+func TestGroupConcurrent(t *testing.T) {
+	r := require.New(t)
+	manager := NewManager()
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := newMockConn()
+			room := manager.Join("room", conn)
+			room.Broadcast([]byte("hello"), conn)
+			manager.LeaveAll(conn)
+		}()
+	}
+	wg.Wait()
+	r.Zero(manager.Group("room").Len())
+}
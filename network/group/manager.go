@@ -0,0 +1,163 @@
+package group
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"sync"
+	"time"
+)
+
+const (
+	// ClosedConnTTL 断开的连接保留的时间，这段时间内再加入分组会被忽略（例如断开后才执行完的处理协程）
+	ClosedConnTTL = time.Minute
+)
+
+// Manager 管理命名分组和每个连接加入的分组，连接断开时通过 NewAgent 包装的 Agent 自动退出所有分组
+type Manager struct {
+	mutex  sync.RWMutex
+	groups map[string]*Group
+	// 每个连接加入的分组
+	conns map[core.ID]map[string]*Group
+	// closed 已经断开的连接和断开的时间，超过 ClosedConnTTL 后在 LeaveAll 中清理
+	closed    map[core.ID]time.Time
+	lastSweep time.Time
+}
+
+// NewManager 创建分组管理
+func NewManager() *Manager {
+	return &Manager{
+		groups: make(map[string]*Group),
+		conns:  make(map[core.ID]map[string]*Group),
+		closed: make(map[core.ID]time.Time),
+	}
+}
+
+// Group 获取分组，不存在时创建
+func (manager *Manager) Group(name string) *Group {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.group(name)
+}
+
+// Get 获取分组，不存在时返回 nil
+func (manager *Manager) Get(name string) *Group {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+	return manager.groups[name]
+}
+
+// Join 加入分组，分组不存在时创建，连接已经断开时不会加入
+func (manager *Manager) Join(name string, conn core.Conn) *Group {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	g := manager.group(name)
+	manager.join(g, conn)
+	return g
+}
+
+// Leave 离开分组
+func (manager *Manager) Leave(name string, conn core.Conn) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if g, ok := manager.groups[name]; ok {
+		manager.leave(g, conn.ID())
+	}
+}
+
+// LeaveAll 离开连接加入的所有分组，连接断开时调用，之后 ClosedConnTTL 内这个连接不能再加入分组
+func (manager *Manager) LeaveAll(conn core.Conn) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	id := conn.ID()
+	for _, g := range manager.conns[id] {
+		delete(g.members, id)
+	}
+	delete(manager.conns, id)
+
+	now := time.Now()
+	manager.closed[id] = now
+	if now.Sub(manager.lastSweep) >= ClosedConnTTL {
+		manager.lastSweep = now
+		for closedID, t := range manager.closed {
+			if now.Sub(t) >= ClosedConnTTL {
+				delete(manager.closed, closedID)
+			}
+		}
+	}
+}
+
+// Groups 连接加入的所有分组名称
+func (manager *Manager) Groups(conn core.Conn) []string {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+	groups := manager.conns[conn.ID()]
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Remove 删除分组，所有成员离开分组，删除后不应该继续使用这个分组
+func (manager *Manager) Remove(name string) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	g, ok := manager.groups[name]
+	if !ok {
+		return
+	}
+	for id := range g.members {
+		manager.leave(g, id)
+	}
+	delete(manager.groups, name)
+}
+
+// NewAgent 包装 newAgent 创建的 Agent，连接断开时在 OnClose 之后自动退出所有分组
+func (manager *Manager) NewAgent(newAgent core.GetAgent) core.GetAgent {
+	return func() core.Agent {
+		agent := newAgent()
+		if agent == nil {
+			return nil
+		}
+		return &groupAgent{Agent: agent, manager: manager}
+	}
+}
+
+// group 获取或者创建分组，调用时需要持有锁
+func (manager *Manager) group(name string) *Group {
+	g, ok := manager.groups[name]
+	if !ok {
+		g = &Group{
+			name:    name,
+			manager: manager,
+			members: make(map[core.ID]core.Conn),
+		}
+		manager.groups[name] = g
+	}
+	return g
+}
+
+// join 调用时需要持有锁，已经断开的连接不会加入
+func (manager *Manager) join(g *Group, conn core.Conn) {
+	id := conn.ID()
+	if _, ok := manager.closed[id]; ok {
+		return
+	}
+	g.members[id] = conn
+	groups, ok := manager.conns[id]
+	if !ok {
+		groups = make(map[string]*Group)
+		manager.conns[id] = groups
+	}
+	groups[g.name] = g
+}
+
+// leave 调用时需要持有锁
+func (manager *Manager) leave(g *Group, id core.ID) {
+	delete(g.members, id)
+	if groups, ok := manager.conns[id]; ok {
+		delete(groups, g.name)
+		if len(groups) == 0 {
+			delete(manager.conns, id)
+		}
+	}
+}
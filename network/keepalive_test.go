@@ -0,0 +1,84 @@
+package network
+
+import (
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testHeartbeat   = time.Millisecond * 50
+	testIdleTimeout = time.Millisecond * 200
+	testKeepalive   = time.Millisecond * 600
+)
+
+// timeoutAgent 记录超时和收到的消息数量
+type timeoutAgent struct {
+	agent.SingleAgent
+	timeouts int32
+	messages int32
+}
+
+func (a *timeoutAgent) OnMessage(_ []byte, _ core.Conn) {
+	atomic.AddInt32(&a.messages, 1)
+}
+
+func (a *timeoutAgent) OnTimeout(_ core.Conn) {
+	atomic.AddInt32(&a.timeouts, 1)
+}
+
+// 测试心跳和服务端空闲超时
+func TestNetworkKeepalive(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test network type: %d", typ)
+		serverAgent := new(timeoutAgent)
+		clientAgent := new(timeoutAgent)
+		_, err := Listen(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return serverAgent },
+			core.WithHeartbeat(testHeartbeat), core.WithIdleTimeout(testIdleTimeout))
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+
+		// 发送心跳的客户端不会超时
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return clientAgent },
+			core.WithClientHeartbeat(testHeartbeat))
+		r.Nil(err)
+		// 服务端发送心跳，设置了空闲超时的客户端也不会超时
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return clientAgent },
+			core.WithClientHeartbeat(testHeartbeat), core.WithClientIdleTimeout(testIdleTimeout))
+		r.Nil(err)
+		// 不发送心跳的客户端被服务端断开
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, agent.GetSingleAgent)
+		r.Nil(err)
+
+		time.Sleep(testKeepalive)
+		r.Equal(int32(1), atomic.LoadInt32(&serverAgent.timeouts))
+		r.Zero(atomic.LoadInt32(&clientAgent.timeouts))
+		// 心跳包不会交给 Agent
+		r.Zero(atomic.LoadInt32(&serverAgent.messages))
+		r.Zero(atomic.LoadInt32(&clientAgent.messages))
+		// 被断开的客户端没有重连，不计入连接数
+		r.Equal(2+2, GetConnNum())
+		destroyAfterTest()
+	}
+}
+
+// 测试客户端空闲超时
+func TestNetworkClientIdleTimeout(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	_, err := Listen(TcpNet, "127.0.0.1:"+TestPort1, agent.GetSingleAgent)
+	r.Nil(err)
+	time.Sleep(ListenAllowWaitTime)
+
+	clientAgent := new(timeoutAgent)
+	_, err = Connect(TcpNet, "127.0.0.1:"+TestPort1, func() core.Agent { return clientAgent },
+		core.WithClientIdleTimeout(testIdleTimeout))
+	r.Nil(err)
+	time.Sleep(testKeepalive)
+	r.Equal(int32(1), atomic.LoadInt32(&clientAgent.timeouts))
+}
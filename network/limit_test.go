@@ -0,0 +1,71 @@
+package network
+
+import (
+	"errors"
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+// 测试 IP 黑名单、单个 IP 连接数和准入回调
+func TestNetworkAdmission(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	errDenied := errors.New("denied")
+	cases := []struct {
+		name    string
+		opts    []core.ServerOption
+		clients int
+		conns   int
+		reason  core.RejectReason
+	}{
+		{"deny", []core.ServerOption{core.WithIPDenyList("127.0.0.0/8")}, 1, 0, core.RejectIPFilter},
+		{"allow", []core.ServerOption{core.WithIPAllowList("10.0.0.0/8")}, 1, 0, core.RejectIPFilter},
+		{"per ip", []core.ServerOption{core.WithMaxConnPerIP(2)}, 3, 2, core.RejectMaxConnPerIP},
+		{"admission", []core.ServerOption{core.WithAdmission(func(remote net.Addr) error {
+			return errDenied
+		})}, 1, 0, core.RejectAdmission},
+	}
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		for _, c := range cases {
+			t.Logf("test network type: %d, case: %s", typ, c.name)
+			rejected := make(chan core.RejectReason, 16)
+			opts := append([]core.ServerOption{core.WithRejectListener(func(_ net.Addr, reason core.RejectReason) {
+				rejected <- reason
+			})}, c.opts...)
+			s, err := Listen(typ, "127.0.0.1:"+TestPort1, agent.GetSingleAgent, opts...)
+			r.Nil(err)
+			time.Sleep(ListenAllowWaitTime)
+			for i := 0; i < c.clients; i++ {
+				_, err = Connect(TcpNet, "127.0.0.1:"+TestPort1, agent.GetSingleAgent)
+				r.Nil(err)
+			}
+			time.Sleep(WaitConnectTime * 5)
+			r.Equal(c.conns, s.GetConnNum())
+			r.Equal(c.reason, <-rejected)
+			r.Greater(s.(core.RejectStats).Rejections()[c.reason], uint64(0))
+			destroyAfterTest()
+		}
+	}
+}
+
+// 测试超过消息速率后断开连接
+func TestNetworkMessageRate(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test network type: %d", typ)
+		s, err := Listen(typ, "127.0.0.1:"+TestPort1, agent.GetSingleAgent, core.WithMessageRate(10, 3))
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+		_, err = Connect(TcpNet, "127.0.0.1:"+TestPort1, newTestSendAgent)
+		r.Nil(err)
+		time.Sleep(WaitConnectTime * 5)
+		r.Equal(0, s.GetConnNum())
+		r.Equal(uint64(1), s.(core.RejectStats).Rejections()[core.RejectMessageRate])
+		destroyAfterTest()
+	}
+}
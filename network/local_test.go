@@ -0,0 +1,62 @@
+package network
+
+import (
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const pingPongNum = 1000
+
+// pingPongAgent 收到消息后立即回复，直到收到 pingPongNum 条消息
+type pingPongAgent struct {
+	agent.SingleAgent
+	received int32
+}
+
+func (a *pingPongAgent) OnConnect(conn core.Conn) {
+	_, _ = conn.Write([]byte("ping"))
+}
+
+func (a *pingPongAgent) OnMessage(b []byte, conn core.Conn) {
+	if atomic.AddInt32(&a.received, 1) < pingPongNum {
+		_, _ = conn.Write(b)
+	}
+}
+
+// 测试 Unix 域套接字和进程内管道，两端都在 OnMessage 中写入
+func TestNetworkLocal(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{UnixNet, PipeNet} {
+		t.Logf("test network type: %d", typ)
+		s, err := Listen(typ, testAddress(typ), agent.GetEchoAgent)
+		r.Nil(err)
+		_, err = Listen(typ, testAddress(typ), agent.GetEchoAgent)
+		r.NotNil(err)
+		_, err = Listen(typ, "127.0.0.1:"+TestPort1, agent.GetEchoAgent)
+		r.Equal(core.ErrInvalidAddress, err)
+		time.Sleep(ListenAllowWaitTime)
+
+		clientAgent := new(pingPongAgent)
+		c, err := Connect(typ, testAddress(typ), func() core.Agent { return clientAgent })
+		r.Nil(err)
+		r.Eventually(func() bool {
+			return atomic.LoadInt32(&clientAgent.received) == pingPongNum
+		}, time.Second*5, WaitConnectTime)
+		r.True(c.IsConnected())
+
+		stats := s.(core.StatsServer).Stats()
+		r.Equal(1, stats.Conns)
+		r.Equal(uint64(pingPongNum), stats.MessagesIn)
+		if typ == PipeNet {
+			r.True(strings.HasPrefix(stats.Connections[0].RemoteAddr, testAddress(typ)+"#"))
+		}
+		destroyAfterTest()
+		r.False(c.IsConnected())
+	}
+}
@@ -0,0 +1,129 @@
+package network
+
+import (
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// kickAgent 记录被踢下线的原因和断开次数
+type kickAgent struct {
+	agent.SingleAgent
+	mutex   sync.Mutex
+	reasons []string
+	closes  int32
+}
+
+func (a *kickAgent) OnKick(_ core.Conn, reason string) {
+	a.mutex.Lock()
+	a.reasons = append(a.reasons, reason)
+	a.mutex.Unlock()
+}
+
+func (a *kickAgent) OnClose(_ core.Conn) {
+	atomic.AddInt32(&a.closes, 1)
+}
+
+// 测试按照连接 ID 查找、踢下线和广播
+func TestNetworkConnManager(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet, WebSocket} {
+		t.Logf("test network type: %d", typ)
+		serverAgent := new(kickAgent)
+		s, err := Listen(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return serverAgent })
+		r.Nil(err)
+		manager, ok := s.(core.ConnManager)
+		r.True(ok)
+		time.Sleep(ListenAllowWaitTime)
+
+		clientAgent := new(timeoutAgent)
+		for i := 0; i < TestThread; i++ {
+			_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return clientAgent })
+			r.Nil(err)
+		}
+		time.Sleep(WaitConnectTime * 5)
+
+		ids := make([]core.ID, 0, TestThread)
+		manager.Range(func(conn core.Conn) bool {
+			ids = append(ids, conn.ID())
+			return true
+		})
+		r.Len(ids, TestThread)
+		for _, id := range ids {
+			conn := manager.GetConn(id)
+			r.NotNil(conn)
+			r.Equal(id, conn.ID())
+		}
+		visited := 0
+		manager.Range(func(conn core.Conn) bool {
+			visited++
+			return false
+		})
+		r.Equal(1, visited)
+
+		// 广播给除了第一个连接之外的所有连接
+		num := manager.Broadcast([]byte("broadcast"), func(conn core.Conn) bool {
+			return conn.ID() != ids[0]
+		})
+		r.Equal(TestThread-1, num)
+		r.Equal(TestThread, manager.Broadcast([]byte("broadcast"), nil))
+
+		r.Nil(manager.Kick(ids[0], "test"))
+		r.Equal(core.ErrConnNotFound, manager.Kick(ids[0]+1, "test"))
+		time.Sleep(WaitConnectTime * 5)
+		r.Nil(manager.GetConn(ids[0]))
+		r.Equal(TestThread-1, s.GetConnNum())
+		r.Equal(int32(2*TestThread-1), atomic.LoadInt32(&clientAgent.messages))
+		serverAgent.mutex.Lock()
+		r.Equal([]string{"test"}, serverAgent.reasons)
+		serverAgent.mutex.Unlock()
+		r.Equal(int32(1), atomic.LoadInt32(&serverAgent.closes))
+		destroyAfterTest()
+	}
+}
+
+// 测试断开后保留的连接引用不会发送给新的对端
+func TestNetworkStaleConn(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test network type: %d", typ)
+		s, err := Listen(typ, "127.0.0.1:"+TestPort1, agent.GetSingleAgent)
+		r.Nil(err)
+		manager := s.(core.ConnManager)
+		time.Sleep(ListenAllowWaitTime)
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, agent.GetSingleAgent)
+		r.Nil(err)
+		time.Sleep(WaitConnectTime * 5)
+
+		var stale core.Conn
+		manager.Range(func(conn core.Conn) bool {
+			stale = conn
+			return false
+		})
+		r.NotNil(stale)
+		remote, local := stale.RemoteAddr().String(), stale.LocalAddr().String()
+		r.Nil(manager.Kick(stale.ID(), "test"))
+		time.Sleep(WaitConnectTime * 5)
+		// 关闭后的连接仍然可以读取地址，例如记录日志
+		r.Equal(remote, stale.RemoteAddr().String())
+		r.Equal(local, stale.LocalAddr().String())
+
+		clientAgent := new(migrateAgent)
+		for i := 0; i < TestThread; i++ {
+			_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return clientAgent })
+			r.Nil(err)
+		}
+		time.Sleep(WaitConnectTime * 5)
+		n, _ := stale.Write([]byte("stale"))
+		r.Equal(0, n)
+		time.Sleep(WaitMsgSendTime)
+		r.Equal(int32(0), atomic.LoadInt32(&clientAgent.messages))
+		destroyAfterTest()
+	}
+}
@@ -0,0 +1,95 @@
+package message
+
+import (
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/core"
+	"sync"
+)
+
+// Conn 支持类型化消息的连接
+type Conn struct {
+	core.Conn
+	registry *Registry
+}
+
+// WriteMessage 按照注册的消息 ID 序列化并发送消息
+func (conn *Conn) WriteMessage(v interface{}) error {
+	b, err := conn.registry.Encode(v)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(b)
+	return err
+}
+
+// Handler 类型化消息的处理者，msg 为注册类型的指针
+type Handler interface {
+	// OnConnect 连接建立
+	OnConnect(conn *Conn)
+	// OnMessage 收到消息
+	OnMessage(msg interface{}, conn *Conn)
+	// OnClose 连接断开
+	OnClose(conn *Conn)
+}
+
+// Agent 实现了 core.Agent，把收到的数据解析为类型化消息交给 Handler
+type Agent struct {
+	registry *Registry
+	handler  Handler
+
+	mutex sync.RWMutex
+	conns map[core.Conn]*Conn
+}
+
+// NewAgent 创建 Agent，registry 为 nil 时使用 DefaultRegistry，多个连接可以共用一个 Agent
+func NewAgent(registry *Registry, handler Handler) *Agent {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &Agent{
+		registry: registry,
+		handler:  handler,
+		conns:    make(map[core.Conn]*Conn),
+	}
+}
+
+// NewAgent 用于 network.Listen/Connect，所有连接共用这个 Agent
+func (a *Agent) NewAgent() core.Agent {
+	return a
+}
+
+// OnConnect ...
+func (a *Agent) OnConnect(c core.Conn) {
+	conn := &Conn{Conn: c, registry: a.registry}
+	a.mutex.Lock()
+	a.conns[c] = conn
+	a.mutex.Unlock()
+	a.handler.OnConnect(conn)
+}
+
+// OnMessage 解析消息，解析失败时记录日志并丢弃
+func (a *Agent) OnMessage(b []byte, c core.Conn) {
+	a.mutex.RLock()
+	conn, ok := a.conns[c]
+	a.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	msgID, msg, err := a.registry.Decode(b)
+	if err != nil {
+		log.Error("message %d from %s decode failed, error: %s", msgID, c.RemoteAddr(), err.Error())
+		return
+	}
+	a.handler.OnMessage(msg, conn)
+}
+
+// OnClose ...
+func (a *Agent) OnClose(c core.Conn) {
+	a.mutex.Lock()
+	conn, ok := a.conns[c]
+	delete(a.conns, c)
+	a.mutex.Unlock()
+	if ok {
+		a.handler.OnClose(conn)
+	}
+}
@@ -0,0 +1,12 @@
+package message
+
+import "errors"
+
+var (
+	// ErrInvalidMessage 消息长度不足消息头
+	ErrInvalidMessage = errors.New("invalid message")
+	// ErrUnknownMessage 消息 ID 没有注册类型
+	ErrUnknownMessage = errors.New("unknown message id")
+	// ErrUnregisteredType 消息类型没有注册消息 ID
+	ErrUnregisteredType = errors.New("message type not registered")
+)
@@ -0,0 +1,129 @@
+package message
+
+import (
+	"github.com/finishy1995/go-library/network/codec"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"net"
+	"sync"
+	"testing"
+)
+
+type mockConn struct {
+	sync.Mutex
+	written [][]byte
+}
+
+func (conn *mockConn) Run()                 {}
+func (conn *mockConn) Close()               {}
+func (conn *mockConn) LocalAddr() net.Addr  { return nil }
+func (conn *mockConn) RemoteAddr() net.Addr { return nil }
+func (conn *mockConn) ID() core.ID          { return 0 }
+func (conn *mockConn) Write(b []byte) (int, error) {
+	conn.Lock()
+	defer conn.Unlock()
+	conn.written = append(conn.written, b)
+	return len(b), nil
+}
+
+type loginReq struct {
+	Name string
+}
+
+type loginResp struct {
+	Welcome string
+}
+
+// echoHandler 收到 loginReq 回复 loginResp
+type echoHandler struct {
+	connects int
+	closes   int
+	messages []interface{}
+}
+
+func (h *echoHandler) OnConnect(_ *Conn) { h.connects++ }
+func (h *echoHandler) OnClose(_ *Conn)   { h.closes++ }
+func (h *echoHandler) OnMessage(msg interface{}, conn *Conn) {
+	h.messages = append(h.messages, msg)
+	if req, ok := msg.(*loginReq); ok {
+		_ = conn.WriteMessage(&loginResp{Welcome: "hello " + req.Name})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := require.New(t)
+	for _, marshaler := range []codec.Marshaler{nil, new(codec.JSONMarshaler), new(codec.MsgpackMarshaler)} {
+		registry := NewRegistry(marshaler)
+		registry.Register(1, (*loginReq)(nil))
+		registry.Register(2, loginResp{})
+
+		id, ok := registry.GetID(loginResp{})
+		r.True(ok)
+		r.Equal(MsgID(2), id)
+
+		b, err := registry.Encode(&loginReq{Name: "a"})
+		r.Nil(err)
+		r.Equal([]byte{0, 1}, b[:HeaderSize])
+		id, v, err := registry.Decode(b)
+		r.Nil(err)
+		r.Equal(MsgID(1), id)
+		r.Equal(&loginReq{Name: "a"}, v)
+
+		_, err = registry.Encode(&struct{}{})
+		r.Equal(ErrUnregisteredType, err)
+		_, _, err = registry.Decode([]byte{0})
+		r.Equal(ErrInvalidMessage, err)
+		_, _, err = registry.Decode([]byte{0, 9})
+		r.Equal(ErrUnknownMessage, err)
+
+		// 没有类型的 nil 不会注册，也不会覆盖已有的类型
+		registry.Register(1, nil)
+		registry.Register(3, nil)
+		_, ok = registry.GetID(nil)
+		r.False(ok)
+		_, ok = registry.New(3)
+		r.False(ok)
+		_, v, err = registry.Decode(b)
+		r.Nil(err)
+		r.Equal(&loginReq{Name: "a"}, v)
+	}
+}
+
+func TestRegistryProtobuf(t *testing.T) {
+	r := require.New(t)
+	registry := NewRegistry(new(codec.ProtobufMarshaler))
+	registry.Register(1, (*wrapperspb.StringValue)(nil))
+
+	b, err := registry.Encode(wrapperspb.String("hello"))
+	r.Nil(err)
+	_, v, err := registry.Decode(b)
+	r.Nil(err)
+	r.Equal("hello", v.(*wrapperspb.StringValue).GetValue())
+}
+
+func TestAgent(t *testing.T) {
+	r := require.New(t)
+	registry := NewRegistry(nil)
+	registry.Register(1, (*loginReq)(nil))
+	registry.Register(2, (*loginResp)(nil))
+	handler := new(echoHandler)
+	agent := NewAgent(registry, handler).NewAgent()
+
+	conn := new(mockConn)
+	agent.OnConnect(conn)
+	b, err := registry.Encode(&loginReq{Name: "a"})
+	r.Nil(err)
+	agent.OnMessage(b, conn)
+	// 无法解析的消息被丢弃
+	agent.OnMessage([]byte{0, 9}, conn)
+	agent.OnClose(conn)
+
+	r.Equal(1, handler.connects)
+	r.Equal(1, handler.closes)
+	r.Equal([]interface{}{&loginReq{Name: "a"}}, handler.messages)
+	r.Len(conn.written, 1)
+	_, v, err := registry.Decode(conn.written[0])
+	r.Nil(err)
+	r.Equal(&loginResp{Welcome: "hello a"}, v)
+}
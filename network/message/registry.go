@@ -0,0 +1,125 @@
+package message
+
+import (
+	"encoding/binary"
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/codec"
+	"github.com/finishy1995/go-library/network/router"
+	"reflect"
+	"sync"
+)
+
+const (
+	// HeaderSize 消息头长度，使用 router 的消息头，两边的消息可以互通
+	HeaderSize = router.HeaderSize
+)
+
+// MsgID 消息 ID，与 router.MsgID 为同一个类型
+type MsgID = router.MsgID
+
+// Registry 消息 ID 与类型的映射，负责类型化消息的编解码
+type Registry struct {
+	marshaler codec.Marshaler
+
+	mutex sync.RWMutex
+	types map[MsgID]reflect.Type
+	ids   map[reflect.Type]MsgID
+}
+
+var (
+	// DefaultRegistry 默认 Registry，使用 JSON 序列化，Register 注册的类型属于这个 Registry
+	DefaultRegistry = NewRegistry(nil)
+)
+
+// NewRegistry 创建 Registry，marshaler 为 nil 时使用 JSON 序列化
+func NewRegistry(marshaler codec.Marshaler) *Registry {
+	if marshaler == nil {
+		marshaler = new(codec.JSONMarshaler)
+	}
+	return &Registry{
+		marshaler: marshaler,
+		types:     make(map[MsgID]reflect.Type),
+		ids:       make(map[reflect.Type]MsgID),
+	}
+}
+
+// Register 在 DefaultRegistry 上注册消息类型
+func Register(msgID MsgID, v interface{}) {
+	DefaultRegistry.Register(msgID, v)
+}
+
+// Register 注册消息类型，v 为该类型的值或指针（可以为 nil 指针，例如 (*LoginReq)(nil)），重复注册时覆盖
+//
+//	v 为没有类型的 nil 时无法得到消息类型，记录错误并忽略
+func (r *Registry) Register(msgID MsgID, v interface{}) {
+	typ := indirect(reflect.TypeOf(v))
+	if typ == nil {
+		log.Error("message %d register failed, type is untyped nil", msgID)
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if old, ok := r.types[msgID]; ok {
+		log.Warning("message %d already registered as %s, replaced", msgID, old.String())
+		delete(r.ids, old)
+	}
+	r.types[msgID] = typ
+	r.ids[typ] = msgID
+}
+
+// GetID 获取类型对应的消息 ID
+func (r *Registry) GetID(v interface{}) (MsgID, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	msgID, ok := r.ids[indirect(reflect.TypeOf(v))]
+	return msgID, ok
+}
+
+// New 创建消息 ID 对应类型的新值，返回指针
+func (r *Registry) New(msgID MsgID) (interface{}, bool) {
+	r.mutex.RLock()
+	typ, ok := r.types[msgID]
+	r.mutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return reflect.New(typ).Interface(), true
+}
+
+// Encode 把消息序列化为带消息头的数据
+func (r *Registry) Encode(v interface{}) ([]byte, error) {
+	msgID, ok := r.GetID(v)
+	if !ok {
+		return nil, ErrUnregisteredType
+	}
+	payload, err := r.marshaler.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return router.Pack(msgID, payload), nil
+}
+
+// Decode 解析带消息头的数据，返回消息 ID 和对应类型的指针
+func (r *Registry) Decode(b []byte) (MsgID, interface{}, error) {
+	if len(b) < HeaderSize {
+		return 0, nil, ErrInvalidMessage
+	}
+	msgID := MsgID(binary.BigEndian.Uint16(b))
+	v, ok := r.New(msgID)
+	if !ok {
+		return msgID, nil, ErrUnknownMessage
+	}
+	err := r.marshaler.Unmarshal(b[HeaderSize:], v)
+	if err != nil {
+		return msgID, nil, err
+	}
+	return msgID, v, nil
+}
+
+// indirect 指针类型取元素类型，值和指针注册为同一个类型
+func indirect(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
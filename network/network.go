@@ -1,9 +1,14 @@
 package network
 
 import (
+	"context"
 	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/network/src/pipenet"
 	"github.com/finishy1995/go-library/network/src/tcpgnet"
 	"github.com/finishy1995/go-library/network/src/tcpnet"
+	"github.com/finishy1995/go-library/network/src/udpnet"
+	"github.com/finishy1995/go-library/network/src/unixnet"
+	"github.com/finishy1995/go-library/network/src/websocket"
 	"github.com/finishy1995/go-library/routine"
 	"sync"
 )
@@ -31,8 +36,33 @@ func init() {
 			codecSupport: true,
 		},
 		TcpGNet: {
-			client:       nil,
+			client:       func() core.Client { return new(tcpgnet.Client) },
 			server:       func() core.Server { return new(tcpgnet.Server) },
+			codecSupport: true,
+		},
+		WebSocket: {
+			client:       func() core.Client { return new(websocket.Client) },
+			server:       func() core.Server { return new(websocket.Server) },
+			codecSupport: true,
+		},
+		UnixNet: {
+			client:       func() core.Client { return new(unixnet.Client) },
+			server:       func() core.Server { return new(unixnet.Server) },
+			codecSupport: true,
+		},
+		PipeNet: {
+			client:       func() core.Client { return new(pipenet.Client) },
+			server:       func() core.Server { return new(pipenet.Server) },
+			codecSupport: true,
+		},
+		UdpNet: {
+			client:       func() core.Client { return new(udpnet.Client) },
+			server:       func() core.Server { return new(udpnet.Server) },
+			codecSupport: false,
+		},
+		ReliableUdpNet: {
+			client:       func() core.Client { return &udpnet.Client{Reliable: true} },
+			server:       func() core.Server { return &udpnet.Server{Reliable: true} },
 			codecSupport: false,
 		},
 	}
@@ -82,32 +112,116 @@ func Connect(netType NetType, address string, newAgent core.GetAgent, opts ...co
 	return nil, ErrUnsupportedNetType
 }
 
-// DestroyAll 摧毁所有服务器客户端
-func DestroyAll() {
+// DestroyAll 摧毁所有服务器客户端，强制关闭所有连接，返回关闭监听时遇到的第一个错误
+func DestroyAll() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := Shutdown(ctx)
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+// Shutdown 优雅关闭所有服务器客户端，返回遇到的第一个错误
+//
+//	实现了 core.GracefulServer 的服务器同时开始优雅关闭，等待连接断开直到 ctx 结束，其他服务器直接关闭；
+//	服务器全部关闭后再关闭客户端；等待期间不持有全局锁，GetConnNum、Stats 等仍然可以调用，
+//	关闭过程中新建的服务器客户端不受影响
+func Shutdown(ctx context.Context) error {
 	mutex.Lock()
-	defer mutex.Unlock()
-	for _, server := range serverList {
-		server.Close()
+	servers := append([]core.Server(nil), serverList...)
+	clients := append([]core.Client(nil), clientList...)
+	mutex.Unlock()
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		firstErr error
+	)
+	for _, server := range servers {
+		s := server
+		task := func() {
+			defer wg.Done()
+			gs, ok := s.(core.GracefulServer)
+			if !ok {
+				s.Close()
+				return
+			}
+			if err := gs.Shutdown(ctx); err != nil && err != core.ErrServerClosed {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMutex.Unlock()
+			}
+		}
+		wg.Add(1)
+		// 协程池不可用时依次关闭
+		if routine.Run(false, task) != nil {
+			task()
+		}
 	}
-	for _, client := range clientList {
+	wg.Wait()
+	for _, client := range clients {
 		client.Close()
 	}
-	serverList = make([]core.Server, 0, 0)
-	clientList = make([]core.Client, 0, 0)
+
+	mutex.Lock()
+	serverList = removeServers(serverList, servers)
+	clientList = removeClients(clientList, clients)
+	mutex.Unlock()
+	return firstErr
+}
+
+// removeServers 从 list 中移除 closed 中的服务器
+func removeServers(list []core.Server, closed []core.Server) []core.Server {
+	remain := make([]core.Server, 0, len(list))
+	for _, s := range list {
+		found := false
+		for _, c := range closed {
+			if s == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			remain = append(remain, s)
+		}
+	}
+	return remain
+}
+
+// removeClients 从 list 中移除 closed 中的客户端
+func removeClients(list []core.Client, closed []core.Client) []core.Client {
+	remain := make([]core.Client, 0, len(list))
+	for _, c := range list {
+		found := false
+		for _, cc := range closed {
+			if c == cc {
+				found = true
+				break
+			}
+		}
+		if !found {
+			remain = append(remain, c)
+		}
+	}
+	return remain
 }
 
-// GetConnNum 获取所有连接数
+// GetConnNum 获取所有连接数，只包括已经连接上的客户端
 func GetConnNum() (num int) {
+	mutex.Lock()
+	defer mutex.Unlock()
 	num = 0
 	for _, server := range serverList {
 		num += server.GetConnNum()
 	}
-	num += len(clientList)
-	//for _, client := range clientList {
-	//	if client.IsConnected() {
-	//		num++
-	//	}
-	//}
+	for _, client := range clientList {
+		if client.IsConnected() {
+			num++
+		}
+	}
 	return
 }
 
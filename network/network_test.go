@@ -7,6 +7,8 @@ import (
 	"github.com/finishy1995/go-library/routine"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -60,6 +62,17 @@ func (agent *testSendAgent) OnMessage(b []byte, conn core.Conn) {
 	}
 }
 
+// testAddress 测试使用的地址，Unix 域套接字和进程内管道使用各自的地址格式
+func testAddress(typ NetType) string {
+	switch typ {
+	case UnixNet:
+		return core.UnixNetwork + ":" + filepath.Join(os.TempDir(), "go-library-"+TestPort1+".sock")
+	case PipeNet:
+		return core.PipeNetwork + ":" + TestPort1
+	}
+	return "127.0.0.1:" + TestPort1
+}
+
 func destroyAfterTest() {
 	DestroyAll()
 	time.Sleep(DestroyAllowWaitTime)
@@ -93,11 +106,11 @@ func TestInvalidAgent(t *testing.T) {
 	for typ, i := range netList {
 		t.Logf("test network type: %d", typ)
 		if i.SupportServer() {
-			_, err := Listen(typ, "127.0.0.1:"+TestPort1, nil, core.WithMaxConnNum(TestThread))
+			_, err := Listen(typ, testAddress(typ), nil, core.WithMaxConnNum(TestThread))
 			r.Equal(err, core.ErrInvalidGetAgentFunc)
 		}
 		if i.SupportClient() {
-			_, err := Connect(typ, "127.0.0.1:"+TestPort1, nil, core.WithReconnect(true))
+			_, err := Connect(typ, testAddress(typ), nil, core.WithReconnect(true))
 			r.Equal(err, core.ErrInvalidGetAgentFunc)
 		}
 	}
@@ -125,17 +138,24 @@ func TestInvalidAddress(t *testing.T) {
 func TestNetworkNormal(t *testing.T) {
 	defer destroyAfterTest()
 	r := require.New(t)
+	// 超过最大连接数的客户端是否已经连接上取决于网络类型：tcpnet、unixnet、pipenet 在 Accept 之前已经建立连接，
+	// 等待中的客户端也计入连接数；tcpgnet 和 UDP 立即拒绝超出的连接，WebSocket 握手没有完成
+	waitingConn := map[NetType]int{
+		TcpNet:  TestWaitThread,
+		UnixNet: TestWaitThread,
+		PipeNet: TestWaitThread,
+	}
 	netList := GetInfo()
 	for typ, inf := range netList {
 		t.Logf("test network type: %d", typ)
 		count = 0
 
-		_, err := Listen(typ, "127.0.0.1:"+TestPort1, agent.GetEchoAgent, core.WithMaxConnNum(TestThread))
+		_, err := Listen(typ, testAddress(typ), agent.GetEchoAgent, core.WithMaxConnNum(TestThread))
 		time.Sleep(ListenAllowWaitTime)
 		r.Nil(err)
 		for i := 0; i < TestThread+TestWaitThread; i++ {
 			if inf.SupportClient() {
-				_, err = Connect(typ, "127.0.0.1:"+TestPort1, newTestSendAgent, core.WithReconnect(true))
+				_, err = Connect(typ, testAddress(typ), newTestSendAgent, core.WithReconnect(true))
 			} else {
 				_, err = Connect(TcpNet, "127.0.0.1:"+TestPort1, newTestSendAgent, core.WithReconnect(true))
 			}
@@ -144,7 +164,7 @@ func TestNetworkNormal(t *testing.T) {
 
 		time.Sleep(WaitMsgSendTime)
 		r.Equal(SendMsgNum*TestThread, count)
-		r.Equal(TestThread*2+TestWaitThread, GetConnNum())
+		r.Equal(TestThread*2+waitingConn[typ], GetConnNum())
 		destroyAfterTest()
 
 		r.Equal(0, GetConnNum())
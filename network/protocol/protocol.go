@@ -4,10 +4,13 @@ type protocol struct {
 	ReceiveMsg []byte
 	ReplyMsg   []byte
 	Flag       int
+	// HeartbeatMsg 心跳包，收到后只刷新超时时间，不交给 Agent
+	HeartbeatMsg []byte
 }
 
 var ProtocolV001 = protocol{
-	ReceiveMsg: []byte("//"),
-	ReplyMsg:   []byte("Hi"),
-	Flag:       2,
+	ReceiveMsg:   []byte("//"),
+	ReplyMsg:     []byte("Hi"),
+	Flag:         2,
+	HeartbeatMsg: []byte("|"),
 }
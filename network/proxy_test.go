@@ -0,0 +1,103 @@
+package network
+
+import (
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/codec"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// proxyEcho 发送 PROXY 协议头部和一条消息，等待回显
+func proxyEcho(r *require.Assertions, header []byte, msg string) net.Conn {
+	frame, err := new(codec.LengthFieldBasedFrameCodec).Encode(nil, []byte(msg))
+	r.Nil(err)
+	conn, err := net.Dial("tcp", "127.0.0.1:"+TestPort1)
+	r.Nil(err)
+	_, err = conn.Write(append(append([]byte{}, header...), frame...))
+	r.Nil(err)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	reply := make([]byte, len(frame))
+	_, err = io.ReadFull(conn, reply)
+	r.Nil(err)
+	r.Equal(frame, reply)
+	return conn
+}
+
+// closeRaw 使用 RST 关闭，服务端不进入 TIME_WAIT
+func closeRaw(conns ...net.Conn) {
+	for _, conn := range conns {
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+	}
+}
+
+// 测试 PROXY 协议头部中的客户端地址、TLV 和按照真实地址的单 IP 限制
+func TestNetworkProxyProtocol(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	v2 := []byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a, 0x21, 0x11, 0, 26,
+		192, 168, 0, 2, 10, 0, 0, 1, 0x03, 0xe8, 0x01, 0xbb, core.ProxyTLVAuthority, 0, 11}
+	v2 = append(v2, "example.com"...)
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test network type: %d", typ)
+		// 没有受信任的地址时拒绝启动
+		_, err := Listen(typ, "127.0.0.1:"+TestPort1, agent.GetEchoAgent, core.WithProxyProtocol())
+		r.Equal(core.ErrProxyTrusted, err)
+		s, err := Listen(typ, "127.0.0.1:"+TestPort1, agent.GetEchoAgent,
+			core.WithProxyProtocol("127.0.0.1/32"), core.WithMaxConnPerIP(1))
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+		c1 := proxyEcho(r, []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"), "v1")
+		c2 := proxyEcho(r, v2, "v2")
+
+		headers := make(map[string]*core.ProxyHeader)
+		s.(core.ConnManager).Range(func(conn core.Conn) bool {
+			headers[conn.RemoteAddr().String()] = conn.(core.ProxyConn).ProxyHeader()
+			return true
+		})
+		r.Len(headers, 2)
+		r.Equal(1, headers["192.168.0.1:56324"].Version)
+		r.Equal(2, headers["192.168.0.2:1000"].Version)
+		authority, ok := headers["192.168.0.2:1000"].TLV(core.ProxyTLVAuthority)
+		r.True(ok)
+		r.Equal("example.com", string(authority))
+		addrs := make([]string, 0, 2)
+		for _, conn := range s.(core.StatsServer).Stats().Connections {
+			addrs = append(addrs, conn.RemoteAddr)
+		}
+		r.ElementsMatch([]string{"192.168.0.1:56324", "192.168.0.2:1000"}, addrs)
+
+		// 单 IP 连接数按照真实地址计算，同一个负载均衡的其他客户端不受影响
+		c3, err := net.Dial("tcp", "127.0.0.1:"+TestPort1)
+		r.Nil(err)
+		_, err = c3.Write([]byte("PROXY TCP4 192.168.0.1 10.0.0.1 56325 443\r\n"))
+		r.Nil(err)
+		// 受信任的地址没有发送头部
+		c4, err := net.Dial("tcp", "127.0.0.1:"+TestPort1)
+		r.Nil(err)
+		_, err = c4.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		r.Nil(err)
+		time.Sleep(WaitConnectTime * 5)
+		r.Equal(2, s.GetConnNum())
+		r.Equal(uint64(1), s.(core.RejectStats).Rejections()[core.RejectMaxConnPerIP])
+		closeRaw(c1, c2, c3, c4)
+		destroyAfterTest()
+
+		// 不受信任的地址不解析头部
+		s, err = Listen(typ, "127.0.0.1:"+TestPort1, agent.GetEchoAgent, core.WithProxyProtocol("10.0.0.0/8"))
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+		c1 = proxyEcho(r, nil, "direct")
+		s.(core.ConnManager).Range(func(conn core.Conn) bool {
+			r.Nil(conn.(core.ProxyConn).ProxyHeader())
+			r.Equal(c1.LocalAddr().String(), conn.RemoteAddr().String())
+			return true
+		})
+		closeRaw(c1)
+		destroyAfterTest()
+	}
+}
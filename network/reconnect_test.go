@@ -0,0 +1,167 @@
+package network
+
+import (
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/codec"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stateRecorder 记录客户端状态变化
+type stateRecorder struct {
+	mutex  sync.Mutex
+	states []core.ClientState
+	err    error
+}
+
+func (recorder *stateRecorder) listen(state core.ClientState, err error) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.states = append(recorder.states, state)
+	if err != nil {
+		recorder.err = err
+	}
+}
+
+func (recorder *stateRecorder) get() ([]core.ClientState, error) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]core.ClientState{}, recorder.states...), recorder.err
+}
+
+// recordAgent 按顺序记录收到的消息
+type recordAgent struct {
+	agent.SingleAgent
+	mutex sync.Mutex
+	msgs  []string
+}
+
+func (agent *recordAgent) OnMessage(b []byte, _ core.Conn) {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	agent.msgs = append(agent.msgs, string(b))
+}
+
+func (agent *recordAgent) get() []string {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	return append([]string{}, agent.msgs...)
+}
+
+const (
+	linkNormal int32 = iota
+	// linkPaused 收到的数据被丢弃，模拟网络中断
+	linkPaused
+	// linkBroken 下一次读取返回错误，连接断开
+	linkBroken
+)
+
+// lossyConn 可以模拟网络中断的连接
+type lossyConn struct {
+	net.Conn
+	state *int32
+}
+
+func (conn *lossyConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+	for atomic.LoadInt32(conn.state) == linkPaused {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.CompareAndSwapInt32(conn.state, linkBroken, linkNormal) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// 测试连续失败达到最大次数后放弃连接
+func TestNetworkReconnectGiveUp(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test network type: %d", typ)
+		recorder := new(stateRecorder)
+		c, err := Connect(typ, "127.0.0.1:"+TestPort1, agent.GetSingleAgent,
+			core.WithReconnectBackoff(time.Millisecond*10, time.Millisecond*40, 2, 0.1),
+			core.WithMaxReconnectAttempts(3), core.WithClientStateListener(recorder.listen))
+		r.Nil(err)
+		time.Sleep(time.Millisecond * 300)
+		states, err := recorder.get()
+		r.Equal([]core.ClientState{core.ClientConnecting, core.ClientConnecting, core.ClientConnecting, core.ClientGaveUp}, states)
+		r.NotNil(err)
+		r.False(c.IsConnected())
+		destroyAfterTest()
+	}
+}
+
+// 测试断开后重连并恢复会话
+func TestNetworkReconnectSession(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test client type: %d", typ)
+		store := codec.NewSessionStore()
+		s, err := Listen(TcpNet, "127.0.0.1:"+TestPort1, agent.GetSingleAgent,
+			core.WithServerHandshake(codec.SessionServerHandshake(store)))
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+
+		recorder := new(stateRecorder)
+		received := new(recordAgent)
+		link := new(int32)
+		session := codec.SessionClientHandshake()
+		c, err := Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return received }, core.WithReconnect(true),
+			core.WithClientHandshake(func(conn net.Conn, cc core.Codec) (net.Conn, core.Codec, error) {
+				conn, cc, err := session(conn, cc)
+				return &lossyConn{Conn: conn, state: link}, cc, err
+			}), core.WithClientStateListener(recorder.listen))
+		r.Nil(err)
+		time.Sleep(WaitConnectTime * 5)
+		r.True(c.IsConnected())
+
+		// 服务端断开连接后客户端重连，恢复同一个会话
+		s.(core.ConnManager).Range(func(conn core.Conn) bool {
+			r.Nil(s.(core.ConnManager).Kick(conn.ID(), "test"))
+			return true
+		})
+		time.Sleep(WaitConnectTime * 10)
+		r.True(c.IsConnected())
+		r.Equal(1, s.GetConnNum())
+		r.Equal(1, store.Len())
+		states, err := recorder.get()
+		r.Nil(err)
+		r.Equal([]core.ClientState{core.ClientConnecting, core.ClientConnected, core.ClientDisconnected,
+			core.ClientConnecting, core.ClientConnected}, states)
+
+		// 收到的消息没有确认，网络中断时丢失的消息在恢复会话后补发，每条消息恰好送达一次
+		write := func(msg string) {
+			s.(core.ConnManager).Range(func(conn core.Conn) bool {
+				_, err = conn.Write([]byte(msg))
+				r.Nil(err)
+				return true
+			})
+		}
+		write("a")
+		time.Sleep(WaitConnectTime)
+		r.Equal([]string{"a"}, received.get())
+		atomic.StoreInt32(link, linkPaused)
+		write("b")
+		write("c")
+		time.Sleep(WaitConnectTime)
+		r.Equal([]string{"a"}, received.get())
+		atomic.StoreInt32(link, linkBroken)
+		time.Sleep(WaitConnectTime * 10)
+		r.True(c.IsConnected())
+		r.Equal(1, s.GetConnNum())
+		write("d")
+		time.Sleep(WaitConnectTime)
+		r.Equal([]string{"a", "b", "c", "d"}, received.get())
+		r.Equal(1, store.Len())
+		destroyAfterTest()
+	}
+}
@@ -0,0 +1,29 @@
+package router
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+)
+
+// Context 单条消息的处理上下文
+type Context struct {
+	// Conn 收到消息的连接
+	Conn core.Conn
+	// Session 连接会话
+	Session *Session
+	// MsgID 消息 ID
+	MsgID MsgID
+	// Payload 去掉消息头后的原始负载
+	Payload []byte
+
+	router *Router
+}
+
+// Reply 向当前连接发送消息
+func (ctx *Context) Reply(msgID MsgID, v interface{}) error {
+	return ctx.router.Send(ctx.Conn, msgID, v)
+}
+
+// Bind 按照 Router 的序列化方式解析负载
+func (ctx *Context) Bind(v interface{}) error {
+	return ctx.router.marshaler.Unmarshal(ctx.Payload, v)
+}
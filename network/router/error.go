@@ -0,0 +1,14 @@
+package router
+
+import "errors"
+
+var (
+	// ErrInvalidMessage 消息长度不足，无法解析消息 ID
+	ErrInvalidMessage = errors.New("invalid router message")
+	// ErrUnknownMessage 没有注册处理函数的消息 ID
+	ErrUnknownMessage = errors.New("unknown router message id")
+	// ErrHandlerPanic 处理函数崩溃，已经被 Recover 中间件恢复
+	ErrHandlerPanic = errors.New("router handler panic")
+	// ErrUnauthorized 没有通过 Auth 中间件的认证
+	ErrUnauthorized = errors.New("router unauthorized")
+)
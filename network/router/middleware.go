@@ -0,0 +1,55 @@
+package router
+
+import (
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/routine"
+	"time"
+)
+
+// Middleware 中间件，包装处理函数，可以在处理前后执行逻辑或者直接返回错误
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Recover 恢复处理函数中的崩溃，通过 routine.Catch 记录堆栈，返回 ErrHandlerPanic
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) (err error) {
+			// 发生崩溃时 next 不会返回，err 保持为 ErrHandlerPanic
+			err = ErrHandlerPanic
+			defer routine.Catch()
+			err = next(ctx)
+			return
+		}
+	}
+}
+
+// Logger 记录每条消息的处理耗时和错误
+func Logger() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) error {
+			start := time.Now()
+			err := next(ctx)
+			if err != nil {
+				log.Error("router message %d from %s failed in %v, error: %s", ctx.MsgID, ctx.Conn.RemoteAddr(), time.Since(start), err.Error())
+			} else {
+				log.Debug("router message %d from %s handled in %v", ctx.MsgID, ctx.Conn.RemoteAddr(), time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// Auth 认证中间件，check 返回 false 时拒绝处理并返回 ErrUnauthorized，public 中的消息 ID 不需要认证（例如登录）
+func Auth(check func(ctx *Context) bool, public ...MsgID) Middleware {
+	publicSet := make(map[MsgID]bool, len(public))
+	for _, msgID := range public {
+		publicSet[msgID] = true
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) error {
+			if !publicSet[ctx.MsgID] && !check(ctx) {
+				return ErrUnauthorized
+			}
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package router
+
+import "github.com/finishy1995/go-library/network/codec"
+
+// Options 选项
+type Options struct {
+	// Marshaler 负载序列化方式，默认为 JSON
+	Marshaler codec.Marshaler
+}
+
+// Option 选项闭包
+type Option func(*Options)
+
+// WithMarshaler 设置负载序列化方式
+func WithMarshaler(marshaler codec.Marshaler) Option {
+	return func(options *Options) {
+		options.Marshaler = marshaler
+	}
+}
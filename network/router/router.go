@@ -0,0 +1,224 @@
+package router
+
+import (
+	"encoding/binary"
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/codec"
+	"github.com/finishy1995/go-library/network/core"
+	"sync"
+)
+
+const (
+	// HeaderSize 消息头长度，消息头为大端序的消息 ID
+	HeaderSize = 2
+)
+
+// MsgID 消息 ID，位于每条消息开头
+type MsgID uint16
+
+// HandlerFunc 处理函数，负载由处理函数自行解析
+type HandlerFunc func(ctx *Context) error
+
+// Router 消息路由，实现了 core.Agent，根据消息 ID 把消息分发给注册的处理函数
+//
+//	所有连接共用一个 Router，连接的状态保存在 Session 中
+type Router struct {
+	marshaler codec.Marshaler
+
+	mutex        sync.RWMutex
+	handlers     map[MsgID]HandlerFunc
+	middlewares  []Middleware
+	notFound     HandlerFunc
+	errorHandler func(ctx *Context, err error)
+	onConnect    func(s *Session)
+	onClose      func(s *Session)
+
+	sessionMutex sync.RWMutex
+	sessions     map[core.Conn]*Session
+}
+
+var (
+	// DefaultRouter 默认 Router，Handle 注册的处理函数属于这个 Router
+	DefaultRouter = New()
+)
+
+// New 创建 Router
+func New(opts ...Option) *Router {
+	options := Options{
+		Marshaler: new(codec.JSONMarshaler),
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	return &Router{
+		marshaler: options.Marshaler,
+		handlers:  make(map[MsgID]HandlerFunc),
+		notFound: func(ctx *Context) error {
+			return ErrUnknownMessage
+		},
+		errorHandler: func(ctx *Context, err error) {
+			log.Error("router handle message %d from %s failed, error: %s", ctx.MsgID, ctx.Conn.RemoteAddr(), err.Error())
+		},
+		sessions: make(map[core.Conn]*Session),
+	}
+}
+
+// Handle 在 DefaultRouter 上注册类型化的处理函数
+func Handle[T any](msgID MsgID, handler func(ctx *Context, req T) error) {
+	Register(DefaultRouter, msgID, handler)
+}
+
+// Register 注册类型化的处理函数，T 为 []byte 时直接传入原始负载，否则按照 Router 的序列化方式解析为 T
+func Register[T any](r *Router, msgID MsgID, handler func(ctx *Context, req T) error) {
+	r.HandleFunc(msgID, func(ctx *Context) error {
+		var req T
+		if raw, ok := interface{}(&req).(*[]byte); ok {
+			*raw = ctx.Payload
+		} else if err := ctx.Bind(&req); err != nil {
+			return err
+		}
+		return handler(ctx, req)
+	})
+}
+
+// HandleFunc 注册处理函数，重复注册时覆盖原来的处理函数
+func (r *Router) HandleFunc(msgID MsgID, handler HandlerFunc) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.handlers[msgID]; ok {
+		log.Warning("router handler %d already registered, replaced", msgID)
+	}
+	r.handlers[msgID] = handler
+}
+
+// Use 添加中间件，先添加的中间件在外层
+func (r *Router) Use(middlewares ...Middleware) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// NotFound 设置未注册消息的处理函数，默认返回 ErrUnknownMessage
+func (r *Router) NotFound(handler HandlerFunc) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.notFound = handler
+}
+
+// OnError 设置处理函数返回错误时的回调，默认记录日志
+func (r *Router) OnError(handler func(ctx *Context, err error)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.errorHandler = handler
+}
+
+// OnSessionStart 设置连接建立、会话创建后的回调
+func (r *Router) OnSessionStart(fn func(s *Session)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.onConnect = fn
+}
+
+// OnSessionEnd 设置连接断开、会话销毁前的回调
+func (r *Router) OnSessionEnd(fn func(s *Session)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.onClose = fn
+}
+
+// GetSession 获取连接对应的会话，连接已经断开时返回 nil
+func (r *Router) GetSession(conn core.Conn) *Session {
+	r.sessionMutex.RLock()
+	defer r.sessionMutex.RUnlock()
+	return r.sessions[conn]
+}
+
+// Send 向连接发送消息，v 为 []byte 时直接作为负载，否则按照 Router 的序列化方式序列化
+func (r *Router) Send(conn core.Conn, msgID MsgID, v interface{}) error {
+	payload, ok := v.([]byte)
+	if !ok {
+		var err error
+		payload, err = r.marshaler.Marshal(v)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := conn.Write(Pack(msgID, payload))
+	return err
+}
+
+// Pack 在负载前加上消息头
+func Pack(msgID MsgID, payload []byte) []byte {
+	b := make([]byte, HeaderSize+len(payload))
+	binary.BigEndian.PutUint16(b, uint16(msgID))
+	copy(b[HeaderSize:], payload)
+	return b
+}
+
+// NewAgent 用于 network.Listen/Connect，所有连接共用这个 Router
+func (r *Router) NewAgent() core.Agent {
+	return r
+}
+
+// OnConnect 创建会话
+func (r *Router) OnConnect(conn core.Conn) {
+	s := newSession(conn)
+	r.sessionMutex.Lock()
+	r.sessions[conn] = s
+	r.sessionMutex.Unlock()
+
+	r.mutex.RLock()
+	fn := r.onConnect
+	r.mutex.RUnlock()
+	if fn != nil {
+		fn(s)
+	}
+}
+
+// OnMessage 解析消息 ID 并分发
+func (r *Router) OnMessage(b []byte, conn core.Conn) {
+	ctx := &Context{
+		Conn:    conn,
+		Session: r.GetSession(conn),
+		router:  r,
+	}
+	r.mutex.RLock()
+	errorHandler := r.errorHandler
+	if len(b) < HeaderSize {
+		r.mutex.RUnlock()
+		errorHandler(ctx, ErrInvalidMessage)
+		return
+	}
+	ctx.MsgID = MsgID(binary.BigEndian.Uint16(b))
+	ctx.Payload = b[HeaderSize:]
+	handler, ok := r.handlers[ctx.MsgID]
+	if !ok {
+		handler = r.notFound
+	}
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
+	r.mutex.RUnlock()
+
+	if err := handler(ctx); err != nil {
+		errorHandler(ctx, err)
+	}
+}
+
+// OnClose 销毁会话
+func (r *Router) OnClose(conn core.Conn) {
+	r.sessionMutex.Lock()
+	s, ok := r.sessions[conn]
+	delete(r.sessions, conn)
+	r.sessionMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	r.mutex.RLock()
+	fn := r.onClose
+	r.mutex.RUnlock()
+	if fn != nil {
+		fn(s)
+	}
+}
@@ -0,0 +1,168 @@
+package router
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"testing"
+)
+
+type mockConn struct {
+	sync.Mutex
+	written [][]byte
+	closed  bool
+}
+
+func (conn *mockConn) Run()                 {}
+func (conn *mockConn) Close()               { conn.closed = true }
+func (conn *mockConn) LocalAddr() net.Addr  { return nil }
+func (conn *mockConn) RemoteAddr() net.Addr { return nil }
+func (conn *mockConn) ID() core.ID          { return 0 }
+func (conn *mockConn) Write(b []byte) (int, error) {
+	conn.Lock()
+	defer conn.Unlock()
+	conn.written = append(conn.written, b)
+	return len(b), nil
+}
+
+type loginReq struct {
+	Name string
+}
+
+type loginResp struct {
+	Welcome string
+}
+
+const (
+	msgLogin MsgID = iota + 1
+	msgLoginResp
+	msgEcho
+	msgPanic
+)
+
+func newTestRouter() (*Router, *[]error) {
+	r := New()
+	errs := new([]error)
+	r.OnError(func(ctx *Context, err error) {
+		*errs = append(*errs, err)
+	})
+	Register(r, msgLogin, func(ctx *Context, req *loginReq) error {
+		ctx.Session.Set("name", req.Name)
+		return ctx.Reply(msgLoginResp, &loginResp{Welcome: "hello " + req.Name})
+	})
+	Register(r, msgEcho, func(ctx *Context, req []byte) error {
+		return ctx.Reply(msgEcho, req)
+	})
+	Register(r, msgPanic, func(ctx *Context, req []byte) error {
+		panic("handler panic")
+	})
+	return r, errs
+}
+
+func TestRouter_Dispatch(t *testing.T) {
+	r := require.New(t)
+	router, errs := newTestRouter()
+	conn := new(mockConn)
+	router.OnConnect(conn)
+
+	router.OnMessage(Pack(msgLogin, []byte(`{"Name":"tom"}`)), conn)
+	r.Empty(*errs)
+	r.Equal(Pack(msgLoginResp, []byte(`{"Welcome":"hello tom"}`)), conn.written[0])
+	name, ok := router.GetSession(conn).Get("name")
+	r.True(ok)
+	r.Equal("tom", name)
+
+	router.OnMessage(Pack(msgEcho, []byte("raw")), conn)
+	r.Equal(Pack(msgEcho, []byte("raw")), conn.written[1])
+
+	// 负载解析失败
+	router.OnMessage(Pack(msgLogin, []byte("{")), conn)
+	r.Len(*errs, 1)
+	// 消息长度不足
+	router.OnMessage([]byte{1}, conn)
+	r.Equal(ErrInvalidMessage, (*errs)[1])
+	// 未注册的消息
+	router.OnMessage(Pack(100, nil), conn)
+	r.Equal(ErrUnknownMessage, (*errs)[2])
+
+	var unknown MsgID
+	router.NotFound(func(ctx *Context) error {
+		unknown = ctx.MsgID
+		return nil
+	})
+	router.OnMessage(Pack(100, nil), conn)
+	r.Equal(MsgID(100), unknown)
+	r.Len(*errs, 3)
+}
+
+func TestRouter_Middleware(t *testing.T) {
+	r := require.New(t)
+	router, errs := newTestRouter()
+	var order []string
+	for _, name := range []string{"first", "second"} {
+		name := name
+		router.Use(func(next HandlerFunc) HandlerFunc {
+			return func(ctx *Context) error {
+				order = append(order, name)
+				return next(ctx)
+			}
+		})
+	}
+	router.Use(Recover(), Logger())
+	router.Use(Auth(func(ctx *Context) bool {
+		_, ok := ctx.Session.Get("name")
+		return ok
+	}, msgLogin))
+
+	conn := new(mockConn)
+	router.OnConnect(conn)
+	router.OnMessage(Pack(msgEcho, []byte("raw")), conn)
+	r.Equal([]error{ErrUnauthorized}, *errs)
+	r.Equal([]string{"first", "second"}, order)
+	r.Empty(conn.written)
+
+	router.OnMessage(Pack(msgLogin, []byte(`{"Name":"tom"}`)), conn)
+	router.OnMessage(Pack(msgEcho, []byte("raw")), conn)
+	r.Len(conn.written, 2)
+
+	router.OnMessage(Pack(msgPanic, nil), conn)
+	r.Equal(ErrHandlerPanic, (*errs)[1])
+}
+
+func TestRouter_Session(t *testing.T) {
+	r := require.New(t)
+	router, _ := newTestRouter()
+	var started, ended *Session
+	router.OnSessionStart(func(s *Session) { started = s })
+	router.OnSessionEnd(func(s *Session) { ended = s })
+
+	conn := new(mockConn)
+	router.OnConnect(conn)
+	r.NotNil(started)
+	r.Equal(started, router.GetSession(conn))
+	r.Equal(conn, started.Conn())
+	started.Set("key", 1)
+	started.Delete("key")
+	_, ok := started.Get("key")
+	r.False(ok)
+
+	router.OnClose(conn)
+	r.Equal(started, ended)
+	r.Nil(router.GetSession(conn))
+	// 重复关闭不会再次回调
+	ended = nil
+	router.OnClose(conn)
+	r.Nil(ended)
+}
+
+func TestHandle(t *testing.T) {
+	r := require.New(t)
+	Handle(msgEcho, func(ctx *Context, req []byte) error {
+		return ctx.Reply(msgEcho, req)
+	})
+	conn := new(mockConn)
+	DefaultRouter.NewAgent().OnConnect(conn)
+	DefaultRouter.OnMessage(Pack(msgEcho, []byte("raw")), conn)
+	r.Equal(Pack(msgEcho, []byte("raw")), conn.written[0])
+}
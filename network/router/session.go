@@ -0,0 +1,47 @@
+package router
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"sync"
+)
+
+// Session 连接会话，和 core.Conn 一一对应，在连接建立时创建，断开时销毁
+type Session struct {
+	conn   core.Conn
+	mutex  sync.RWMutex
+	values map[string]interface{}
+}
+
+func newSession(conn core.Conn) *Session {
+	return &Session{
+		conn:   conn,
+		values: make(map[string]interface{}),
+	}
+}
+
+// Conn 会话对应的连接
+func (s *Session) Conn() core.Conn {
+	return s.conn
+}
+
+// Get 获取会话数据
+func (s *Session) Get(key string) (interface{}, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set 设置会话数据
+func (s *Session) Set(key string, value interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values[key] = value
+}
+
+// Delete 删除会话数据
+func (s *Session) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.values, key)
+}
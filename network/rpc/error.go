@@ -0,0 +1,26 @@
+package rpc
+
+import "errors"
+
+var (
+	// ErrClosed 连接已经断开，正在等待回复的调用都会返回这个错误
+	ErrClosed = errors.New("rpc connection closed")
+	// ErrInvalidFrame 不合法的 RPC 数据帧
+	ErrInvalidFrame = errors.New("invalid rpc frame")
+	// ErrUnknownMethod 调用了没有注册的方法
+	ErrUnknownMethod = errors.New("rpc unknown method")
+	// ErrHandlerPanic 方法处理函数崩溃
+	ErrHandlerPanic = errors.New("rpc handler panic")
+)
+
+// RemoteError 对端处理函数返回的错误
+type RemoteError struct {
+	// Method 调用的方法
+	Method string
+	// Message 对端的错误信息
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "rpc " + e.Method + " remote error: " + e.Message
+}
@@ -0,0 +1,49 @@
+package rpc
+
+import "encoding/binary"
+
+const (
+	kindRequest  byte = 1
+	kindResponse byte = 2
+	kindNotify   byte = 3
+	// kindError 失败的回复，错误信息可以为空，不能通过错误信息是否为空判断成功
+	kindError byte = 4
+
+	// frameHeaderSize kind(1) seq(4) nameLen(2)
+	frameHeaderSize = 7
+)
+
+// frame RPC 数据帧，请求和通知的 name 为方法名，kindError 回复的 name 为错误信息
+type frame struct {
+	kind    byte
+	seq     uint32
+	name    string
+	payload []byte
+}
+
+func (f *frame) encode() []byte {
+	b := make([]byte, frameHeaderSize+len(f.name)+len(f.payload))
+	b[0] = f.kind
+	binary.BigEndian.PutUint32(b[1:], f.seq)
+	binary.BigEndian.PutUint16(b[5:], uint16(len(f.name)))
+	copy(b[frameHeaderSize:], f.name)
+	copy(b[frameHeaderSize+len(f.name):], f.payload)
+	return b
+}
+
+func decodeFrame(b []byte) (*frame, error) {
+	if len(b) < frameHeaderSize {
+		return nil, ErrInvalidFrame
+	}
+	f := &frame{
+		kind: b[0],
+		seq:  binary.BigEndian.Uint32(b[1:]),
+	}
+	nameLen := int(binary.BigEndian.Uint16(b[5:]))
+	if len(b) < frameHeaderSize+nameLen || f.kind < kindRequest || f.kind > kindError {
+		return nil, ErrInvalidFrame
+	}
+	f.name = string(b[frameHeaderSize : frameHeaderSize+nameLen])
+	f.payload = b[frameHeaderSize+nameLen:]
+	return f, nil
+}
@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"github.com/finishy1995/go-library/network/codec"
+	"time"
+)
+
+const (
+	// DefaultCallTimeout 默认调用超时时间，ctx 没有设置截止时间时使用
+	DefaultCallTimeout = time.Second * 10
+)
+
+// Options 选项
+type Options struct {
+	// Marshaler 请求和回复的序列化方式，默认为 JSON
+	Marshaler codec.Marshaler
+	// CallTimeout 调用超时时间，ctx 没有设置截止时间时使用，0 为不限制
+	CallTimeout time.Duration
+}
+
+// Option 选项闭包
+type Option func(*Options)
+
+// WithMarshaler 设置序列化方式
+func WithMarshaler(marshaler codec.Marshaler) Option {
+	return func(options *Options) {
+		options.Marshaler = marshaler
+	}
+}
+
+// WithCallTimeout 设置默认调用超时时间
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(options *Options) {
+		options.CallTimeout = timeout
+	}
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"context"
+	"github.com/finishy1995/go-library/network/core"
+	"sync"
+	"sync/atomic"
+)
+
+// result 一次调用的回复
+type result struct {
+	payload []byte
+	err     error
+}
+
+// Peer 一个连接上的 RPC 端点，可以发起调用和推送通知
+type Peer struct {
+	conn    core.Conn
+	service *Service
+	seq     uint32
+
+	mutex   sync.Mutex
+	pending map[uint32]chan *result
+	closed  bool
+}
+
+func newPeer(conn core.Conn, service *Service) *Peer {
+	return &Peer{
+		conn:    conn,
+		service: service,
+		pending: make(map[uint32]chan *result),
+	}
+}
+
+// Conn 获取对应的连接
+func (p *Peer) Conn() core.Conn {
+	return p.conn
+}
+
+// Call 调用对端注册的方法并等待回复，req 和 resp 为 []byte（resp 为 *[]byte）时不经过序列化，resp 为 nil 时忽略回复内容
+//
+//	ctx 没有设置截止时间时使用 CallTimeout；ctx 取消或超时返回 ctx.Err()，连接断开返回 ErrClosed，对端返回错误时为 *RemoteError
+func (p *Peer) Call(ctx context.Context, method string, req interface{}, resp interface{}) error {
+	payload, err := p.service.marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok && p.service.callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.service.callTimeout)
+		defer cancel()
+	}
+
+	seq := atomic.AddUint32(&p.seq, 1)
+	ch := make(chan *result, 1)
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return ErrClosed
+	}
+	p.pending[seq] = ch
+	p.mutex.Unlock()
+
+	err = p.write(&frame{kind: kindRequest, seq: seq, name: method, payload: payload})
+	if err != nil {
+		p.remove(seq)
+		return err
+	}
+
+	select {
+	case res := <-ch:
+		if remote, ok := res.err.(*RemoteError); ok {
+			remote.Method = method
+		}
+		if res.err != nil {
+			return res.err
+		}
+		return p.service.unmarshal(res.payload, resp)
+	case <-ctx.Done():
+		p.remove(seq)
+		return ctx.Err()
+	}
+}
+
+// Notify 向对端推送单向通知，不等待回复
+func (p *Peer) Notify(method string, v interface{}) error {
+	payload, err := p.service.marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.write(&frame{kind: kindNotify, name: method, payload: payload})
+}
+
+func (p *Peer) write(f *frame) error {
+	p.mutex.Lock()
+	closed := p.closed
+	p.mutex.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	_, err := p.conn.Write(f.encode())
+	return err
+}
+
+// reply 回复一次调用
+func (p *Peer) reply(seq uint32, payload []byte, err error) {
+	f := &frame{kind: kindResponse, seq: seq, payload: payload}
+	if err != nil {
+		f.kind = kindError
+		f.name = err.Error()
+		f.payload = nil
+	}
+	_ = p.write(f)
+}
+
+// resolve 把回复交给等待的调用，调用已经超时或取消时丢弃
+func (p *Peer) resolve(f *frame) {
+	ch := p.remove(f.seq)
+	if ch == nil {
+		return
+	}
+	res := &result{payload: f.payload}
+	if f.kind == kindError {
+		res.err = &RemoteError{Message: f.name}
+	}
+	ch <- res
+}
+
+func (p *Peer) remove(seq uint32) chan *result {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ch, ok := p.pending[seq]
+	if !ok {
+		return nil
+	}
+	delete(p.pending, seq)
+	return ch
+}
+
+// close 标记端点关闭，所有等待中的调用返回 ErrClosed
+func (p *Peer) close() {
+	p.mutex.Lock()
+	p.closed = true
+	pending := p.pending
+	p.pending = make(map[uint32]chan *result)
+	p.mutex.Unlock()
+
+	for _, ch := range pending {
+		ch <- &result{err: ErrClosed}
+	}
+}
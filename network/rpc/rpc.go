@@ -0,0 +1,225 @@
+package rpc
+
+import (
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/codec"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/routine"
+	"sync"
+	"time"
+)
+
+// handler 方法处理函数，负载为序列化后的请求和回复
+type handler func(p *Peer, payload []byte) ([]byte, error)
+
+// notifyHandler 通知处理函数
+type notifyHandler func(p *Peer, payload []byte) error
+
+// Service RPC 服务，实现了 core.Agent，客户端和服务端使用相同的 Service
+//
+//	每个连接对应一个 Peer，两端都可以注册方法、发起调用和推送通知；
+//	调用在独立的协程中处理，处理函数中可以反向调用对端，通知按照收到的顺序在连接的协程中处理
+type Service struct {
+	marshaler   codec.Marshaler
+	callTimeout time.Duration
+
+	mutex    sync.RWMutex
+	methods  map[string]handler
+	notifies map[string]notifyHandler
+	onOpen   func(p *Peer)
+	onClose  func(p *Peer)
+
+	peerMutex sync.RWMutex
+	peers     map[core.Conn]*Peer
+}
+
+// New 创建 Service
+func New(opts ...Option) *Service {
+	options := Options{
+		Marshaler:   new(codec.JSONMarshaler),
+		CallTimeout: DefaultCallTimeout,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	return &Service{
+		marshaler:   options.Marshaler,
+		callTimeout: options.CallTimeout,
+		methods:     make(map[string]handler),
+		notifies:    make(map[string]notifyHandler),
+		peers:       make(map[core.Conn]*Peer),
+	}
+}
+
+// Register 注册方法，Req/Resp 为 []byte 时不经过序列化
+func Register[Req any, Resp any](s *Service, method string, fn func(p *Peer, req Req) (Resp, error)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.methods[method]; ok {
+		log.Warning("rpc method %s already registered, replaced", method)
+	}
+	s.methods[method] = func(p *Peer, payload []byte) ([]byte, error) {
+		var req Req
+		if err := s.unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		resp, err := fn(p, req)
+		if err != nil {
+			return nil, err
+		}
+		return s.marshal(resp)
+	}
+}
+
+// RegisterNotify 注册通知处理函数，T 为 []byte 时不经过序列化
+func RegisterNotify[T any](s *Service, method string, fn func(p *Peer, msg T)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.notifies[method]; ok {
+		log.Warning("rpc notify %s already registered, replaced", method)
+	}
+	s.notifies[method] = func(p *Peer, payload []byte) error {
+		var msg T
+		if err := s.unmarshal(payload, &msg); err != nil {
+			return err
+		}
+		fn(p, msg)
+		return nil
+	}
+}
+
+// OnPeerOpen 设置连接建立、Peer 创建后的回调，客户端可以在这里保存 Peer 用于发起调用
+func (s *Service) OnPeerOpen(fn func(p *Peer)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onOpen = fn
+}
+
+// OnPeerClose 设置连接断开后的回调，此时等待中的调用已经返回 ErrClosed
+func (s *Service) OnPeerClose(fn func(p *Peer)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onClose = fn
+}
+
+// GetPeer 获取连接对应的 Peer，连接已经断开时返回 nil
+func (s *Service) GetPeer(conn core.Conn) *Peer {
+	s.peerMutex.RLock()
+	defer s.peerMutex.RUnlock()
+	return s.peers[conn]
+}
+
+// NewAgent 用于 network.Listen/Connect，所有连接共用这个 Service
+func (s *Service) NewAgent() core.Agent {
+	return s
+}
+
+// OnConnect 创建 Peer
+func (s *Service) OnConnect(conn core.Conn) {
+	p := newPeer(conn, s)
+	s.peerMutex.Lock()
+	s.peers[conn] = p
+	s.peerMutex.Unlock()
+
+	s.mutex.RLock()
+	fn := s.onOpen
+	s.mutex.RUnlock()
+	if fn != nil {
+		fn(p)
+	}
+}
+
+// OnMessage 处理调用、回复和通知
+func (s *Service) OnMessage(b []byte, conn core.Conn) {
+	p := s.GetPeer(conn)
+	if p == nil {
+		return
+	}
+	f, err := decodeFrame(b)
+	if err != nil {
+		log.Error("rpc message from %s invalid, error: %s", conn.RemoteAddr(), err.Error())
+		return
+	}
+	// 负载在回调后可能被复用
+	f.payload = append([]byte(nil), f.payload...)
+
+	switch f.kind {
+	case kindRequest:
+		s.mutex.RLock()
+		h, ok := s.methods[f.name]
+		s.mutex.RUnlock()
+		if !ok {
+			p.reply(f.seq, nil, ErrUnknownMethod)
+			return
+		}
+		err = routine.Run(false, func() {
+			s.serve(p, f, h)
+		})
+		if err != nil {
+			p.reply(f.seq, nil, err)
+		}
+	case kindResponse, kindError:
+		p.resolve(f)
+	case kindNotify:
+		s.mutex.RLock()
+		h, ok := s.notifies[f.name]
+		s.mutex.RUnlock()
+		if !ok {
+			log.Warning("rpc notify %s from %s not registered", f.name, conn.RemoteAddr())
+			return
+		}
+		if err = h(p, f.payload); err != nil {
+			log.Error("rpc notify %s from %s failed, error: %s", f.name, conn.RemoteAddr(), err.Error())
+		}
+	}
+}
+
+// serve 执行方法并回复，处理函数崩溃时回复 ErrHandlerPanic
+func (s *Service) serve(p *Peer, f *frame, h handler) {
+	var payload []byte
+	err := ErrHandlerPanic
+	func() {
+		defer routine.Catch()
+		payload, err = h(p, f.payload)
+	}()
+	p.reply(f.seq, payload, err)
+}
+
+// OnClose 关闭 Peer，等待中的调用返回 ErrClosed
+func (s *Service) OnClose(conn core.Conn) {
+	s.peerMutex.Lock()
+	p, ok := s.peers[conn]
+	delete(s.peers, conn)
+	s.peerMutex.Unlock()
+	if !ok {
+		return
+	}
+	p.close()
+
+	s.mutex.RLock()
+	fn := s.onClose
+	s.mutex.RUnlock()
+	if fn != nil {
+		fn(p)
+	}
+}
+
+// marshal v 为 []byte 时直接使用
+func (s *Service) marshal(v interface{}) ([]byte, error) {
+	if raw, ok := v.([]byte); ok {
+		return raw, nil
+	}
+	return s.marshaler.Marshal(v)
+}
+
+// unmarshal v 为 nil 时忽略，为 *[]byte 时直接赋值
+func (s *Service) unmarshal(data []byte, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	if raw, ok := v.(*[]byte); ok {
+		*raw = data
+		return nil
+	}
+	return s.marshaler.Unmarshal(data, v)
+}
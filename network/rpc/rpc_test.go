@@ -0,0 +1,229 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeConn 内存中的连接，Write 的数据按顺序交给对端 Service
+type pipeConn struct {
+	sync.Mutex
+	remote  *pipeConn
+	service *Service
+	queue   chan []byte
+	closed  bool
+}
+
+func newPipe(a *Service, b *Service) (*pipeConn, *pipeConn) {
+	ca := &pipeConn{service: a, queue: make(chan []byte, 64)}
+	cb := &pipeConn{service: b, queue: make(chan []byte, 64)}
+	ca.remote, cb.remote = cb, ca
+	for _, c := range []*pipeConn{ca, cb} {
+		c.service.OnConnect(c)
+		go c.Run()
+	}
+	return ca, cb
+}
+
+func (conn *pipeConn) Run() {
+	for b := range conn.queue {
+		conn.service.OnMessage(b, conn)
+	}
+	conn.service.OnClose(conn)
+}
+
+func (conn *pipeConn) Close() {
+	for _, c := range []*pipeConn{conn, conn.remote} {
+		c.Lock()
+		if !c.closed {
+			c.closed = true
+			close(c.queue)
+		}
+		c.Unlock()
+	}
+}
+
+func (conn *pipeConn) LocalAddr() net.Addr  { return nil }
+func (conn *pipeConn) RemoteAddr() net.Addr { return nil }
+func (conn *pipeConn) ID() core.ID          { return 0 }
+func (conn *pipeConn) Write(b []byte) (int, error) {
+	remote := conn.remote
+	remote.Lock()
+	defer remote.Unlock()
+	if remote.closed {
+		return 0, ErrClosed
+	}
+	remote.queue <- b
+	return len(b), nil
+}
+
+type addReq struct {
+	A, B int
+}
+
+type addResp struct {
+	Sum int
+}
+
+func newTestPair(opts ...Option) (*Service, *Service, *Peer, *Peer) {
+	server := New(opts...)
+	client := New(opts...)
+	Register(server, "add", func(p *Peer, req *addReq) (*addResp, error) {
+		return &addResp{Sum: req.A + req.B}, nil
+	})
+	Register(server, "echo", func(p *Peer, req []byte) ([]byte, error) {
+		return req, nil
+	})
+	Register(server, "fail", func(p *Peer, req []byte) ([]byte, error) {
+		return nil, errors.New("bad request")
+	})
+	Register(server, "empty", func(p *Peer, req []byte) ([]byte, error) {
+		return nil, errors.New("")
+	})
+	Register(server, "panic", func(p *Peer, req []byte) ([]byte, error) {
+		panic("handler panic")
+	})
+	Register(server, "sleep", func(p *Peer, req []byte) ([]byte, error) {
+		time.Sleep(time.Millisecond * 200)
+		return nil, nil
+	})
+	sc, cc := newPipe(server, client)
+	return server, client, server.GetPeer(sc), client.GetPeer(cc)
+}
+
+func TestCall(t *testing.T) {
+	r := require.New(t)
+	_, _, _, client := newTestPair()
+	defer client.Conn().Close()
+
+	resp := new(addResp)
+	r.Nil(client.Call(context.Background(), "add", &addReq{A: 1, B: 2}, resp))
+	r.Equal(3, resp.Sum)
+
+	var raw []byte
+	r.Nil(client.Call(context.Background(), "echo", []byte("hi"), &raw))
+	r.Equal([]byte("hi"), raw)
+
+	// 并发调用通过序列号区分回复
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := new(addResp)
+			r.Nil(client.Call(context.Background(), "add", &addReq{A: i, B: i}, resp))
+			r.Equal(i*2, resp.Sum)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCallError(t *testing.T) {
+	r := require.New(t)
+	_, _, _, client := newTestPair()
+	defer client.Conn().Close()
+
+	var remote *RemoteError
+	err := client.Call(context.Background(), "fail", nil, nil)
+	r.True(errors.As(err, &remote))
+	r.Equal("fail", remote.Method)
+	r.Equal("bad request", remote.Message)
+
+	// 错误信息为空时仍然是失败
+	err = client.Call(context.Background(), "empty", nil, nil)
+	r.True(errors.As(err, &remote))
+	r.Equal("", remote.Message)
+
+	err = client.Call(context.Background(), "panic", nil, nil)
+	r.True(errors.As(err, &remote))
+	r.Equal(ErrHandlerPanic.Error(), remote.Message)
+
+	err = client.Call(context.Background(), "unknown", nil, nil)
+	r.True(errors.As(err, &remote))
+	r.Equal(ErrUnknownMethod.Error(), remote.Message)
+}
+
+func TestCallTimeoutAndCancel(t *testing.T) {
+	r := require.New(t)
+	_, _, _, client := newTestPair(WithCallTimeout(time.Millisecond * 50))
+	defer client.Conn().Close()
+
+	r.Equal(context.DeadlineExceeded, client.Call(context.Background(), "sleep", nil, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*20, cancel)
+	r.Equal(context.Canceled, client.Call(ctx, "sleep", nil, nil))
+
+	// 超时后到达的回复被丢弃，不影响后续调用
+	time.Sleep(time.Millisecond * 300)
+	r.Nil(client.Call(context.Background(), "echo", nil, nil))
+}
+
+func TestCallClosed(t *testing.T) {
+	r := require.New(t)
+	_, _, server, client := newTestPair()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call(context.Background(), "sleep", nil, nil)
+	}()
+	time.Sleep(time.Millisecond * 50)
+	server.Conn().Close()
+	r.Equal(ErrClosed, <-done)
+	r.Equal(ErrClosed, client.Call(context.Background(), "echo", nil, nil))
+}
+
+func TestNotify(t *testing.T) {
+	r := require.New(t)
+	server, client, _, clientPeer := newTestPair()
+	defer clientPeer.Conn().Close()
+
+	received := make(chan string, 10)
+	RegisterNotify(server, "hello", func(p *Peer, msg string) {
+		received <- msg
+		// 服务端在同一个连接上反向推送
+		_ = p.Notify("world", msg+"!")
+	})
+	RegisterNotify(client, "world", func(p *Peer, msg string) {
+		received <- msg
+	})
+
+	r.Nil(clientPeer.Notify("hello", "a"))
+	r.Nil(clientPeer.Notify("hello", "b"))
+	var got []string
+	for i := 0; i < 4; i++ {
+		select {
+		case msg := <-received:
+			got = append(got, msg)
+		case <-time.After(time.Second):
+			r.FailNow("notify timeout")
+		}
+	}
+	r.ElementsMatch([]string{"a", "b", "a!", "b!"}, got)
+}
+
+func TestCallBothWays(t *testing.T) {
+	r := require.New(t)
+	server, client, _, clientPeer := newTestPair()
+	defer clientPeer.Conn().Close()
+
+	Register(client, "name", func(p *Peer, req []byte) (string, error) {
+		return "client", nil
+	})
+	// 处理函数中反向调用对端
+	Register(server, "whoami", func(p *Peer, req []byte) (string, error) {
+		var name string
+		err := p.Call(context.Background(), "name", nil, &name)
+		return "hello " + name, err
+	})
+
+	var resp string
+	r.Nil(clientPeer.Call(context.Background(), "whoami", nil, &resp))
+	r.Equal("hello client", resp)
+}
@@ -0,0 +1,106 @@
+package network
+
+import (
+	"context"
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shutdownAgent 服务器优雅关闭时通知客户端迁移
+type shutdownAgent struct {
+	agent.SingleAgent
+	shutdowns int32
+}
+
+func (a *shutdownAgent) OnShutdown(conn core.Conn) {
+	atomic.AddInt32(&a.shutdowns, 1)
+	_, _ = conn.Write([]byte("migrate"))
+}
+
+// migrateAgent 收到迁移通知后主动断开
+type migrateAgent struct {
+	agent.SingleAgent
+	migrate  bool
+	messages int32
+}
+
+func (a *migrateAgent) OnMessage(b []byte, conn core.Conn) {
+	atomic.AddInt32(&a.messages, 1)
+	if a.migrate && string(b) == "migrate" {
+		conn.Close()
+	}
+}
+
+// 测试优雅关闭：通知 Agent，客户端断开后立即返回，超时后强制关闭剩余连接
+func TestNetworkShutdown(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet, WebSocket} {
+		for _, migrate := range []bool{true, false} {
+			t.Logf("test network type: %d, migrate: %v", typ, migrate)
+			serverAgent := new(shutdownAgent)
+			s, err := Listen(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return serverAgent })
+			r.Nil(err)
+			gs, ok := s.(core.GracefulServer)
+			r.True(ok)
+			time.Sleep(ListenAllowWaitTime)
+
+			clientAgent := &migrateAgent{migrate: migrate}
+			for i := 0; i < TestThread; i++ {
+				_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return clientAgent })
+				r.Nil(err)
+			}
+			time.Sleep(WaitConnectTime * 5)
+			r.Equal(TestThread, s.GetConnNum())
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+			start := time.Now()
+			err = gs.Shutdown(ctx)
+			elapsed := time.Since(start)
+			cancel()
+			if migrate {
+				r.Nil(err)
+				r.Less(elapsed, time.Millisecond*400)
+			} else {
+				r.Equal(context.DeadlineExceeded, err)
+			}
+			r.Equal(int32(TestThread), atomic.LoadInt32(&serverAgent.shutdowns))
+			r.Equal(int32(TestThread), atomic.LoadInt32(&clientAgent.messages))
+			r.Equal(0, s.GetConnNum())
+			r.Equal(core.ErrServerClosed, gs.Shutdown(context.Background()))
+			r.Nil(DestroyAll())
+			time.Sleep(DestroyAllowWaitTime)
+		}
+	}
+}
+
+// 测试全局优雅关闭期间不阻塞 GetConnNum 和 Stats
+func TestNetworkShutdownNotBlocking(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	_, err := Listen(TcpNet, "127.0.0.1:"+TestPort1, agent.GetSingleAgent)
+	r.Nil(err)
+	time.Sleep(ListenAllowWaitTime)
+	_, err = Connect(TcpNet, "127.0.0.1:"+TestPort1, agent.GetSingleAgent)
+	r.Nil(err)
+	time.Sleep(WaitConnectTime * 5)
+
+	done := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	go func() {
+		done <- Shutdown(ctx)
+	}()
+	time.Sleep(time.Millisecond * 100)
+	start := time.Now()
+	r.Equal(2, GetConnNum())
+	r.Len(Stats().Servers, 1)
+	r.Less(time.Since(start), time.Millisecond*100)
+	r.Equal(context.DeadlineExceeded, <-done)
+	r.Equal(0, GetConnNum())
+	r.Len(Stats().Servers, 0)
+}
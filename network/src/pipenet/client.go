@@ -0,0 +1,33 @@
+package pipenet
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/network/src/tcpnet"
+	"net"
+	"time"
+)
+
+// Client 进程内管道客户端，基于 tcpnet 实现，地址格式为 pipe:名称，没有配置写队列时使用 core.DefaultWriteQueueOptions
+type Client struct {
+	tcpnet.Client
+}
+
+// Start 开启管道客户端连接
+func (client *Client) Start(address string, newAgent core.GetAgent, opts ...core.ClientOption) error {
+	if network, _ := core.SplitAddress(address); network != core.PipeNetwork {
+		return core.ErrInvalidAddress
+	}
+	options := core.DefaultClientOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.WriteQueue.HighWatermark <= 0 {
+		queue := core.DefaultWriteQueueOptions
+		opts = append(opts, core.WithClientWriteQueue(queue.HighWatermark, queue.LowWatermark, queue.Policy))
+	}
+	client.Dial = func(address string, timeout time.Duration) (net.Conn, error) {
+		_, name := core.SplitAddress(address)
+		return Dial(name, timeout)
+	}
+	return client.Client.Start(address, newAgent, opts...)
+}
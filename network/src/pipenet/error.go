@@ -0,0 +1,12 @@
+package pipenet
+
+import "errors"
+
+var (
+	// ErrAddressInUse 管道名称已经被监听
+	ErrAddressInUse = errors.New("pipe address already in use")
+	// ErrConnectRefused 管道名称没有被监听
+	ErrConnectRefused = errors.New("pipe connection refused")
+	// ErrConnectTimeout 等待队列已满，超时前没有被 Accept
+	ErrConnectTimeout = errors.New("pipe connect timeout")
+)
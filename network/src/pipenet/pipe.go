@@ -0,0 +1,144 @@
+package pipenet
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backlog 每个监听等待 Accept 的连接数量，超过后 Dial 等待直到超时
+const Backlog = 128
+
+var (
+	mutex     sync.Mutex
+	listeners = make(map[string]*listener)
+	// 连接序号，用于区分同一个管道的不同连接
+	seq uint64
+)
+
+// Addr 管道地址
+type Addr string
+
+// Network 网络类型
+func (addr Addr) Network() string {
+	return core.PipeNetwork
+}
+
+// String 地址字符串，和监听地址的格式相同
+func (addr Addr) String() string {
+	return core.PipeNetwork + ":" + string(addr)
+}
+
+// conn 管道的一端，替换 net.Pipe 的地址
+type conn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// listener 进程内的管道监听，Dial 创建的连接放入等待队列由 Accept 取出
+type listener struct {
+	name     string
+	backlog  chan net.Conn
+	closeSig chan struct{}
+	once     sync.Once
+}
+
+// Listen 监听管道名称，同一个名称同时只能有一个监听，关闭后可以重新监听
+func Listen(name string) (net.Listener, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := listeners[name]; ok {
+		return nil, ErrAddressInUse
+	}
+	ln := &listener{
+		name:     name,
+		backlog:  make(chan net.Conn, Backlog),
+		closeSig: make(chan struct{}),
+	}
+	listeners[name] = ln
+	return ln, nil
+}
+
+// Dial 连接管道名称，返回的连接基于 net.Pipe，写入会阻塞到对端读取
+func Dial(name string, timeout time.Duration) (net.Conn, error) {
+	mutex.Lock()
+	ln, ok := listeners[name]
+	mutex.Unlock()
+	if !ok {
+		return nil, ErrConnectRefused
+	}
+
+	client, server := net.Pipe()
+	clientAddr := Addr(name + "#" + strconv.FormatUint(atomic.AddUint64(&seq, 1), 10))
+	serverConn := &conn{Conn: server, local: Addr(name), remote: clientAddr}
+	clientConn := &conn{Conn: client, local: clientAddr, remote: Addr(name)}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ln.backlog <- serverConn:
+		// 放入后监听可能已经关闭，关闭等待队列中剩余的连接
+		select {
+		case <-ln.closeSig:
+			ln.drain()
+		default:
+		}
+		return clientConn, nil
+	case <-ln.closeSig:
+		return nil, ErrConnectRefused
+	case <-timer.C:
+		return nil, ErrConnectTimeout
+	}
+}
+
+// Accept 取出等待队列中的连接，监听关闭后返回 net.ErrClosed
+func (ln *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.backlog:
+		return c, nil
+	case <-ln.closeSig:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭监听和等待队列中的连接，已经 Accept 的连接不受影响
+func (ln *listener) Close() error {
+	ln.once.Do(func() {
+		mutex.Lock()
+		if listeners[ln.name] == ln {
+			delete(listeners, ln.name)
+		}
+		mutex.Unlock()
+		close(ln.closeSig)
+		ln.drain()
+	})
+	return nil
+}
+
+// Addr 监听地址
+func (ln *listener) Addr() net.Addr {
+	return Addr(ln.name)
+}
+
+// drain 关闭等待队列中的连接
+func (ln *listener) drain() {
+	for {
+		select {
+		case c := <-ln.backlog:
+			_ = c.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package pipenet
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	r := require.New(t)
+	_, err := Dial("test", time.Millisecond*10)
+	r.Equal(ErrConnectRefused, err)
+
+	ln, err := Listen("test")
+	r.Nil(err)
+	_, err = Listen("test")
+	r.Equal(ErrAddressInUse, err)
+	r.Equal("pipe:test", ln.Addr().String())
+
+	client, err := Dial("test", time.Millisecond*10)
+	r.Nil(err)
+	server, err := ln.Accept()
+	r.Nil(err)
+	r.Equal(client.LocalAddr(), server.RemoteAddr())
+	r.Equal("pipe:test", client.RemoteAddr().String())
+
+	go func() {
+		_, _ = client.Write([]byte("hello"))
+	}()
+	b := make([]byte, 5)
+	_, err = io.ReadFull(server, b)
+	r.Nil(err)
+	r.Equal("hello", string(b))
+
+	// 关闭监听时关闭等待队列中的连接，已经 Accept 的连接不受影响
+	pending, err := Dial("test", time.Millisecond*10)
+	r.Nil(err)
+	r.Nil(ln.Close())
+	_, err = ln.Accept()
+	r.Equal(net.ErrClosed, err)
+	_, err = pending.Read(b)
+	r.Equal(io.EOF, err)
+	go func() {
+		_, _ = server.Write([]byte("world"))
+	}()
+	_, err = io.ReadFull(client, b)
+	r.Nil(err)
+	_, err = Dial("test", time.Millisecond*10)
+	r.Equal(ErrConnectRefused, err)
+
+	// 关闭后可以重新监听
+	ln, err = Listen("test")
+	r.Nil(err)
+	r.Nil(ln.Close())
+}
+
+func TestPipeBacklog(t *testing.T) {
+	r := require.New(t)
+	ln, err := Listen("backlog")
+	r.Nil(err)
+	defer ln.Close()
+	for i := 0; i < Backlog; i++ {
+		_, err = Dial("backlog", time.Millisecond*10)
+		r.Nil(err)
+	}
+	_, err = Dial("backlog", time.Millisecond*10)
+	r.Equal(ErrConnectTimeout, err)
+}
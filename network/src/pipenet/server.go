@@ -0,0 +1,35 @@
+package pipenet
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/network/src/tcpnet"
+	"net"
+)
+
+// Server 进程内管道服务器，基于 tcpnet 实现，地址格式为 pipe:名称，可以不占用端口测试 Agent
+//
+//	net.Pipe 的写入会阻塞到对端读取，没有配置写队列时使用 core.DefaultWriteQueueOptions，
+//	避免两端同时在 OnMessage 中写入时互相等待
+type Server struct {
+	tcpnet.Server
+}
+
+// Start 开始管道监听
+func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core.ServerOption) error {
+	if network, _ := core.SplitAddress(address); network != core.PipeNetwork {
+		return core.ErrInvalidAddress
+	}
+	options := core.DefaultServerOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.WriteQueue.HighWatermark <= 0 {
+		queue := core.DefaultWriteQueueOptions
+		opts = append(opts, core.WithWriteQueue(queue.HighWatermark, queue.LowWatermark, queue.Policy))
+	}
+	server.Listen = func(address string) (net.Listener, error) {
+		_, name := core.SplitAddress(address)
+		return Listen(name)
+	}
+	return server.Server.Start(address, newAgent, opts...)
+}
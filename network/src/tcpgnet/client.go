@@ -0,0 +1,13 @@
+package tcpgnet
+
+import (
+	"github.com/finishy1995/go-library/network/src/tcpnet"
+)
+
+// Client TCP GNet 客户端，基于 tcpnet 实现
+//
+//	gnet v1.4.6 没有客户端 API，客户端直接使用 tcpnet 客户端，写队列、写入超时等选项和 tcpnet 相同；
+//	两者的连接都使用 core.Codec 解析数据，因此可以和 tcpnet 服务端或者 tcpgnet 服务端互相连接
+type Client struct {
+	tcpnet.Client
+}
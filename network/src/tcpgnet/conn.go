@@ -1,11 +1,16 @@
 package tcpgnet
 
 import (
+	"bytes"
+	"crypto/tls"
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/codec"
 	"github.com/finishy1995/go-library/network/core"
 	"github.com/finishy1995/go-library/network/protocol"
-	"bytes"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/panjf2000/gnet"
 )
@@ -14,41 +19,103 @@ var (
 	protoc = protocol.ProtocolV001
 )
 
+// Conn 连接，底层为 gnet 连接，收到的数据经过 ConnHelper 缓冲后由 Codec 解析
 type Conn struct {
 	sync.Mutex
-	gnetConn  gnet.Conn
-	closeFlag bool
+	codec.ConnHelper
+	id       core.ID
+	gnetConn gnet.Conn
+	// 关闭标记，其他协程（Kick、Broadcast）可能关闭连接，使用原子操作
+	closeFlag int32
 	agent     core.Agent
+	codec     core.Codec
+
+	// 使用 TLS 时负责加解密，为 nil 时直接收发明文
+	bridge *tlsBridge
+
+	// 最近一次发送数据（包括心跳包）时间，Tick 和 Write 在不同协程，使用原子操作
+	lastHeartbeatTime int64
+	// 最近一次收到包时间
+	lastRecvTime int64
+	// 消息速率和带宽限制，nil 为不限制
+	limiter *core.ConnLimiter
+	// 流量统计，服务端连接同时累加到服务器的统计
+	counter core.Counter
+	// 建立连接时缓存的地址（启用 PROXY 协议时为头部中的地址），关闭后仍然可以读取
+	remote      net.Addr
+	local       net.Addr
+	connectedAt time.Time
+
+	// PROXY 协议头部，没有启用或者连接来自不受信任的地址时为 nil
+	proxyHeader *core.ProxyHeader
+	// 正在等待 PROXY 协议头部，收到的数据缓冲在 proxyBuf
+	proxyPending bool
+	proxyBuf     []byte
+}
+
+func getTime() int64 {
+	return time.Now().UnixNano() / 1000000
 }
 
 // Init 初始化
-func (conn *Conn) Init(c gnet.Conn) {
+func (conn *Conn) Init(c gnet.Conn, cc core.Codec) {
+	if cc == nil {
+		panic(core.ErrInvalidCodec)
+	}
+	conn.InitBuffer()
+	conn.id = core.GenerateID()
+	conn.codec = cc
 	conn.gnetConn = c
-	conn.closeFlag = false
+	conn.remote = c.RemoteAddr()
+	conn.local = c.LocalAddr()
+	atomic.StoreInt32(&conn.closeFlag, 0)
+	conn.bridge = nil
+	conn.limiter = nil
+	conn.counter.Reset(nil)
+	conn.connectedAt = time.Now()
+	conn.proxyHeader = nil
+	conn.proxyPending = false
+	conn.proxyBuf = nil
+	t := getTime()
+	atomic.StoreInt64(&conn.lastHeartbeatTime, t)
+	atomic.StoreInt64(&conn.lastRecvTime, t)
 }
 
 // Run 主逻辑
 func (conn *Conn) Run() {
 }
 
+// isClosed 连接是否已经关闭
+func (conn *Conn) isClosed() bool {
+	return atomic.LoadInt32(&conn.closeFlag) != 0
+}
+
 // Close 关闭
 func (conn *Conn) Close() {
-	if conn.closeFlag {
+	if conn.isClosed() {
 		return
 	}
 	conn.Lock()
 	defer conn.Unlock()
-	if conn.closeFlag {
+	if conn.isClosed() {
 		return
 	}
-	conn.closeFlag = true
+	atomic.StoreInt32(&conn.closeFlag, 1)
 	if conn.agent != nil {
 		conn.agent.OnClose(conn)
 	}
+	if cc, ok := conn.codec.(core.ClosingCodec); ok {
+		if b := cc.Closing(); b != nil {
+			_ = conn.send(b)
+		}
+	}
+	if conn.bridge != nil {
+		_ = conn.bridge.raw.Close()
+	}
 	if conn.gnetConn != nil {
 		err := conn.gnetConn.Close()
 		if err != nil {
-			panic(err)
+			log.Error("tcp close failed, error: %s", err.Error())
 		}
 	}
 
@@ -56,43 +123,202 @@ func (conn *Conn) Close() {
 	conn.gnetConn = nil
 }
 
-// Write 发送数据
+// Write 经过 Codec 编码后发送数据
 func (conn *Conn) Write(b []byte) (n int, err error) {
-	if conn.closeFlag {
+	if conn.isClosed() {
 		return
 	}
 	conn.Lock()
 	defer conn.Unlock()
-	if conn.closeFlag {
+	if conn.isClosed() {
 		return
 	}
-	n = len(b)
-	err = conn.gnetConn.AsyncWrite(b)
+	out, err := conn.codec.Encode(conn, b)
+	if err != nil {
+		return
+	}
+	err = conn.send(out)
+	if err == nil {
+		n = len(b)
+		atomic.StoreInt64(&conn.lastHeartbeatTime, getTime())
+		conn.counter.AddOut(len(out))
+		conn.counter.AddMessageOut()
+	}
 	return
 }
 
-// LocalAddr 本地地址
+// WriteFrame 发送广播消息，使用同一个 Codec 的连接共用编码结果
+func (conn *Conn) WriteFrame(frames *core.FrameCache) error {
+	if conn.isClosed() {
+		return core.ErrConnNotFound
+	}
+	conn.Lock()
+	defer conn.Unlock()
+	if conn.isClosed() {
+		return core.ErrConnNotFound
+	}
+	out, err := frames.Encode(conn.codec, conn)
+	if err != nil {
+		return err
+	}
+	err = conn.send(out)
+	if err == nil {
+		atomic.StoreInt64(&conn.lastHeartbeatTime, getTime())
+		conn.counter.AddOut(len(out))
+		conn.counter.AddMessageOut()
+	}
+	return err
+}
+
+// send 发送编码后的数据，调用时需要持有锁
+func (conn *Conn) send(b []byte) error {
+	if conn.bridge != nil {
+		_, err := conn.bridge.tlsConn.Write(b)
+		return err
+	}
+	return conn.gnetConn.AsyncWrite(b)
+}
+
+// WriteAsync gnet 本身异步写入，和 Write 相同
+func (conn *Conn) WriteAsync(b []byte) error {
+	_, err := conn.Write(b)
+	return err
+}
+
+// Flush gnet 没有提供等待写入完成的接口，直接返回
+func (conn *Conn) Flush() error {
+	return nil
+}
+
+// input 收到的数据写入缓冲区，再由 Codec 解析出消息，解析失败时断开连接
+//
+//	在 gnet 事件循环或者 TLS 桥接协程中调用，同一个连接不会并发调用
+func (conn *Conn) input(b []byte) {
+	atomic.StoreInt64(&conn.lastRecvTime, getTime())
+	cc := conn.codec
+	conn.PushPacket(b)
+	conn.counter.AddIn(len(b))
+	for conn.BufferLength() > 0 && !conn.isClosed() {
+		out, err := cc.Decode(conn)
+		if err != nil {
+			if err != core.ErrPacketSplit {
+				conn.counter.AddDecodeError()
+				log.Error("tcp decode failed, error: %s", err.Error())
+				conn.ResetBuffer()
+				conn.Close()
+			}
+			return
+		}
+		if out != nil {
+			conn.counter.AddMessageIn()
+			conn.onMessage(out)
+		}
+	}
+}
+
+// ID 连接唯一键
+func (conn *Conn) ID() core.ID {
+	return conn.id
+}
+
+// setProxyHeader 设置 PROXY 协议头部，之后 RemoteAddr 和 LocalAddr 使用头部中的地址
+func (conn *Conn) setProxyHeader(header *core.ProxyHeader) {
+	conn.proxyHeader = header
+	if header.Source != nil {
+		conn.remote = header.Source
+	}
+	if header.Destination != nil {
+		conn.local = header.Destination
+	}
+}
+
+// ProxyHeader PROXY 协议头部，RemoteAddr 已经是头部中的客户端地址
+func (conn *Conn) ProxyHeader() *core.ProxyHeader {
+	return conn.proxyHeader
+}
+
+// LocalAddr 本地地址，使用建立连接时缓存的地址，连接关闭后仍然可以调用
 func (conn *Conn) LocalAddr() net.Addr {
-	return conn.gnetConn.LocalAddr()
+	return conn.local
 }
 
-// RemoteAddr 远程地址
+// RemoteAddr 远程地址，使用建立连接时缓存的地址，连接关闭后仍然可以调用
 func (conn *Conn) RemoteAddr() net.Addr {
-	return conn.gnetConn.RemoteAddr()
+	return conn.remote
+}
+
+// Stats 连接的统计，gnet 没有提供写缓冲区的长度，WriteQueueBytes 为 0
+func (conn *Conn) Stats() core.ConnStats {
+	stats := core.ConnStats{
+		Traffic:     conn.counter.Load(),
+		ID:          conn.id,
+		ConnectedAt: conn.connectedAt,
+		Duration:    time.Since(conn.connectedAt),
+	}
+	if conn.remote != nil {
+		stats.RemoteAddr = conn.remote.String()
+	}
+	return stats
+}
+
+// ConnectionState TLS 连接状态，不是 TLS 连接时返回 nil
+func (conn *Conn) ConnectionState() *tls.ConnectionState {
+	if conn.bridge != nil {
+		state := conn.bridge.tlsConn.ConnectionState()
+		return &state
+	}
+	return nil
 }
 
 func (conn *Conn) setAgent(agent core.Agent) {
+	conn.Lock()
 	conn.agent = agent
-	conn.agent.OnConnect(conn)
+	conn.Unlock()
+	agent.OnConnect(conn)
+}
+
+// getAgent 在锁内读取 Agent，Close 可能在其他协程清空，连接关闭后返回 nil
+func (conn *Conn) getAgent() core.Agent {
+	conn.Lock()
+	defer conn.Unlock()
+	return conn.agent
+}
+
+// keepalive 检查空闲超时并按需发送心跳包，超时返回 false，参数为毫秒，0 为不启用
+func (conn *Conn) keepalive(now int64, heartbeatInterval int64, idleTimeout int64) bool {
+	if idleTimeout > 0 && now-atomic.LoadInt64(&conn.lastRecvTime) > idleTimeout {
+		return false
+	}
+	if heartbeatInterval > 0 && now-atomic.LoadInt64(&conn.lastHeartbeatTime) >= heartbeatInterval {
+		_, _ = conn.Write(protoc.HeartbeatMsg)
+	}
+	return true
+}
+
+// timeout 通知 Agent 超时后断开连接
+func (conn *Conn) timeout() {
+	if agent := conn.getAgent(); agent != nil {
+		core.NotifyTimeout(agent, conn)
+	}
+	conn.Close()
 }
 
 func (conn *Conn) onMessage(b []byte) {
-	if conn.agent != nil && !conn.closeFlag {
+	if agent := conn.getAgent(); agent != nil && !conn.isClosed() {
+		if bytes.Equal(b, protoc.HeartbeatMsg) {
+			// 这个是心跳包，应用层不处理
+			return
+		}
 		if bytes.Equal(b, protoc.ReceiveMsg) {
 			conn.Write(protoc.ReplyMsg)
 			return
 		}
 
-		conn.agent.OnMessage(b, conn)
+		if !conn.limiter.AllowMessage() {
+			log.Error("tcp connection %d exceeds message rate, disconnect", conn.id)
+			conn.Close()
+			return
+		}
+		agent.OnMessage(b, conn)
 	}
 }
@@ -1,10 +1,14 @@
 package tcpgnet
 
 import (
-	"encoding/binary"
+	"context"
+	"crypto/tls"
 	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/codec"
 	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/routine"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/panjf2000/gnet"
@@ -14,33 +18,34 @@ import (
 type Server struct {
 	*gnet.EventServer
 	// 连接管理
-	connSet map[string]*Conn
+	connSet map[core.ID]*Conn
 
 	addr       string
 	maxConnNum int
 	newAgent   core.GetAgent
-	codec      gnet.ICodec
+	// 消息编解码，gnet 只负责收发原始数据，每个连接通过 ConnHelper 缓冲后由 codec 解析
+	codec     core.Codec
+	tlsConfig *tls.Config
+	// 心跳间隔和空闲超时（毫秒），0 为不启用
+	heartbeatInterval int64
+	idleTimeout       int64
 
 	connMutex sync.RWMutex
 	wgConn    sync.WaitGroup
 	closeFlag bool
-}
-
-func defaultCodec() gnet.ICodec {
-	ec := gnet.EncoderConfig{
-		ByteOrder:                       binary.BigEndian,
-		LengthFieldLength:               4,
-		LengthAdjustment:                0,
-		LengthIncludesLengthFieldLength: false,
-	}
-	dc := gnet.DecoderConfig{
-		ByteOrder:           binary.BigEndian,
-		LengthFieldLength:   4,
-		LengthAdjustment:    0,
-		LengthFieldOffset:   0,
-		InitialBytesToStrip: 4,
-	}
-	return gnet.NewLengthFieldBasedFrameCodec(ec, dc)
+	// 正在优雅关闭，拒绝新连接
+	draining bool
+	// Run 返回（gnet 关闭监听）时关闭
+	stopped chan struct{}
+	// 连接准入和限流
+	admission *core.Admission
+	// PROXY 协议，nil 为不启用
+	proxy *core.ProxyProtocol
+	// 等待 PROXY 协议头部的连接和建立时间（毫秒），还没有加入 connSet，使用 connMutex 保护
+	proxyConns map[*Conn]int64
+	// 所有连接的流量统计和累计接受的连接数
+	traffic  core.Counter
+	accepted uint64
 }
 
 // Start 开始tcp监听
@@ -49,7 +54,7 @@ func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core
 	if newAgent == nil {
 		return core.ErrInvalidGetAgentFunc
 	}
-	if !core.VerifyAddress(address) {
+	if !core.VerifyIPAddress(address) {
 		return core.ErrInvalidAddress
 	}
 	server.newAgent = newAgent
@@ -63,18 +68,39 @@ func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core
 	} else {
 		server.maxConnNum = options.MaxConnNum
 	}
-	server.codec = defaultCodec()
+	server.codec = new(codec.LengthFieldBasedFrameCodec)
 	if options.Context != nil {
 		if i, ok := options.Context["stick"]; ok {
 			if !i.(bool) {
-				server.codec = new(gnet.BuiltInFrameCodec)
+				server.codec = new(codec.BuiltInCodec)
 			}
 		}
 	}
+	if options.Codec != nil {
+		server.codec = options.Codec
+	}
+	server.tlsConfig = options.TLSConfig
+	server.heartbeatInterval = int64(options.HeartbeatInterval / time.Millisecond)
+	server.idleTimeout = int64(options.IdleTimeout / time.Millisecond)
+
+	admission, err := core.NewAdmission(options.Limit)
+	if err != nil {
+		return err
+	}
+	server.admission = admission
+	server.proxy = nil
+	if options.ProxyProtocol {
+		if server.proxy, err = core.NewProxyProtocol(options.ProxyTrusted); err != nil {
+			return err
+		}
+	}
 
 	// 初始化数组
-	server.connSet = make(map[string]*Conn)
+	server.connSet = make(map[core.ID]*Conn)
+	server.proxyConns = make(map[*Conn]int64)
 	server.closeFlag = false
+	server.draining = false
+	server.stopped = make(chan struct{})
 
 	log.Info("TCP Listen %s", server.addr)
 
@@ -83,6 +109,7 @@ func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core
 
 // Run 执行服务端逻辑
 func (server *Server) Run() {
+	defer close(server.stopped)
 	// 创建监听
 	err := gnet.Serve(server, "tcp://"+server.addr,
 		gnet.WithMulticore(true),
@@ -90,85 +117,243 @@ func (server *Server) Run() {
 		gnet.WithReusePort(false),
 		gnet.WithTicker(true),
 		gnet.WithTCPKeepAlive(core.DefaultKeepAlive),
-		gnet.WithCodec(server.codec),
+		gnet.WithCodec(new(gnet.BuiltInFrameCodec)),
 		gnet.WithLockOSThread(true),
 		gnet.WithTCPNoDelay(gnet.TCPNoDelay),
 	)
 	if err != nil {
-		panic(err)
+		log.Error("TCP serve %s failed, error: %s", server.addr, err.Error())
 	}
 }
 
-// Close 关闭TCP监听
+// Close 关闭TCP监听，并强制关闭所有连接
 func (server *Server) Close() {
+	server.connMutex.Lock()
 	if server.closeFlag {
-		return
-	}
-	server.connMutex.RLock()
-	if server.closeFlag {
+		server.connMutex.Unlock()
 		return
 	}
 	server.closeFlag = true
-	for _, conn := range server.connSet {
+	server.connMutex.Unlock()
+	for _, conn := range server.conns() {
 		conn.Close()
 	}
-	server.connMutex.RUnlock()
+	server.closeProxyConns(0)
 	log.Info("TCP Close %s", server.addr)
 	server.wgConn.Wait()
+	// gnet 在下一次 Tick 时关闭监听，等待端口释放，Run 没有执行时最多等待 DefaultConnectMaxWait
+	select {
+	case <-server.stopped:
+	case <-time.After(core.DefaultConnectMaxWait):
+	}
+}
+
+// Shutdown 优雅关闭，拒绝新连接并通知 core.ShutdownAgent，等待连接断开直到 ctx 结束，之后强制关闭剩余的连接
+//
+//	gnet 的监听在 Tick 中停止，等待期间新连接建立后会被立即关闭
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.connMutex.Lock()
+	if server.closeFlag || server.draining {
+		server.connMutex.Unlock()
+		return core.ErrServerClosed
+	}
+	server.draining = true
+	server.connMutex.Unlock()
+	if ctx.Err() == nil {
+		for _, conn := range server.conns() {
+			if agent := conn.getAgent(); agent != nil {
+				core.NotifyShutdown(agent, conn)
+			}
+		}
+	}
+
+	for server.GetConnNum() > 0 && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(core.UpdateInterval):
+		}
+	}
+	var err error
+	if ctx.Err() != nil && server.GetConnNum() > 0 {
+		log.Info("TCP shutdown %s timeout, force close %d connections", server.addr, server.GetConnNum())
+		err = ctx.Err()
+	}
+	server.Close()
+	return err
+}
+
+// isClosed 服务器是否已经关闭，Tick 和 OnClosed 在事件循环中调用，需要加锁读取
+func (server *Server) isClosed() bool {
+	server.connMutex.RLock()
+	defer server.connMutex.RUnlock()
+	return server.closeFlag
 }
 
 // GetConnNum 获取所有连接的数量
 func (server *Server) GetConnNum() (num int) {
+	server.connMutex.RLock()
+	defer server.connMutex.RUnlock()
 	return len(server.connSet)
 }
 
 // OnOpened 当有新连接建立时调用
 func (server *Server) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	tcpConn := pool.Get().(*Conn)
+	tcpConn.Init(c, server.codec)
+	if server.proxy != nil && server.proxy.Trusted(c.RemoteAddr()) {
+		// 收到 PROXY 协议头部后再使用真实的客户端地址检查准入
+		server.connMutex.Lock()
+		if server.draining || server.closeFlag {
+			server.connMutex.Unlock()
+			pool.Put(tcpConn)
+			action = gnet.Close
+			return
+		}
+		tcpConn.proxyPending = true
+		server.proxyConns[tcpConn] = getTime()
+		server.connMutex.Unlock()
+		c.SetContext(tcpConn)
+		return
+	}
+	if !server.open(c, tcpConn) {
+		action = gnet.Close
+	}
+	return
+}
+
+// open 检查准入并加入连接管理，失败时返回 false，需要关闭连接
+func (server *Server) open(c gnet.Conn, tcpConn *Conn) bool {
+	remote := tcpConn.RemoteAddr()
+	if server.admission.Accept(remote) != nil {
+		pool.Put(tcpConn)
+		return false
+	}
+	if err := server.admission.Admit(remote); err != nil {
+		server.admission.Release(remote)
+		pool.Put(tcpConn)
+		log.Info("TCP connection from %s rejected, error: %s", remote.String(), err.Error())
+		return false
+	}
 	agent := server.newAgent()
 	if agent == nil {
-		action = gnet.Close
+		server.admission.Release(remote)
+		pool.Put(tcpConn)
 		log.Error("New agent error: %v", core.ErrInvalidGetAgentFunc)
-		return
+		return false
 	}
-	tcpConn := pool.Get().(*Conn)
-	tcpConn.Init(c)
 
 	server.connMutex.Lock()
+	// 正在关闭时拒绝新连接
+	if server.draining || server.closeFlag {
+		server.connMutex.Unlock()
+		server.admission.Release(remote)
+		pool.Put(tcpConn)
+		return false
+	}
 	// 如果超过了最大限制，则关闭连接
-	if server.GetConnNum() >= server.maxConnNum {
+	if len(server.connSet) >= server.maxConnNum {
 		server.connMutex.Unlock()
+		server.admission.Release(remote)
+		server.admission.Reject(remote, core.RejectMaxConn)
 		pool.Put(tcpConn)
-		action = gnet.Close
 		log.Info("Over connection limit!")
-		return
+		return false
 	}
-	server.connSet[c.RemoteAddr().String()] = tcpConn
+	server.connSet[tcpConn.id] = tcpConn
 	server.connMutex.Unlock()
-
-	tcpConn.setAgent(agent)
+	atomic.AddUint64(&server.accepted, 1)
+	tcpConn.counter.Reset(&server.traffic)
+	tcpConn.limiter = server.admission.NewConnLimiter(remote)
+	c.SetContext(tcpConn)
 	server.wgConn.Add(1)
-	return
+
+	if server.tlsConfig == nil {
+		tcpConn.setAgent(agent)
+		return true
+	}
+	// TLS 握手完成后再通知 Agent
+	bridge := newTLSBridge(tcpConn, c, server.tlsConfig)
+	tcpConn.bridge = bridge
+	err := routine.Run(true, func() {
+		bridge.run(agent)
+	})
+	if err != nil {
+		log.Error("TCP serve %s failed, error: %s", remote.String(), err.Error())
+		return false
+	}
+	return true
+}
+
+// readProxyHeader 缓冲收到的数据直到 PROXY 协议头部完整，之后打开连接并返回头部之后的数据
+//
+//	头部不完整时返回 nil，头部不合法或者连接被拒绝时返回 gnet.Close
+func (server *Server) readProxyHeader(c gnet.Conn, conn *Conn, frame []byte) ([]byte, gnet.Action) {
+	conn.proxyBuf = append(conn.proxyBuf, frame...)
+	header, n, err := core.ParseProxyHeader(conn.proxyBuf)
+	if err == core.ErrPacketSplit {
+		return nil, gnet.None
+	}
+	server.connMutex.Lock()
+	delete(server.proxyConns, conn)
+	server.connMutex.Unlock()
+	conn.proxyPending = false
+	c.SetContext(nil)
+	if err != nil {
+		log.Error("TCP read proxy header from %s failed, error: %s", c.RemoteAddr().String(), err.Error())
+		pool.Put(conn)
+		return nil, gnet.Close
+	}
+	rest := conn.proxyBuf[n:]
+	conn.proxyBuf = nil
+	conn.setProxyHeader(header)
+	if !server.open(c, conn) {
+		return nil, gnet.Close
+	}
+	return rest, gnet.None
+}
+
+// closeProxyConns 关闭等待 PROXY 协议头部超过 timeout（毫秒）的连接，0 为关闭所有等待中的连接
+func (server *Server) closeProxyConns(timeout int64) {
+	now := getTime()
+	server.connMutex.RLock()
+	expired := make([]gnet.Conn, 0)
+	for conn, t := range server.proxyConns {
+		if now-t >= timeout {
+			expired = append(expired, conn.gnetConn)
+		}
+	}
+	server.connMutex.RUnlock()
+	for _, c := range expired {
+		_ = c.Close()
+	}
 }
 
 // OnClosed 当连接关闭时调用
 func (server *Server) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
-	server.connMutex.RLock()
-	if conn, ok := server.connSet[c.RemoteAddr().String()]; ok {
-		server.connMutex.RUnlock()
-		if !server.closeFlag {
+	// 超过连接数上限时没有设置 Context
+	if conn, ok := c.Context().(*Conn); ok {
+		if conn.proxyPending {
+			// 还没有收到 PROXY 协议头部，没有加入连接管理
+			server.connMutex.Lock()
+			delete(server.proxyConns, conn)
+			server.connMutex.Unlock()
+			pool.Put(conn)
+			return
+		}
+		if !server.isClosed() {
 			// 如果是服务器还没关闭的情况下关闭了链接
 			conn.Close()
 			if err != nil {
 				log.Info("Connection closed err %v", err)
 			}
-			server.connMutex.Lock()
-			delete(server.connSet, c.RemoteAddr().String())
-			server.connMutex.Unlock()
 		}
-		pool.Put(conn)
+		server.connMutex.Lock()
+		delete(server.connSet, conn.id)
+		server.connMutex.Unlock()
+		server.admission.Release(conn.remote)
+		// 加入过连接管理的连接可能被 GetConn、Range 或者分组持有，不放回对象池，避免旧的引用发送给新的对端
 		server.wgConn.Done()
 	} else {
-		server.connMutex.RUnlock()
 		// 当连接数超过上限时会触发
 		log.Error("Connection closed when not store in map")
 	}
@@ -178,23 +363,129 @@ func (server *Server) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
 
 // React 当有消息收到时调用
 func (server *Server) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
-	server.connMutex.RLock()
-	if conn, ok := server.connSet[c.RemoteAddr().String()]; ok {
-		server.connMutex.RUnlock()
-		conn.onMessage(frame)
-	} else {
-		server.connMutex.RUnlock()
+	if conn, ok := c.Context().(*Conn); ok {
+		if conn.proxyPending {
+			if frame, action = server.readProxyHeader(c, conn, frame); len(frame) == 0 {
+				return
+			}
+		}
+		atomic.StoreInt64(&conn.lastRecvTime, getTime())
+		// gnet 不能暂停单个连接的读取，超过带宽时断开连接
+		if !conn.limiter.AllowBytes(len(frame)) {
+			log.Error("tcp connection %d exceeds bandwidth, disconnect", conn.id)
+			conn.Close()
+			return
+		}
+		if conn.bridge != nil {
+			conn.bridge.raw.push(frame)
+		} else {
+			conn.input(frame)
+		}
 	}
 	return
 }
 
 // Tick 每隔一段时间调用
 func (server *Server) Tick() (delay time.Duration, action gnet.Action) {
-	if server.closeFlag {
+	if server.isClosed() {
 		action = gnet.Shutdown
 		return
 	}
 	delay = core.UpdateInterval
+	if server.proxy != nil {
+		server.closeProxyConns(int64(core.ProxyHeaderTimeout / time.Millisecond))
+	}
+
+	if server.heartbeatInterval <= 0 && server.idleTimeout <= 0 {
+		return
+	}
+	now := getTime()
+	for _, conn := range server.conns() {
+		if !conn.keepalive(now, server.heartbeatInterval, server.idleTimeout) {
+			conn.timeout()
+		}
+	}
+	return
+}
+
+// GetConn 获取连接，不存在时返回 nil
+func (server *Server) GetConn(id core.ID) core.Conn {
+	server.connMutex.RLock()
+	defer server.connMutex.RUnlock()
+	if conn, ok := server.connSet[id]; ok {
+		return conn
+	}
+	return nil
+}
+
+// Kick 断开连接，Agent 实现了 core.KickAgent 时先通知原因
+func (server *Server) Kick(id core.ID, reason string) error {
+	server.connMutex.RLock()
+	conn, ok := server.connSet[id]
+	server.connMutex.RUnlock()
+	if !ok {
+		return core.ErrConnNotFound
+	}
+	log.Info("TCP kick connection %d, reason: %s", id, reason)
+	if agent := conn.getAgent(); agent != nil {
+		core.NotifyKick(agent, conn, reason)
+	}
+	conn.Close()
+	return nil
+}
+
+// Range 遍历所有连接，遍历的是调用时的快照，f 中可以调用 Kick
+func (server *Server) Range(f func(conn core.Conn) bool) {
+	for _, conn := range server.conns() {
+		if !f(conn) {
+			return
+		}
+	}
+}
 
+// Broadcast 向 filter 返回 true 的连接发送消息，filter 为 nil 时发送给所有连接
+func (server *Server) Broadcast(msg []byte, filter func(conn core.Conn) bool) (num int) {
+	frames := core.NewFrameCache(msg)
+	for _, conn := range server.conns() {
+		if filter != nil && !filter(conn) {
+			continue
+		}
+		if conn.WriteFrame(frames) == nil {
+			num++
+		}
+	}
 	return
 }
+
+// Rejections 按照原因统计的拒绝次数
+func (server *Server) Rejections() map[core.RejectReason]uint64 {
+	return server.admission.Rejections()
+}
+
+// Stats 服务器和所有连接的统计
+func (server *Server) Stats() core.ServerStats {
+	conns := server.conns()
+	stats := core.ServerStats{
+		Traffic:     server.traffic.Load(),
+		Address:     server.addr,
+		Conns:       len(conns),
+		Accepted:    atomic.LoadUint64(&server.accepted),
+		Rejections:  server.admission.Rejections(),
+		Connections: make([]core.ConnStats, 0, len(conns)),
+	}
+	for _, conn := range conns {
+		stats.Connections = append(stats.Connections, conn.Stats())
+	}
+	return stats
+}
+
+// conns 所有连接的快照
+func (server *Server) conns() []*Conn {
+	server.connMutex.RLock()
+	defer server.connMutex.RUnlock()
+	conns := make([]*Conn, 0, len(server.connSet))
+	for _, conn := range server.connSet {
+		conns = append(conns, conn)
+	}
+	return conns
+}
@@ -0,0 +1,129 @@
+package tcpgnet
+
+import (
+	"crypto/tls"
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/core"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+// tlsBridge gnet 不支持 TLS，每个 TLS 连接在单独的协程中通过 tls.Conn 加解密
+//
+//	gnet 只负责收发密文，收到的密文交给 tls.Conn，解密后的数据再交给 Conn 按照 Codec 解析为消息
+type tlsBridge struct {
+	conn    *Conn
+	raw     *rawConn
+	tlsConn *tls.Conn
+}
+
+func newTLSBridge(conn *Conn, c gnet.Conn, config *tls.Config) *tlsBridge {
+	raw := newRawConn(c)
+	return &tlsBridge{
+		conn:    conn,
+		raw:     raw,
+		tlsConn: tls.Server(raw, config),
+	}
+}
+
+// run 完成 TLS 握手后通知 Agent，然后持续读取解密后的数据，直到连接关闭
+func (bridge *tlsBridge) run(agent core.Agent) {
+	// rawConn 不支持超时，握手超时后直接关闭连接
+	timer := time.AfterFunc(core.DefaultHandshakeTimeout, bridge.conn.Close)
+	err := bridge.tlsConn.Handshake()
+	timer.Stop()
+	if err != nil {
+		log.Error("TCP handshake with %s failed, error: %s", bridge.raw.RemoteAddr().String(), err.Error())
+		bridge.conn.Close()
+		return
+	}
+	bridge.conn.setAgent(agent)
+
+	b := make([]byte, core.TCPMaxPackageSize)
+	for {
+		n, err := bridge.tlsConn.Read(b)
+		if n > 0 {
+			bridge.conn.input(b[:n])
+		}
+		if err != nil {
+			bridge.conn.Close()
+			return
+		}
+	}
+}
+
+// rawConn 把 gnet 连接适配为 net.Conn 供 tls.Conn 使用，React 收到的数据写入缓冲区
+type rawConn struct {
+	gnetConn gnet.Conn
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	buf      []byte
+	closed   bool
+}
+
+func newRawConn(c gnet.Conn) *rawConn {
+	raw := &rawConn{gnetConn: c}
+	raw.cond = sync.NewCond(&raw.mutex)
+	return raw
+}
+
+// push 写入 gnet 收到的数据
+func (raw *rawConn) push(b []byte) {
+	raw.mutex.Lock()
+	raw.buf = append(raw.buf, b...)
+	raw.mutex.Unlock()
+	raw.cond.Signal()
+}
+
+// Read 阻塞直到有数据或者连接关闭
+func (raw *rawConn) Read(b []byte) (int, error) {
+	raw.mutex.Lock()
+	defer raw.mutex.Unlock()
+	for len(raw.buf) == 0 && !raw.closed {
+		raw.cond.Wait()
+	}
+	if len(raw.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, raw.buf)
+	raw.buf = raw.buf[n:]
+	return n, nil
+}
+
+// Write gnet 异步发送，tls.Conn 会复用 b，需要复制
+func (raw *rawConn) Write(b []byte) (int, error) {
+	raw.mutex.Lock()
+	closed := raw.closed
+	raw.mutex.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	out := make([]byte, len(b))
+	copy(out, b)
+	err := raw.gnetConn.AsyncWrite(out)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 唤醒阻塞的 Read，gnet 连接由 Conn 关闭
+func (raw *rawConn) Close() error {
+	raw.mutex.Lock()
+	raw.closed = true
+	raw.mutex.Unlock()
+	raw.cond.Broadcast()
+	return nil
+}
+
+func (raw *rawConn) LocalAddr() net.Addr  { return raw.gnetConn.LocalAddr() }
+func (raw *rawConn) RemoteAddr() net.Addr { return raw.gnetConn.RemoteAddr() }
+
+// 握手超时由 tlsBridge 的定时器处理，这里不需要支持
+func (raw *rawConn) SetDeadline(time.Time) error      { return nil }
+func (raw *rawConn) SetReadDeadline(time.Time) error  { return nil }
+func (raw *rawConn) SetWriteDeadline(time.Time) error { return nil }
@@ -13,7 +13,9 @@ import (
 // Client 客户端
 type Client struct {
 	sync.Mutex
-	reconnect bool
+	// Dial 建立连接，为 nil 时连接 TCP 地址，基于 tcpnet 实现其他流式传输（例如 Unix 域套接字）时设置
+	Dial func(address string, timeout time.Duration) (net.Conn, error)
+
 	addr      string
 	isConnect bool
 	closeSig  chan bool
@@ -22,6 +24,16 @@ type Client struct {
 	codec     core.Codec
 	wg        sync.WaitGroup
 	conn      *Conn
+
+	// 连接建立后依次执行的握手流程
+	handshakes []core.Handshake
+	// 心跳间隔和空闲超时
+	heartbeatInterval time.Duration
+	idleTimeout       time.Duration
+	// 异步写队列配置
+	writeQueue core.WriteQueueOptions
+	// 重连退避和状态通知
+	reconnector *core.Reconnector
 }
 
 // Start 开启客户端连接
@@ -29,7 +41,7 @@ func (client *Client) Start(address string, newAgent core.GetAgent, opts ...core
 	if newAgent == nil {
 		return core.ErrInvalidGetAgentFunc
 	}
-	if !core.VerifyAddress(address) {
+	if !core.VerifyAddress(address) || (client.Dial == nil && !core.VerifyIPAddress(address)) {
 		return core.ErrInvalidAddress
 	}
 	client.newAgent = newAgent
@@ -46,8 +58,27 @@ func (client *Client) Start(address string, newAgent core.GetAgent, opts ...core
 			}
 		}
 	}
+	if options.Codec != nil {
+		client.codec = options.Codec
+	}
 
-	client.reconnect = options.Reconnect
+	client.handshakes = options.Handshakes
+	if options.TLSConfig != nil {
+		tlsConf := options.TLSConfig
+		if tlsConf.ServerName == "" && !tlsConf.InsecureSkipVerify {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			tlsConf = tlsConf.Clone()
+			tlsConf.ServerName = host
+		}
+		client.handshakes = append([]core.Handshake{core.TLSClientHandshake(tlsConf)}, client.handshakes...)
+	}
+	client.heartbeatInterval = options.HeartbeatInterval
+	client.idleTimeout = options.IdleTimeout
+	client.writeQueue = options.WriteQueue
+	client.reconnector = core.NewReconnector(options)
 	client.isConnect = false
 	client.closeSig = make(chan bool, 1)
 	client.closeFlag = false
@@ -57,22 +88,20 @@ func (client *Client) Start(address string, newAgent core.GetAgent, opts ...core
 	return nil
 }
 
-// Run 执行主逻辑
+// Run 执行主逻辑，连接失败时按照退避策略重试，开启重连时连接断开后重新连接
 func (client *Client) Run() {
-	firstStart := make(chan bool, 1)
-	firstStart <- true
-	for {
-		select {
-		case <-client.closeSig:
-			return
-		case <-firstStart:
-			break
-		case <-time.After(core.DefaultConnectMaxWait):
-			break
+	for client.reconnector.Next(client.closeSig) {
+		conn, err := client.dial()
+		if err != nil {
+			client.reconnector.Failed(err)
+			continue
 		}
 
-		conn, err := net.DialTimeout("tcp", client.addr, core.DefaultConnectMaxWait)
+		conn, cc, err := core.RunHandshakes(client.handshakes, conn, client.codec)
 		if err != nil {
+			log.Error("TCP handshake with %s failed, error: %s", client.addr, err.Error())
+			_ = conn.Close()
+			client.reconnector.Failed(err)
 			continue
 		}
 
@@ -83,10 +112,16 @@ func (client *Client) Run() {
 		}
 		client.Lock()
 		tcpConn := pool.Get().(*Conn)
-		tcpConn.Init(conn, client.codec)
+		tcpConn.Init(conn, cc)
 		client.conn = tcpConn
+		tcpConn.setKeepalive(client.heartbeatInterval, client.idleTimeout)
+		// 写协程启动失败时退回同步写入
+		if err = tcpConn.setWriteQueue(client.writeQueue); err != nil {
+			log.Error("TCP start write queue failed, error: %s", err.Error())
+		}
 		tcpConn.setAgent(newAgent)
 		client.Unlock()
+		client.reconnector.Connected()
 		client.wg.Add(1)
 
 		runFunc := func() {
@@ -108,7 +143,18 @@ func (client *Client) Run() {
 			}
 		}
 		client.wg.Wait()
+		if !client.reconnector.Disconnected() {
+			return
+		}
+	}
+}
+
+// dial 建立连接，没有设置 Dial 时连接 TCP 地址
+func (client *Client) dial() (net.Conn, error) {
+	if client.Dial != nil {
+		return client.Dial(client.addr, core.DefaultConnectMaxWait)
 	}
+	return net.DialTimeout("tcp", client.addr, core.DefaultConnectMaxWait)
 }
 
 // Close 关闭客户端连接
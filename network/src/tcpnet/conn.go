@@ -2,18 +2,21 @@ package tcpnet
 
 import (
 	"bytes"
+	"crypto/tls"
 	"github.com/finishy1995/go-library/log"
 	"github.com/finishy1995/go-library/network/codec"
 	"github.com/finishy1995/go-library/network/core"
 	"github.com/finishy1995/go-library/network/protocol"
+	"github.com/finishy1995/go-library/routine"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	heartBeatMsg = []byte("|")
-	protoc       = protocol.ProtocolV001
+	protoc = protocol.ProtocolV001
 )
 
 // Conn tcp 连接
@@ -21,17 +24,34 @@ type Conn struct {
 	sync.Mutex
 	codec.ConnHelper
 
-	id        core.ID
-	conn      net.Conn
-	closeFlag bool
+	id   core.ID
+	conn net.Conn
+	// 关闭标记，其他协程（写协程、Kick）可能关闭连接，使用原子操作
+	closeFlag int32
 	closeSig  chan bool
 	agent     core.Agent
 	codec     core.Codec
 
-	// 最近一次心跳包时间
+	// 最近一次发送数据（包括心跳包）时间，Write 可能在其他协程调用，使用原子操作
 	lastHeartbeatTime int64
 	// 最近一次收到包时间
 	lastRecvTime int64
+	// 心跳间隔和空闲超时（毫秒），0 为不启用
+	heartbeatInterval int64
+	idleTimeout       int64
+
+	// 异步写队列，保存 *writeQueue，没有启用时为 nil
+	queue        atomic.Value
+	queueOptions core.WriteQueueOptions
+	// 消息速率和带宽限制，nil 为不限制
+	limiter *core.ConnLimiter
+	// 流量统计，服务端连接同时累加到服务器的统计
+	counter     core.Counter
+	remote      net.Addr
+	local       net.Addr
+	connectedAt time.Time
+	// PROXY 协议头部，没有启用或者连接来自不受信任的地址时为 nil
+	proxyHeader *core.ProxyHeader
 }
 
 func getTime() int64 {
@@ -44,71 +64,300 @@ func (tcpConn *Conn) Init(conn net.Conn, codec core.Codec) {
 		panic(core.ErrInvalidCodec)
 	}
 	tcpConn.conn = conn
+	atomic.StoreInt32(&tcpConn.closeFlag, 0)
 	tcpConn.InitBuffer()
 	tcpConn.codec = codec
 	t := getTime()
 	tcpConn.lastHeartbeatTime = t
 	tcpConn.lastRecvTime = t
+	tcpConn.heartbeatInterval = 0
+	tcpConn.idleTimeout = 0
 	tcpConn.closeSig = make(chan bool, 1)
 	tcpConn.id = core.GenerateID()
+	tcpConn.queue.Store((*writeQueue)(nil))
+	tcpConn.queueOptions = core.WriteQueueOptions{}
+	tcpConn.limiter = nil
+	tcpConn.counter.Reset(nil)
+	tcpConn.remote = conn.RemoteAddr()
+	tcpConn.local = conn.LocalAddr()
+	tcpConn.connectedAt = time.Now()
+	tcpConn.proxyHeader = nil
+}
+
+// isClosed 连接是否已经关闭
+func (tcpConn *Conn) isClosed() bool {
+	return atomic.LoadInt32(&tcpConn.closeFlag) != 0
 }
 
-// Close 断连
+// Close 断连，只关闭 socket，读协程可能仍在使用 conn 和 codec，不清空
 func (tcpConn *Conn) Close() {
-	if tcpConn.closeFlag {
+	if tcpConn.isClosed() {
 		return
 	}
+	// 先停止写队列，唤醒持有锁阻塞在 OverflowBlock 的写入
+	if q := tcpConn.writeQueue(); q != nil {
+		q.close()
+	}
 	tcpConn.Lock()
 	defer tcpConn.Unlock()
 
-	if tcpConn.closeFlag {
+	if tcpConn.isClosed() {
 		return
 	}
 	tcpConn.closeSig <- true
-	tcpConn.closeFlag = true
-	err := tcpConn.conn.(*net.TCPConn).SetLinger(0)
-	if err != nil {
-		log.Error("tcp close failed, error: %s", err.Error())
+	atomic.StoreInt32(&tcpConn.closeFlag, 1)
+	if q := tcpConn.writeQueue(); q != nil {
+		q.close()
+		// 中断正在进行的写入，等待写协程退出后再写入关闭数据
+		_ = tcpConn.conn.SetWriteDeadline(time.Now())
+		<-q.done
 	}
-	err = tcpConn.conn.Close()
+	if cc, ok := tcpConn.codec.(core.ClosingCodec); ok {
+		if b := cc.Closing(); b != nil {
+			_ = tcpConn.conn.SetWriteDeadline(time.Now().Add(core.UpdateInterval))
+			_, _ = tcpConn.conn.Write(b)
+		}
+	}
+	// 握手后的连接可能被包装过，只有原始 TCP 连接才设置 linger
+	if tc, ok := tcpConn.conn.(*net.TCPConn); ok {
+		err := tc.SetLinger(0)
+		if err != nil {
+			log.Error("tcp close failed, error: %s", err.Error())
+		}
+	}
+	err := tcpConn.conn.Close()
 	if err != nil {
-		panic(err)
+		log.Error("tcp close failed, error: %s", err.Error())
 	}
 
-	tcpConn.conn = nil
 	tcpConn.agent = nil
-	tcpConn.codec = nil
 }
 
-// Write b 必须在其他协程中不被修改
+// Write b 必须在其他协程中不被修改，启用写队列时放入队列后立即返回
 func (tcpConn *Conn) Write(b []byte) (n int, err error) {
-	if tcpConn.closeFlag || b == nil {
+	if tcpConn.isClosed() || b == nil {
 		return
 	}
+	err = tcpConn.send(func() ([]byte, error) {
+		return tcpConn.codec.Encode(tcpConn, b)
+	}, false)
+	if err == core.ErrConnClosed {
+		return 0, nil
+	}
+	if err == nil {
+		n = len(b)
+	}
+	return
+}
+
+// WriteEncoded 发送已经编码的数据，不经过 Codec，和 Write 共用锁和写队列，用于 Codec 回复控制帧（例如 WebSocket pong）
+func (tcpConn *Conn) WriteEncoded(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	return tcpConn.send(func() ([]byte, error) {
+		return b, nil
+	}, false)
+}
+
+// WriteAsync 放入写队列后立即返回，没有配置写队列时使用 core.DefaultWriteQueueOptions 创建
+func (tcpConn *Conn) WriteAsync(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	return tcpConn.send(func() ([]byte, error) {
+		return tcpConn.codec.Encode(tcpConn, b)
+	}, true)
+}
+
+// Flush 等待写队列中的数据全部写入 socket，没有启用写队列时直接返回
+func (tcpConn *Conn) Flush() error {
+	if q := tcpConn.writeQueue(); q != nil {
+		return q.flush()
+	}
+	return nil
+}
+
+// WriteFrame 发送广播消息，使用同一个 Codec 的连接共用编码结果
+func (tcpConn *Conn) WriteFrame(frames *core.FrameCache) error {
+	if tcpConn.isClosed() {
+		return core.ErrConnNotFound
+	}
+	err := tcpConn.send(func() ([]byte, error) {
+		return frames.Encode(tcpConn.codec, tcpConn)
+	}, false)
+	if err == core.ErrConnClosed {
+		return core.ErrConnNotFound
+	}
+	return err
+}
+
+// send 编码并发送，启用写队列或者 async 为 true 时放入写队列，否则直接写入 socket
+//
+//	放入写队列前先按照 OverflowPolicy 检查，被丢弃的消息不会经过编码，不影响有状态的 Codec（例如加密）
+func (tcpConn *Conn) send(encode func() ([]byte, error), async bool) error {
+	tcpConn.Lock()
+	if tcpConn.isClosed() {
+		tcpConn.Unlock()
+		return core.ErrConnClosed
+	}
+	q := tcpConn.writeQueue()
+	if q == nil && async {
+		options := core.DefaultWriteQueueOptions
+		options.WriteDeadline = tcpConn.queueOptions.WriteDeadline
+		var err error
+		q, err = tcpConn.startWriteQueue(options)
+		if err != nil {
+			tcpConn.Unlock()
+			return err
+		}
+	}
+
+	if q == nil {
+		out, err := encode()
+		if err == nil {
+			if d := tcpConn.queueOptions.WriteDeadline; d > 0 {
+				_ = tcpConn.conn.SetWriteDeadline(time.Now().Add(d))
+			}
+			_, err = tcpConn.conn.Write(out)
+		}
+		tcpConn.Unlock()
+		if err == nil {
+			atomic.StoreInt64(&tcpConn.lastHeartbeatTime, getTime())
+			tcpConn.counter.AddOut(len(out))
+			tcpConn.counter.AddMessageOut()
+		} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			log.Error("tcp write timeout, error: %s", err.Error())
+			tcpConn.Close()
+		}
+		return err
+	}
+
+	err := q.acquire()
+	if err == nil {
+		var out []byte
+		out, err = encode()
+		if err == nil {
+			err = q.push(out)
+		}
+	}
+	tcpConn.Unlock()
+	if err == nil {
+		atomic.StoreInt64(&tcpConn.lastHeartbeatTime, getTime())
+		tcpConn.counter.AddMessageOut()
+	} else if err == core.ErrWriteQueueFull && q.policy == core.OverflowDisconnect {
+		log.Error("tcp write queue full, disconnect connection %d", tcpConn.id)
+		tcpConn.Close()
+	}
+	return err
+}
+
+// setWriteQueue 设置写队列，需要在 Run 之前调用，HighWatermark 大于 0 时启用写队列
+func (tcpConn *Conn) setWriteQueue(options core.WriteQueueOptions) error {
 	tcpConn.Lock()
 	defer tcpConn.Unlock()
-	if tcpConn.closeFlag {
-		return
+	tcpConn.queueOptions = options
+	if options.HighWatermark <= 0 {
+		return nil
 	}
+	_, err := tcpConn.startWriteQueue(options)
+	return err
+}
 
-	out, _ := tcpConn.codec.Encode(tcpConn, b)
-	return tcpConn.conn.Write(out)
+// startWriteQueue 创建写队列并启动写协程，调用时需要持有锁
+func (tcpConn *Conn) startWriteQueue(options core.WriteQueueOptions) (*writeQueue, error) {
+	q := newWriteQueue(tcpConn.conn, options, &tcpConn.counter, func(err error) {
+		log.Error("tcp write failed, error: %s", err.Error())
+		tcpConn.Close()
+	})
+	err := routine.Run(true, q.run)
+	if err != nil {
+		return nil, err
+	}
+	tcpConn.queue.Store(q)
+	return q, nil
+}
+
+func (tcpConn *Conn) writeQueue() *writeQueue {
+	q, _ := tcpConn.queue.Load().(*writeQueue)
+	return q
+}
+
+// ID 连接唯一键
+func (tcpConn *Conn) ID() core.ID {
+	return tcpConn.id
 }
 
 // LocalAddr 本地socket端口地址
 func (tcpConn *Conn) LocalAddr() net.Addr {
-	return tcpConn.conn.LocalAddr()
+	return tcpConn.local
 }
 
 // RemoteAddr 远程socket端口地址
 func (tcpConn *Conn) RemoteAddr() net.Addr {
-	return tcpConn.conn.RemoteAddr()
+	return tcpConn.remote
+}
+
+// ProxyHeader PROXY 协议头部，RemoteAddr 已经是头部中的客户端地址
+func (tcpConn *Conn) ProxyHeader() *core.ProxyHeader {
+	return tcpConn.proxyHeader
+}
+
+// Stats 连接的统计
+func (tcpConn *Conn) Stats() core.ConnStats {
+	stats := core.ConnStats{
+		Traffic:     tcpConn.counter.Load(),
+		ID:          tcpConn.id,
+		ConnectedAt: tcpConn.connectedAt,
+		Duration:    time.Since(tcpConn.connectedAt),
+	}
+	if tcpConn.remote != nil {
+		stats.RemoteAddr = tcpConn.remote.String()
+	}
+	if q := tcpConn.writeQueue(); q != nil {
+		stats.WriteQueueBytes = q.size()
+	}
+	return stats
+}
+
+// ConnectionState TLS 连接状态，不是 TLS 连接时返回 nil
+func (tcpConn *Conn) ConnectionState() *tls.ConnectionState {
+	return core.GetConnectionState(tcpConn.conn)
+}
+
+// setKeepalive 设置心跳间隔和空闲超时，需要在 Run 之前调用
+func (tcpConn *Conn) setKeepalive(heartbeatInterval time.Duration, idleTimeout time.Duration) {
+	tcpConn.heartbeatInterval = int64(heartbeatInterval / time.Millisecond)
+	tcpConn.idleTimeout = int64(idleTimeout / time.Millisecond)
+}
+
+// keepalive 检查空闲超时并按需发送心跳包，超时返回 false
+func (tcpConn *Conn) keepalive(now int64) bool {
+	if tcpConn.idleTimeout > 0 && now-tcpConn.lastRecvTime > tcpConn.idleTimeout {
+		return false
+	}
+	if tcpConn.heartbeatInterval > 0 && now-atomic.LoadInt64(&tcpConn.lastHeartbeatTime) >= tcpConn.heartbeatInterval {
+		_, err := tcpConn.Write(protoc.HeartbeatMsg)
+		if err != nil {
+			log.Error("tcp heartbeat failed, error: %s", err.Error())
+		}
+	}
+	return true
 }
 
 // setAgent 设置 Agent
 func (tcpConn *Conn) setAgent(agent core.Agent) {
+	tcpConn.Lock()
 	tcpConn.agent = agent
-	tcpConn.agent.OnConnect(tcpConn)
+	tcpConn.Unlock()
+	agent.OnConnect(tcpConn)
+}
+
+// getAgent 在锁内读取 Agent，Close 可能在其他协程清空，连接关闭后返回 nil
+func (tcpConn *Conn) getAgent() core.Agent {
+	tcpConn.Lock()
+	defer tcpConn.Unlock()
+	return tcpConn.agent
 }
 
 // Run 循环运行
@@ -117,7 +366,7 @@ func (tcpConn *Conn) Run() {
 	t := time.Millisecond * 2
 	update := core.UpdateInterval - t
 	for {
-		if tcpConn.closeFlag {
+		if tcpConn.isClosed() {
 			return
 		}
 		err := tcpConn.conn.SetReadDeadline(time.Now().Add(t))
@@ -126,18 +375,17 @@ func (tcpConn *Conn) Run() {
 		}
 		n, err := tcpConn.conn.Read(b)
 		now := getTime()
+		if n > 0 {
+			tcpConn.lastRecvTime = now
+		}
+		if !tcpConn.keepalive(now) {
+			if agent := tcpConn.getAgent(); agent != nil {
+				core.NotifyTimeout(agent, tcpConn)
+			}
+			return
+		}
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				//if now - tcpConn.lastRecvTime > core.TimeoutTime {
-				//	return
-				//}
-				//if now - tcpConn.lastHeartbeatTime > core.HeartbeatTime {
-				//	_, err := tcpConn.Write(heartBeatMsg)
-				//	tcpConn.lastHeartbeatTime = now
-				//	if err != nil {
-				//		return
-				//	}
-				//}
 				continue
 			} else if ok && netErr.Temporary() {
 				select {
@@ -152,35 +400,59 @@ func (tcpConn *Conn) Run() {
 		}
 
 		if n > 0 {
-			if tcpConn.agent == nil {
+			agent := tcpConn.getAgent()
+			if agent == nil {
+				if tcpConn.isClosed() {
+					return
+				}
 				panic(core.ErrInvalidAgent)
 			}
-			tcpConn.lastRecvTime = now
-			tcpConn.lastHeartbeatTime = now
 			tcpConn.PushPacket(b[:n])
+			tcpConn.counter.AddIn(n)
+			// 超过带宽时暂停读取，由 TCP 流量控制限制对端发送
+			if wait := tcpConn.limiter.ReserveBytes(n); wait > 0 {
+				select {
+				case <-tcpConn.closeSig:
+					return
+				case <-time.After(wait):
+				}
+			}
 
 			for {
 				out, err := tcpConn.codec.Decode(tcpConn)
 				if err != nil {
 					if err == core.ErrPacketSplit {
 						break
-					} else {
-						panic(err)
 					}
+					// 对端正常关闭（例如 WebSocket close 帧）时 Codec 返回 io.EOF
+					if err != io.EOF {
+						tcpConn.counter.AddDecodeError()
+						log.Error("tcp decode failed, error: %s", err.Error())
+					}
+					return
 				}
 				if out == nil {
 					continue
 				}
-				//if len(out) == len(heartBeatMsg) && out[0] == heartBeatMsg[0] {
-				//	// 这个是心跳包，应用层不处理
-				//	continue
-				//}
+				tcpConn.counter.AddMessageIn()
+				if bytes.Equal(out, protoc.HeartbeatMsg) {
+					// 这个是心跳包，应用层不处理
+					continue
+				}
 				if bytes.Equal(out, protoc.ReceiveMsg) {
 					tcpConn.Write(protoc.ReplyMsg)
 					continue
 				}
 
-				tcpConn.agent.OnMessage(out, tcpConn)
+				if !tcpConn.limiter.AllowMessage() {
+					log.Error("tcp connection %d exceeds message rate, disconnect", tcpConn.id)
+					return
+				}
+				agent.OnMessage(out, tcpConn)
+				// Agent 可能在 OnMessage 中关闭连接
+				if tcpConn.isClosed() {
+					return
+				}
 			}
 			continue
 		} else {
@@ -1,6 +1,7 @@
 package tcpnet
 
 import (
+	"context"
 	"crypto/tls"
 	"github.com/finishy1995/go-library/log"
 	"github.com/finishy1995/go-library/network/codec"
@@ -8,11 +9,15 @@ import (
 	"github.com/finishy1995/go-library/routine"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Server tcp 服务器
 type Server struct {
+	// Listen 创建监听，为 nil 时监听 TCP 地址，基于 tcpnet 实现其他流式传输（例如 Unix 域套接字）时设置
+	Listen func(address string) (net.Listener, error)
+
 	// 连接管理
 	connSet map[core.ID]*Conn
 
@@ -23,6 +28,12 @@ type Server struct {
 	maxConnNum int
 	newAgent   core.GetAgent
 	codec      core.Codec
+	handshakes []core.Handshake
+	// 心跳间隔和空闲超时
+	heartbeatInterval time.Duration
+	idleTimeout       time.Duration
+	// 异步写队列配置
+	writeQueue core.WriteQueueOptions
 
 	ln        net.Listener
 	connMutex sync.Mutex
@@ -30,6 +41,16 @@ type Server struct {
 	wgConn    sync.WaitGroup
 	closeSig  chan bool
 	closeFlag bool
+
+	// 正在握手、尚未加入 connSet 的连接数量，同样占用最大连接数
+	pending int32
+	// 连接准入和限流
+	admission *core.Admission
+	// PROXY 协议，nil 为不启用
+	proxy *core.ProxyProtocol
+	// 所有连接的流量统计和累计接受的连接数
+	traffic  core.Counter
+	accepted uint64
 }
 
 // Start 开始tcp监听
@@ -38,7 +59,7 @@ func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core
 	if newAgent == nil {
 		return core.ErrInvalidGetAgentFunc
 	}
-	if !core.VerifyAddress(address) {
+	if !core.VerifyAddress(address) || (server.Listen == nil && !core.VerifyIPAddress(address)) {
 		return core.ErrInvalidAddress
 	}
 	server.newAgent = newAgent
@@ -70,6 +91,41 @@ func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core
 			}
 		}
 	}
+	if options.Codec != nil {
+		server.codec = options.Codec
+	}
+
+	// 创建 tls，TLS 握手在其他握手流程之前完成
+	tlsConf := options.TLSConfig
+	if tlsConf == nil && server.tls {
+		tlsConf = new(tls.Config)
+		tlsConf.Certificates = make([]tls.Certificate, 1)
+		var err error
+		tlsConf.Certificates[0], err = tls.LoadX509KeyPair(server.certFile, server.keyFile)
+		if err != nil {
+			return err
+		}
+		log.Info("TCP Listen TLS load success")
+	}
+	server.heartbeatInterval = options.HeartbeatInterval
+	server.idleTimeout = options.IdleTimeout
+	server.writeQueue = options.WriteQueue
+	server.handshakes = options.Handshakes
+	if tlsConf != nil {
+		server.handshakes = append([]core.Handshake{core.TLSServerHandshake(tlsConf)}, server.handshakes...)
+	}
+
+	admission, err := core.NewAdmission(options.Limit)
+	if err != nil {
+		return err
+	}
+	server.admission = admission
+	server.proxy = nil
+	if options.ProxyProtocol {
+		if server.proxy, err = core.NewProxyProtocol(options.ProxyTrusted); err != nil {
+			return err
+		}
+	}
 
 	// 初始化数组
 	server.connSet = make(map[core.ID]*Conn)
@@ -77,24 +133,16 @@ func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core
 	server.closeFlag = false
 
 	// 创建监听
-	ln, err := net.Listen("tcp", server.addr)
+	var ln net.Listener
+	if server.Listen != nil {
+		ln, err = server.Listen(server.addr)
+	} else {
+		ln, err = net.Listen("tcp", server.addr)
+	}
 	if err != nil {
 		return err
 	}
 
-	// 创建 tls
-	if server.tls {
-		tlsConf := new(tls.Config)
-		tlsConf.Certificates = make([]tls.Certificate, 1)
-		tlsConf.Certificates[0], err = tls.LoadX509KeyPair(server.certFile, server.keyFile)
-		if err == nil {
-			ln = tls.NewListener(ln, tlsConf)
-			log.Info("TCP Listen TLS load success")
-		} else {
-			return err
-		}
-	}
-
 	server.ln = ln
 	log.Info("TCP Listen %s", server.addr)
 
@@ -112,7 +160,7 @@ func (server *Server) Run() {
 			return
 		}
 
-		if server.GetConnNum() >= server.maxConnNum {
+		if server.GetConnNum()+int(atomic.LoadInt32(&server.pending)) >= server.maxConnNum {
 			select {
 			case <-server.closeSig:
 				return
@@ -144,62 +192,281 @@ func (server *Server) Run() {
 			}
 		}
 		tempDelay = 0
-
-		agent := server.newAgent()
-		if agent == nil {
-			panic(core.ErrInvalidGetAgentFunc)
+		// 启用 PROXY 协议时读取头部后再使用真实地址检查准入
+		if server.proxy == nil && server.admission.Accept(conn.RemoteAddr()) != nil {
+			closeRejected(conn)
+			continue
 		}
-		tcpConn := pool.Get().(*Conn)
-		tcpConn.Init(conn, server.codec)
-		tcpConnID := tcpConn.id
-		server.connSet[tcpConnID] = tcpConn
-		tcpConn.setAgent(agent)
+
 		server.wgConn.Add(1)
+		atomic.AddInt32(&server.pending, 1)
+		err = routine.Run(true, func() {
+			server.serve(conn)
+		})
+		if err != nil {
+			log.Error("TCP serve %s failed, error: %s", conn.RemoteAddr().String(), err.Error())
+			_ = conn.Close()
+			if server.proxy == nil {
+				server.admission.Release(conn.RemoteAddr())
+			}
+			atomic.AddInt32(&server.pending, -1)
+			server.wgConn.Done()
+		}
+	}
+}
 
-		closeConn := func() {
-			agent.OnClose(tcpConn)
+// serve 完成握手后处理单个连接，直到连接断开
+func (server *Server) serve(conn net.Conn) {
+	defer server.wgConn.Done()
+	conn, header, ok := server.acceptProxy(conn)
+	if !ok {
+		atomic.AddInt32(&server.pending, -1)
+		return
+	}
+	remote := conn.RemoteAddr()
+	defer server.admission.Release(remote)
+
+	tcpConn, agent := server.handshake(conn)
+	server.connMutex.Lock()
+	atomic.AddInt32(&server.pending, -1)
+	if tcpConn == nil || server.closeFlag {
+		server.connMutex.Unlock()
+		if tcpConn != nil {
 			tcpConn.Close()
+		}
+		return
+	}
+	tcpConn.proxyHeader = header
+	tcpConnID := tcpConn.id
+	server.connSet[tcpConnID] = tcpConn
+	server.connMutex.Unlock()
+	atomic.AddUint64(&server.accepted, 1)
+
+	defer func() {
+		agent.OnClose(tcpConn)
+		tcpConn.Close()
 
-			if !server.closeFlag {
-				server.connMutex.Lock()
-				delete(server.connSet, tcpConnID)
-				server.connMutex.Unlock()
+		server.connMutex.Lock()
+		delete(server.connSet, tcpConnID)
+		server.connMutex.Unlock()
+	}()
+	tcpConn.setKeepalive(server.heartbeatInterval, server.idleTimeout)
+	tcpConn.limiter = server.admission.NewConnLimiter(remote)
+	// 写协程启动失败时退回同步写入
+	if err := tcpConn.setWriteQueue(server.writeQueue); err != nil {
+		log.Error("TCP start write queue failed, error: %s", err.Error())
+	}
+	tcpConn.setAgent(agent)
+	tcpConn.Run()
+}
+
+// acceptProxy 启用 PROXY 协议时读取受信任连接的头部，再使用真实的客户端地址检查准入，失败时关闭连接并返回 false
+func (server *Server) acceptProxy(conn net.Conn) (net.Conn, *core.ProxyHeader, bool) {
+	if server.proxy == nil {
+		return conn, nil, true
+	}
+	proxied, header, err := server.proxy.Accept(conn)
+	if err != nil {
+		log.Error("TCP read proxy header from %s failed, error: %s", conn.RemoteAddr().String(), err.Error())
+		closeRejected(conn)
+		return nil, nil, false
+	}
+	if server.admission.Accept(proxied.RemoteAddr()) != nil {
+		closeRejected(conn)
+		return nil, nil, false
+	}
+	return proxied, header, true
+}
+
+// handshake 执行握手并创建连接，失败时关闭原始连接并返回 nil
+//
+//	握手可能比较耗时，在连接自己的协程里执行，不阻塞 Accept
+func (server *Server) handshake(conn net.Conn) (*Conn, core.Agent) {
+	if err := server.admission.Admit(conn.RemoteAddr()); err != nil {
+		log.Info("TCP connection from %s rejected, error: %s", conn.RemoteAddr().String(), err.Error())
+		closeRejected(conn)
+		return nil, nil
+	}
+	conn, cc, err := core.RunHandshakes(server.handshakes, conn, server.codec)
+	if err != nil {
+		log.Error("TCP handshake with %s failed, error: %s", conn.RemoteAddr().String(), err.Error())
+		_ = conn.Close()
+		return nil, nil
+	}
+	agent := server.newAgent()
+	if agent == nil {
+		log.Error("New agent error: %v", core.ErrInvalidGetAgentFunc)
+		_ = conn.Close()
+		return nil, nil
+	}
+	tcpConn := pool.Get().(*Conn)
+	tcpConn.Init(conn, cc)
+	tcpConn.counter.Reset(&server.traffic)
+	return tcpConn, agent
+}
+
+// closeRejected 关闭被拒绝的连接，不进入 TIME_WAIT
+func closeRejected(conn net.Conn) {
+	if w, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = w.NetConn()
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+// Close 关闭TCP监听，并强制关闭所有连接
+func (server *Server) Close() {
+	if err := server.stopAccept(); err != nil && err != core.ErrServerClosed {
+		log.Error("TCP close listener %s failed, error: %s", server.addr, err.Error())
+	}
+	server.closeConns()
+	log.Info("TCP Close %s", server.addr)
+}
+
+// Shutdown 优雅关闭，停止接受新连接并通知 core.ShutdownAgent，等待连接断开直到 ctx 结束，之后强制关闭剩余的连接
+func (server *Server) Shutdown(ctx context.Context) error {
+	err := server.stopAccept()
+	if err == core.ErrServerClosed {
+		return err
+	}
+	if err != nil {
+		log.Error("TCP close listener %s failed, error: %s", server.addr, err.Error())
+	}
+	if ctx.Err() == nil {
+		for _, conn := range server.conns() {
+			if agent := conn.getAgent(); agent != nil {
+				core.NotifyShutdown(agent, conn)
 			}
-			server.wgConn.Done()
 		}
+	}
 
-		err = routine.Run(true, func() {
-			defer closeConn()
-			tcpConn.Run()
-		})
-		if err != nil {
-			closeConn()
+	for server.GetConnNum()+int(atomic.LoadInt32(&server.pending)) > 0 && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(core.UpdateInterval):
 		}
 	}
+	if ctx.Err() != nil && server.GetConnNum() > 0 {
+		log.Info("TCP shutdown %s timeout, force close %d connections", server.addr, server.GetConnNum())
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	server.closeConns()
+	log.Info("TCP Close %s", server.addr)
+	return err
 }
 
-// Close 关闭TCP监听
-func (server *Server) Close() {
+// stopAccept 停止接受新连接并等待 Accept 协程退出，正在握手的连接会在握手完成后被关闭
+func (server *Server) stopAccept() error {
+	server.connMutex.Lock()
 	if server.closeFlag {
-		return
+		server.connMutex.Unlock()
+		return core.ErrServerClosed
 	}
-	server.connMutex.Lock()
 	server.closeFlag = true
 	server.closeSig <- true
-	for _, conn := range server.connSet {
-		conn.Close()
-	}
 	server.connMutex.Unlock()
 	err := server.ln.Close()
-	log.Info("TCP Close %s", server.addr)
-	if err != nil {
-		panic(err)
-	}
 	server.wgLn.Wait()
+	return err
+}
+
+// closeConns 强制关闭所有连接并等待连接协程退出
+func (server *Server) closeConns() {
+	for _, conn := range server.conns() {
+		conn.Close()
+	}
 	server.wgConn.Wait()
 }
 
 // GetConnNum 获取所有连接的数量
 func (server *Server) GetConnNum() (num int) {
+	server.connMutex.Lock()
+	defer server.connMutex.Unlock()
 	return len(server.connSet)
 }
+
+// GetConn 获取连接，不存在时返回 nil
+func (server *Server) GetConn(id core.ID) core.Conn {
+	server.connMutex.Lock()
+	defer server.connMutex.Unlock()
+	if conn, ok := server.connSet[id]; ok {
+		return conn
+	}
+	return nil
+}
+
+// Kick 断开连接，Agent 实现了 core.KickAgent 时先通知原因
+func (server *Server) Kick(id core.ID, reason string) error {
+	server.connMutex.Lock()
+	conn, ok := server.connSet[id]
+	server.connMutex.Unlock()
+	if !ok {
+		return core.ErrConnNotFound
+	}
+	log.Info("TCP kick connection %d, reason: %s", id, reason)
+	if agent := conn.getAgent(); agent != nil {
+		core.NotifyKick(agent, conn, reason)
+	}
+	conn.Close()
+	return nil
+}
+
+// Range 遍历所有连接，遍历的是调用时的快照，f 中可以调用 Kick
+func (server *Server) Range(f func(conn core.Conn) bool) {
+	for _, conn := range server.conns() {
+		if !f(conn) {
+			return
+		}
+	}
+}
+
+// Broadcast 向 filter 返回 true 的连接发送消息，filter 为 nil 时发送给所有连接
+func (server *Server) Broadcast(msg []byte, filter func(conn core.Conn) bool) (num int) {
+	frames := core.NewFrameCache(msg)
+	for _, conn := range server.conns() {
+		if filter != nil && !filter(conn) {
+			continue
+		}
+		if conn.WriteFrame(frames) == nil {
+			num++
+		}
+	}
+	return
+}
+
+// Rejections 按照原因统计的拒绝次数
+func (server *Server) Rejections() map[core.RejectReason]uint64 {
+	return server.admission.Rejections()
+}
+
+// Stats 服务器和所有连接的统计
+func (server *Server) Stats() core.ServerStats {
+	conns := server.conns()
+	stats := core.ServerStats{
+		Traffic:     server.traffic.Load(),
+		Address:     server.addr,
+		Conns:       len(conns),
+		Accepted:    atomic.LoadUint64(&server.accepted),
+		Rejections:  server.admission.Rejections(),
+		Connections: make([]core.ConnStats, 0, len(conns)),
+	}
+	for _, conn := range conns {
+		stats.Connections = append(stats.Connections, conn.Stats())
+	}
+	return stats
+}
+
+// conns 所有连接的快照
+func (server *Server) conns() []*Conn {
+	server.connMutex.Lock()
+	defer server.connMutex.Unlock()
+	conns := make([]*Conn, 0, len(server.connSet))
+	for _, conn := range server.connSet {
+		conns = append(conns, conn)
+	}
+	return conns
+}
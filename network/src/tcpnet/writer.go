@@ -0,0 +1,182 @@
+package tcpnet
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"net"
+	"sync"
+	"time"
+)
+
+// writeQueue 连接的异步写队列，编码后的数据放入队列，由单独的协程合并写入 socket
+//
+//	队列中（包括正在写入）的数据超过高水位后进入不可写状态，降到低水位以下才恢复，
+//	不可写时按照 OverflowPolicy 处理新的消息
+type writeQueue struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	conn  net.Conn
+
+	high     int
+	low      int
+	policy   core.OverflowPolicy
+	deadline time.Duration
+
+	frames [][]byte
+	// 写入成功后累加写出的字节数
+	counter *core.Counter
+	// 队列中和正在写入的字节数
+	pending    int
+	unwritable bool
+	closed     bool
+	// 写入失败时的错误，写协程退出后调用 onError
+	err     error
+	onError func(err error)
+	done    chan struct{}
+}
+
+func newWriteQueue(conn net.Conn, options core.WriteQueueOptions, counter *core.Counter, onError func(err error)) *writeQueue {
+	q := &writeQueue{
+		conn:     conn,
+		counter:  counter,
+		high:     options.HighWatermark,
+		low:      options.LowWatermark,
+		policy:   options.Policy,
+		deadline: options.WriteDeadline,
+		onError:  onError,
+		done:     make(chan struct{}),
+	}
+	if q.low > q.high {
+		q.low = q.high
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// acquire 按照 OverflowPolicy 等待队列可写，需要在编码之前调用，避免丢弃的消息改变 Codec 的状态
+func (q *writeQueue) acquire() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for {
+		if q.closed {
+			return core.ErrConnClosed
+		}
+		if !q.unwritable {
+			return nil
+		}
+		if q.policy != core.OverflowBlock {
+			return core.ErrWriteQueueFull
+		}
+		q.cond.Wait()
+	}
+}
+
+// push 放入编码后的数据，调用前需要 acquire
+func (q *writeQueue) push(b []byte) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return core.ErrConnClosed
+	}
+	q.frames = append(q.frames, b)
+	q.pending += len(b)
+	if q.pending > q.high {
+		q.unwritable = true
+	}
+	q.cond.Broadcast()
+	return nil
+}
+
+// flush 等待队列中的数据全部写入
+func (q *writeQueue) flush() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for q.pending > 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.pending > 0 {
+		if q.err != nil {
+			return q.err
+		}
+		return core.ErrConnClosed
+	}
+	return nil
+}
+
+// size 队列中和正在写入的字节数
+func (q *writeQueue) size() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pending
+}
+
+// close 停止写入，队列中的数据被丢弃，不等待写协程退出
+func (q *writeQueue) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+}
+
+// run 写协程，每次取出队列中的全部数据合并写入
+func (q *writeQueue) run() {
+	for {
+		q.mutex.Lock()
+		for len(q.frames) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mutex.Unlock()
+			close(q.done)
+			return
+		}
+		frames := q.frames
+		q.frames = nil
+		q.mutex.Unlock()
+
+		size, err := q.write(frames)
+		if err == nil {
+			q.counter.AddOut(size)
+		}
+
+		q.mutex.Lock()
+		q.pending -= size
+		if q.unwritable && q.pending <= q.low {
+			q.unwritable = false
+		}
+		if err != nil && !q.closed {
+			q.closed = true
+			q.err = err
+		}
+		closed := q.closed
+		q.mutex.Unlock()
+		q.cond.Broadcast()
+		if closed {
+			close(q.done)
+			if err != nil {
+				q.onError(err)
+			}
+			return
+		}
+	}
+}
+
+// write 合并写入，原始 TCP 连接使用 writev，被包装的连接（例如 TLS）合并成一次写入
+func (q *writeQueue) write(frames [][]byte) (size int, err error) {
+	for _, frame := range frames {
+		size += len(frame)
+	}
+	if q.deadline > 0 {
+		_ = q.conn.SetWriteDeadline(time.Now().Add(q.deadline))
+	}
+	if _, ok := q.conn.(*net.TCPConn); ok || len(frames) == 1 {
+		buffers := net.Buffers(frames)
+		_, err = buffers.WriteTo(q.conn)
+		return
+	}
+	buf := make([]byte, 0, size)
+	for _, frame := range frames {
+		buf = append(buf, frame...)
+	}
+	_, err = q.conn.Write(buf)
+	return
+}
@@ -0,0 +1,506 @@
+package udpnet
+
+import (
+	"encoding/binary"
+	"github.com/finishy1995/go-library/network/core"
+)
+
+// arq 可靠有序传输（类 KCP 的 ARQ 实现），不涉及网络，通过 output 发送数据报
+//
+//	支持选择性确认（ack 单个 sn + una 累计确认）、超时重传、快速重传、滑动窗口和拥塞控制
+//	非线程安全，由 Conn 加锁调用
+type arq struct {
+	output func(b []byte)
+
+	mtu, mss uint32
+	current  uint32
+
+	sndUna, sndNxt, rcvNxt uint32
+	sndWnd, rcvWnd, rmtWnd uint32
+	cwnd, incr, ssthresh   uint32
+
+	rxSrtt, rxRttVar, rxRto int32
+
+	probe, probeWait, tsProbe uint32
+
+	sndQueue []*segment
+	sndBuf   []*segment
+	rcvQueue []*segment
+	rcvBuf   []*segment
+	ackList  []ackItem
+
+	// dead 某个数据段重传次数过多，连接已经不可用
+	dead   bool
+	buffer []byte
+}
+
+type segment struct {
+	cmd      byte
+	frg      uint8
+	wnd      uint16
+	ts       uint32
+	sn       uint32
+	una      uint32
+	resendTs uint32
+	rto      uint32
+	fastAck  uint32
+	xmit     uint32
+	data     []byte
+}
+
+type ackItem struct {
+	sn uint32
+	ts uint32
+}
+
+const (
+	arqCmdPush byte = 81 // 数据
+	arqCmdAck  byte = 82 // 确认
+	arqCmdWask byte = 83 // 询问对端窗口
+	arqCmdWins byte = 84 // 告知本端窗口
+
+	arqAskSend uint32 = 1
+	arqAskTell uint32 = 2
+
+	// arqHeaderSize cmd(1) frg(1) wnd(2) ts(4) sn(4) una(4) len(2)
+	arqHeaderSize = 18
+
+	arqMtu        = 1400
+	arqSndWnd     = 128
+	arqRcvWnd     = 128
+	arqRtoDefault = 200
+	arqRtoMin     = 30
+	arqRtoMax     = 60000
+	arqFastResend = 2
+	arqDeadLink   = 20
+	arqThreshInit = 2
+	arqThreshMin  = 2
+	arqProbeInit  = 7000
+	arqProbeLimit = 120000
+)
+
+func newARQ(output func(b []byte)) *arq {
+	a := &arq{
+		output:   output,
+		mtu:      arqMtu,
+		mss:      arqMtu - arqHeaderSize,
+		sndWnd:   arqSndWnd,
+		rcvWnd:   arqRcvWnd,
+		rmtWnd:   arqRcvWnd,
+		cwnd:     1,
+		ssthresh: arqThreshInit,
+		rxRto:    arqRtoDefault,
+	}
+	a.incr = a.mss
+	a.buffer = make([]byte, 0, a.mtu)
+	return a
+}
+
+// timeDiff 考虑回绕的时间/序号差值
+func timeDiff(later, earlier uint32) int32 {
+	return int32(later - earlier)
+}
+
+// send 把消息切分为数据段放入发送队列，在 flush 时根据窗口发送
+func (a *arq) send(b []byte) error {
+	count := (uint32(len(b)) + a.mss - 1) / a.mss
+	if count == 0 {
+		count = 1
+	}
+	if count > a.rcvWnd || count > 255 {
+		return core.ErrTooMoreLength
+	}
+	for i := uint32(0); i < count; i++ {
+		size := uint32(len(b))
+		if size > a.mss {
+			size = a.mss
+		}
+		seg := &segment{
+			data: make([]byte, size),
+			frg:  uint8(count - i - 1),
+		}
+		copy(seg.data, b[:size])
+		a.sndQueue = append(a.sndQueue, seg)
+		b = b[size:]
+	}
+	return nil
+}
+
+// recv 取出一个完整的消息，没有时返回 nil
+func (a *arq) recv() []byte {
+	if len(a.rcvQueue) == 0 {
+		return nil
+	}
+	count := int(a.rcvQueue[0].frg) + 1
+	if len(a.rcvQueue) < count {
+		return nil
+	}
+	size := 0
+	for _, seg := range a.rcvQueue[:count] {
+		size += len(seg.data)
+	}
+	msg := make([]byte, 0, size)
+	for _, seg := range a.rcvQueue[:count] {
+		msg = append(msg, seg.data...)
+	}
+	a.rcvQueue = a.rcvQueue[count:]
+	a.moveToRcvQueue()
+	return msg
+}
+
+// input 处理收到的数据报，一个数据报可能包含多个数据段
+func (a *arq) input(data []byte) error {
+	prevUna := a.sndUna
+	var maxAck uint32
+	ackFound := false
+
+	for len(data) >= arqHeaderSize {
+		seg := segment{
+			cmd: data[0],
+			frg: data[1],
+			wnd: binary.BigEndian.Uint16(data[2:]),
+			ts:  binary.BigEndian.Uint32(data[4:]),
+			sn:  binary.BigEndian.Uint32(data[8:]),
+			una: binary.BigEndian.Uint32(data[12:]),
+		}
+		length := int(binary.BigEndian.Uint16(data[16:]))
+		data = data[arqHeaderSize:]
+		if len(data) < length {
+			return ErrInvalidSegment
+		}
+		if seg.cmd < arqCmdPush || seg.cmd > arqCmdWins {
+			return ErrInvalidSegment
+		}
+
+		a.rmtWnd = uint32(seg.wnd)
+		a.parseUna(seg.una)
+		a.shrinkBuf()
+
+		switch seg.cmd {
+		case arqCmdAck:
+			if timeDiff(a.current, seg.ts) >= 0 {
+				a.updateAck(timeDiff(a.current, seg.ts))
+			}
+			a.parseAck(seg.sn)
+			a.shrinkBuf()
+			if !ackFound || timeDiff(seg.sn, maxAck) > 0 {
+				ackFound = true
+				maxAck = seg.sn
+			}
+		case arqCmdPush:
+			if timeDiff(seg.sn, a.rcvNxt+a.rcvWnd) < 0 {
+				a.ackList = append(a.ackList, ackItem{sn: seg.sn, ts: seg.ts})
+				if timeDiff(seg.sn, a.rcvNxt) >= 0 {
+					seg.data = make([]byte, length)
+					copy(seg.data, data[:length])
+					a.parseData(&seg)
+				}
+			}
+		case arqCmdWask:
+			a.probe |= arqAskTell
+		case arqCmdWins:
+			// 窗口大小已经在上面更新
+		}
+		data = data[length:]
+	}
+
+	if ackFound {
+		a.parseFastAck(maxAck)
+	}
+
+	// 有新的数据被确认，扩大拥塞窗口
+	if timeDiff(a.sndUna, prevUna) > 0 && a.cwnd < a.rmtWnd {
+		if a.cwnd < a.ssthresh {
+			a.cwnd++
+			a.incr += a.mss
+		} else {
+			if a.incr < a.mss {
+				a.incr = a.mss
+			}
+			a.incr += (a.mss*a.mss)/a.incr + a.mss/16
+			if (a.cwnd+1)*a.mss <= a.incr {
+				a.cwnd = (a.incr + a.mss - 1) / a.mss
+			}
+		}
+		if a.cwnd > a.rmtWnd {
+			a.cwnd = a.rmtWnd
+			a.incr = a.rmtWnd * a.mss
+		}
+	}
+	return nil
+}
+
+func (a *arq) updateAck(rtt int32) {
+	if a.rxSrtt == 0 {
+		a.rxSrtt = rtt
+		a.rxRttVar = rtt / 2
+	} else {
+		delta := rtt - a.rxSrtt
+		if delta < 0 {
+			delta = -delta
+		}
+		a.rxRttVar = (3*a.rxRttVar + delta) / 4
+		a.rxSrtt = (7*a.rxSrtt + rtt) / 8
+		if a.rxSrtt < 1 {
+			a.rxSrtt = 1
+		}
+	}
+	rto := a.rxSrtt + 4*a.rxRttVar
+	if rto < arqRtoMin {
+		rto = arqRtoMin
+	} else if rto > arqRtoMax {
+		rto = arqRtoMax
+	}
+	a.rxRto = rto
+}
+
+func (a *arq) shrinkBuf() {
+	if len(a.sndBuf) > 0 {
+		a.sndUna = a.sndBuf[0].sn
+	} else {
+		a.sndUna = a.sndNxt
+	}
+}
+
+// parseUna 累计确认，移除所有 sn < una 的数据段
+func (a *arq) parseUna(una uint32) {
+	count := 0
+	for _, seg := range a.sndBuf {
+		if timeDiff(una, seg.sn) > 0 {
+			count++
+		} else {
+			break
+		}
+	}
+	a.sndBuf = a.sndBuf[count:]
+}
+
+// parseAck 选择性确认，移除指定 sn 的数据段
+func (a *arq) parseAck(sn uint32) {
+	if timeDiff(sn, a.sndUna) < 0 || timeDiff(sn, a.sndNxt) >= 0 {
+		return
+	}
+	for i, seg := range a.sndBuf {
+		if seg.sn == sn {
+			a.sndBuf = append(a.sndBuf[:i], a.sndBuf[i+1:]...)
+			return
+		}
+		if timeDiff(sn, seg.sn) < 0 {
+			return
+		}
+	}
+}
+
+// parseFastAck 被跳过确认的数据段计数，超过阈值时快速重传
+func (a *arq) parseFastAck(sn uint32) {
+	if timeDiff(sn, a.sndUna) < 0 || timeDiff(sn, a.sndNxt) >= 0 {
+		return
+	}
+	for _, seg := range a.sndBuf {
+		if timeDiff(sn, seg.sn) < 0 {
+			break
+		}
+		if sn != seg.sn {
+			seg.fastAck++
+		}
+	}
+}
+
+// parseData 按序号插入接收缓存，忽略重复数据
+func (a *arq) parseData(seg *segment) {
+	sn := seg.sn
+	if timeDiff(sn, a.rcvNxt+a.rcvWnd) >= 0 || timeDiff(sn, a.rcvNxt) < 0 {
+		return
+	}
+	index := len(a.rcvBuf)
+	for i := len(a.rcvBuf) - 1; i >= 0; i-- {
+		cur := a.rcvBuf[i]
+		if cur.sn == sn {
+			return
+		}
+		if timeDiff(sn, cur.sn) > 0 {
+			break
+		}
+		index = i
+	}
+	a.rcvBuf = append(a.rcvBuf, nil)
+	copy(a.rcvBuf[index+1:], a.rcvBuf[index:])
+	a.rcvBuf[index] = seg
+	a.moveToRcvQueue()
+}
+
+// moveToRcvQueue 把连续的数据段移动到接收队列
+func (a *arq) moveToRcvQueue() {
+	count := 0
+	for _, seg := range a.rcvBuf {
+		if seg.sn == a.rcvNxt && uint32(len(a.rcvQueue)) < a.rcvWnd {
+			a.rcvQueue = append(a.rcvQueue, seg)
+			a.rcvNxt++
+			count++
+		} else {
+			break
+		}
+	}
+	a.rcvBuf = a.rcvBuf[count:]
+}
+
+func (a *arq) wndUnused() uint16 {
+	if uint32(len(a.rcvQueue)) < a.rcvWnd {
+		return uint16(a.rcvWnd - uint32(len(a.rcvQueue)))
+	}
+	return 0
+}
+
+// write 把数据段加入待发送的数据报，超过 MTU 时先发送
+func (a *arq) write(seg *segment) {
+	if len(a.buffer)+arqHeaderSize+len(seg.data) > int(a.mtu) {
+		a.flushBuffer()
+	}
+	var header [arqHeaderSize]byte
+	header[0] = seg.cmd
+	header[1] = seg.frg
+	binary.BigEndian.PutUint16(header[2:], seg.wnd)
+	binary.BigEndian.PutUint32(header[4:], seg.ts)
+	binary.BigEndian.PutUint32(header[8:], seg.sn)
+	binary.BigEndian.PutUint32(header[12:], seg.una)
+	binary.BigEndian.PutUint16(header[16:], uint16(len(seg.data)))
+	a.buffer = append(a.buffer, header[:]...)
+	a.buffer = append(a.buffer, seg.data...)
+}
+
+func (a *arq) flushBuffer() {
+	if len(a.buffer) > 0 {
+		a.output(a.buffer)
+		a.buffer = make([]byte, 0, a.mtu)
+	}
+}
+
+// update 更新当前时间（毫秒）并发送确认、新数据和需要重传的数据
+func (a *arq) update(current uint32) {
+	a.current = current
+	a.flush()
+}
+
+func (a *arq) flush() {
+	current := a.current
+	seg := segment{
+		wnd: a.wndUnused(),
+		una: a.rcvNxt,
+	}
+
+	// 确认
+	seg.cmd = arqCmdAck
+	for _, ack := range a.ackList {
+		seg.sn, seg.ts = ack.sn, ack.ts
+		a.write(&seg)
+	}
+	a.ackList = a.ackList[:0]
+
+	// 对端窗口为 0 时定时探测
+	if a.rmtWnd == 0 {
+		if a.probeWait == 0 {
+			a.probeWait = arqProbeInit
+			a.tsProbe = current + a.probeWait
+		} else if timeDiff(current, a.tsProbe) >= 0 {
+			if a.probeWait < arqProbeInit {
+				a.probeWait = arqProbeInit
+			}
+			a.probeWait += a.probeWait / 2
+			if a.probeWait > arqProbeLimit {
+				a.probeWait = arqProbeLimit
+			}
+			a.tsProbe = current + a.probeWait
+			a.probe |= arqAskSend
+		}
+	} else {
+		a.tsProbe = 0
+		a.probeWait = 0
+	}
+	seg.sn, seg.ts = 0, 0
+	if a.probe&arqAskSend != 0 {
+		seg.cmd = arqCmdWask
+		a.write(&seg)
+	}
+	if a.probe&arqAskTell != 0 {
+		seg.cmd = arqCmdWins
+		a.write(&seg)
+	}
+	a.probe = 0
+
+	// 根据窗口把发送队列中的数据段移入发送缓存
+	cwnd := a.sndWnd
+	if a.rmtWnd < cwnd {
+		cwnd = a.rmtWnd
+	}
+	if a.cwnd < cwnd {
+		cwnd = a.cwnd
+	}
+	for len(a.sndQueue) > 0 && timeDiff(a.sndNxt, a.sndUna+cwnd) < 0 {
+		newSeg := a.sndQueue[0]
+		a.sndQueue = a.sndQueue[1:]
+		newSeg.cmd = arqCmdPush
+		newSeg.sn = a.sndNxt
+		a.sndNxt++
+		a.sndBuf = append(a.sndBuf, newSeg)
+	}
+
+	rtoMin := uint32(a.rxRto >> 3)
+	change, lost := false, false
+	for _, s := range a.sndBuf {
+		needSend := false
+		if s.xmit == 0 {
+			needSend = true
+			s.rto = uint32(a.rxRto)
+			s.resendTs = current + s.rto + rtoMin
+		} else if timeDiff(current, s.resendTs) >= 0 {
+			needSend = true
+			s.rto += s.rto / 2
+			s.resendTs = current + s.rto
+			lost = true
+		} else if s.fastAck >= arqFastResend {
+			needSend = true
+			s.fastAck = 0
+			s.resendTs = current + s.rto
+			change = true
+		}
+		if needSend {
+			s.xmit++
+			s.ts = current
+			s.wnd = seg.wnd
+			s.una = a.rcvNxt
+			a.write(s)
+			if s.xmit >= arqDeadLink {
+				a.dead = true
+			}
+		}
+	}
+	a.flushBuffer()
+
+	// 发生丢包时收缩拥塞窗口
+	if change {
+		inflight := a.sndNxt - a.sndUna
+		a.ssthresh = inflight / 2
+		if a.ssthresh < arqThreshMin {
+			a.ssthresh = arqThreshMin
+		}
+		a.cwnd = a.ssthresh + arqFastResend
+		a.incr = a.cwnd * a.mss
+	}
+	if lost {
+		a.ssthresh = a.cwnd / 2
+		if a.ssthresh < arqThreshMin {
+			a.ssthresh = arqThreshMin
+		}
+		a.cwnd = 1
+		a.incr = a.mss
+	}
+	if a.cwnd < 1 {
+		a.cwnd = 1
+		a.incr = a.mss
+	}
+}
+
+// waitSnd 尚未被确认的数据段数量
+func (a *arq) waitSnd() int {
+	return len(a.sndBuf) + len(a.sndQueue)
+}
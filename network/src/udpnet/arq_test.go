@@ -0,0 +1,139 @@
+package udpnet
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"testing"
+)
+
+// lossyLink 模拟会丢包和乱序的链路
+type lossyLink struct {
+	rand    *rand.Rand
+	loss    float64
+	packets [][]byte
+}
+
+func (link *lossyLink) output(b []byte) {
+	if link.rand.Float64() < link.loss {
+		return
+	}
+	packet := make([]byte, len(b))
+	copy(packet, b)
+	link.packets = append(link.packets, packet)
+}
+
+// deliver 打乱顺序后把数据报交给接收方
+func (link *lossyLink) deliver(r *require.Assertions, to *arq) {
+	packets := link.packets
+	link.packets = nil
+	link.rand.Shuffle(len(packets), func(i, j int) {
+		packets[i], packets[j] = packets[j], packets[i]
+	})
+	for _, packet := range packets {
+		r.Nil(to.input(packet))
+	}
+}
+
+func TestARQ_LossyLink(t *testing.T) {
+	r := require.New(t)
+	rnd := rand.New(rand.NewSource(1))
+	toB := &lossyLink{rand: rnd, loss: 0.2}
+	toA := &lossyLink{rand: rnd, loss: 0.2}
+	a := newARQ(toB.output)
+	b := newARQ(toA.output)
+
+	var sent [][]byte
+	for i := 0; i < 200; i++ {
+		msg := []byte(fmt.Sprintf("message-%d-", i))
+		// 部分消息需要切分为多个数据段
+		if i%10 == 0 {
+			msg = append(msg, bytes.Repeat([]byte{byte(i)}, 5000)...)
+		}
+		sent = append(sent, msg)
+		r.Nil(a.send(msg))
+	}
+
+	var received [][]byte
+	current := uint32(0)
+	for step := 0; step < 10000 && len(received) < len(sent); step++ {
+		current += 10
+		a.update(current)
+		toB.deliver(r, b)
+		b.update(current)
+		toA.deliver(r, a)
+		for msg := b.recv(); msg != nil; msg = b.recv() {
+			received = append(received, msg)
+		}
+	}
+	r.Equal(sent, received)
+	r.False(a.dead)
+
+	// 所有数据都被确认后发送缓存为空
+	for step := 0; step < 1000 && a.waitSnd() > 0; step++ {
+		current += 10
+		a.update(current)
+		toB.deliver(r, b)
+		b.update(current)
+		toA.deliver(r, a)
+	}
+	r.Zero(a.waitSnd())
+}
+
+func TestARQ_SelectiveAck(t *testing.T) {
+	r := require.New(t)
+	var packets [][]byte
+	a := newARQ(func(b []byte) {
+		packet := make([]byte, len(b))
+		copy(packet, b)
+		packets = append(packets, packet)
+	})
+	a.cwnd = 4
+	for i := 0; i < 4; i++ {
+		r.Nil(a.send(bytes.Repeat([]byte{byte(i)}, int(a.mss))))
+	}
+	a.update(0)
+	r.Len(packets, 4)
+	r.Equal(4, len(a.sndBuf))
+
+	var acks [][]byte
+	b := newARQ(func(p []byte) {
+		packet := make([]byte, len(p))
+		copy(packet, p)
+		acks = append(acks, packet)
+	})
+	// 第 0 个数据段丢失，其余数据段被单独确认
+	for _, packet := range packets[1:] {
+		r.Nil(b.input(packet))
+	}
+	b.update(0)
+	r.Nil(b.recv())
+	for _, ack := range acks {
+		r.Nil(a.input(ack))
+	}
+	r.Len(a.sndBuf, 1)
+	r.Equal(uint32(0), a.sndBuf[0].sn)
+	packets = nil
+	a.update(1)
+	r.Empty(packets)
+	// 再次收到跳过第 0 个数据段的确认，触发快速重传
+	for _, ack := range acks {
+		r.Nil(a.input(ack))
+	}
+	a.update(1)
+	r.Len(packets, 1)
+	r.Nil(b.input(packets[0]))
+	for i := 0; i < 4; i++ {
+		r.Equal(bytes.Repeat([]byte{byte(i)}, int(a.mss)), b.recv())
+	}
+}
+
+func TestARQ_Invalid(t *testing.T) {
+	r := require.New(t)
+	a := newARQ(func([]byte) {})
+	r.Equal(ErrInvalidSegment, a.input(make([]byte, arqHeaderSize)))
+
+	r.NotNil(a.send(make([]byte, int(a.mss)*arqRcvWnd+1)))
+	r.Nil(a.send(nil))
+}
@@ -0,0 +1,215 @@
+package udpnet
+
+import (
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/core"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// connectRetryInterval 建立连接请求的重发间隔
+	connectRetryInterval = time.Millisecond * 100
+)
+
+// Client UDP 客户端
+type Client struct {
+	sync.Mutex
+	// Reliable 是否使用可靠有序模式（类 KCP 的 ARQ），需要和服务端一致
+	Reliable bool
+
+	reconnect bool
+	addr      string
+	isConnect bool
+	closeSig  chan bool
+	closeFlag bool
+	newAgent  core.GetAgent
+	wg        sync.WaitGroup
+	conn      *Conn
+}
+
+// Start 开启客户端连接
+func (client *Client) Start(address string, newAgent core.GetAgent, opts ...core.ClientOption) error {
+	if newAgent == nil {
+		return core.ErrInvalidGetAgentFunc
+	}
+	if !core.VerifyIPAddress(address) {
+		return core.ErrInvalidAddress
+	}
+	client.newAgent = newAgent
+	client.addr = address
+	options := core.DefaultClientOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	client.reconnect = options.Reconnect
+	client.isConnect = false
+	client.closeSig = make(chan bool)
+	client.closeFlag = false
+
+	log.Info("UDP trying to connect %s", client.addr)
+
+	return nil
+}
+
+// Run 执行主逻辑，连接断开后如果需要重连会重新建立连接
+func (client *Client) Run() {
+	firstStart := make(chan bool, 1)
+	firstStart <- true
+	for {
+		select {
+		case <-client.closeSig:
+			return
+		case <-firstStart:
+			break
+		case <-time.After(core.DefaultConnectMaxWait):
+			break
+		}
+
+		socket, err := client.dial()
+		if err != nil {
+			continue
+		}
+		client.serve(socket)
+		if !client.reconnect {
+			return
+		}
+	}
+}
+
+// dial 创建 socket 并完成建立连接请求
+func (client *Client) dial() (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", client.addr)
+	if err != nil {
+		return nil, err
+	}
+	socket, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	err = client.connect(socket)
+	if err != nil {
+		_ = socket.Close()
+		return nil, err
+	}
+	return socket, nil
+}
+
+func (client *Client) connect(socket *net.UDPConn) error {
+	buf := make([]byte, MaxDatagramSize)
+	deadline := time.Now().Add(core.DefaultConnectMaxWait)
+	for time.Now().Before(deadline) {
+		select {
+		case <-client.closeSig:
+			return ErrConnectTimeout
+		default:
+		}
+		if _, err := socket.Write([]byte{kindConnect}); err != nil {
+			return err
+		}
+		_ = socket.SetReadDeadline(time.Now().Add(connectRetryInterval))
+		n, err := socket.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		switch buf[0] {
+		case kindAccept:
+			return nil
+		case kindClose:
+			return ErrConnectRejected
+		}
+	}
+	return ErrConnectTimeout
+}
+
+// serve 处理一个已经建立的连接，直到连接断开
+func (client *Client) serve(socket *net.UDPConn) {
+	agent := client.newAgent()
+	if agent == nil {
+		log.Error("New agent error: %v", core.ErrInvalidGetAgentFunc)
+		_ = socket.Close()
+		return
+	}
+	conn := newConn(socket, nil, client.Reliable, func(conn *Conn) {
+		agent.OnClose(conn)
+		_ = socket.Close()
+	})
+	conn.heartbeat = true
+
+	client.Lock()
+	if client.closeFlag {
+		client.Unlock()
+		_ = socket.Close()
+		return
+	}
+	client.conn = conn
+	client.isConnect = true
+	client.wg.Add(1)
+	client.Unlock()
+	defer func() {
+		client.Lock()
+		client.conn = nil
+		client.isConnect = false
+		client.Unlock()
+		client.wg.Done()
+	}()
+
+	log.Info("UDP connect to %s", client.addr)
+	conn.setAgent(agent)
+
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		_ = socket.SetReadDeadline(time.Now().Add(core.UpdateInterval))
+		n, err := socket.Read(buf)
+		if err == nil && n > 0 {
+			payload := make([]byte, n-1)
+			copy(payload, buf[1:n])
+			conn.input(buf[0], payload)
+		} else if err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				conn.Close()
+				return
+			}
+		}
+		if conn.isClosed() {
+			return
+		}
+		if !conn.update(getTime()) {
+			conn.timeout()
+			return
+		}
+	}
+}
+
+// Close 关闭客户端连接
+func (client *Client) Close() {
+	client.Lock()
+	if client.closeFlag {
+		client.Unlock()
+		return
+	}
+	client.closeFlag = true
+	close(client.closeSig)
+	conn := client.conn
+	client.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	client.wg.Wait()
+}
+
+// IsConnected 是否处于连接状态
+func (client *Client) IsConnected() bool {
+	client.Lock()
+	defer client.Unlock()
+	return client.isConnect
+}
@@ -0,0 +1,259 @@
+package udpnet
+
+import (
+	"bytes"
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/network/protocol"
+	"net"
+	"sync"
+	"time"
+)
+
+// 数据报第一个字节为类型，后面为负载
+const (
+	// kindConnect 客户端请求建立连接
+	kindConnect byte = 1
+	// kindAccept 服务端接受连接
+	kindAccept byte = 2
+	// kindData 数据，可靠模式下负载为 arq 数据段
+	kindData byte = 3
+	// kindPing 客户端心跳
+	kindPing byte = 4
+	// kindPong 服务端心跳回复
+	kindPong byte = 5
+	// kindClose 断开连接，服务端拒绝连接时也会发送
+	kindClose byte = 6
+
+	// MaxDatagramSize UDP 数据报最大长度
+	MaxDatagramSize = 65507
+)
+
+var (
+	protoc = protocol.ProtocolV001
+)
+
+func getTime() int64 {
+	return time.Now().UnixNano() / 1000000
+}
+
+// Conn UDP 虚拟连接，服务端按照远程地址区分不同的连接
+//
+//	普通模式下一次 Write 对应一个数据报，可能丢失或乱序；可靠模式下通过 arq 保证有序送达
+type Conn struct {
+	sync.Mutex
+
+	id     core.ID
+	socket *net.UDPConn
+	// 服务端连接的远程地址，客户端连接使用已经 Dial 的 socket，为 nil
+	addr      *net.UDPAddr
+	agent     core.Agent
+	arq       *arq
+	closeFlag bool
+	// 连接关闭后回调，由所属的 Server/Client 移除连接并通知 Agent
+	onClose func(conn *Conn)
+	// 是否定时发送心跳，只有客户端发送
+	heartbeat bool
+
+	// 最近一次心跳包时间
+	lastHeartbeatTime int64
+	// 最近一次收到包时间
+	lastRecvTime int64
+}
+
+func newConn(socket *net.UDPConn, addr *net.UDPAddr, reliable bool, onClose func(conn *Conn)) *Conn {
+	t := getTime()
+	conn := &Conn{
+		id:                core.GenerateID(),
+		socket:            socket,
+		addr:              addr,
+		onClose:           onClose,
+		lastHeartbeatTime: t,
+		lastRecvTime:      t,
+	}
+	if reliable {
+		conn.arq = newARQ(func(b []byte) {
+			_ = conn.send(kindData, b)
+		})
+	}
+	return conn
+}
+
+// Run UDP 连接的数据由 Server/Client 统一读取，不需要单独运行
+func (conn *Conn) Run() {}
+
+// Close 断连，通知对端后回调 Agent.OnClose
+func (conn *Conn) Close() {
+	if conn.shutdown(true) {
+		conn.onClose(conn)
+	}
+}
+
+// shutdown 标记连接关闭，notify 为 true 时通知对端；已经关闭时返回 false
+func (conn *Conn) shutdown(notify bool) bool {
+	conn.Lock()
+	defer conn.Unlock()
+	if conn.closeFlag {
+		return false
+	}
+	conn.closeFlag = true
+	if notify {
+		_ = conn.send(kindClose, nil)
+	}
+	return true
+}
+
+// Write b 必须在其他协程中不被修改
+func (conn *Conn) Write(b []byte) (n int, err error) {
+	if b == nil {
+		return
+	}
+	conn.Lock()
+	defer conn.Unlock()
+	if conn.closeFlag {
+		return
+	}
+
+	if conn.arq == nil {
+		if len(b)+1 > MaxDatagramSize {
+			return 0, core.ErrTooMoreLength
+		}
+		err = conn.send(kindData, b)
+	} else {
+		err = conn.arq.send(b)
+		if err == nil {
+			conn.arq.update(uint32(getTime()))
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// LocalAddr 本地socket端口地址
+func (conn *Conn) LocalAddr() net.Addr {
+	return conn.socket.LocalAddr()
+}
+
+// ID 连接唯一键
+func (conn *Conn) ID() core.ID {
+	return conn.id
+}
+
+// RemoteAddr 远程socket端口地址
+func (conn *Conn) RemoteAddr() net.Addr {
+	if conn.addr != nil {
+		return conn.addr
+	}
+	return conn.socket.RemoteAddr()
+}
+
+// send 发送一个数据报，调用时需要持有锁
+func (conn *Conn) send(kind byte, payload []byte) (err error) {
+	buf := make([]byte, 1+len(payload))
+	buf[0] = kind
+	copy(buf[1:], payload)
+	if conn.addr != nil {
+		_, err = conn.socket.WriteToUDP(buf, conn.addr)
+	} else {
+		_, err = conn.socket.Write(buf)
+	}
+	return
+}
+
+// reply 回复一个不带负载的控制数据报
+func (conn *Conn) reply(kind byte) {
+	conn.Lock()
+	defer conn.Unlock()
+	if !conn.closeFlag {
+		_ = conn.send(kind, nil)
+	}
+}
+
+// setAgent 设置 Agent
+func (conn *Conn) setAgent(agent core.Agent) {
+	conn.agent = agent
+	conn.agent.OnConnect(conn)
+}
+
+// input 处理收到的数据报，payload 在调用后不会被复用
+func (conn *Conn) input(kind byte, payload []byte) {
+	var msgs [][]byte
+	conn.Lock()
+	if conn.closeFlag {
+		conn.Unlock()
+		return
+	}
+	conn.lastRecvTime = getTime()
+	switch kind {
+	case kindData:
+		if conn.arq == nil {
+			msgs = append(msgs, payload)
+			break
+		}
+		err := conn.arq.input(payload)
+		if err != nil {
+			log.Error("udp input failed, error: %s", err.Error())
+		}
+		// 立即发送确认
+		conn.arq.update(uint32(conn.lastRecvTime))
+		for msg := conn.arq.recv(); msg != nil; msg = conn.arq.recv() {
+			msgs = append(msgs, msg)
+		}
+	case kindPing:
+		_ = conn.send(kindPong, nil)
+	case kindClose:
+		conn.closeFlag = true
+	}
+	closed := conn.closeFlag
+	conn.Unlock()
+
+	if closed {
+		conn.onClose(conn)
+		return
+	}
+	for _, msg := range msgs {
+		if bytes.Equal(msg, protoc.ReceiveMsg) {
+			_, _ = conn.Write(protoc.ReplyMsg)
+			continue
+		}
+		conn.agent.OnMessage(msg, conn)
+	}
+}
+
+// update 定时检查超时、发送心跳和重传数据，连接不再可用时返回 false
+func (conn *Conn) update(now int64) bool {
+	conn.Lock()
+	defer conn.Unlock()
+	if conn.closeFlag {
+		return true
+	}
+	if now-conn.lastRecvTime > core.TimeoutTime {
+		return false
+	}
+	if conn.arq != nil {
+		conn.arq.update(uint32(now))
+		if conn.arq.dead {
+			return false
+		}
+	}
+	if conn.heartbeat && now-conn.lastHeartbeatTime >= core.HeartbeatTime {
+		conn.lastHeartbeatTime = now
+		_ = conn.send(kindPing, nil)
+	}
+	return true
+}
+
+// timeout 通知 Agent 超时后断开连接
+func (conn *Conn) timeout() {
+	core.NotifyTimeout(conn.agent, conn)
+	conn.Close()
+}
+
+// isClosed 连接是否已经关闭
+func (conn *Conn) isClosed() bool {
+	conn.Lock()
+	defer conn.Unlock()
+	return conn.closeFlag
+}
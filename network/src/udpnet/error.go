@@ -0,0 +1,12 @@
+package udpnet
+
+import "errors"
+
+var (
+	// ErrInvalidSegment 收到不合法的可靠 UDP 数据段
+	ErrInvalidSegment = errors.New("invalid reliable udp segment")
+	// ErrConnectRejected 服务端拒绝连接，例如超过最大连接数
+	ErrConnectRejected = errors.New("udp connect rejected")
+	// ErrConnectTimeout 服务端没有回复建立连接请求
+	ErrConnectTimeout = errors.New("udp connect timeout")
+)
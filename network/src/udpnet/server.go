@@ -0,0 +1,220 @@
+package udpnet
+
+import (
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/routine"
+	"net"
+	"sync"
+	"time"
+)
+
+// Server UDP 服务器，所有连接共用一个 socket，按照远程地址分发到不同的虚拟连接
+type Server struct {
+	// Reliable 是否使用可靠有序模式（类 KCP 的 ARQ），需要和客户端一致
+	Reliable bool
+
+	// 连接管理
+	connSet map[string]*Conn
+
+	addr       string
+	maxConnNum int
+	newAgent   core.GetAgent
+
+	socket    *net.UDPConn
+	connMutex sync.Mutex
+	wg        sync.WaitGroup
+	closeSig  chan bool
+	closeFlag bool
+}
+
+// Start 开始udp监听
+func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core.ServerOption) error {
+	// 读取并初始化参数
+	if newAgent == nil {
+		return core.ErrInvalidGetAgentFunc
+	}
+	if !core.VerifyIPAddress(address) {
+		return core.ErrInvalidAddress
+	}
+	server.newAgent = newAgent
+	server.addr = address
+	options := core.DefaultServerOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.MaxConnNum < 0 {
+		server.maxConnNum = core.DefaultMaxConnNum
+	} else {
+		server.maxConnNum = options.MaxConnNum
+	}
+
+	// 初始化数组
+	server.connSet = make(map[string]*Conn)
+	server.closeSig = make(chan bool)
+	server.closeFlag = false
+
+	// 创建监听
+	udpAddr, err := net.ResolveUDPAddr("udp", server.addr)
+	if err != nil {
+		return err
+	}
+	socket, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	server.socket = socket
+	log.Info("UDP Listen %s", server.addr)
+
+	return nil
+}
+
+// Run 执行服务端逻辑
+func (server *Server) Run() {
+	server.wg.Add(1)
+	defer server.wg.Done()
+
+	server.wg.Add(1)
+	err := routine.Run(true, server.update)
+	if err != nil {
+		log.Error("UDP update %s failed, error: %s", server.addr, err.Error())
+		server.wg.Done()
+		return
+	}
+
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, addr, err := server.socket.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		payload := make([]byte, n-1)
+		copy(payload, buf[1:n])
+		server.handle(buf[0], payload, addr)
+	}
+}
+
+// handle 把数据报分发到对应的连接，新的地址只接受建立连接请求
+func (server *Server) handle(kind byte, payload []byte, addr *net.UDPAddr) {
+	key := addr.String()
+	server.connMutex.Lock()
+	conn, ok := server.connSet[key]
+	if ok {
+		server.connMutex.Unlock()
+		if kind == kindConnect {
+			// 客户端没有收到之前的回复
+			conn.reply(kindAccept)
+			return
+		}
+		conn.input(kind, payload)
+		return
+	}
+
+	if kind != kindConnect {
+		server.connMutex.Unlock()
+		// 服务端已经没有这个连接（例如超时或者重启），通知客户端重新连接
+		if kind != kindClose {
+			server.reject(addr)
+		}
+		return
+	}
+	if server.closeFlag || len(server.connSet) >= server.maxConnNum {
+		server.connMutex.Unlock()
+		server.reject(addr)
+		return
+	}
+	agent := server.newAgent()
+	if agent == nil {
+		server.connMutex.Unlock()
+		log.Error("New agent error: %v", core.ErrInvalidGetAgentFunc)
+		server.reject(addr)
+		return
+	}
+	conn = newConn(server.socket, addr, server.Reliable, server.remove)
+	server.connSet[key] = conn
+	server.connMutex.Unlock()
+
+	conn.reply(kindAccept)
+	// 读取协程在 OnConnect 返回后才会处理这个连接的数据
+	conn.setAgent(agent)
+}
+
+func (server *Server) reject(addr *net.UDPAddr) {
+	_, _ = server.socket.WriteToUDP([]byte{kindClose}, addr)
+}
+
+// remove 连接关闭后移除并通知 Agent
+func (server *Server) remove(conn *Conn) {
+	server.connMutex.Lock()
+	key := conn.addr.String()
+	if server.connSet[key] == conn {
+		delete(server.connSet, key)
+	}
+	server.connMutex.Unlock()
+	conn.agent.OnClose(conn)
+}
+
+// update 定时检查所有连接
+func (server *Server) update() {
+	defer server.wg.Done()
+	ticker := time.NewTicker(core.UpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-server.closeSig:
+			return
+		case <-ticker.C:
+		}
+		now := getTime()
+		for _, conn := range server.conns() {
+			if !conn.update(now) {
+				conn.timeout()
+			}
+		}
+	}
+}
+
+func (server *Server) conns() []*Conn {
+	server.connMutex.Lock()
+	defer server.connMutex.Unlock()
+	conns := make([]*Conn, 0, len(server.connSet))
+	for _, conn := range server.connSet {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Close 关闭UDP监听
+func (server *Server) Close() {
+	server.connMutex.Lock()
+	if server.closeFlag {
+		server.connMutex.Unlock()
+		return
+	}
+	server.closeFlag = true
+	close(server.closeSig)
+	server.connMutex.Unlock()
+
+	for _, conn := range server.conns() {
+		conn.Close()
+	}
+	err := server.socket.Close()
+	log.Info("UDP Close %s", server.addr)
+	if err != nil {
+		log.Error("UDP close failed, error: %s", err.Error())
+	}
+	server.wg.Wait()
+}
+
+// GetConnNum 获取所有连接的数量
+func (server *Server) GetConnNum() (num int) {
+	server.connMutex.Lock()
+	defer server.connMutex.Unlock()
+	return len(server.connSet)
+}
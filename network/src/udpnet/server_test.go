@@ -0,0 +1,150 @@
+package udpnet
+
+import (
+	"bytes"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/routine"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAddress = "127.0.0.1:6689"
+	testWait    = time.Second * 2
+)
+
+type echoAgent struct{}
+
+func (agent *echoAgent) OnConnect(core.Conn) {}
+func (agent *echoAgent) OnMessage(b []byte, conn core.Conn) {
+	_, _ = conn.Write(b)
+}
+func (agent *echoAgent) OnClose(core.Conn) {}
+
+type recordAgent struct {
+	sync.Mutex
+	connected chan core.Conn
+	closed    chan bool
+	messages  [][]byte
+}
+
+func newRecordAgent() *recordAgent {
+	return &recordAgent{
+		connected: make(chan core.Conn, 1),
+		closed:    make(chan bool, 1),
+	}
+}
+
+func (agent *recordAgent) OnConnect(conn core.Conn) {
+	agent.connected <- conn
+}
+
+func (agent *recordAgent) OnMessage(b []byte, _ core.Conn) {
+	agent.Lock()
+	defer agent.Unlock()
+	agent.messages = append(agent.messages, b)
+}
+
+func (agent *recordAgent) OnClose(core.Conn) {
+	agent.closed <- true
+}
+
+func (agent *recordAgent) count() int {
+	agent.Lock()
+	defer agent.Unlock()
+	return len(agent.messages)
+}
+
+func startServer(r *require.Assertions, reliable bool, opts ...core.ServerOption) *Server {
+	server := &Server{Reliable: reliable}
+	r.Nil(server.Start(testAddress, func() core.Agent { return new(echoAgent) }, opts...))
+	r.Nil(routine.Run(false, server.Run))
+	return server
+}
+
+func startClient(r *require.Assertions, reliable bool, agent core.Agent) *Client {
+	client := &Client{Reliable: reliable}
+	r.Nil(client.Start(testAddress, func() core.Agent { return agent }))
+	r.Nil(routine.Run(false, client.Run))
+	return client
+}
+
+func waitConnect(r *require.Assertions, agent *recordAgent) core.Conn {
+	select {
+	case conn := <-agent.connected:
+		return conn
+	case <-time.After(testWait):
+		r.FailNow("connect timeout")
+		return nil
+	}
+}
+
+func TestServer_Echo(t *testing.T) {
+	for _, reliable := range []bool{false, true} {
+		r := require.New(t)
+		server := startServer(r, reliable)
+		agent := newRecordAgent()
+		client := startClient(r, reliable, agent)
+		conn := waitConnect(r, agent)
+		r.True(client.IsConnected())
+		r.Equal(1, server.GetConnNum())
+
+		msgs := [][]byte{[]byte("hello"), []byte("world")}
+		if reliable {
+			// 可靠模式支持超过 MTU 的消息
+			msgs = append(msgs, bytes.Repeat([]byte{'a'}, 20000))
+		}
+		for _, msg := range msgs {
+			n, err := conn.Write(msg)
+			r.Nil(err)
+			r.Equal(len(msg), n)
+		}
+		r.Eventually(func() bool { return agent.count() == len(msgs) }, testWait, core.UpdateInterval)
+		if reliable {
+			agent.Lock()
+			r.Equal(msgs, agent.messages)
+			agent.Unlock()
+		}
+
+		client.Close()
+		r.False(client.IsConnected())
+		r.Eventually(func() bool { return server.GetConnNum() == 0 }, testWait, core.UpdateInterval)
+		server.Close()
+	}
+}
+
+func TestServer_MaxConnNum(t *testing.T) {
+	r := require.New(t)
+	server := startServer(r, false, core.WithMaxConnNum(1))
+	defer server.Close()
+
+	first := newRecordAgent()
+	defer startClient(r, false, first).Close()
+	waitConnect(r, first)
+
+	client := new(Client)
+	r.Nil(client.Start(testAddress, func() core.Agent { return newRecordAgent() }))
+	_, err := client.dial()
+	r.Equal(ErrConnectRejected, err)
+	r.Equal(1, server.GetConnNum())
+}
+
+func TestServer_Close(t *testing.T) {
+	r := require.New(t)
+	server := startServer(r, true)
+	agent := newRecordAgent()
+	client := startClient(r, true, agent)
+	defer client.Close()
+	waitConnect(r, agent)
+
+	// 服务端关闭时通知客户端
+	server.Close()
+	select {
+	case <-agent.closed:
+	case <-time.After(testWait):
+		r.FailNow("close timeout")
+	}
+	r.Eventually(func() bool { return !client.IsConnected() }, testWait, core.UpdateInterval)
+}
@@ -0,0 +1,25 @@
+package unixnet
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/network/src/tcpnet"
+	"net"
+	"time"
+)
+
+// Client Unix 域套接字客户端，基于 tcpnet 实现，地址格式为 unix:路径
+type Client struct {
+	tcpnet.Client
+}
+
+// Start 开启 Unix 域套接字客户端连接
+func (client *Client) Start(address string, newAgent core.GetAgent, opts ...core.ClientOption) error {
+	if network, _ := core.SplitAddress(address); network != core.UnixNetwork {
+		return core.ErrInvalidAddress
+	}
+	client.Dial = func(address string, timeout time.Duration) (net.Conn, error) {
+		_, path := core.SplitAddress(address)
+		return net.DialTimeout("unix", path, timeout)
+	}
+	return client.Client.Start(address, newAgent, opts...)
+}
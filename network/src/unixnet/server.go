@@ -0,0 +1,26 @@
+package unixnet
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/network/src/tcpnet"
+	"net"
+)
+
+// Server Unix 域套接字服务器，基于 tcpnet 实现，地址格式为 unix:路径
+//
+//	关闭时删除套接字文件，进程异常退出后残留的文件需要调用方删除，否则监听失败
+type Server struct {
+	tcpnet.Server
+}
+
+// Start 开始 Unix 域套接字监听
+func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core.ServerOption) error {
+	if network, _ := core.SplitAddress(address); network != core.UnixNetwork {
+		return core.ErrInvalidAddress
+	}
+	server.Listen = func(address string) (net.Listener, error) {
+		_, path := core.SplitAddress(address)
+		return net.Listen("unix", path)
+	}
+	return server.Server.Start(address, newAgent, opts...)
+}
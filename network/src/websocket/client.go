@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/network/src/tcpnet"
+)
+
+// Client WebSocket 客户端，基于 tcpnet 实现
+//
+//	Context 支持 "path" 设置请求路径（默认为 /），"host" 设置 Host 头（默认为连接地址）
+type Client struct {
+	tcpnet.Client
+}
+
+// Start 开启 WebSocket 客户端连接
+func (client *Client) Start(address string, newAgent core.GetAgent, opts ...core.ClientOption) error {
+	options := core.DefaultClientOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	host, path := address, DefaultPath
+	if options.Context != nil {
+		if h, ok := options.Context["host"]; ok {
+			if host, ok = h.(string); !ok {
+				return ErrInvalidContext
+			}
+		}
+		if p, ok := options.Context["path"]; ok {
+			if path, ok = p.(string); !ok {
+				return ErrInvalidContext
+			}
+		}
+	}
+	opts = append(opts, core.WithClientHandshake(ClientHandshake(host, path)))
+	return client.Client.Start(address, newAgent, opts...)
+}
@@ -0,0 +1,271 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/finishy1995/go-library/network/core"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xa
+
+	finBit  byte = 0x80
+	maskBit byte = 0x80
+
+	// maxHeaderLength 帧头最大长度：2 字节基础头 + 8 字节扩展长度 + 4 字节掩码
+	maxHeaderLength = 14
+	// maxControlPayload 控制帧负载最大长度
+	maxControlPayload = 125
+
+	// CloseNormal 正常关闭
+	CloseNormal uint16 = 1000
+	// CloseProtocolError 协议错误
+	CloseProtocolError uint16 = 1002
+	// CloseMessageTooBig 消息过大
+	CloseMessageTooBig uint16 = 1009
+)
+
+var (
+	// MaxMessageSize 单条消息（包括所有分片）的最大长度
+	MaxMessageSize = 1 << 20
+
+	// ErrProtocol WebSocket 协议错误
+	ErrProtocol = errors.New("websocket protocol error")
+	// ErrInvalidContext Context 中的 path、host 不是字符串
+	ErrInvalidContext = errors.New("websocket context path and host must be string")
+)
+
+// encodedWriter 可以发送已经编码的数据的连接，tcpnet 连接通过它和 Write 共用锁和写队列
+type encodedWriter interface {
+	WriteEncoded(b []byte) error
+}
+
+// Codec WebSocket 帧 Codec，每个连接独立一个实例
+//
+//	分片消息会被合并后再交给 Agent，ping/pong/close 控制帧在 Codec 内部处理：
+//	pong 帧通过连接的发送流程写入，不会和并发的 Write 交错；close 帧在连接关闭时由 Closing 返回
+type Codec struct {
+	conn     net.Conn
+	isClient bool
+
+	// 正在接收的分片消息
+	fragments []byte
+	// 是否正在接收分片消息
+	fragmented bool
+
+	closeMutex sync.Mutex
+	closeSent  bool
+	// 回复对端或者协议错误时的关闭码，0 为正常关闭
+	closeCode uint16
+}
+
+// NewCodec 创建 WebSocket Codec，连接不支持发送已经编码的数据时使用 conn 回复控制帧，isClient 为 true 时发送的帧需要掩码
+func NewCodec(conn net.Conn, isClient bool) *Codec {
+	return &Codec{
+		conn:     conn,
+		isClient: isClient,
+	}
+}
+
+// Encode 把数据封装为一个二进制帧
+func (cc *Codec) Encode(_ core.CodecConn, buf []byte) ([]byte, error) {
+	return cc.frame(opBinary, buf), nil
+}
+
+// Decode 解析一个完整的消息，控制帧和未结束的分片返回 nil
+func (cc *Codec) Decode(c core.CodecConn) ([]byte, error) {
+	size, header := c.ReadN(maxHeaderLength)
+	if size < 2 {
+		return nil, core.ErrPacketSplit
+	}
+	fin := header[0]&finBit != 0
+	opcode := header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		return nil, cc.fail(CloseProtocolError)
+	}
+	masked := header[1]&maskBit != 0
+	// 客户端发送的帧必须有掩码，服务端发送的帧不能有掩码
+	if masked == cc.isClient {
+		return nil, cc.fail(CloseProtocolError)
+	}
+
+	offset := 2
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		if size < offset+2 {
+			return nil, core.ErrPacketSplit
+		}
+		length = uint64(binary.BigEndian.Uint16(header[offset:]))
+		offset += 2
+	case 127:
+		if size < offset+8 {
+			return nil, core.ErrPacketSplit
+		}
+		length = binary.BigEndian.Uint64(header[offset:])
+		offset += 8
+	}
+	var key [4]byte
+	if masked {
+		if size < offset+4 {
+			return nil, core.ErrPacketSplit
+		}
+		copy(key[:], header[offset:offset+4])
+		offset += 4
+	}
+	if length > uint64(MaxMessageSize) || len(cc.fragments)+int(length) > MaxMessageSize {
+		return nil, cc.fail(CloseMessageTooBig)
+	}
+
+	total := offset + int(length)
+	if c.BufferLength() < total {
+		return nil, core.ErrPacketSplit
+	}
+	_, buf := c.ReadN(total)
+	payload := make([]byte, length)
+	copy(payload, buf[offset:total])
+	c.ShiftN(total)
+	if masked {
+		maskBytes(key, payload)
+	}
+
+	switch opcode {
+	case opPing:
+		if !fin || length > maxControlPayload {
+			return nil, cc.fail(CloseProtocolError)
+		}
+		return nil, cc.writeControl(c, cc.frame(opPong, payload))
+	case opPong:
+		if !fin || length > maxControlPayload {
+			return nil, cc.fail(CloseProtocolError)
+		}
+		return nil, nil
+	case opClose:
+		if !fin || length > maxControlPayload || length == 1 {
+			return nil, cc.fail(CloseProtocolError)
+		}
+		code := CloseNormal
+		if length >= 2 {
+			code = binary.BigEndian.Uint16(payload)
+		}
+		cc.sendClose(code)
+		return nil, io.EOF
+	case opText, opBinary:
+		if cc.fragmented {
+			return nil, cc.fail(CloseProtocolError)
+		}
+		if fin {
+			return payload, nil
+		}
+		cc.fragmented = true
+		cc.fragments = payload
+		return nil, nil
+	case opContinuation:
+		if !cc.fragmented {
+			return nil, cc.fail(CloseProtocolError)
+		}
+		cc.fragments = append(cc.fragments, payload...)
+		if !fin {
+			return nil, nil
+		}
+		msg := cc.fragments
+		cc.fragments = nil
+		cc.fragmented = false
+		return msg, nil
+	default:
+		return nil, cc.fail(CloseProtocolError)
+	}
+}
+
+// writeControl 发送控制帧，优先使用连接的发送流程
+func (cc *Codec) writeControl(c core.CodecConn, b []byte) error {
+	if w, ok := c.(encodedWriter); ok {
+		return w.WriteEncoded(b)
+	}
+	_, err := cc.conn.Write(b)
+	return err
+}
+
+// Closing 关闭连接前发送 close 帧，回复对端或者协议错误时使用 sendClose 记录的关闭码
+func (cc *Codec) Closing() []byte {
+	cc.closeMutex.Lock()
+	defer cc.closeMutex.Unlock()
+	if cc.closeSent {
+		return nil
+	}
+	cc.closeSent = true
+	code := cc.closeCode
+	if code == 0 {
+		code = CloseNormal
+	}
+	return cc.closeFrame(code)
+}
+
+// fail 记录协议错误的关闭码并返回协议错误，连接随后关闭时发送 close 帧
+func (cc *Codec) fail(code uint16) error {
+	cc.sendClose(code)
+	return ErrProtocol
+}
+
+// sendClose 记录 close 帧的关闭码，Decode 返回错误后连接关闭，由 Closing 在连接的锁内发送
+func (cc *Codec) sendClose(code uint16) {
+	cc.closeMutex.Lock()
+	defer cc.closeMutex.Unlock()
+	if cc.closeCode == 0 {
+		cc.closeCode = code
+	}
+}
+
+func (cc *Codec) closeFrame(code uint16) []byte {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	return cc.frame(opClose, payload)
+}
+
+// frame 生成一个完整的单帧，客户端发送时加上随机掩码
+func (cc *Codec) frame(opcode byte, payload []byte) []byte {
+	length := len(payload)
+	out := make([]byte, 0, maxHeaderLength+length)
+	out = append(out, finBit|opcode)
+
+	var maskFlag byte
+	if cc.isClient {
+		maskFlag = maskBit
+	}
+	switch {
+	case length <= 125:
+		out = append(out, maskFlag|byte(length))
+	case length <= 0xffff:
+		out = append(out, maskFlag|126, 0, 0)
+		binary.BigEndian.PutUint16(out[len(out)-2:], uint16(length))
+	default:
+		out = append(out, maskFlag|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(out[len(out)-8:], uint64(length))
+	}
+
+	if !cc.isClient {
+		return append(out, payload...)
+	}
+	var key [4]byte
+	_, _ = rand.Read(key[:])
+	out = append(out, key[:]...)
+	start := len(out)
+	out = append(out, payload...)
+	maskBytes(key, out[start:])
+	return out
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
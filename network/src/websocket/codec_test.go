@@ -0,0 +1,229 @@
+package websocket
+
+import (
+	"bytes"
+	"github.com/finishy1995/go-library/network/codec"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// recordConn 记录 Codec 直接写入的控制帧，连接不支持 WriteEncoded 时使用
+type recordConn struct {
+	net.Conn
+	sync.Mutex
+	written bytes.Buffer
+}
+
+func (conn *recordConn) Write(b []byte) (int, error) {
+	conn.Lock()
+	defer conn.Unlock()
+	return conn.written.Write(b)
+}
+
+type mockConn struct {
+	codec.ConnHelper
+}
+
+func newMockConn() *mockConn {
+	c := new(mockConn)
+	c.InitBuffer()
+	return c
+}
+
+func (conn *mockConn) Run()                        {}
+func (conn *mockConn) Close()                      {}
+func (conn *mockConn) LocalAddr() net.Addr         { return nil }
+func (conn *mockConn) RemoteAddr() net.Addr        { return nil }
+func (conn *mockConn) ID() core.ID                 { return 0 }
+func (conn *mockConn) Write(b []byte) (int, error) { return len(b), nil }
+func (conn *mockConn) push(b []byte) *mockConn     { conn.PushPacket(b); return conn }
+
+// encodedConn 支持 WriteEncoded 的连接，控制帧通过连接的发送流程写入
+type encodedConn struct {
+	mockConn
+	written [][]byte
+}
+
+func (conn *encodedConn) WriteEncoded(b []byte) error {
+	conn.written = append(conn.written, b)
+	return nil
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	r := require.New(t)
+	server := NewCodec(new(recordConn), false)
+	client := NewCodec(new(recordConn), true)
+
+	for _, size := range []int{0, 5, 125, 126, 0xffff, 0x10000} {
+		msg := bytes.Repeat([]byte{'a'}, size)
+		frame, err := client.Encode(nil, msg)
+		r.Nil(err)
+		r.NotZero(frame[1] & maskBit)
+		out, err := server.Decode(newMockConn().push(frame))
+		r.Nil(err)
+		r.Equal(msg, out)
+
+		frame, err = server.Encode(nil, msg)
+		r.Nil(err)
+		r.Zero(frame[1] & maskBit)
+		out, err = client.Decode(newMockConn().push(frame))
+		r.Nil(err)
+		r.Equal(msg, out)
+	}
+}
+
+func TestCodec_Split(t *testing.T) {
+	r := require.New(t)
+	server := NewCodec(new(recordConn), false)
+	client := NewCodec(new(recordConn), true)
+	first, _ := client.Encode(nil, []byte("hello"))
+	second, _ := client.Encode(nil, []byte("world"))
+	b := append(first, second...)
+
+	conn := newMockConn()
+	conn.push(b[:1])
+	_, err := server.Decode(conn)
+	r.Equal(core.ErrPacketSplit, err)
+	conn.push(b[1:8])
+	_, err = server.Decode(conn)
+	r.Equal(core.ErrPacketSplit, err)
+	conn.push(b[8:])
+	out, err := server.Decode(conn)
+	r.Nil(err)
+	r.Equal([]byte("hello"), out)
+	out, err = server.Decode(conn)
+	r.Nil(err)
+	r.Equal([]byte("world"), out)
+	_, err = server.Decode(conn)
+	r.Equal(core.ErrPacketSplit, err)
+}
+
+func TestCodec_Fragmentation(t *testing.T) {
+	r := require.New(t)
+	raw := new(recordConn)
+	server := NewCodec(raw, false)
+	client := NewCodec(new(recordConn), true)
+
+	conn := newMockConn()
+	first := client.frame(opText, []byte("hel"))
+	first[0] &^= finBit
+	conn.push(first)
+	out, err := server.Decode(conn)
+	r.Nil(err)
+	r.Nil(out)
+
+	// 分片之间允许插入控制帧
+	conn.push(client.frame(opPing, []byte("p")))
+	out, err = server.Decode(conn)
+	r.Nil(err)
+	r.Nil(out)
+	r.Equal(server.frame(opPong, []byte("p")), raw.written.Bytes())
+
+	conn.push(client.frame(opContinuation, []byte("lo")))
+	out, err = server.Decode(conn)
+	r.Nil(err)
+	r.Equal([]byte("hello"), out)
+
+	// 连接支持 WriteEncoded 时 pong 帧经过连接的发送流程
+	ec := &encodedConn{}
+	ec.InitBuffer()
+	ec.PushPacket(client.frame(opPing, []byte("q")))
+	out, err = server.Decode(ec)
+	r.Nil(err)
+	r.Nil(out)
+	r.Equal([][]byte{server.frame(opPong, []byte("q"))}, ec.written)
+	r.Equal(server.frame(opPong, []byte("p")), raw.written.Bytes())
+
+	// 没有开始分片时收到后续帧是协议错误
+	conn.push(client.frame(opContinuation, []byte("x")))
+	_, err = server.Decode(conn)
+	r.Equal(ErrProtocol, err)
+}
+
+func TestCodec_Close(t *testing.T) {
+	r := require.New(t)
+	raw := new(recordConn)
+	server := NewCodec(raw, false)
+	client := NewCodec(new(recordConn), true)
+
+	_, err := server.Decode(newMockConn().push(client.closeFrame(CloseNormal)))
+	r.Equal(io.EOF, err)
+	// close 帧在连接关闭时回复，不直接写入原始连接
+	r.Zero(raw.written.Len())
+	r.Equal(server.closeFrame(CloseNormal), server.Closing())
+	r.Nil(server.Closing())
+
+	other := NewCodec(new(recordConn), false)
+	r.Equal(other.closeFrame(CloseNormal), other.Closing())
+	r.Nil(other.Closing())
+}
+
+func TestCodec_Protocol(t *testing.T) {
+	r := require.New(t)
+	raw := new(recordConn)
+	server := NewCodec(raw, false)
+	// 服务端收到没有掩码的帧
+	frame, _ := NewCodec(nil, false).Encode(nil, []byte("hello"))
+	_, err := server.Decode(newMockConn().push(frame))
+	r.Equal(ErrProtocol, err)
+	r.Zero(raw.written.Len())
+	r.Equal(server.closeFrame(CloseProtocolError), server.Closing())
+
+	old := MaxMessageSize
+	MaxMessageSize = 4
+	defer func() { MaxMessageSize = old }()
+	frame, _ = NewCodec(nil, true).Encode(nil, []byte("hello"))
+	_, err = NewCodec(new(recordConn), false).Decode(newMockConn().push(frame))
+	r.Equal(ErrProtocol, err)
+}
+
+func TestHandshake(t *testing.T) {
+	r := require.New(t)
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	var (
+		serverCodec core.Codec
+		serverErr   error
+		wg          sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, serverCodec, serverErr = ServerHandshake("/ws")(serverConn, nil)
+	}()
+	_, clientCodec, err := ClientHandshake("example.com", "/ws")(clientConn, nil)
+	wg.Wait()
+	r.Nil(err)
+	r.Nil(serverErr)
+	r.IsType(&Codec{}, serverCodec)
+	r.True(clientCodec.(*Codec).isClient)
+	r.False(serverCodec.(*Codec).isClient)
+
+	serverConn, clientConn = net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _, serverErr = ServerHandshake("/ws")(serverConn, nil)
+	}()
+	_, _, err = ClientHandshake("example.com", "/other")(clientConn, nil)
+	wg.Wait()
+	r.Equal(ErrBadHandshake, serverErr)
+	r.Equal(ErrBadHandshake, err)
+}
+
+func TestContext(t *testing.T) {
+	r := require.New(t)
+	server := new(Server)
+	r.Equal(ErrInvalidContext, server.Start("127.0.0.1:0", nil, core.WithServerContext(map[string]interface{}{"path": 1})))
+	client := new(Client)
+	r.Equal(ErrInvalidContext, client.Start("127.0.0.1:0", nil, core.WithClientContext(map[string]interface{}{"host": []byte("example.com")})))
+	r.Equal(ErrInvalidContext, client.Start("127.0.0.1:0", nil, core.WithClientContext(map[string]interface{}{"path": true})))
+}
@@ -0,0 +1,147 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/finishy1995/go-library/network/core"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPath 默认的 WebSocket 路径
+	DefaultPath = "/"
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+	// ErrBadHandshake 握手失败
+	ErrBadHandshake = errors.New("websocket bad handshake")
+)
+
+// bufferedConn 握手时 bufio 可能多读取了后续的帧数据，读取时先消费这些数据
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	if conn.reader.Buffered() > 0 {
+		return conn.reader.Read(b)
+	}
+	return conn.Conn.Read(b)
+}
+
+// NetConn 被包装的原始连接
+func (conn *bufferedConn) NetConn() net.Conn {
+	return conn.Conn
+}
+
+func wrapConn(conn net.Conn, reader *bufio.Reader) net.Conn {
+	if reader.Buffered() == 0 {
+		return conn
+	}
+	return &bufferedConn{Conn: conn, reader: reader}
+}
+
+func computeAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(header http.Header, name string, value string) bool {
+	for _, v := range header.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ServerHandshake 服务端 RFC 6455 握手，path 不为空时只接受这个路径的请求
+func ServerHandshake(path string) core.Handshake {
+	return func(conn net.Conn, _ core.Codec) (net.Conn, core.Codec, error) {
+		_ = conn.SetDeadline(time.Now().Add(core.DefaultHandshakeTimeout))
+		defer conn.SetDeadline(time.Time{})
+
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return conn, nil, err
+		}
+		key := req.Header.Get("Sec-WebSocket-Key")
+		switch {
+		case req.Method != http.MethodGet,
+			!headerContains(req.Header, "Connection", "upgrade"),
+			!headerContains(req.Header, "Upgrade", "websocket"),
+			req.Header.Get("Sec-WebSocket-Version") != "13",
+			key == "":
+			_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nSec-WebSocket-Version: 13\r\nConnection: close\r\n\r\n"))
+			return conn, nil, ErrBadHandshake
+		case path != "" && req.URL.Path != path:
+			_, _ = conn.Write([]byte("HTTP/1.1 404 Not Found\r\nConnection: close\r\n\r\n"))
+			return conn, nil, ErrBadHandshake
+		}
+
+		response := "HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + computeAccept(key) + "\r\n\r\n"
+		if _, err = conn.Write([]byte(response)); err != nil {
+			return conn, nil, err
+		}
+		conn = wrapConn(conn, reader)
+		return conn, NewCodec(conn, false), nil
+	}
+}
+
+// ClientHandshake 客户端 RFC 6455 握手，host 为请求的 Host 头，path 为请求路径
+func ClientHandshake(host string, path string) core.Handshake {
+	if path == "" {
+		path = DefaultPath
+	}
+	return func(conn net.Conn, _ core.Codec) (net.Conn, core.Codec, error) {
+		_ = conn.SetDeadline(time.Now().Add(core.DefaultHandshakeTimeout))
+		defer conn.SetDeadline(time.Time{})
+
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return conn, nil, err
+		}
+		key := base64.StdEncoding.EncodeToString(nonce)
+		var buf bytes.Buffer
+		_, _ = fmt.Fprintf(&buf, "GET %s HTTP/1.1\r\n", path)
+		_, _ = fmt.Fprintf(&buf, "Host: %s\r\n", host)
+		buf.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n")
+		_, _ = fmt.Fprintf(&buf, "Sec-WebSocket-Key: %s\r\n\r\n", key)
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			return conn, nil, err
+		}
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			return conn, nil, err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusSwitchingProtocols ||
+			!headerContains(resp.Header, "Upgrade", "websocket") ||
+			!headerContains(resp.Header, "Connection", "upgrade") ||
+			resp.Header.Get("Sec-WebSocket-Accept") != computeAccept(key) {
+			return conn, nil, ErrBadHandshake
+		}
+		conn = wrapConn(conn, reader)
+		return conn, NewCodec(conn, true), nil
+	}
+}
@@ -0,0 +1,32 @@
+package websocket
+
+import (
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/finishy1995/go-library/network/src/tcpnet"
+)
+
+// Server WebSocket 服务器，基于 tcpnet 实现，握手完成后每个连接使用独立的 WebSocket Codec
+//
+//	Context 支持 "path" 限制请求路径，默认接受所有路径
+type Server struct {
+	tcpnet.Server
+}
+
+// Start 开始 WebSocket 监听
+func (server *Server) Start(address string, newAgent core.GetAgent, opts ...core.ServerOption) error {
+	options := core.DefaultServerOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	path := ""
+	if options.Context != nil {
+		if p, ok := options.Context["path"]; ok {
+			if path, ok = p.(string); !ok {
+				return ErrInvalidContext
+			}
+		}
+	}
+	// WebSocket 握手放在最后，之前的握手（例如 TLS）完成后再升级协议
+	opts = append(opts, core.WithServerHandshake(ServerHandshake(path)))
+	return server.Server.Start(address, newAgent, opts...)
+}
@@ -0,0 +1,150 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/finishy1995/go-library/network/core"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NetworkStats 所有服务器和客户端的统计
+type NetworkStats struct {
+	// Servers 按照创建顺序排列的服务器统计，没有实现 core.StatsServer 的服务器只有连接数
+	Servers []core.ServerStats
+	// Clients 客户端数量
+	Clients int
+	// ConnectedClients 已经连接上的客户端数量
+	ConnectedClients int
+}
+
+// Stats 获取所有服务器和客户端的统计
+func Stats() NetworkStats {
+	mutex.Lock()
+	defer mutex.Unlock()
+	stats := NetworkStats{
+		Servers: make([]core.ServerStats, 0, len(serverList)),
+		Clients: len(clientList),
+	}
+	for _, server := range serverList {
+		if s, ok := server.(core.StatsServer); ok {
+			stats.Servers = append(stats.Servers, s.Stats())
+		} else {
+			stats.Servers = append(stats.Servers, core.ServerStats{Conns: server.GetConnNum()})
+		}
+	}
+	for _, client := range clientList {
+		if client.IsConnected() {
+			stats.ConnectedClients++
+		}
+	}
+	return stats
+}
+
+// MetricsHandler Prometheus 文本格式的监控接口，每个服务器使用 server（创建顺序）和 address 标签区分
+//
+//	为了控制标签数量，不输出单个连接的统计，只输出写队列总长度和最长的连接持续时间
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writeMetrics(bw, Stats())
+		_ = bw.Flush()
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// serverMetric 服务器的一项指标
+type serverMetric struct {
+	name  string
+	typ   string
+	help  string
+	value func(stats *core.ServerStats) float64
+}
+
+var serverMetrics = []serverMetric{
+	{"network_server_connections", "gauge", "Current connections.", func(s *core.ServerStats) float64 {
+		return float64(s.Conns)
+	}},
+	{"network_server_accepted_total", "counter", "Accepted connections.", func(s *core.ServerStats) float64 {
+		return float64(s.Accepted)
+	}},
+	{"network_server_bytes_in_total", "counter", "Bytes received.", func(s *core.ServerStats) float64 {
+		return float64(s.BytesIn)
+	}},
+	{"network_server_bytes_out_total", "counter", "Bytes written to sockets.", func(s *core.ServerStats) float64 {
+		return float64(s.BytesOut)
+	}},
+	{"network_server_messages_in_total", "counter", "Messages decoded.", func(s *core.ServerStats) float64 {
+		return float64(s.MessagesIn)
+	}},
+	{"network_server_messages_out_total", "counter", "Messages sent.", func(s *core.ServerStats) float64 {
+		return float64(s.MessagesOut)
+	}},
+	{"network_server_decode_errors_total", "counter", "Decode errors.", func(s *core.ServerStats) float64 {
+		return float64(s.DecodeErrors)
+	}},
+	{"network_server_write_queue_bytes", "gauge", "Bytes waiting in write queues.", func(s *core.ServerStats) float64 {
+		n := 0
+		for i := range s.Connections {
+			n += s.Connections[i].WriteQueueBytes
+		}
+		return float64(n)
+	}},
+	{"network_server_connection_duration_max_seconds", "gauge", "Duration of the oldest connection.", func(s *core.ServerStats) float64 {
+		var max float64
+		for i := range s.Connections {
+			if d := s.Connections[i].Duration.Seconds(); d > max {
+				max = d
+			}
+		}
+		return max
+	}},
+}
+
+// writeMetrics 按照 Prometheus 文本格式输出统计
+func writeMetrics(w *bufio.Writer, stats NetworkStats) {
+	labels := make([]string, len(stats.Servers))
+	for i := range stats.Servers {
+		labels[i] = fmt.Sprintf(`server="%d",address="%s"`, i, labelEscaper.Replace(stats.Servers[i].Address))
+	}
+	for _, m := range serverMetrics {
+		writeHeader(w, m.name, m.typ, m.help)
+		for i := range stats.Servers {
+			writeSample(w, m.name, labels[i], m.value(&stats.Servers[i]))
+		}
+	}
+
+	writeHeader(w, "network_server_rejections_total", "counter", "Rejected connections by reason.")
+	for i := range stats.Servers {
+		reasons := make([]core.RejectReason, 0, len(stats.Servers[i].Rejections))
+		for reason := range stats.Servers[i].Rejections {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(a, b int) bool { return reasons[a] < reasons[b] })
+		for _, reason := range reasons {
+			writeSample(w, "network_server_rejections_total", labels[i]+`,reason="`+reason.String()+`"`,
+				float64(stats.Servers[i].Rejections[reason]))
+		}
+	}
+
+	writeHeader(w, "network_clients", "gauge", "Clients.")
+	writeSample(w, "network_clients", "", float64(stats.Clients))
+	writeHeader(w, "network_client_connections", "gauge", "Connected clients.")
+	writeSample(w, "network_client_connections", "", float64(stats.ConnectedClients))
+}
+
+func writeHeader(w *bufio.Writer, name string, typ string, help string) {
+	_, _ = w.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + typ + "\n")
+}
+
+func writeSample(w *bufio.Writer, name string, labels string, value float64) {
+	_, _ = w.WriteString(name)
+	if labels != "" {
+		_, _ = w.WriteString("{" + labels + "}")
+	}
+	_, _ = w.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试服务器和连接的统计以及 Prometheus 接口
+func TestNetworkStats(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test network type: %d", typ)
+		s, err := Listen(typ, "127.0.0.1:"+TestPort1, agent.GetEchoAgent)
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+		_, err = Connect(TcpNet, "127.0.0.1:"+TestPort1, newTestSendAgent)
+		r.Nil(err)
+		time.Sleep(WaitMsgSendTime)
+
+		stats := s.(core.StatsServer).Stats()
+		r.Equal("127.0.0.1:"+TestPort1, stats.Address)
+		r.Equal(1, stats.Conns)
+		r.Equal(uint64(1), stats.Accepted)
+		r.Equal(uint64(SendMsgNum), stats.MessagesIn)
+		r.Equal(uint64(SendMsgNum), stats.MessagesOut)
+		r.Equal(stats.BytesIn, stats.BytesOut)
+		r.Greater(stats.BytesIn, uint64(0))
+		r.Len(stats.Connections, 1)
+		r.Equal(stats.Traffic, stats.Connections[0].Traffic)
+		r.NotEmpty(stats.Connections[0].RemoteAddr)
+		r.Greater(stats.Connections[0].Duration, time.Duration(0))
+
+		// 解码失败的连接被断开
+		conn, err := net.Dial("tcp", "127.0.0.1:"+TestPort1)
+		r.Nil(err)
+		_, err = conn.Write([]byte{0xff, 0xff, 0xff, 0xff})
+		r.Nil(err)
+		time.Sleep(WaitConnectTime * 5)
+		// 服务端先断开，客户端使用 RST 关闭，服务端不进入 TIME_WAIT
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+		stats = s.(core.StatsServer).Stats()
+		r.Equal(uint64(2), stats.Accepted)
+		r.Equal(uint64(1), stats.DecodeErrors)
+		r.Equal(1, stats.Conns)
+
+		all := Stats()
+		r.Len(all.Servers, 1)
+		r.Equal(1, all.Clients)
+		r.Equal(1, all.ConnectedClients)
+		r.Equal(2, GetConnNum())
+
+		recorder := httptest.NewRecorder()
+		MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		body := recorder.Body.String()
+		r.True(strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+		labels := `{server="0",address="127.0.0.1:` + TestPort1 + `"}`
+		r.Contains(body, "# TYPE network_server_connections gauge\n")
+		r.Contains(body, "network_server_connections"+labels+" 1\n")
+		r.Contains(body, "network_server_accepted_total"+labels+" 2\n")
+		r.Contains(body, "network_server_messages_in_total"+labels+" 10\n")
+		r.Contains(body, "network_server_decode_errors_total"+labels+" 1\n")
+		r.Contains(body, "network_client_connections 1\n")
+		r.NotContains(body, "network_server_rejections_total{")
+		destroyAfterTest()
+	}
+}
@@ -0,0 +1,105 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCert 生成自签名证书，同时作为 CA 使用
+func newTestCert(r *require.Assertions, name string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.Nil(err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	r.Nil(err)
+	leaf, err := x509.ParseCertificate(der)
+	r.Nil(err)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// tlsEchoAgent 记录客户端证书的 Echo 代理
+type tlsEchoAgent struct {
+	agent.EchoAgent
+	mutex sync.Mutex
+	names []string
+}
+
+func (a *tlsEchoAgent) OnConnect(conn core.Conn) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if cert := core.PeerCertificate(conn); cert != nil {
+		a.names = append(a.names, cert.Subject.CommonName)
+	}
+}
+
+// 测试 TLS 双向认证
+func TestNetworkTLS(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	serverCert, serverPool := newTestCert(r, "server")
+	clientCert, clientPool := newTestCert(r, "client")
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	}
+	clientConfig := &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      serverPool,
+	}
+
+	for _, typ := range []NetType{TcpNet, TcpGNet, WebSocket} {
+		t.Logf("test network type: %d", typ)
+		count = 0
+		serverAgent := new(tlsEchoAgent)
+		_, err := Listen(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return serverAgent },
+			core.WithMaxConnNum(TestThread), core.WithTLSConfig(serverConfig))
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+
+		clientType := typ
+		if !GetInfo()[typ].SupportClient() {
+			clientType = TcpNet
+		}
+		_, err = Connect(clientType, "127.0.0.1:"+TestPort1, newTestSendAgent,
+			core.WithReconnect(true), core.WithClientTLSConfig(clientConfig))
+		r.Nil(err)
+		// 没有客户端证书的连接会握手失败
+		_, err = Connect(clientType, "127.0.0.1:"+TestPort1, newTestSendAgent,
+			core.WithReconnect(true), core.WithClientTLSConfig(&tls.Config{RootCAs: serverPool}))
+		r.Nil(err)
+
+		time.Sleep(WaitMsgSendTime)
+		countMutex.Lock()
+		r.Equal(SendMsgNum, count)
+		countMutex.Unlock()
+		serverAgent.mutex.Lock()
+		r.Equal([]string{"client"}, serverAgent.names)
+		serverAgent.mutex.Unlock()
+		destroyAfterTest()
+	}
+}
@@ -0,0 +1,172 @@
+package network
+
+import (
+	"github.com/finishy1995/go-library/network/agent"
+	"github.com/finishy1995/go-library/network/core"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// floodAgent 连接建立后持续写入，直到写入失败
+type floodAgent struct {
+	agent.SingleAgent
+	closes int32
+	done   chan error
+}
+
+func (a *floodAgent) OnConnect(conn core.Conn) {
+	go func() {
+		msg := make([]byte, 64<<10)
+		for i := 0; i < 1024; i++ {
+			if _, err := conn.Write(msg); err != nil {
+				a.done <- err
+				return
+			}
+		}
+		a.done <- nil
+	}()
+}
+
+func (a *floodAgent) OnClose(_ core.Conn) {
+	atomic.AddInt32(&a.closes, 1)
+}
+
+// orderAgent 按顺序记录收到的消息
+type orderAgent struct {
+	agent.SingleAgent
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (a *orderAgent) OnMessage(b []byte, _ core.Conn) {
+	a.mutex.Lock()
+	a.messages = append(a.messages, string(b))
+	a.mutex.Unlock()
+}
+
+// 测试对端不读取数据时的溢出策略和写入超时
+func TestNetworkWriteQueueOverflow(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	cases := []struct {
+		opts   []core.ServerOption
+		err    error
+		closes int32
+	}{
+		{[]core.ServerOption{core.WithWriteQueue(1<<20, 1<<18, core.OverflowDrop)}, core.ErrWriteQueueFull, 0},
+		{[]core.ServerOption{core.WithWriteQueue(1<<20, 1<<18, core.OverflowDisconnect)}, core.ErrWriteQueueFull, 1},
+		{[]core.ServerOption{core.WithWriteDeadline(time.Millisecond * 100)}, nil, 1},
+	}
+	for i, c := range cases {
+		t.Logf("test case: %d", i)
+		serverAgent := &floodAgent{done: make(chan error, 1)}
+		_, err := Listen(TcpNet, "127.0.0.1:"+TestPort1, func() core.Agent { return serverAgent }, c.opts...)
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+
+		// 不读取任何数据的客户端
+		peer, err := net.Dial("tcp", "127.0.0.1:"+TestPort1)
+		r.Nil(err)
+		select {
+		case err = <-serverAgent.done:
+		case <-time.After(time.Second * 5):
+			r.Fail("write blocked")
+		}
+		r.NotNil(err)
+		if c.err != nil {
+			r.Equal(c.err, err)
+		}
+		time.Sleep(WaitConnectTime)
+		r.Equal(c.closes, atomic.LoadInt32(&serverAgent.closes))
+		_ = peer.Close()
+		destroyAfterTest()
+	}
+}
+
+// 测试 WriteAsync 保持顺序，Flush 等待全部写入
+func TestNetworkWriteAsync(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test network type: %d", typ)
+		serverAgent := new(orderAgent)
+		_, err := Listen(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return serverAgent })
+		r.Nil(err)
+		time.Sleep(ListenAllowWaitTime)
+
+		flushed := make(chan error, 1)
+		clientAgent := new(agent.SingleAgent)
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent {
+			return &connectFuncAgent{Agent: clientAgent, onConnect: func(conn core.Conn) {
+				go func() {
+					ac := conn.(core.AsyncConn)
+					for i := 0; i < 100; i++ {
+						_ = ac.WriteAsync([]byte(strconv.Itoa(i)))
+					}
+					flushed <- ac.Flush()
+				}()
+			}}
+		}, core.WithClientWriteQueue(1<<10, 1<<8, core.OverflowBlock))
+		r.Nil(err)
+
+		r.Nil(<-flushed)
+		time.Sleep(WaitConnectTime * 5)
+		serverAgent.mutex.Lock()
+		r.Len(serverAgent.messages, 100)
+		for i, msg := range serverAgent.messages {
+			r.Equal(strconv.Itoa(i), msg)
+		}
+		serverAgent.mutex.Unlock()
+		destroyAfterTest()
+	}
+}
+
+// connectFuncAgent 连接建立时调用 onConnect
+type connectFuncAgent struct {
+	core.Agent
+	onConnect func(conn core.Conn)
+}
+
+func (a *connectFuncAgent) OnConnect(conn core.Conn) {
+	a.onConnect(conn)
+}
+
+// 测试客户端的写队列，对端不读取数据时按照溢出策略返回
+func TestNetworkClientWriteQueueOverflow(t *testing.T) {
+	defer destroyAfterTest()
+	r := require.New(t)
+	for _, typ := range []NetType{TcpNet, TcpGNet} {
+		t.Logf("test network type: %d", typ)
+		// 不读取任何数据的服务端
+		ln, err := net.Listen("tcp", "127.0.0.1:"+TestPort1)
+		r.Nil(err)
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, _ := ln.Accept()
+			accepted <- conn
+		}()
+
+		clientAgent := &floodAgent{done: make(chan error, 1)}
+		_, err = Connect(typ, "127.0.0.1:"+TestPort1, func() core.Agent { return clientAgent },
+			core.WithClientWriteQueue(1<<20, 1<<18, core.OverflowDrop))
+		r.Nil(err)
+		select {
+		case err = <-clientAgent.done:
+		case <-time.After(time.Second * 5):
+			r.Fail("write blocked")
+		}
+		r.Equal(core.ErrWriteQueueFull, err)
+
+		peer := <-accepted
+		r.NotNil(peer)
+		_ = peer.(*net.TCPConn).SetLinger(0)
+		_ = peer.Close()
+		_ = ln.Close()
+		destroyAfterTest()
+	}
+}
@@ -0,0 +1,35 @@
+package core
+
+// AggregateType 聚合计算类型
+type AggregateType uint8
+
+const (
+	// AggregateSum 求和
+	AggregateSum AggregateType = iota
+	// AggregateMin 最小值
+	AggregateMin
+	// AggregateMax 最大值
+	AggregateMax
+	// AggregateAvg 平均值
+	AggregateAvg
+)
+
+// Aggregation 聚合查询参数
+type Aggregation struct {
+	// Type 聚合计算类型
+	Type AggregateType
+	// Field 参与计算的字段名，需要是数值类型，与 Find 表达式中的字段名规则一致
+	Field string
+	// GroupBy 分组字段名，为空时不分组，对整张表（满足筛选条件的对象）计算
+	GroupBy string
+}
+
+// AggregateResult 聚合查询结果
+type AggregateResult struct {
+	// Group 分组字段的值，不分组时为 nil，数值类型统一为 float64
+	Group interface{}
+	// Value 聚合计算结果
+	Value float64
+	// Count 分组内参与计算的对象数量
+	Count int64
+}
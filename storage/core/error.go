@@ -18,6 +18,15 @@ var (
 	// ErrNotFound 未查询到指定对象
 	ErrNotFound = errors.New("no item found")
 
+	// ErrMissingTableName 表名为空
+	ErrMissingTableName = errors.New("missing table name")
+
+	// ErrInvalidAggregation 不合法的聚合查询参数，或者参与计算的字段不是数值类型
+	ErrInvalidAggregation = errors.New("invalid aggregation")
+
+	// ErrClosed 存储已关闭
+	ErrClosed = errors.New("storage closed")
+
 	// ErrExpiredValue 当前对象非最新
 	ErrExpiredValue = errors.New("item has updated, or you cannot change hash or range key")
 )
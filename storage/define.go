@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"context"
+	"github.com/finishy1995/go-library/log"
 	"github.com/finishy1995/go-library/storage/src/dynamodb"
 	"github.com/finishy1995/go-library/storage/src/memory"
 	"github.com/finishy1995/go-library/storage/src/mongodb"
@@ -34,6 +36,21 @@ type Storage interface {
 	// expr 为表达式（空代表不使用表达式）
 	// 其他为补充表达式的具体值
 	Find(value interface{}, tableName string, limit int64, expr string, args ...interface{}) error
+
+	// Count 获取所有符合要求的对象数量
+	// expr 为表达式（空代表不使用表达式），其他为补充表达式的具体值
+	Count(tableName string, expr string, args ...interface{}) (int64, error)
+
+	// Aggregate 对所有符合要求的对象做聚合计算（sum/min/max/avg），可按字段分组
+	// 不分组时固定返回一个结果，分组时每组一个结果，按分组值升序排列
+	// expr 为表达式（空代表不使用表达式），其他为补充表达式的具体值
+	Aggregate(tableName string, agg *Aggregation, expr string, args ...interface{}) ([]AggregateResult, error)
+
+	// Ping 检查存储是否可用，例如数据库是否可以连接
+	Ping(ctx context.Context) error
+
+	// Close 关闭存储，释放连接和后台协程，关闭后请勿继续使用
+	Close() error
 }
 
 var (
@@ -51,16 +68,64 @@ func GetStorageTypeStr(typ Type) string {
 	return typeStrMap[InMemory]
 }
 
-func NewStorage(config *Config) Storage {
-	switch config.StorageType {
-	case typeStrMap[DynamoDB]:
-		if config.Database != "" {
-			return dynamodb.NewStorage(config.Region, config.Endpoint, config.Database, config.User, config.Password)
-		}
-		return dynamodb.NewStorage(config.Region, config.Endpoint, "", config.User, config.Password)
-	case typeStrMap[MongoDB]:
-		return mongodb.NewStorage(config.Endpoint, config.User, config.Password, config.Database)
-	default:
-		return memory.NewStorage(config.MaxLength, config.Tick)
+// NewBackendFunc 创建存储后端实例的函数
+type NewBackendFunc func(config *Config) (Storage, error)
+
+var (
+	backendMap = map[string]NewBackendFunc{
+		InMemoryStr: newMemoryBackend,
+		DynamoDBStr: newDynamoDBBackend,
+		MongoDBStr:  newMongoDBBackend,
+		"":          newMemoryBackend,
+	}
+)
+
+// RegisterBackend 注册一个新的存储后端类型，Config.StorageType 为 name 时使用 factory 创建存储
+//
+//	通过这个函数，可以轻松扩展这个库
+func RegisterBackend(name string, factory NewBackendFunc) {
+	if _, ok := backendMap[name]; ok {
+		log.Warning("replace exist storage backend %s", name)
+	}
+	backendMap[name] = factory
+}
+
+// NewStorage 通过配置创建存储，不支持的存储类型或创建失败会返回 error
+func NewStorage(config *Config) (Storage, error) {
+	if config == nil {
+		return nil, ErrInvalidConfig
+	}
+	factory, ok := backendMap[config.StorageType]
+	if !ok {
+		return nil, ErrUnsupportedStorageType
+	}
+	return factory(config)
+}
+
+func newMemoryBackend(config *Config) (Storage, error) {
+	return memory.NewStorage(config.MaxLength, config.Tick), nil
+}
+
+func newDynamoDBBackend(config *Config) (Storage, error) {
+	st, err := dynamodb.NewStorage(config.Region, config.Endpoint, config.Database, config.User, config.Password,
+		dynamodb.WithMaxRetries(config.MaxRetries),
+		dynamodb.WithHTTPTimeout(config.HTTPTimeout),
+		dynamodb.WithMaxIdleConns(config.MaxIdleConns),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func newMongoDBBackend(config *Config) (Storage, error) {
+	st, err := mongodb.NewStorage(config.Endpoint, config.User, config.Password, config.Database,
+		mongodb.WithMaxPoolSize(config.MaxPoolSize),
+		mongodb.WithMinPoolSize(config.MinPoolSize),
+		mongodb.WithConnectTimeout(config.ConnectTimeout),
+	)
+	if err != nil {
+		return nil, err
 	}
+	return st, nil
 }
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewStorage(t *testing.T) {
+	r := require.New(t)
+	st, err := NewStorage(nil)
+	r.Equal(ErrInvalidConfig, err)
+	r.Nil(st)
+
+	st, err = NewStorage(&Config{StorageType: InMemoryStr})
+	r.Nil(err)
+	r.NotNil(st)
+	st, err = NewStorage(&Config{})
+	r.Nil(err)
+	r.NotNil(st)
+
+	st, err = NewStorage(&Config{StorageType: "unknown"})
+	r.Equal(ErrUnsupportedStorageType, err)
+	r.Nil(st)
+}
+
+func TestRegisterBackend(t *testing.T) {
+	r := require.New(t)
+	custom := newMapStorage()
+	RegisterBackend("map", func(config *Config) (Storage, error) {
+		if config.Options["fail"] == true {
+			return nil, errors.New("create failed")
+		}
+		return custom, nil
+	})
+
+	st, err := NewStorage(&Config{StorageType: "map"})
+	r.Nil(err)
+	r.Equal(custom, st)
+
+	st, err = NewStorage(&Config{StorageType: "map", Options: map[string]interface{}{"fail": true}})
+	r.NotNil(err)
+	r.Nil(st)
+}
@@ -0,0 +1,14 @@
+package storage
+
+import "errors"
+
+var (
+	// ErrInvalidConfig 不合法的存储配置
+	ErrInvalidConfig = errors.New("invalid storage config")
+	// ErrUnsupportedStorageType 不支持的存储类型，请先调用 RegisterBackend 注册
+	ErrUnsupportedStorageType = errors.New("unsupported storage type")
+	// ErrNoShard 分片存储至少需要一个分片
+	ErrNoShard = errors.New("sharded storage needs at least one shard")
+	// ErrRebalancing 上一次扩容的数据迁移还没有完成
+	ErrRebalancing = errors.New("storage rebalance not finished")
+)
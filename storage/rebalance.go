@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/finishy1995/go-library/log"
+	"github.com/finishy1995/go-library/routine"
+	"github.com/finishy1995/go-library/storage/core"
+	"github.com/finishy1995/go-library/storage/src/tools"
+	"reflect"
+)
+
+// RebalanceTable 需要迁移数据的表
+type RebalanceTable struct {
+	// Value 表对应的 struct slice ptr（注：&[]struct），只用来获取表结构
+	Value interface{}
+	// TableName 表名，为空时使用结构体名
+	TableName string
+}
+
+// Rebalance 把所有对象迁移到当前哈希环上所属的分片，返回迁移的对象数量
+//
+//	所有表迁移成功后才会丢弃扩容前的哈希环；迁移失败可以直接重试，迁移完成前不能再次添加分片
+func (s *ShardedStorage) Rebalance(tables ...RebalanceTable) (moved int, err error) {
+	for _, table := range tables {
+		n, err := s.rebalanceTable(table)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	s.Lock()
+	s.previous = nil
+	s.Unlock()
+	return moved, nil
+}
+
+// RebalanceAsync 在后台迁移数据，完成后调用 callback（可以为 nil）
+func (s *ShardedStorage) RebalanceAsync(callback func(moved int, err error), tables ...RebalanceTable) error {
+	return routine.Run(true, func() {
+		moved, err := s.Rebalance(tables...)
+		if err != nil {
+			log.Error("storage rebalance failed after %d items moved, error: %s", moved, err.Error())
+		} else {
+			log.Info("storage rebalance finished, %d items moved", moved)
+		}
+		if callback != nil {
+			callback(moved, err)
+		}
+	})
+}
+
+func (s *ShardedStorage) rebalanceTable(table RebalanceTable) (moved int, err error) {
+	tp := reflect.TypeOf(table.Value)
+	if tp == nil || tp.Kind() != reflect.Ptr || tp.Elem().Kind() != reflect.Slice {
+		return 0, core.ErrUnsupportedValueType
+	}
+	_, rangeKey := tools.GetHashAndRangeKey(reflect.New(tp.Elem().Elem()).Interface(), false)
+
+	for index, shard := range s.allShards() {
+		items := reflect.New(tp.Elem())
+		err = shard.Find(items.Interface(), table.TableName, 0, "")
+		if err != nil {
+			return
+		}
+
+		for i := 0; i < items.Elem().Len(); i++ {
+			item := items.Elem().Index(i).Interface()
+			hashValue, _ := tools.GetHashAndRangeValue(item)
+			if hashValue == nil {
+				return moved, core.ErrUnsupportedValueType
+			}
+			s.RLock()
+			owner := s.ring.get(fmt.Sprintf("%v", hashValue))
+			target := s.shards[owner]
+			s.RUnlock()
+			if owner == index {
+				continue
+			}
+
+			var ok bool
+			ok, err = s.moveItem(shard, target, item, table.TableName, hashValue, rangeArgs(item, rangeKey))
+			if err != nil {
+				return
+			}
+			if ok {
+				moved++
+			}
+		}
+	}
+	return moved, nil
+}
+
+// moveItem 把一个对象从 source 迁移到 target，迁移期间独占 migrate，其他读写和查询等待迁移完成
+//
+//	Find 得到的快照可能已经过期，迁移前重新读取；对象已经被删除或者迁移时返回 false
+func (s *ShardedStorage) moveItem(source, target Storage, item interface{}, tableName string, hashValue interface{}, args []interface{}) (bool, error) {
+	s.migrate.Lock()
+	defer s.migrate.Unlock()
+	latest := newStructPtr(item)
+	err := source.First(latest, tableName, hashValue, args...)
+	if err == core.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// 目标分片上已经存在的对象是上一次迁移中断前写入的，之后的 Save 都写入了目标分片，比旧分片上的更新
+	err = target.Create(reflect.ValueOf(latest).Elem().Interface(), tableName)
+	if err != nil && err != core.ErrDuplicateKey {
+		return false, err
+	}
+	if err = source.Delete(item, tableName, hashValue, args...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// Hasher 分片哈希函数，用于把主键映射到一致性哈希环上
+type Hasher func(key []byte) uint32
+
+const (
+	// DefaultVirtualNodes 每个分片在哈希环上的虚拟节点数量
+	DefaultVirtualNodes = 160
+)
+
+// DefaultHasher 默认分片哈希函数（CRC32）
+func DefaultHasher(key []byte) uint32 {
+	return crc32.ChecksumIEEE(key)
+}
+
+// hashRing 一致性哈希环
+type hashRing struct {
+	hasher Hasher
+	nodes  []uint32
+	owners map[uint32]int
+}
+
+func newHashRing(hasher Hasher, shardNum int, replicas int) *hashRing {
+	r := &hashRing{
+		hasher: hasher,
+		nodes:  make([]uint32, 0, shardNum*replicas),
+		owners: make(map[uint32]int, shardNum*replicas),
+	}
+	for i := 0; i < shardNum; i++ {
+		for j := 0; j < replicas; j++ {
+			node := hasher([]byte(strconv.Itoa(i) + "#" + strconv.Itoa(j)))
+			// 虚拟节点冲突时保留编号较小的分片，保证扩容时已有分片的归属不变
+			if _, ok := r.owners[node]; ok {
+				continue
+			}
+			r.owners[node] = i
+			r.nodes = append(r.nodes, node)
+		}
+	}
+	sort.Slice(r.nodes, func(i, j int) bool {
+		return r.nodes[i] < r.nodes[j]
+	})
+	return r
+}
+
+// get 获取 key 所属的分片下标
+func (r *hashRing) get(key string) int {
+	h := r.hasher([]byte(key))
+	i := sort.Search(len(r.nodes), func(i int) bool {
+		return r.nodes[i] >= h
+	})
+	if i == len(r.nodes) {
+		i = 0
+	}
+	return r.owners[r.nodes[i]]
+}
@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/finishy1995/go-library/routine"
+	"github.com/finishy1995/go-library/storage/core"
+	"github.com/finishy1995/go-library/storage/src/tools"
+	"reflect"
+	"sync"
+)
+
+// ShardedStorage 分片存储，按照主键的一致性哈希把对象路由到多个后端存储
+//
+//	Create/First/Save/Delete 只访问主键所属的分片，Find 会并行查询所有分片后合并结果
+type ShardedStorage struct {
+	sync.RWMutex
+	shards []Storage
+	hasher Hasher
+	ring   *hashRing
+	// previous 扩容前的哈希环，迁移完成前用于查找尚未迁移的对象
+	previous *hashRing
+	// tables 已经创建的表，添加分片时在新分片上创建
+	tables []tableSpec
+	// migrate 迁移单个对象时独占，其他读写和查询共享，对象不会在迁移中途被修改，也不会同时出现在两个分片上被查询到
+	migrate sync.RWMutex
+}
+
+// tableSpec CreateTable 的参数
+type tableSpec struct {
+	value     interface{}
+	tableName string
+}
+
+// NewShardedStorage 创建分片存储，hasher 为 nil 时使用 DefaultHasher，shards 为空时返回 ErrNoShard
+func NewShardedStorage(shards []Storage, hasher Hasher) (*ShardedStorage, error) {
+	if len(shards) == 0 {
+		return nil, ErrNoShard
+	}
+	if hasher == nil {
+		hasher = DefaultHasher
+	}
+	s := &ShardedStorage{
+		shards: make([]Storage, len(shards)),
+		hasher: hasher,
+	}
+	copy(s.shards, shards)
+	s.ring = newHashRing(hasher, len(s.shards), DefaultVirtualNodes)
+	return s, nil
+}
+
+// AddShard 添加一个新的分片，返回新分片的下标，已经通过 CreateTable 创建的表会先在新分片上创建
+//
+//	添加后部分对象的归属会发生变化，请调用 Rebalance 或 RebalanceAsync 迁移数据，迁移完成前读写会兼容旧的归属；
+//	迁移完成前只保留一个旧的哈希环，再次添加分片返回 ErrRebalancing
+func (s *ShardedStorage) AddShard(shard Storage) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.previous != nil {
+		return 0, ErrRebalancing
+	}
+	for _, table := range s.tables {
+		if err := shard.CreateTable(table.value, table.tableName); err != nil {
+			return 0, err
+		}
+	}
+	s.previous = s.ring
+	s.shards = append(s.shards, shard)
+	s.ring = newHashRing(s.hasher, len(s.shards), DefaultVirtualNodes)
+	return len(s.shards) - 1, nil
+}
+
+// ShardNum 获取分片数量
+func (s *ShardedStorage) ShardNum() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.shards)
+}
+
+// locate 获取主键当前所属的分片，以及迁移未完成时的旧分片（没有则为 nil）
+func (s *ShardedStorage) locate(hash interface{}) (current Storage, previous Storage) {
+	key := fmt.Sprintf("%v", hash)
+	s.RLock()
+	defer s.RUnlock()
+	index := s.ring.get(key)
+	current = s.shards[index]
+	if s.previous != nil {
+		if prevIndex := s.previous.get(key); prevIndex != index {
+			previous = s.shards[prevIndex]
+		}
+	}
+	return
+}
+
+func (s *ShardedStorage) allShards() []Storage {
+	s.RLock()
+	defer s.RUnlock()
+	shards := make([]Storage, len(s.shards))
+	copy(shards, s.shards)
+	return shards
+}
+
+// CreateTable 在所有分片上创建存储对象表，之后添加的分片也会创建这个表
+func (s *ShardedStorage) CreateTable(value interface{}, tableName string) error {
+	for _, shard := range s.allShards() {
+		if err := shard.CreateTable(value, tableName); err != nil {
+			return err
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, table := range s.tables {
+		if table.tableName == tableName && reflect.TypeOf(table.value) == reflect.TypeOf(value) {
+			return nil
+		}
+	}
+	s.tables = append(s.tables, tableSpec{value: value, tableName: tableName})
+	return nil
+}
+
+// Create 在主键所属的分片上创建存储对象
+func (s *ShardedStorage) Create(value interface{}, tableName string) error {
+	hashValue, _ := tools.GetHashAndRangeValue(value)
+	if hashValue == nil {
+		return core.ErrUnsupportedValueType
+	}
+	s.migrate.RLock()
+	defer s.migrate.RUnlock()
+	current, previous := s.locate(hashValue)
+	if previous != nil {
+		// 迁移未完成时，旧分片上可能还存在这个对象
+		hashKey, rangeKey := tools.GetHashAndRangeKey(value, false)
+		if hashKey == "" {
+			return core.ErrUnsupportedValueType
+		}
+		err := previous.First(newStructPtr(value), tableName, hashValue, rangeArgs(value, rangeKey)...)
+		if err == nil {
+			return core.ErrDuplicateKey
+		}
+		if err != core.ErrNotFound {
+			return err
+		}
+	}
+	return current.Create(value, tableName)
+}
+
+// Delete 在主键所属的分片上删除存储对象，迁移未完成时新旧分片都会删除，两个分片上都不存在时才返回 ErrNotFound
+func (s *ShardedStorage) Delete(value interface{}, tableName string, hash interface{}, args ...interface{}) error {
+	s.migrate.RLock()
+	defer s.migrate.RUnlock()
+	current, previous := s.locate(hash)
+	err := current.Delete(value, tableName, hash, args...)
+	if previous == nil {
+		return err
+	}
+	if err != nil && err != core.ErrNotFound {
+		return err
+	}
+	prevErr := previous.Delete(value, tableName, hash, args...)
+	if prevErr == core.ErrNotFound && err == nil {
+		return nil
+	}
+	return prevErr
+}
+
+// Save 在对象所在的分片上保存存储对象
+func (s *ShardedStorage) Save(value interface{}, tableName string) error {
+	hashValue, _ := tools.GetHashAndRangeValue(value)
+	if hashValue == nil {
+		return core.ErrUnsupportedValueType
+	}
+	s.migrate.RLock()
+	defer s.migrate.RUnlock()
+	current, previous := s.locate(hashValue)
+	if previous != nil {
+		hashKey, rangeKey := tools.GetHashAndRangeKey(value, false)
+		if hashKey == "" {
+			return core.ErrUnsupportedValueType
+		}
+		err := current.First(newStructPtr(value), tableName, hashValue, rangeArgs(value, rangeKey)...)
+		if err == core.ErrNotFound {
+			return previous.Save(value, tableName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return current.Save(value, tableName)
+}
+
+// First 在主键所属的分片上获取存储对象
+func (s *ShardedStorage) First(value interface{}, tableName string, hash interface{}, args ...interface{}) error {
+	s.migrate.RLock()
+	defer s.migrate.RUnlock()
+	current, previous := s.locate(hash)
+	err := current.First(value, tableName, hash, args...)
+	if err == core.ErrNotFound && previous != nil {
+		return previous.First(value, tableName, hash, args...)
+	}
+	return err
+}
+
+// Find 并行查询所有分片，合并后的结果数量不超过 limit
+func (s *ShardedStorage) Find(value interface{}, tableName string, limit int64, expr string, args ...interface{}) error {
+	target := reflect.ValueOf(value)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Slice {
+		return core.ErrUnsupportedValueType
+	}
+	sliceType := target.Elem().Type()
+
+	s.migrate.RLock()
+	defer s.migrate.RUnlock()
+	shards := s.allShards()
+	results := make([]reflect.Value, len(shards))
+	err := parallel(shards, func(i int, shard Storage) error {
+		results[i] = reflect.New(sliceType)
+		return shard.Find(results[i].Interface(), tableName, limit, expr, args...)
+	})
+	if err != nil {
+		return err
+	}
+
+	merged := reflect.MakeSlice(sliceType, 0, 0)
+	for i := range shards {
+		merged = reflect.AppendSlice(merged, results[i].Elem())
+	}
+	if limit > 0 && int64(merged.Len()) > limit {
+		merged = merged.Slice(0, int(limit))
+	}
+	target.Elem().Set(merged)
+	return nil
+}
+
+// Count 所有分片的数量之和
+func (s *ShardedStorage) Count(tableName string, expr string, args ...interface{}) (int64, error) {
+	s.migrate.RLock()
+	defer s.migrate.RUnlock()
+	shards := s.allShards()
+	counts := make([]int64, len(shards))
+	err := parallel(shards, func(i int, shard Storage) error {
+		var err error
+		counts[i], err = shard.Count(tableName, expr, args...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	var total int64 = 0
+	for _, count := range counts {
+		total += count
+	}
+	return total, nil
+}
+
+// Aggregate 合并所有分片的聚合结果，平均值按数量加权计算
+func (s *ShardedStorage) Aggregate(tableName string, agg *Aggregation, expr string, args ...interface{}) ([]AggregateResult, error) {
+	aggregator, err := tools.NewAggregator(agg)
+	if err != nil {
+		return nil, err
+	}
+	s.migrate.RLock()
+	defer s.migrate.RUnlock()
+	shards := s.allShards()
+	results := make([][]AggregateResult, len(shards))
+	err = parallel(shards, func(i int, shard Storage) error {
+		var err error
+		results[i], err = shard.Aggregate(tableName, agg, expr, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		for _, r := range result {
+			aggregator.Merge(r)
+		}
+	}
+	return aggregator.Result(), nil
+}
+
+// Ping 检查所有分片是否可用
+func (s *ShardedStorage) Ping(ctx context.Context) error {
+	return parallel(s.allShards(), func(_ int, shard Storage) error {
+		return shard.Ping(ctx)
+	})
+}
+
+// Close 关闭所有分片，返回第一个关闭失败的错误
+func (s *ShardedStorage) Close() error {
+	var result error
+	for _, shard := range s.allShards() {
+		if err := shard.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// parallel 在所有分片上并发执行 fn，返回第一个分片的错误
+func parallel(shards []Storage, fn func(i int, shard Storage) error) error {
+	errs := make([]error, len(shards))
+	wg := sync.WaitGroup{}
+	for i, shard := range shards {
+		i, shard := i, shard
+		task := func() {
+			defer wg.Done()
+			errs[i] = fn(i, shard)
+		}
+		wg.Add(1)
+		if err := routine.Run(true, task); err != nil {
+			task()
+		}
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newStructPtr 创建一个和 value 类型相同的空 struct ptr
+func newStructPtr(value interface{}) interface{} {
+	tp := reflect.TypeOf(value)
+	if tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	return reflect.New(tp).Interface()
+}
+
+// rangeArgs 获取 First/Delete 需要的排序键参数
+func rangeArgs(value interface{}, rangeKey string) []interface{} {
+	if rangeKey == "" {
+		return nil
+	}
+	_, rangeValue := tools.GetHashAndRangeValue(value)
+	return []interface{}{rangeValue}
+}
@@ -0,0 +1,32 @@
+package storage_test
+
+import (
+	"github.com/finishy1995/go-library/storage"
+	"github.com/finishy1995/go-library/storage/src/memory"
+	"github.com/finishy1995/go-library/storage/storagetest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestShardedConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		st, err := storage.NewShardedStorage([]storage.Storage{memory.NewStorage(0, 0), memory.NewStorage(0, 0)}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	})
+}
+
+// 扩容后迁移完成前，读写同时兼容新旧分片
+func TestShardedConformance_Rebalancing(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		st, err := storage.NewShardedStorage([]storage.Storage{memory.NewStorage(0, 0), memory.NewStorage(0, 0)}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = st.AddShard(memory.NewStorage(0, 0))
+		require.Nil(t, err)
+		return st
+	})
+}
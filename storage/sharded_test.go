@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/finishy1995/go-library/storage/core"
+	"github.com/finishy1995/go-library/storage/src/tools"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testPlayer struct {
+	core.Model
+	Id    string `dynamo:",hash"`
+	Level int
+}
+
+// mapStorage 测试用的简单存储，只按主键存取
+type mapStorage struct {
+	sync.Mutex
+	items  map[string]interface{}
+	tables int
+	// createDelay 模拟较慢的写入，扩大迁移的时间窗口
+	createDelay time.Duration
+}
+
+func newMapStorage() *mapStorage {
+	return &mapStorage{items: make(map[string]interface{})}
+}
+
+func (m *mapStorage) CreateTable(_ interface{}, _ string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.tables++
+	return nil
+}
+
+func (m *mapStorage) Create(value interface{}, _ string) error {
+	time.Sleep(m.createDelay)
+	m.Lock()
+	defer m.Unlock()
+	hash, _ := tools.GetHashAndRangeValue(value)
+	key := fmt.Sprintf("%v", hash)
+	if _, ok := m.items[key]; ok {
+		return core.ErrDuplicateKey
+	}
+	m.items[key] = value
+	return nil
+}
+
+// Delete 模拟删除不存在的对象时返回 ErrNotFound 的后端
+func (m *mapStorage) Delete(_ interface{}, _ string, hash interface{}, _ ...interface{}) error {
+	m.Lock()
+	defer m.Unlock()
+	key := fmt.Sprintf("%v", hash)
+	if _, ok := m.items[key]; !ok {
+		return core.ErrNotFound
+	}
+	delete(m.items, key)
+	return nil
+}
+
+func (m *mapStorage) Save(value interface{}, _ string) error {
+	m.Lock()
+	defer m.Unlock()
+	hash, _ := tools.GetHashAndRangeValue(value)
+	key := fmt.Sprintf("%v", hash)
+	if _, ok := m.items[key]; !ok {
+		return core.ErrExpiredValue
+	}
+	m.items[key] = value
+	return nil
+}
+
+func (m *mapStorage) First(value interface{}, _ string, hash interface{}, _ ...interface{}) error {
+	m.Lock()
+	defer m.Unlock()
+	item, ok := m.items[fmt.Sprintf("%v", hash)]
+	if !ok {
+		return core.ErrNotFound
+	}
+	return tools.DeepCopy(item, value)
+}
+
+func (m *mapStorage) Find(value interface{}, _ string, limit int64, _ string, _ ...interface{}) error {
+	m.Lock()
+	defer m.Unlock()
+	slc := make([]interface{}, 0, len(m.items))
+	for _, item := range m.items {
+		if limit > 0 && int64(len(slc)) >= limit {
+			break
+		}
+		slc = append(slc, item)
+	}
+	return tools.DeepCopy(slc, value)
+}
+
+func (m *mapStorage) Count(_ string, _ string, _ ...interface{}) (int64, error) {
+	return int64(m.size()), nil
+}
+
+func (m *mapStorage) Aggregate(_ string, agg *Aggregation, _ string, _ ...interface{}) ([]AggregateResult, error) {
+	aggregator, err := tools.NewAggregator(agg)
+	if err != nil {
+		return nil, err
+	}
+	m.Lock()
+	defer m.Unlock()
+	for _, item := range m.items {
+		if err = aggregator.Add(item); err != nil {
+			return nil, err
+		}
+	}
+	return aggregator.Result(), nil
+}
+
+func (m *mapStorage) Ping(_ context.Context) error {
+	return nil
+}
+
+func (m *mapStorage) Close() error {
+	return nil
+}
+
+func (m *mapStorage) size() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.items)
+}
+
+func TestHashRing(t *testing.T) {
+	r := require.New(t)
+	before := newHashRing(DefaultHasher, 3, DefaultVirtualNodes)
+	after := newHashRing(DefaultHasher, 4, DefaultVirtualNodes)
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("player-%d", i)
+		r.Equal(before.get(key), before.get(key))
+		if b, a := before.get(key), after.get(key); b != a {
+			// 扩容时只允许对象迁移到新分片
+			r.Equal(3, a)
+			moved++
+		}
+	}
+	r.Greater(moved, 0)
+	r.Less(moved, 500)
+}
+
+func TestShardedStorage(t *testing.T) {
+	r := require.New(t)
+	st, err := NewShardedStorage(nil, nil)
+	r.Nil(st)
+	r.Equal(ErrNoShard, err)
+
+	shards := []*mapStorage{newMapStorage(), newMapStorage(), newMapStorage()}
+	st, err = NewShardedStorage([]Storage{shards[0], shards[1], shards[2]}, nil)
+	r.Nil(err)
+	r.Nil(st.CreateTable(testPlayer{}, ""))
+
+	for i := 0; i < 30; i++ {
+		r.Nil(st.Create(testPlayer{Id: fmt.Sprintf("p%d", i), Level: i}, ""))
+	}
+	r.Equal(core.ErrDuplicateKey, st.Create(testPlayer{Id: "p1"}, ""))
+	for _, shard := range shards {
+		r.Greater(shard.size(), 0)
+	}
+
+	var player testPlayer
+	r.Nil(st.First(&player, "", "p7"))
+	r.Equal(7, player.Level)
+	player.Level = 70
+	r.Nil(st.Save(&player, ""))
+	r.Nil(st.First(&player, "", "p7"))
+	r.Equal(70, player.Level)
+	r.Nil(st.Delete(testPlayer{}, "", "p7"))
+	r.Equal(core.ErrNotFound, st.First(&player, "", "p7"))
+	r.Equal(core.ErrNotFound, st.Delete(testPlayer{}, "", "p7"))
+
+	var players []testPlayer
+	r.Nil(st.Find(&players, "", 0, ""))
+	r.Len(players, 29)
+	r.Nil(st.Find(&players, "", 5, ""))
+	r.Len(players, 5)
+	r.Equal(core.ErrUnsupportedValueType, st.Find(players, "", 0, ""))
+
+	count, err := st.Count("", "")
+	r.Nil(err)
+	r.Equal(int64(29), count)
+	// p7 已被删除，剩余 Level 为 0..29 中除 7 以外的值
+	results, err := st.Aggregate("", &Aggregation{Type: AggregateAvg, Field: "Level"}, "")
+	r.Nil(err)
+	r.Len(results, 1)
+	r.Equal(int64(29), results[0].Count)
+	r.InDelta(float64(435-7)/29, results[0].Value, 1e-9)
+	results, err = st.Aggregate("", &Aggregation{Type: AggregateMax, Field: "Level"}, "")
+	r.Nil(err)
+	r.Equal(float64(29), results[0].Value)
+
+	r.Nil(st.Ping(context.Background()))
+	r.Nil(st.Close())
+}
+
+func TestShardedStorage_Rebalance(t *testing.T) {
+	r := require.New(t)
+	shards := []*mapStorage{newMapStorage(), newMapStorage()}
+	st, err := NewShardedStorage([]Storage{shards[0], shards[1]}, nil)
+	r.Nil(err)
+	r.Nil(st.CreateTable(testPlayer{}, ""))
+	r.Nil(st.CreateTable(testPlayer{}, ""))
+	for i := 0; i < 100; i++ {
+		r.Nil(st.Create(testPlayer{Id: fmt.Sprintf("p%d", i), Level: i}, ""))
+	}
+
+	newShard := newMapStorage()
+	index, err := st.AddShard(newShard)
+	r.Nil(err)
+	r.Equal(2, index)
+	r.Equal(3, st.ShardNum())
+	r.Equal(1, newShard.tables)
+	// 迁移完成前不能再次扩容
+	_, err = st.AddShard(newMapStorage())
+	r.Equal(ErrRebalancing, err)
+	r.Equal(3, st.ShardNum())
+
+	// 迁移完成前，仍然可以读写旧分片上的对象
+	var player testPlayer
+	for i := 0; i < 100; i++ {
+		r.Nil(st.First(&player, "", fmt.Sprintf("p%d", i)))
+		r.Equal(i, player.Level)
+	}
+	r.Equal(core.ErrDuplicateKey, st.Create(testPlayer{Id: "p3"}, ""))
+
+	done := make(chan int, 1)
+	r.Nil(st.RebalanceAsync(func(moved int, err error) {
+		if err != nil {
+			moved = -1
+		}
+		done <- moved
+	}, RebalanceTable{Value: &[]testPlayer{}}))
+	select {
+	case moved := <-done:
+		r.Equal(newShard.size(), moved)
+		r.Greater(moved, 0)
+	case <-time.After(time.Second):
+		r.FailNow("rebalance timeout")
+	}
+
+	r.Equal(100, shards[0].size()+shards[1].size()+newShard.size())
+	for i := 0; i < 100; i++ {
+		r.Nil(st.First(&player, "", fmt.Sprintf("p%d", i)))
+		r.Equal(i, player.Level)
+	}
+	var players []testPlayer
+	r.Nil(st.Find(&players, "", 0, ""))
+	r.Len(players, 100)
+
+	// 迁移完成后可以继续扩容
+	index, err = st.AddShard(newMapStorage())
+	r.Nil(err)
+	r.Equal(3, index)
+}
+
+// 测试迁移完成前删除还在旧分片上的对象
+func TestShardedStorage_DeleteRebalancing(t *testing.T) {
+	r := require.New(t)
+	st, err := NewShardedStorage([]Storage{newMapStorage(), newMapStorage()}, nil)
+	r.Nil(err)
+	for i := 0; i < 100; i++ {
+		r.Nil(st.Create(testPlayer{Id: fmt.Sprintf("p%d", i)}, ""))
+	}
+	_, err = st.AddShard(newMapStorage())
+	r.Nil(err)
+
+	deleted := 0
+	var player testPlayer
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("p%d", i)
+		if _, previous := st.locate(id); previous == nil {
+			continue
+		}
+		// 新分片上没有这个对象，旧分片上的对象仍然会被删除
+		r.Nil(st.Delete(testPlayer{}, "", id))
+		r.Equal(core.ErrNotFound, st.First(&player, "", id))
+		r.Equal(core.ErrNotFound, st.Delete(testPlayer{}, "", id))
+		deleted++
+	}
+	r.Greater(deleted, 0)
+	count, err := st.Count("", "")
+	r.Nil(err)
+	r.Equal(int64(100-deleted), count)
+}
+
+// 测试迁移期间并发保存，更新不会丢失，查询不会重复
+func TestShardedStorage_RebalanceConcurrentSave(t *testing.T) {
+	r := require.New(t)
+	shards := []*mapStorage{newMapStorage(), newMapStorage()}
+	st, err := NewShardedStorage([]Storage{shards[0], shards[1]}, nil)
+	r.Nil(err)
+	r.Nil(st.CreateTable(testPlayer{}, ""))
+	const num = 200
+	for i := 0; i < num; i++ {
+		r.Nil(st.Create(testPlayer{Id: fmt.Sprintf("p%d", i)}, ""))
+	}
+	newShard := newMapStorage()
+	newShard.createDelay = time.Millisecond
+	_, err = st.AddShard(newShard)
+	r.Nil(err)
+
+	done := make(chan error, 1)
+	r.Nil(st.RebalanceAsync(func(_ int, err error) {
+		done <- err
+	}, RebalanceTable{Value: &[]testPlayer{}}))
+
+	level := 0
+	for finished := false; !finished; {
+		select {
+		case err = <-done:
+			r.Nil(err)
+			finished = true
+		default:
+		}
+		level++
+		for i := 0; i < num; i++ {
+			var player testPlayer
+			r.Nil(st.First(&player, "", fmt.Sprintf("p%d", i)))
+			player.Level = level
+			r.Nil(st.Save(&player, ""))
+		}
+		count, err := st.Count("", "")
+		r.Nil(err)
+		r.Equal(int64(num), count)
+	}
+
+	var players []testPlayer
+	r.Nil(st.Find(&players, "", 0, ""))
+	r.Len(players, num)
+	for _, player := range players {
+		r.Equal(level, player.Level, player.Id)
+	}
+}
@@ -0,0 +1,24 @@
+package dynamodb_test
+
+import (
+	"github.com/finishy1995/go-library/storage"
+	"github.com/finishy1995/go-library/storage/src/dynamodb"
+	"github.com/finishy1995/go-library/storage/storagetest"
+	"os"
+	"testing"
+)
+
+// TestConformance 需要可用的 DynamoDB Local，通过环境变量 STORAGE_TEST_DYNAMO_ENDPOINT 指定，例如 http://127.0.0.1:8000
+func TestConformance(t *testing.T) {
+	endpoint := os.Getenv("STORAGE_TEST_DYNAMO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("STORAGE_TEST_DYNAMO_ENDPOINT not set")
+	}
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		st, err := dynamodb.NewStorage("", endpoint, "conformance", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	})
+}
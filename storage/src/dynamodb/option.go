@@ -0,0 +1,68 @@
+package dynamodb
+
+import (
+	"net/http"
+	"time"
+)
+
+// Options 连接选项，零值代表使用 SDK 默认值
+type Options struct {
+	// MaxRetries 请求失败的最大重试次数，0 使用 SDK 默认值，负数代表不重试
+	MaxRetries int
+	// HTTPClient 自定义 HTTP 客户端，设置后忽略 HTTPTimeout 和 MaxIdleConns
+	HTTPClient *http.Client
+	// HTTPTimeout 单次 HTTP 请求的超时时间
+	HTTPTimeout time.Duration
+	// MaxIdleConns 保持的最大空闲连接数
+	MaxIdleConns int
+}
+
+// Option 选项闭包
+type Option func(*Options)
+
+// WithMaxRetries 设置请求失败的最大重试次数，负数代表不重试
+func WithMaxRetries(retries int) Option {
+	return func(options *Options) {
+		options.MaxRetries = retries
+	}
+}
+
+// WithHTTPClient 设置自定义 HTTP 客户端
+func WithHTTPClient(client *http.Client) Option {
+	return func(options *Options) {
+		options.HTTPClient = client
+	}
+}
+
+// WithHTTPTimeout 设置单次 HTTP 请求的超时时间
+func WithHTTPTimeout(timeout time.Duration) Option {
+	return func(options *Options) {
+		options.HTTPTimeout = timeout
+	}
+}
+
+// WithMaxIdleConns 设置保持的最大空闲连接数
+func WithMaxIdleConns(num int) Option {
+	return func(options *Options) {
+		options.MaxIdleConns = num
+	}
+}
+
+// newHTTPClient 根据选项创建 HTTP 客户端，全部使用默认值时返回 nil
+func (o *Options) newHTTPClient() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+	if o.HTTPTimeout <= 0 && o.MaxIdleConns <= 0 {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.MaxIdleConns > 0 {
+		transport.MaxIdleConns = o.MaxIdleConns
+		transport.MaxIdleConnsPerHost = o.MaxIdleConns
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   o.HTTPTimeout,
+	}
+}
@@ -10,29 +10,34 @@ import (
 	"github.com/finishy1995/go-library/storage/core"
 	"github.com/finishy1995/go-library/storage/src/tools"
 	"github.com/guregu/dynamo"
+	"net/http"
 	"time"
 )
 
 type Storage struct {
-	db     *dynamo.DB
-	prefix string
+	db         *dynamo.DB
+	httpClient *http.Client
+	prefix     string
 }
 
 const (
 	DefaultAWSRegion = "us-east-1"
 	DefaultTimeout   = 200 * time.Millisecond
+	// DefaultScanTimeout Count 和 Aggregate 需要分页扫描整张表，使用更长的超时时间
+	DefaultScanTimeout = 10 * time.Second
 )
 
-func NewStorage(region string, mock string, prefix string, ak string, sk string) *Storage {
+func NewStorage(region string, mock string, prefix string, ak string, sk string, opts ...Option) (*Storage, error) {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
 	st := new(Storage)
 	if region == "" {
 		region = DefaultAWSRegion
 	}
 
 	config := aws.NewConfig().WithRegion(region)
-	if config == nil {
-		return nil
-	}
 	if mock != "" {
 		config = config.WithEndpoint(mock).WithCredentials(credentials.NewStaticCredentials("1", "1", "1"))
 	} else {
@@ -40,13 +45,39 @@ func NewStorage(region string, mock string, prefix string, ak string, sk string)
 			config = config.WithCredentials(credentials.NewStaticCredentials(ak, sk, ""))
 		}
 	}
-	mySession := session.Must(session.NewSession(config))
+	if o.MaxRetries > 0 {
+		config = config.WithMaxRetries(o.MaxRetries)
+	} else if o.MaxRetries < 0 {
+		config = config.WithMaxRetries(0)
+	}
+	st.httpClient = o.newHTTPClient()
+	if st.httpClient != nil {
+		config = config.WithHTTPClient(st.httpClient)
+	}
+	mySession, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
 
 	st.db = dynamo.New(mySession, config)
 	if prefix != "" {
 		st.prefix = prefix + "-"
 	}
-	return st
+	return st, nil
+}
+
+// Ping 通过 ListTables 检查 DynamoDB 是否可用
+func (st *Storage) Ping(ctx context.Context) error {
+	_, err := st.db.Client().ListTablesWithContext(ctx, &dynamodb.ListTablesInput{Limit: aws.Int64(1)})
+	return err
+}
+
+// Close 关闭空闲的 HTTP 连接
+func (st *Storage) Close() error {
+	if st.httpClient != nil {
+		st.httpClient.CloseIdleConnections()
+	}
+	return nil
 }
 
 func getContext() (aws.Context, context.CancelFunc) {
@@ -88,16 +119,24 @@ func (st *Storage) Create(value interface{}, tableName string) error {
 		return core.ErrUnsupportedValueType
 	}
 	tableName = st.prefix + tableName
+	valPtr := tools.GetPointer(value)
+	if valPtr == nil {
+		return core.ErrUnsupportedValueType
+	}
+	err := tools.TrySetStructDefaultValue(valPtr)
+	if err != nil {
+		return err
+	}
 
 	table := st.db.Table(tableName)
-	process := table.Put(value)
+	process := table.Put(valPtr)
 	if process == nil {
 		return core.ErrUnsupportedValueType
 	}
 
 	ctx, cancel := getContext()
 	defer cancel()
-	err := process.If(fmt.Sprintf("attribute_not_exists(%s)", hashKey)).RunWithContext(ctx)
+	err = process.If(fmt.Sprintf("attribute_not_exists(%s)", hashKey)).RunWithContext(ctx)
 	_, ok := err.(*dynamodb.ConditionalCheckFailedException)
 	if ok {
 		return core.ErrDuplicateKey
@@ -165,7 +204,11 @@ func (st *Storage) Save(value interface{}, tableName string) error {
 
 	ctx, cancel := getContext()
 	defer cancel()
-	return process.RunWithContext(ctx)
+	err = process.RunWithContext(ctx)
+	if _, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+		return core.ErrExpiredValue
+	}
+	return err
 }
 
 // First 获取符合要求的存储对象（单主键时不需要额外参数，主键+排序键时需要把排序键的值作为额外参数）
@@ -239,3 +282,55 @@ func (st *Storage) Find(value interface{}, tableName string, limit int64, expr s
 	defer cancel()
 	return process.AllWithContext(ctx, value)
 }
+
+// Count 使用 Select: COUNT 分页扫描统计数量
+func (st *Storage) Count(tableName string, expr string, args ...interface{}) (int64, error) {
+	if tableName == "" {
+		return 0, core.ErrMissingTableName
+	}
+	process := st.db.Table(st.prefix + tableName).Scan()
+	if expr != "" {
+		process.Filter(expr, args...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultScanTimeout)
+	defer cancel()
+	return process.CountWithContext(ctx)
+}
+
+// Aggregate DynamoDB 不支持服务端聚合，分页扫描后在客户端计算
+func (st *Storage) Aggregate(tableName string, agg *core.Aggregation, expr string, args ...interface{}) ([]core.AggregateResult, error) {
+	if tableName == "" {
+		return nil, core.ErrMissingTableName
+	}
+	aggregator, err := tools.NewAggregator(agg)
+	if err != nil {
+		return nil, err
+	}
+	process := st.db.Table(st.prefix + tableName).Scan()
+	// 只读取参与计算的字段，使用引号避免和保留字冲突
+	if agg.GroupBy != "" {
+		process.Project("'"+agg.Field+"'", "'"+agg.GroupBy+"'")
+	} else {
+		process.Project("'" + agg.Field + "'")
+	}
+	if expr != "" {
+		process.Filter(expr, args...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultScanTimeout)
+	defer cancel()
+	iter := process.Iter()
+	item := make(map[string]interface{})
+	for iter.NextWithContext(ctx, &item) {
+		err = aggregator.Add(item)
+		if err != nil {
+			return nil, err
+		}
+		item = make(map[string]interface{})
+	}
+	if iter.Err() != nil {
+		return nil, iter.Err()
+	}
+	return aggregator.Result(), nil
+}
@@ -0,0 +1,31 @@
+package memory
+
+import (
+	"context"
+	"github.com/finishy1995/go-library/storage/core"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type closeItem struct {
+	Id string `dynamo:",hash"`
+}
+
+func TestStorage_Close(t *testing.T) {
+	r := require.New(t)
+	st := NewStorage(10, MinTickTime)
+	r.Nil(st.CreateTable(closeItem{}, "a"))
+	r.Nil(st.CreateTable(closeItem{}, "b"))
+	r.Nil(st.CreateTable(closeItem{}, "a"))
+	r.Equal(int32(2), atomic.LoadInt32(&st.tickers))
+	r.Nil(st.Ping(context.Background()))
+
+	r.Nil(st.Close())
+	r.Nil(st.Close())
+	r.Equal(core.ErrClosed, st.Ping(context.Background()))
+	r.Eventually(func() bool {
+		return atomic.LoadInt32(&st.tickers) == 0
+	}, time.Second, MinTickTime)
+}
@@ -1,10 +1,11 @@
 package memory
 
 import (
-	"github.com/finishy1995/go-library/storage/src/tools"
 	"fmt"
+	"github.com/finishy1995/go-library/storage/src/tools"
 	"github.com/stretchr/testify/assert"
 	"strings"
+	"sync"
 )
 
 type elemType uint8
@@ -68,7 +69,8 @@ var (
 		"NOT": Not,
 	}
 
-	exprMap = map[string]*exprNode{}
+	exprMap      = map[string]*exprNode{}
+	exprMapMutex sync.RWMutex
 
 	t = &nilTest{}
 )
@@ -92,7 +94,10 @@ func prior(before, after operatorType) bool {
 // getExprRoot 获取表达式的根节点
 // TODO: 完善逻辑，在表达式复杂时，下面可能会导致bug
 func getExprRoot(expr string) *exprNode {
-	if nod, ok := exprMap[expr]; ok {
+	exprMapMutex.RLock()
+	nod, ok := exprMap[expr]
+	exprMapMutex.RUnlock()
+	if ok {
 		return nod
 	} else {
 		var root, current *exprNode
@@ -171,7 +176,9 @@ func getExprRoot(expr string) *exprNode {
 		if st.Size() != 0 {
 			return nil
 		}
+		exprMapMutex.Lock()
 		exprMap[expr] = root
+		exprMapMutex.Unlock()
 
 		return root
 	}
@@ -1,12 +1,14 @@
 package memory
 
 import (
+	"context"
 	"fmt"
 	"github.com/finishy1995/go-library/routine"
 	"github.com/finishy1995/go-library/storage/core"
 	"github.com/finishy1995/go-library/storage/src/tools"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,7 +26,9 @@ const (
 
 type Storage struct {
 	sync.RWMutex
-	runFlag   bool
+	closeOnce sync.Once
+	closeSig  chan struct{} // 关闭后停止所有表的周期刷新协程
+	tickers   int32         // 正在运行的周期刷新协程数量，原子操作
 	maxLength int
 	tick      time.Duration
 	db        map[string]*table
@@ -50,7 +54,7 @@ type node struct {
 
 func NewStorage(maxLength int, tick time.Duration) *Storage {
 	stg := &Storage{
-		runFlag:   true,
+		closeSig:  make(chan struct{}),
 		maxLength: maxLength,
 		db:        make(map[string]*table, 0),
 	}
@@ -62,6 +66,24 @@ func NewStorage(maxLength int, tick time.Duration) *Storage {
 	return stg
 }
 
+// Ping 内存存储始终可用，关闭后返回 ErrClosed
+func (s *Storage) Ping(_ context.Context) error {
+	select {
+	case <-s.closeSig:
+		return core.ErrClosed
+	default:
+		return nil
+	}
+}
+
+// Close 停止所有表的周期刷新协程，已存储的数据仍然可以读取
+func (s *Storage) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closeSig)
+	})
+	return nil
+}
+
 func (s *Storage) CreateTable(value interface{}, tableName string) error {
 	if tableName == "" {
 		tableName = tools.GetStructOnlyName(value)
@@ -108,13 +130,17 @@ func (s *Storage) createTable(name string, rangeKey string) *table {
 
 	// 最大长度有意义才执行
 	if s.maxLength > 0 {
+		atomic.AddInt32(&s.tickers, 1)
 		err := routine.Run(false, func() {
+			ticker := time.NewTicker(s.tick)
+			defer ticker.Stop()
+			defer atomic.AddInt32(&s.tickers, -1)
 			for {
-				if s.runFlag {
-					<-time.After(s.tick)
-					s.process(tb)
-				} else {
+				select {
+				case <-s.closeSig:
 					return
+				case <-ticker.C:
+					s.process(tb)
 				}
 			}
 		})
@@ -180,10 +206,24 @@ func (s *Storage) Create(value interface{}, tableName string) error {
 	}
 
 	tb := s.createTable(tableName, rangeKey)
-	key := getRealKey(hashKey, rangeKey, tb.key, value)
+	key := getRealKey(tb.key, value)
 	if key == "" {
 		return core.ErrUnsupportedValueType
 	}
+	// 存储副本，避免调用方修改已存储的对象
+	tp := reflect.TypeOf(value)
+	if tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	cpy := reflect.New(tp)
+	err := tools.DeepCopy(value, cpy.Interface())
+	if err != nil {
+		return err
+	}
+	err = tools.TrySetStructDefaultValue(cpy.Interface())
+	if err != nil {
+		return err
+	}
 
 	tb.itemsMutex.Lock()
 	defer tb.itemsMutex.Unlock()
@@ -194,7 +234,7 @@ func (s *Storage) Create(value interface{}, tableName string) error {
 	newNode := &node{
 		front: &tb.head,
 		next:  tb.head.next,
-		value: value,
+		value: cpy.Elem().Interface(),
 		key:   key,
 	}
 	tb.head.next.front = newNode
@@ -204,16 +244,18 @@ func (s *Storage) Create(value interface{}, tableName string) error {
 	return nil
 }
 
-func getRealKey(hashKey string, rangeKey string, typ keyType, value interface{}) string {
+func getRealKey(typ keyType, value interface{}) string {
+	hashValue, rangeValue := tools.GetHashAndRangeValue(value)
+	if hashValue == nil {
+		return ""
+	}
 	if typ == HashRange {
-		if rangeKey == "" {
+		if rangeValue == nil {
 			return ""
 		}
-		return fmt.Sprintf("%v-%v",
-			tools.GetFieldValueByName(value, hashKey),
-			tools.GetFieldValueByName(value, rangeKey))
+		return getRealKeyByValue(typ, hashValue, rangeValue)
 	}
-	return fmt.Sprintf("%v", tools.GetFieldValueByName(value, hashKey))
+	return getRealKeyByValue(typ, hashValue)
 }
 
 func getRealKeyByValue(typ keyType, value ...interface{}) string {
@@ -266,35 +308,50 @@ func (s *Storage) Save(value interface{}, tableName string) error {
 		}
 	}
 	hashKey, rangeKey := tools.GetHashAndRangeKey(value, false)
-	if hashKey == "" {
+	if hashKey == "" || reflect.ValueOf(value).Kind() != reflect.Ptr {
 		return core.ErrUnsupportedValueType
 	}
-	_, err := tools.TrySetStructVersion(value)
-	if err != nil {
-		return err
-	}
 
 	tb := s.createTable(tableName, rangeKey)
-	key := getRealKey(hashKey, rangeKey, tb.key, value)
+	key := getRealKey(tb.key, value)
 	if key == "" {
 		return core.ErrUnsupportedValueType
 	}
 
-	tb.itemsMutex.RLock()
+	tb.itemsMutex.Lock()
+	defer tb.itemsMutex.Unlock()
 	getNode, ok := tb.items[key]
-	tb.itemsMutex.RUnlock()
+	if !ok {
+		return core.ErrExpiredValue
+	}
+	// 乐观锁，只有版本号和存储的一致才允许保存
+	current, err := tools.GetStructVersionFromOriginData(getNode.value)
+	if err != nil {
+		return err
+	}
+	version, err := tools.GetStructVersionFromOriginData(reflect.ValueOf(value).Elem().Interface())
+	if err != nil {
+		return err
+	}
+	if version != current {
+		return core.ErrExpiredValue
+	}
+	_, err = tools.TrySetStructVersion(value)
+	if err != nil {
+		return err
+	}
 	tb.preRefreshMutex.Lock()
 	tb.preRefresh[key] = true
 	tb.preRefreshMutex.Unlock()
 
-	if ok {
-		original := reflect.ValueOf(value).Elem()
-		cpy := reflect.New(original.Type())
-		err = tools.DeepCopy(value, cpy.Interface())
-		getNode.value = cpy.Elem().Interface()
+	original := reflect.ValueOf(value).Elem()
+	cpy := reflect.New(original.Type())
+	err = tools.DeepCopy(value, cpy.Interface())
+	if err != nil {
+		return err
 	}
-
-	return err
+	getNode.value = cpy.Elem().Interface()
+	return nil
 }
 
 func (s *Storage) First(value interface{}, tableName string, hash interface{}, args ...interface{}) error {
@@ -323,21 +380,16 @@ func (s *Storage) First(value interface{}, tableName string, hash interface{}, a
 	}
 
 	tb.itemsMutex.RLock()
+	defer tb.itemsMutex.RUnlock()
 	getNode, ok := tb.items[key]
-	tb.itemsMutex.RUnlock()
-
-	if ok {
-		tb.preRefreshMutex.Lock()
-		tb.preRefresh[key] = true
-		tb.preRefreshMutex.Unlock()
-		err := tools.DeepCopy(getNode.value, value)
-		if err != nil {
-			return err
-		}
-	} else {
+	if !ok {
 		return core.ErrNotFound
 	}
-	return nil
+
+	tb.preRefreshMutex.Lock()
+	tb.preRefresh[key] = true
+	tb.preRefreshMutex.Unlock()
+	return tools.DeepCopy(getNode.value, value)
 }
 
 // Find 支持 > ; < ; >= ; <= ; <> ; = ; and ; or ; () ; not
@@ -371,7 +423,7 @@ func (s *Storage) Find(value interface{}, tableName string, limit int64, expr st
 	defer tb.itemsMutex.RUnlock()
 
 	for _, item := range tb.items {
-		if nod.calculate(item.value, args) {
+		if nod == nil || nod.calculate(item.value, args) {
 			slc = append(slc, item.value)
 
 			count++
@@ -383,3 +435,55 @@ func (s *Storage) Find(value interface{}, tableName string, limit int64, expr st
 
 	return tools.DeepCopy(slc, value)
 }
+
+func (s *Storage) Count(tableName string, expr string, args ...interface{}) (int64, error) {
+	var count int64 = 0
+	err := s.foreach(tableName, expr, args, func(value interface{}) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func (s *Storage) Aggregate(tableName string, agg *core.Aggregation, expr string, args ...interface{}) ([]core.AggregateResult, error) {
+	aggregator, err := tools.NewAggregator(agg)
+	if err != nil {
+		return nil, err
+	}
+	err = s.foreach(tableName, expr, args, aggregator.Add)
+	if err != nil {
+		return nil, err
+	}
+	return aggregator.Result(), nil
+}
+
+// foreach 遍历表中所有符合表达式的对象，表不存在时不报错
+func (s *Storage) foreach(tableName string, expr string, args []interface{}, fn func(value interface{}) error) error {
+	if tableName == "" {
+		return core.ErrMissingTableName
+	}
+	var nod *exprNode
+	if expr != "" {
+		nod = getExprRoot(expr)
+		if nod == nil {
+			return core.ErrUnsupportedExprType
+		}
+	}
+	s.RLock()
+	tb, ok := s.db[tableName]
+	s.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	tb.itemsMutex.RLock()
+	defer tb.itemsMutex.RUnlock()
+	for _, item := range tb.items {
+		if nod == nil || nod.calculate(item.value, args) {
+			if err := fn(item.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
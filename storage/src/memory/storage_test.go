@@ -0,0 +1,14 @@
+package memory_test
+
+import (
+	"github.com/finishy1995/go-library/storage"
+	"github.com/finishy1995/go-library/storage/src/memory"
+	"github.com/finishy1995/go-library/storage/storagetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return memory.NewStorage(0, 0)
+	})
+}
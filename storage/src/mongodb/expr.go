@@ -17,6 +17,7 @@ var (
 		"OR":  "or",
 		"NOT": "not",
 		"=":   "==",
+		"<>":  "!=",
 	}
 )
 
@@ -27,11 +28,12 @@ const (
 )
 
 func replaceWithExtended(expr string) string {
-	tmp := expr
+	// 首尾补空格，保证位于开头的关键字（例如 NOT）也能被替换
+	tmp := " " + expr + " "
 	for key, value := range keyWordExtended {
 		tmp = strings.ReplaceAll(tmp, " "+key+" ", " "+value+" ")
 	}
-	return tmp
+	return strings.TrimSpace(tmp)
 }
 
 func replaceDotAndAltWithMagic(input string) string {
@@ -55,7 +57,7 @@ func replaceWithValue(expr string, args ...interface{}) string {
 	return fmt.Sprintf(realExpr, args...)
 }
 
-func getRootNode(expr string, args ...interface{}) (*ast.BinaryNode, error) {
+func getRootNode(expr string, args ...interface{}) (ast.Node, error) {
 	realExpr := replaceWithExtended(expr)
 	realExpr = replaceWithValue(realExpr, args...)
 	realExpr = replaceDotAndAltWithMagic(realExpr)
@@ -66,51 +68,56 @@ func getRootNode(expr string, args ...interface{}) (*ast.BinaryNode, error) {
 		return nil, err
 	}
 
-	// 断言根节点是二元节点
-	node, ok := tree.Node.(*ast.BinaryNode)
-	if !ok {
-		return nil, errors.New("root node is not a BinaryNode")
+	// 断言根节点是二元节点或者 not 一元节点
+	switch tree.Node.(type) {
+	case *ast.BinaryNode, *ast.UnaryNode:
+		return tree.Node, nil
+	default:
+		return nil, errors.New("root node is not a BinaryNode or UnaryNode")
 	}
-	return node, nil
 }
 
 // buildFilterFromAST 根据 AST 节点构建 MongoDB 查询条件
-func buildFilterFromAST(node *ast.BinaryNode) (bson.D, error) {
-	if node == nil {
-		return bson.D{}, fmt.Errorf("node is nil")
+func buildFilterFromAST(n ast.Node) (bson.D, error) {
+	if unary, ok := n.(*ast.UnaryNode); ok {
+		if unary.Operator != "not" && unary.Operator != "!" {
+			return nil, fmt.Errorf("unsupported operator: %s", unary.Operator)
+		}
+		// MongoDB 的 $not 只能作用于单个字段，整体取反使用 $nor
+		operand, err := buildFilterFromAST(unary.Node)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{"$nor", bson.A{operand}}}, nil
+	}
+	node, ok := n.(*ast.BinaryNode)
+	if !ok || node == nil {
+		return bson.D{}, fmt.Errorf("node is nil or not a BinaryNode")
 	}
 
 	switch node.Operator {
 	case "and":
-		left, err := buildFilterFromAST(node.Left.(*ast.BinaryNode))
+		left, err := buildFilterFromAST(node.Left)
 		if err != nil {
 			return nil, err
 		}
-		right, err := buildFilterFromAST(node.Right.(*ast.BinaryNode))
+		right, err := buildFilterFromAST(node.Right)
 		if err != nil {
 			return nil, err
 		}
 		return bson.D{{"$and", bson.A{left, right}}}, nil
 
 	case "or":
-		left, err := buildFilterFromAST(node.Left.(*ast.BinaryNode))
+		left, err := buildFilterFromAST(node.Left)
 		if err != nil {
 			return nil, err
 		}
-		right, err := buildFilterFromAST(node.Right.(*ast.BinaryNode))
+		right, err := buildFilterFromAST(node.Right)
 		if err != nil {
 			return nil, err
 		}
 		return bson.D{{"$or", bson.A{left, right}}}, nil
 
-	case "not":
-		// TODO: MongoDB 的 `$not` 运算符的行为可能与一般的 not 运算符有所不同
-		operand, err := buildFilterFromAST(node.Left.(*ast.BinaryNode))
-		if err != nil {
-			return nil, err
-		}
-		return bson.D{{"$not", operand}}, nil
-
 	default:
 		// 处理比较运算符
 		leftVal, err := getOperandValue(node.Left)
@@ -139,6 +146,8 @@ func buildFilterFromAST(node *ast.BinaryNode) (bson.D, error) {
 			return bson.D{{left, bson.M{"$lt": rightVal}}}, nil
 		case "==":
 			return bson.D{{left, bson.M{"$eq": rightVal}}}, nil
+		case "!=":
+			return bson.D{{left, bson.M{"$ne": rightVal}}}, nil
 		case ">=":
 			return bson.D{{left, bson.M{"$gte": rightVal}}}, nil
 		case "<=":
@@ -0,0 +1,37 @@
+package mongodb
+
+import "time"
+
+// Options 连接选项，零值代表使用驱动默认值
+type Options struct {
+	// MaxPoolSize 连接池最大连接数
+	MaxPoolSize uint64
+	// MinPoolSize 连接池最小连接数
+	MinPoolSize uint64
+	// ConnectTimeout 建立连接的超时时间
+	ConnectTimeout time.Duration
+}
+
+// Option 选项闭包
+type Option func(*Options)
+
+// WithMaxPoolSize 设置连接池最大连接数
+func WithMaxPoolSize(size uint64) Option {
+	return func(options *Options) {
+		options.MaxPoolSize = size
+	}
+}
+
+// WithMinPoolSize 设置连接池最小连接数
+func WithMinPoolSize(size uint64) Option {
+	return func(options *Options) {
+		options.MinPoolSize = size
+	}
+}
+
+// WithConnectTimeout 设置建立连接的超时时间
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(options *Options) {
+		options.ConnectTimeout = timeout
+	}
+}
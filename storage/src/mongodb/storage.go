@@ -10,20 +10,30 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"strings"
 	"time"
 )
 
 type Storage struct {
-	db *mongo.Database
+	client *mongo.Client
+	db     *mongo.Database
 }
 
 var (
 	defaultTimeout = 10 * time.Second
 )
 
-func NewStorage(endpoint, username, password, database string) *Storage {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+func NewStorage(endpoint, username, password, database string, opts ...Option) (*Storage, error) {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	timeout := defaultTimeout
+	if o.ConnectTimeout > 0 {
+		timeout = o.ConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	uri := endpoint
 	if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
@@ -57,19 +67,47 @@ func NewStorage(endpoint, username, password, database string) *Storage {
 		}
 	}
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	clientOptions := options.Client().ApplyURI(uri)
+	if o.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(o.MinPoolSize)
+	}
+	if o.ConnectTimeout > 0 {
+		clientOptions.SetConnectTimeout(o.ConnectTimeout)
+	}
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		log.Error("try mongo connect failed, error: %s", err.Error())
-		return nil
+		return nil, err
+	}
+	// Connect 不会建立连接，在超时时间内 Ping 一次，地址或者认证错误时立即返回
+	if err = client.Ping(ctx, readpref.Primary()); err != nil {
+		log.Error("try mongo ping failed, error: %s", err.Error())
+		_ = client.Disconnect(context.Background())
+		return nil, err
 	}
 	if database == "" {
 		database = "data"
 	}
 	db := client.Database(database)
 	if db == nil {
-		return nil
+		return nil, errors.New("mongo database " + database + " not found")
 	}
-	return &Storage{db: db}
+	return &Storage{client: client, db: db}, nil
+}
+
+// Ping 检查 MongoDB 主节点是否可用
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx, readpref.Primary())
+}
+
+// Close 断开所有连接
+func (s *Storage) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return s.client.Disconnect(ctx)
 }
 
 func (s *Storage) CreateTable(value interface{}, tableName string) error {
@@ -138,6 +176,9 @@ func (s *Storage) Create(value interface{}, tableName string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 	_, err = collection.InsertOne(ctx, valPtr)
+	if mongo.IsDuplicateKeyError(err) {
+		return core.ErrDuplicateKey
+	}
 	return err
 }
 
@@ -270,19 +311,9 @@ func (s *Storage) Find(value interface{}, tableName string, limit int64, expr st
 		return core.ErrUnsupportedValueType
 	}
 
-	filter := bson.D{}
-	var err error
-	if expr != "" {
-		// 解析表达式获取根节点
-		rootNode, err := getRootNode(expr, args...)
-		if err != nil {
-			return err
-		}
-		// 根据 AST 节点构建 MongoDB 查询条件
-		filter, err = buildFilterFromAST(rootNode)
-		if err != nil {
-			return err
-		}
+	filter, err := buildFilter(expr, args...)
+	if err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
@@ -303,3 +334,98 @@ func (s *Storage) Find(value interface{}, tableName string, limit int64, expr st
 	// 解码查询结果
 	return cursor.All(ctx, value)
 }
+
+// buildFilter 将表达式转换为 MongoDB 查询条件，空表达式匹配所有对象
+func buildFilter(expr string, args ...interface{}) (bson.D, error) {
+	if expr == "" {
+		return bson.D{}, nil
+	}
+	// 解析表达式获取根节点
+	rootNode, err := getRootNode(expr, args...)
+	if err != nil {
+		return nil, err
+	}
+	// 根据 AST 节点构建 MongoDB 查询条件
+	return buildFilterFromAST(rootNode)
+}
+
+func (s *Storage) Count(tableName string, expr string, args ...interface{}) (int64, error) {
+	if tableName == "" {
+		return 0, core.ErrMissingTableName
+	}
+	filter, err := buildFilter(expr, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return s.db.Collection(tableName).CountDocuments(ctx, filter)
+}
+
+var (
+	aggregateOperatorMap = map[core.AggregateType]string{
+		core.AggregateSum: "$sum",
+		core.AggregateMin: "$min",
+		core.AggregateMax: "$max",
+		core.AggregateAvg: "$avg",
+	}
+)
+
+// Aggregate 使用 $match + $group 聚合管道在服务端计算
+func (s *Storage) Aggregate(tableName string, agg *core.Aggregation, expr string, args ...interface{}) ([]core.AggregateResult, error) {
+	if tableName == "" {
+		return nil, core.ErrMissingTableName
+	}
+	aggregator, err := tools.NewAggregator(agg)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := buildFilter(expr, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	field := "$" + tools.LowerAllChar(agg.Field)
+	var groupID interface{}
+	if agg.GroupBy != "" {
+		groupID = "$" + tools.LowerAllChar(agg.GroupBy)
+	}
+	pipeline := mongo.Pipeline{
+		// 只统计包含计算字段的对象，和其他存储的行为保持一致
+		{{Key: "$match", Value: bson.D{{Key: "$and", Value: bson.A{filter, bson.D{{Key: tools.LowerAllChar(agg.Field), Value: bson.M{"$exists": true}}}}}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: groupID},
+			{Key: "value", Value: bson.M{aggregateOperatorMap[agg.Type]: field}},
+			{Key: "count", Value: bson.M{"$sum": 1}},
+			// $sum 等操作会忽略非数值，这里统计出来按不合法的聚合参数处理
+			{Key: "invalid", Value: bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$isNumber": field}, 0, 1}}}},
+		}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	cursor, err := s.db.Collection(tableName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Group   interface{} `bson:"_id"`
+		Value   interface{} `bson:"value"`
+		Count   int64       `bson:"count"`
+		Invalid int64       `bson:"invalid"`
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		value, ok := tools.ToFloat64(row.Value)
+		if !ok || row.Invalid > 0 {
+			return nil, core.ErrInvalidAggregation
+		}
+		aggregator.Merge(core.AggregateResult{Group: row.Group, Value: value, Count: row.Count})
+	}
+	return aggregator.Result(), nil
+}
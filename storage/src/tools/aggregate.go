@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"fmt"
+	"github.com/finishy1995/go-library/storage/core"
+	"reflect"
+	"sort"
+)
+
+// Aggregator 客户端聚合计算，用于不支持服务端聚合的存储，以及合并多个存储的聚合结果
+type Aggregator struct {
+	agg    *core.Aggregation
+	groups map[string]*aggregateGroup
+}
+
+type aggregateGroup struct {
+	group interface{}
+	value float64 // 平均值计算时保存总和，在 Result 中再相除
+	count int64
+}
+
+// NewAggregator 创建一个聚合计算器，agg 不合法时返回 ErrInvalidAggregation
+func NewAggregator(agg *core.Aggregation) (*Aggregator, error) {
+	if agg == nil || agg.Field == "" || agg.Type > core.AggregateAvg {
+		return nil, core.ErrInvalidAggregation
+	}
+	return &Aggregator{
+		agg:    agg,
+		groups: make(map[string]*aggregateGroup),
+	}, nil
+}
+
+// Add 加入一个对象，item 为 struct（或 struct ptr）或者 map[string]interface{}
+// 不包含计算字段的对象会被忽略，计算字段不是数值类型时返回 ErrInvalidAggregation
+func (a *Aggregator) Add(item interface{}) error {
+	field := getAggregateFieldValue(item, a.agg.Field)
+	if field == nil {
+		return nil
+	}
+	value, ok := ToFloat64(field)
+	if !ok {
+		return core.ErrInvalidAggregation
+	}
+	var group interface{}
+	if a.agg.GroupBy != "" {
+		group = getAggregateFieldValue(item, a.agg.GroupBy)
+	}
+	a.merge(group, value, 1)
+	return nil
+}
+
+// Merge 合并一个已经计算好的聚合结果，例如分片存储中单个分片的结果
+func (a *Aggregator) Merge(result core.AggregateResult) {
+	if result.Count <= 0 {
+		return
+	}
+	value := result.Value
+	if a.agg.Type == core.AggregateAvg {
+		value *= float64(result.Count)
+	}
+	a.merge(result.Group, value, result.Count)
+}
+
+func (a *Aggregator) merge(group interface{}, value float64, count int64) {
+	group = normalizeGroupValue(group)
+	key := fmt.Sprintf("%T-%v", group, group)
+	g, ok := a.groups[key]
+	if !ok {
+		a.groups[key] = &aggregateGroup{group: group, value: value, count: count}
+		return
+	}
+	switch a.agg.Type {
+	case core.AggregateSum, core.AggregateAvg:
+		g.value += value
+	case core.AggregateMin:
+		if value < g.value {
+			g.value = value
+		}
+	case core.AggregateMax:
+		if value > g.value {
+			g.value = value
+		}
+	}
+	g.count += count
+}
+
+// Result 获取聚合结果，不分组时固定返回一个结果，分组时按分组值升序排列
+func (a *Aggregator) Result() []core.AggregateResult {
+	if a.agg.GroupBy == "" && len(a.groups) == 0 {
+		return []core.AggregateResult{{}}
+	}
+	results := make([]core.AggregateResult, 0, len(a.groups))
+	for _, g := range a.groups {
+		value := g.value
+		if a.agg.Type == core.AggregateAvg && g.count > 0 {
+			value /= float64(g.count)
+		}
+		results = append(results, core.AggregateResult{
+			Group: g.group,
+			Value: value,
+			Count: g.count,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return lessGroupValue(results[i].Group, results[j].Group)
+	})
+	return results
+}
+
+// ToFloat64 将数值类型转换为 float64，不是数值类型时返回 false
+func ToFloat64(value interface{}) (float64, bool) {
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func getAggregateFieldValue(item interface{}, name string) interface{} {
+	if m, ok := item.(map[string]interface{}); ok {
+		return m[name]
+	}
+	return GetFieldValueByRealName(item, name)
+}
+
+// normalizeGroupValue 不同存储返回的数值类型不同，分组值统一为 float64
+func normalizeGroupValue(group interface{}) interface{} {
+	if value, ok := ToFloat64(group); ok {
+		return value
+	}
+	return group
+}
+
+func lessGroupValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	fa, okA := a.(float64)
+	fb, okB := b.(float64)
+	if okA && okB {
+		return fa < fb
+	}
+	return fmt.Sprintf("%v", a) < fmt.Sprintf("%v", b)
+}
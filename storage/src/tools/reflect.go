@@ -54,6 +54,9 @@ func GetHashAndRangeKey(value interface{}, useTag bool) (hashKey string, rangeKe
 	if tp.Kind() == reflect.Ptr {
 		tp = tp.Elem()
 	}
+	if tp.Kind() == reflect.Slice {
+		tp = tp.Elem()
+	}
 	if tp.Kind() != reflect.Struct {
 		return
 	}
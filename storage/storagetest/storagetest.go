@@ -0,0 +1,338 @@
+// Package storagetest 存储后端的一致性测试套件，用于验证自定义后端是否符合 storage.Storage 的约定
+//
+// Example Usage
+//
+//	func TestConformance(t *testing.T) {
+//	  storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+//	    return memory.NewStorage(0, 0)
+//	  })
+//	}
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"github.com/finishy1995/go-library/storage"
+	"github.com/finishy1995/go-library/storage/core"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// HashItem 单主键测试模型
+type HashItem struct {
+	core.Model
+	Id    string `dynamo:",hash"`
+	Age   int
+	Tag   string
+	Score float64 `dynamo:",default=1.5"`
+}
+
+// RangeItem 主键+排序键测试模型
+type RangeItem struct {
+	core.Model
+	Owner string `dynamo:",hash"`
+	Slot  int    `dynamo:",range"`
+	Count int
+}
+
+// tables 单个测试用例使用的表名，每个用例独立，避免残留数据互相影响
+type tables struct {
+	hash      string
+	hashRange string
+}
+
+type testCase struct {
+	name string
+	run  func(t *testing.T, st storage.Storage, tb tables)
+}
+
+var (
+	tableIndex uint64
+	cases      = []testCase{
+		{"CreateAndFirst", testCreateAndFirst},
+		{"DefaultTag", testDefaultTag},
+		{"DuplicateKey", testDuplicateKey},
+		{"NotFound", testNotFound},
+		{"HashRange", testHashRange},
+		{"Save", testSave},
+		{"VersionConflict", testVersionConflict},
+		{"Delete", testDelete},
+		{"FindOperators", testFindOperators},
+		{"FindLimit", testFindLimit},
+		{"Count", testCount},
+		{"Aggregate", testAggregate},
+		{"Ping", testPing},
+	}
+)
+
+// RunConformance 对 factory 创建的存储执行所有一致性测试，每个测试用例都会调用一次 factory
+//
+//	factory 收到当前测试用例的 t，创建失败时调用 t.Fatal 报告具体错误
+func RunConformance(t *testing.T, factory func(t *testing.T) storage.Storage) {
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			st := factory(t)
+			require.NotNil(t, st, "storage factory returns nil")
+			t.Cleanup(func() {
+				require.Nil(t, st.Close())
+			})
+			tb := newTables()
+			require.Nil(t, st.CreateTable(HashItem{}, tb.hash))
+			require.Nil(t, st.CreateTable(RangeItem{}, tb.hashRange))
+			c.run(t, st, tb)
+		})
+	}
+}
+
+func newTables() tables {
+	suffix := fmt.Sprintf("%d_%d", time.Now().UnixNano(), atomic.AddUint64(&tableIndex, 1))
+	return tables{
+		hash:      "HashItem_" + suffix,
+		hashRange: "RangeItem_" + suffix,
+	}
+}
+
+func testCreateAndFirst(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	r.Nil(st.Create(HashItem{Id: "a", Age: 18, Tag: "x", Score: 3}, tb.hash))
+
+	var item HashItem
+	r.Nil(st.First(&item, tb.hash, "a"))
+	r.Equal("a", item.Id)
+	r.Equal(18, item.Age)
+	r.Equal("x", item.Tag)
+	r.Equal(float64(3), item.Score)
+	r.Equal(uint64(0), item.Version)
+}
+
+func testDefaultTag(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	r.Nil(st.Create(HashItem{Id: "default"}, tb.hash))
+
+	var item HashItem
+	r.Nil(st.First(&item, tb.hash, "default"))
+	r.Equal(1.5, item.Score)
+}
+
+func testDuplicateKey(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	r.Nil(st.Create(HashItem{Id: "dup", Age: 1}, tb.hash))
+	r.Equal(core.ErrDuplicateKey, st.Create(HashItem{Id: "dup", Age: 2}, tb.hash))
+
+	var item HashItem
+	r.Nil(st.First(&item, tb.hash, "dup"))
+	r.Equal(1, item.Age)
+}
+
+func testNotFound(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	var item HashItem
+	r.Equal(core.ErrNotFound, st.First(&item, tb.hash, "missing"))
+	var rangeItem RangeItem
+	r.Equal(core.ErrNotFound, st.First(&rangeItem, tb.hashRange, "missing", 1))
+}
+
+func testHashRange(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	r.Nil(st.Create(RangeItem{Owner: "o1", Slot: 1, Count: 10}, tb.hashRange))
+	r.Nil(st.Create(RangeItem{Owner: "o1", Slot: 2, Count: 20}, tb.hashRange))
+	r.Nil(st.Create(RangeItem{Owner: "o2", Slot: 1, Count: 30}, tb.hashRange))
+	r.Equal(core.ErrDuplicateKey, st.Create(RangeItem{Owner: "o1", Slot: 2}, tb.hashRange))
+
+	var item RangeItem
+	r.Equal(core.ErrMissingRangeValue, st.First(&item, tb.hashRange, "o1"))
+	r.Nil(st.First(&item, tb.hashRange, "o1", 2))
+	r.Equal(20, item.Count)
+	r.Nil(st.First(&item, tb.hashRange, "o2", 1))
+	r.Equal(30, item.Count)
+	r.Equal(core.ErrNotFound, st.First(&item, tb.hashRange, "o2", 2))
+
+	var items []RangeItem
+	r.Nil(st.Find(&items, tb.hashRange, 0, ""))
+	r.Len(items, 3)
+}
+
+func testSave(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	r.Nil(st.Create(HashItem{Id: "save", Age: 1}, tb.hash))
+
+	var item HashItem
+	r.Nil(st.First(&item, tb.hash, "save"))
+	item.Age = 2
+	r.Nil(st.Save(&item, tb.hash))
+	r.Equal(uint64(1), item.Version)
+	item.Age = 3
+	r.Nil(st.Save(&item, tb.hash))
+	r.Equal(uint64(2), item.Version)
+
+	var saved HashItem
+	r.Nil(st.First(&saved, tb.hash, "save"))
+	r.Equal(3, saved.Age)
+	r.Equal(uint64(2), saved.Version)
+
+	var rangeItem RangeItem
+	r.Nil(st.Create(RangeItem{Owner: "save", Slot: 7}, tb.hashRange))
+	r.Nil(st.First(&rangeItem, tb.hashRange, "save", 7))
+	rangeItem.Count = 5
+	r.Nil(st.Save(&rangeItem, tb.hashRange))
+	r.Nil(st.First(&rangeItem, tb.hashRange, "save", 7))
+	r.Equal(5, rangeItem.Count)
+}
+
+func testVersionConflict(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	r.Nil(st.Create(HashItem{Id: "conflict", Age: 1}, tb.hash))
+
+	var first, second HashItem
+	r.Nil(st.First(&first, tb.hash, "conflict"))
+	r.Nil(st.First(&second, tb.hash, "conflict"))
+	first.Age = 2
+	r.Nil(st.Save(&first, tb.hash))
+	second.Age = 3
+	r.Equal(core.ErrExpiredValue, st.Save(&second, tb.hash))
+
+	var saved HashItem
+	r.Nil(st.First(&saved, tb.hash, "conflict"))
+	r.Equal(2, saved.Age)
+
+	// 不存在的对象不能通过 Save 创建
+	r.Equal(core.ErrExpiredValue, st.Save(&HashItem{Id: "never-created"}, tb.hash))
+}
+
+func testDelete(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	r.Nil(st.Create(HashItem{Id: "del"}, tb.hash))
+	r.Nil(st.Delete(HashItem{}, tb.hash, "del"))
+	var item HashItem
+	r.Equal(core.ErrNotFound, st.First(&item, tb.hash, "del"))
+	// 删除不存在的对象不报错
+	r.Nil(st.Delete(HashItem{}, tb.hash, "del"))
+
+	r.Nil(st.Create(RangeItem{Owner: "del", Slot: 1}, tb.hashRange))
+	r.Nil(st.Create(RangeItem{Owner: "del", Slot: 2}, tb.hashRange))
+	r.Equal(core.ErrMissingRangeValue, st.Delete(RangeItem{}, tb.hashRange, "del"))
+	r.Nil(st.Delete(RangeItem{}, tb.hashRange, "del", 1))
+	var rangeItem RangeItem
+	r.Equal(core.ErrNotFound, st.First(&rangeItem, tb.hashRange, "del", 1))
+	r.Nil(st.First(&rangeItem, tb.hashRange, "del", 2))
+}
+
+func createFindItems(r *require.Assertions, st storage.Storage, tb tables) {
+	r.Nil(st.Create(HashItem{Id: "f1", Age: 10, Tag: "a"}, tb.hash))
+	r.Nil(st.Create(HashItem{Id: "f2", Age: 20, Tag: "b"}, tb.hash))
+	r.Nil(st.Create(HashItem{Id: "f3", Age: 30, Tag: "a"}, tb.hash))
+	r.Nil(st.Create(HashItem{Id: "f4", Age: 40, Tag: "b"}, tb.hash))
+}
+
+func findIds(r *require.Assertions, st storage.Storage, tb tables, expr string, args ...interface{}) []string {
+	var items []HashItem
+	r.Nil(st.Find(&items, tb.hash, 0, expr, args...), expr)
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func testFindOperators(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	createFindItems(r, st, tb)
+
+	r.Equal([]string{"f1", "f2", "f3", "f4"}, findIds(r, st, tb, ""))
+	r.Equal([]string{"f2"}, findIds(r, st, tb, "'Age' = ?", 20))
+	r.Equal([]string{"f1", "f3", "f4"}, findIds(r, st, tb, "'Age' <> ?", 20))
+	r.Equal([]string{"f3", "f4"}, findIds(r, st, tb, "'Age' > ?", 20))
+	r.Equal([]string{"f2", "f3", "f4"}, findIds(r, st, tb, "'Age' >= ?", 20))
+	r.Equal([]string{"f1"}, findIds(r, st, tb, "'Age' < ?", 20))
+	r.Equal([]string{"f1", "f2"}, findIds(r, st, tb, "'Age' <= ?", 20))
+	r.Equal([]string{"f2", "f4"}, findIds(r, st, tb, "'Tag' = ?", "b"))
+	r.Equal([]string{"f3"}, findIds(r, st, tb, "'Age' > ? AND 'Tag' = ?", 10, "a"))
+	r.Equal([]string{"f1", "f4"}, findIds(r, st, tb, "'Age' < ? OR 'Age' > ?", 20, 30))
+	r.Equal([]string{"f1", "f2"}, findIds(r, st, tb, "NOT ('Age' > ?)", 20))
+	r.Equal([]string{"f1", "f2", "f4"}, findIds(r, st, tb, "('Age' > ? AND 'Tag' = ?) OR 'Age' < ?", 30, "b", 30))
+	r.Empty(findIds(r, st, tb, "'Age' > ?", 100))
+}
+
+func testFindLimit(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	createFindItems(r, st, tb)
+
+	var items []HashItem
+	r.Nil(st.Find(&items, tb.hash, 2, ""))
+	r.Len(items, 2)
+	r.Nil(st.Find(&items, tb.hash, 1, "'Tag' = ?", "a"))
+	r.Len(items, 1)
+	r.Equal("a", items[0].Tag)
+	r.Nil(st.Find(&items, tb.hash, 10, ""))
+	r.Len(items, 4)
+}
+
+func testCount(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	count, err := st.Count(tb.hash, "")
+	r.Nil(err)
+	r.Equal(int64(0), count)
+
+	createFindItems(r, st, tb)
+	count, err = st.Count(tb.hash, "")
+	r.Nil(err)
+	r.Equal(int64(4), count)
+	count, err = st.Count(tb.hash, "'Tag' = ?", "a")
+	r.Nil(err)
+	r.Equal(int64(2), count)
+	count, err = st.Count(tb.hash, "'Age' > ? AND 'Tag' = ?", 10, "b")
+	r.Nil(err)
+	r.Equal(int64(2), count)
+	count, err = st.Count(tb.hash, "'Age' > ?", 100)
+	r.Nil(err)
+	r.Equal(int64(0), count)
+}
+
+func testAggregate(t *testing.T, st storage.Storage, tb tables) {
+	r := require.New(t)
+	results, err := st.Aggregate(tb.hash, &storage.Aggregation{Type: storage.AggregateSum, Field: "Age"}, "")
+	r.Nil(err)
+	r.Equal([]storage.AggregateResult{{}}, results)
+
+	createFindItems(r, st, tb)
+	aggregate := func(typ core.AggregateType, groupBy string, expr string, args ...interface{}) []storage.AggregateResult {
+		results, err := st.Aggregate(tb.hash, &storage.Aggregation{Type: typ, Field: "Age", GroupBy: groupBy}, expr, args...)
+		r.Nil(err)
+		return results
+	}
+	r.Equal([]storage.AggregateResult{{Value: 100, Count: 4}}, aggregate(storage.AggregateSum, "", ""))
+	r.Equal([]storage.AggregateResult{{Value: 10, Count: 4}}, aggregate(storage.AggregateMin, "", ""))
+	r.Equal([]storage.AggregateResult{{Value: 40, Count: 4}}, aggregate(storage.AggregateMax, "", ""))
+	r.Equal([]storage.AggregateResult{{Value: 25, Count: 4}}, aggregate(storage.AggregateAvg, "", ""))
+	r.Equal([]storage.AggregateResult{{Value: 70, Count: 2}}, aggregate(storage.AggregateSum, "", "'Age' > ?", 20))
+
+	r.Equal([]storage.AggregateResult{
+		{Group: "a", Value: 40, Count: 2},
+		{Group: "b", Value: 60, Count: 2},
+	}, aggregate(storage.AggregateSum, "Tag", ""))
+	r.Equal([]storage.AggregateResult{
+		{Group: "a", Value: 20, Count: 2},
+		{Group: "b", Value: 30, Count: 2},
+	}, aggregate(storage.AggregateAvg, "Tag", ""))
+	r.Equal([]storage.AggregateResult{
+		{Group: float64(10), Value: 10, Count: 1},
+		{Group: float64(20), Value: 20, Count: 1},
+	}, aggregate(storage.AggregateMax, "Age", "'Age' < ?", 30))
+	r.Empty(aggregate(storage.AggregateSum, "Tag", "'Age' > ?", 100))
+
+	_, err = st.Aggregate(tb.hash, &storage.Aggregation{Type: storage.AggregateSum, Field: "Tag"}, "")
+	r.Equal(core.ErrInvalidAggregation, err)
+	_, err = st.Aggregate(tb.hash, nil, "")
+	r.Equal(core.ErrInvalidAggregation, err)
+}
+
+func testPing(t *testing.T, st storage.Storage, _ tables) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, st.Ping(ctx))
+}
@@ -22,8 +22,24 @@ const (
 // Model 存储基本模型
 type Model core.Model
 
+// Aggregation 聚合查询参数
+type Aggregation = core.Aggregation
+
+// AggregateResult 聚合查询结果
+type AggregateResult = core.AggregateResult
+
+const (
+	AggregateSum = core.AggregateSum
+	AggregateMin = core.AggregateMin
+	AggregateMax = core.AggregateMax
+	AggregateAvg = core.AggregateAvg
+)
+
+// Config 存储配置
+//
+//	StorageType 为 memory|dynamo|mongo 或者通过 RegisterBackend 注册的自定义后端名称
 type Config struct {
-	StorageType string        `json:",default=memory,options=memory|dynamo|mongo"`
+	StorageType string        `json:",default=memory"`
 	Region      string        `json:",optional"`
 	Endpoint    string        `json:",optional"`
 	Database    string        `json:",optional"`
@@ -31,6 +47,20 @@ type Config struct {
 	Tick        time.Duration `json:",default=1s"`
 	User        string        `json:",optional"`
 	Password    string        `json:",optional"`
+	// MaxPoolSize MongoDB 连接池最大连接数，0 使用驱动默认值
+	MaxPoolSize uint64 `json:",optional"`
+	// MinPoolSize MongoDB 连接池最小连接数
+	MinPoolSize uint64 `json:",optional"`
+	// ConnectTimeout MongoDB 建立连接的超时时间
+	ConnectTimeout time.Duration `json:",optional"`
+	// MaxRetries DynamoDB 请求失败的最大重试次数，0 使用 SDK 默认值，负数代表不重试
+	MaxRetries int `json:",optional"`
+	// HTTPTimeout DynamoDB 单次 HTTP 请求的超时时间
+	HTTPTimeout time.Duration `json:",optional"`
+	// MaxIdleConns DynamoDB 保持的最大空闲连接数
+	MaxIdleConns int `json:",optional"`
+	// Options 自定义后端的补充配置
+	Options map[string]interface{} `json:",optional"`
 }
 
 const (